@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// errArchiveLimit stops reading an archive whose entries exceed
+// MaxArchiveBytes.
+var errArchiveLimit = errors.New("archive size limit reached")
+
+// archiveHit is a forbidden string or pattern inside an archive entry.
+type archiveHit struct {
+	entry   string // path inside the archive; nested archives are joined with "!/"
+	content []byte
+	pattern string
+	detail  string
+	match   matcher
+}
+
+// archiveScan searches the entries of zip (jar, war, ...), tar and gzip
+// blobs, and archives nested in them up to MaxArchiveDepth. At most
+// MaxArchiveBytes are decompressed per blob, so that compression bombs
+// cannot stall the audit; truncated is set when the limit cut a scan short.
+type archiveScan struct {
+	needles   [][]byte
+	regexes   []*regexp.Regexp
+	opts      Options
+	budget    int64
+	truncated bool
+}
+
+func scanArchive(payload []byte, needles [][]byte, regexes []*regexp.Regexp, opts Options) (*archiveHit, bool) {
+	s := &archiveScan{needles: needles, regexes: regexes, opts: opts, budget: opts.MaxArchiveBytes}
+	return s.scan(payload, 1), s.truncated
+}
+
+// isArchive reports whether payload looks like an archive scanArchive reads.
+func isArchive(payload []byte) bool {
+	return bytes.HasPrefix(payload, []byte("PK\x03\x04")) ||
+		bytes.HasPrefix(payload, []byte{0x1f, 0x8b}) ||
+		len(payload) > 262 && string(payload[257:262]) == "ustar"
+}
+
+func (s *archiveScan) scan(payload []byte, depth int) *archiveHit {
+	var hit *archiveHit
+	err := forEachEntry(payload, func(name string, size int64, r io.Reader) error {
+		if size > s.opts.MaxBlobBytes {
+			return nil
+		}
+		content, err := s.read(r)
+		if err != nil {
+			return err
+		}
+		// Name the innermost entry holding the hit.
+		if depth < s.opts.MaxArchiveDepth && isArchive(content) {
+			if inner := s.scan(content, depth+1); inner != nil {
+				inner.entry = name + "!/" + inner.entry
+				hit = inner
+				return io.EOF
+			}
+			if s.truncated {
+				return errArchiveLimit
+			}
+		}
+		if pattern, detail, match := blobHit(content, s.needles, s.regexes, s.opts); detail != "" {
+			hit = &archiveHit{entry: name, content: content, pattern: pattern, detail: detail, match: match}
+			return io.EOF
+		}
+		return nil
+	})
+	if errors.Is(err, errArchiveLimit) {
+		s.truncated = true
+	}
+	// Other errors mean a corrupt or unsupported archive, whose entries
+	// read so far were searched.
+	return hit
+}
+
+// read decompresses an entry within the remaining budget.
+func (s *archiveScan) read(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, s.budget+1))
+	if int64(len(b)) > s.budget {
+		s.budget = 0
+		return nil, errArchiveLimit
+	}
+	s.budget -= int64(len(b))
+	return b, err
+}
+
+// forEachEntry calls fn with the regular files of the zip, tar or gzip
+// archive payload, stopping at the first error. A gzip stream is one entry
+// named after the compressed file, unless it holds a tar archive. fn
+// returning io.EOF stops early without error.
+func forEachEntry(payload []byte, fn func(name string, size int64, r io.Reader) error) error {
+	err := func() error {
+		switch {
+		case bytes.HasPrefix(payload, []byte("PK\x03\x04")):
+			zr, err := zip.NewReader(bytes.NewReader(payload), int64(len(payload)))
+			if err != nil {
+				return err
+			}
+			for _, f := range zr.File {
+				if f.FileInfo().IsDir() {
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				err = fn(f.Name, int64(f.UncompressedSize64), rc)
+				rc.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		case bytes.HasPrefix(payload, []byte{0x1f, 0x8b}):
+			zr, err := gzip.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			// Peek for a tar header without decompressing the whole stream.
+			br := bufio.NewReader(zr)
+			head, _ := br.Peek(262)
+			if len(head) == 262 && string(head[257:262]) == "ustar" {
+				return forEachTarEntry(br, fn)
+			}
+			name := zr.Name
+			if name == "" {
+				name = "(gzip)"
+			}
+			return fn(name, 0, br)
+		case len(payload) > 262 && string(payload[257:262]) == "ustar":
+			return forEachTarEntry(bytes.NewReader(payload), fn)
+		}
+		return nil
+	}()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func forEachTarEntry(r io.Reader, fn func(name string, size int64, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -6,50 +6,152 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
 )
 
 type Options struct {
 	// ForbiddenPaths are paths that must not exist anywhere in reachable history.
-	// These are evaluated using `git rev-list --all -- <path>`.
+	// These are evaluated using `git rev-list --all -- <path>`. Entries with
+	// glob characters (`**/.env*`) are matched against every path in the
+	// history, and each matching path is reported.
 	ForbiddenPaths []string
 
 	// ForbiddenStrings are substrings that must not exist in reachable blobs.
 	// If CaseInsensitive is true, matching is done with a lowercased search.
 	ForbiddenStrings []string
 	CaseInsensitive  bool
+	// MatchWholeWord only reports ForbiddenStrings hits that are not adjacent
+	// to ASCII word characters (letters, digits, underscore).
+	MatchWholeWord bool
+	// ForbiddenRegexes are regular expressions (RE2 syntax) that must not
+	// match reachable blobs, for structured secrets such as key formats or
+	// internal email domains. They are case-sensitive unless they use (?i).
+	ForbiddenRegexes []string
+	// BinaryHitsAsWarnings reports ForbiddenStrings hits inside binary blobs
+	// (NUL in the first 8000 bytes) as warnings rather than failures. Use it
+	// when sync passes binary blobs through without rewriting.
+	BinaryHitsAsWarnings bool
+
+	// ForbiddenIdentities are substrings, such as private email domains,
+	// that must not appear in the "Name <email>" of a commit author,
+	// committer or tagger. ForbiddenStrings are checked there too.
+	ForbiddenIdentities []string
+	// AllowedIdentities, if set, are the only emails (or "Name <email>"
+	// identities) that may author, commit or tag reachable objects.
+	AllowedIdentities []string
+
+	// Gitleaks also scans the history with gitleaks, if it is installed,
+	// and reports its leaks as findings.
+	Gitleaks bool
+
+	// LargestBlobs lists this many of the largest blobs in the report,
+	// whatever their content.
+	LargestBlobs int
+
+	// Allow lists known-acceptable findings (see BaselineFile). They are
+	// left out of the report rather than failing the audit.
+	Allow []AllowEntry
+
+	// Since lists objects (the Tips of an earlier report) whose history was
+	// already audited with the same options; only history reachable from
+	// the refs but not from them is scanned. Objects missing from the repo
+	// are ignored.
+	Since []string
 
 	// ReplaceHistoryWithCurrentFiles are files that should appear to have the same
 	// content throughout history as they do at HEAD.
 	ReplaceHistoryWithCurrentFiles []string
 
-	// MaxBlobBytes skips blobs larger than this size when scanning.
+	// ScanArchives also searches the entries of zip (jar, war, ...), tar
+	// and gzip blobs, and of archives nested in them up to MaxArchiveDepth
+	// levels deep. At most MaxArchiveBytes are decompressed per blob;
+	// archives cut short are reported as "archive-truncated" warnings.
+	ScanArchives    bool
+	MaxArchiveDepth int
+	MaxArchiveBytes int64
+
+	// MaxBlobBytes skips blobs (and archive entries) larger than this size
+	// when scanning.
 	MaxBlobBytes int64
-	// MaxHits limits the number of findings per category (paths/strings).
-	MaxHits int
+	// MaxHits limits the findings of each kind for one forbidden path,
+	// string or pattern; MaxHitsPerKind those of each kind, and
+	// MaxTotalHits all of them. Warnings are limited separately. Findings
+	// over a limit are counted in Report.Omitted.
+	MaxHits        int
+	MaxHitsPerKind int
+	MaxTotalHits   int
+	// Parallelism is the number of cat-file processes searching blobs
+	// (default: one per CPU).
+	Parallelism int
+	// BlobCacheDir, if set, records the blobs found clean under the current
+	// string, pattern and archive options, so that later audits with the
+	// same options search only new blobs.
+	BlobCacheDir string
+
+	// Severities overrides the severity of finding kinds (by default
+	// replace-history-mismatch is medium, binary-string-hit low and the
+	// rest high).
+	Severities map[string]Severity
+	// FailOn is the lowest severity failing the audit; findings below it are
+	// reported as warnings. Empty fails on every finding.
+	FailOn Severity
+
+	// worktree is set by AuditRepo for a working repo: reflog entries are
+	// not audited, nor files that sync always drops.
+	worktree bool
 }
 
 type Finding struct {
-	Kind string // "path-history" | "string-hit" | "replace-history-mismatch"
-
-	Path string
-	Ref  string // commit sha for history-based findings, blob sha for string hits
-
-	Detail string
+	Kind string `json:"kind"` // "path-history" | "string-hit" | "binary-string-hit" | "replace-history-mismatch" | "message-hit" | "identity-hit" | "gitleaks"
+
+	Path string `json:"path,omitempty"`
+	Ref  string `json:"ref,omitempty"` // commit sha for history-based findings, blob sha for string hits
+
+	Detail string `json:"detail,omitempty"`
+	// Lines are the 1-based numbers of the lines holding a string hit, and
+	// Excerpt the first of them with the hit masked.
+	Lines   []int  `json:"lines,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+	// Commits introduced the blob of a string hit, newest first.
+	Commits []string `json:"commits,omitempty"`
+	// Pattern is the forbidden glob, string or regular expression matched,
+	// or the gitleaks rule, if any.
+	Pattern  string   `json:"pattern,omitempty"`
+	Severity Severity `json:"severity,omitempty"`
+	// Fingerprint identifies the finding in an audit baseline.
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 type Report struct {
-	RepoPath  string
-	Findings  []Finding
-	Succeeded bool
+	RepoPath   string    `json:"repo"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Findings   []Finding `json:"findings"`
+	Warnings   []Finding `json:"warnings"`             // reported but do not fail the audit
+	Suppressed int       `json:"suppressed,omitempty"` // findings and warnings allowed by the baseline
+	// Omitted counts, by kind, the findings and warnings over the hit
+	// limits (see Options.MaxHits).
+	Omitted   map[string]int `json:"omitted,omitempty"`
+	Succeeded bool           `json:"succeeded"`
+	// Tips are the objects the refs pointed at, for a later Options.Since.
+	Tips []string `json:"tips,omitempty"`
+	// Incremental is set when only history new since Options.Since was scanned.
+	Incremental bool `json:"incremental,omitempty"`
+	// CachedBlobs is how many blobs were not searched because
+	// Options.BlobCacheDir recorded them clean.
+	CachedBlobs int `json:"cached_blobs,omitempty"`
+	// LargestBlobs are the largest blobs (see Options.LargestBlobs).
+	LargestBlobs []LargeBlob `json:"largest_blobs,omitempty"`
 }
 
 func DefaultOptions() Options {
@@ -65,6 +167,10 @@ func DefaultOptions() Options {
 		CaseInsensitive:  true,
 		MaxBlobBytes:     5 * 1024 * 1024,
 		MaxHits:          20,
+		MaxHitsPerKind:   100,
+		MaxTotalHits:     500,
+		MaxArchiveDepth:  3,
+		MaxArchiveBytes:  64 * 1024 * 1024,
 	}
 }
 
@@ -79,16 +185,34 @@ func AuditBareRepo(ctx context.Context, bareRepoPath string, opts Options) (Repo
 	}
 
 	opts = normalizeOptions(opts)
+	started := time.Now().UTC()
+	regexes, err := compileForbiddenRegexes(opts.ForbiddenRegexes)
+	if err != nil {
+		return Report{}, err
+	}
+	if err := validateSeverities(opts.Severities, opts.FailOn); err != nil {
+		return Report{}, err
+	}
+	tips, err := refTips(ctx, bareRepoPath)
+	if err != nil {
+		return Report{}, err
+	}
+	if opts.Since, err = existingObjects(ctx, bareRepoPath, opts.Since); err != nil {
+		return Report{}, err
+	}
 
-	var findings []Finding
+	var findings, warnings []Finding
+	found, warned := newHitLimits(opts), newHitLimits(opts)
+	cachedBlobs := 0
 
 	// 1) Forbidden paths in reachable history.
-	for _, p := range opts.ForbiddenPaths {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		res, err := gitx.Run(ctx, bareRepoPath, "rev-list", "--all", "--", p)
+	forbiddenPaths, err := expandForbiddenPaths(ctx, bareRepoPath, opts)
+	if err != nil {
+		return Report{}, err
+	}
+	for _, fp := range forbiddenPaths {
+		p := fp.path
+		res, err := gitx.Run(ctx, bareRepoPath, append(historyCmd(opts, "rev-list"), "--", p)...)
 		if err != nil {
 			// If path never existed, git rev-list returns empty output with exit 0;
 			// any errors are unexpected and should fail the audit.
@@ -98,21 +222,31 @@ func AuditBareRepo(ctx context.Context, bareRepoPath string, opts Options) (Repo
 		if len(lines) == 0 {
 			continue
 		}
-		for i, sha := range lines {
-			if i >= opts.MaxHits {
-				break
+		for _, sha := range lines {
+			detail := "path exists in reachable history"
+			if fp.pattern != "" {
+				detail += fmt.Sprintf(" (matches %q)", fp.pattern)
 			}
 			findings = append(findings, Finding{
-				Kind:   "path-history",
-				Path:   p,
-				Ref:    sha,
-				Detail: "path exists in reachable history",
+				Kind:    "path-history",
+				Path:    p,
+				Ref:     sha,
+				Pattern: fp.pattern,
+				Detail:  detail,
 			})
 		}
 	}
 
-	// 2) Replace-history consistency checks.
-	for _, p := range opts.ReplaceHistoryWithCurrentFiles {
+	// 2) Replace-history consistency checks. Globs are expanded against HEAD.
+	replaceFiles := opts.ReplaceHistoryWithCurrentFiles
+	for _, p := range replaceFiles {
+		if scrub.IsGlobPattern(p) {
+			headFiles, _ := gitx.ListFiles(bareRepoPath, "HEAD")
+			replaceFiles = scrub.ExpandReplaceHistory(replaceFiles, headFiles)
+			break
+		}
+	}
+	for _, p := range replaceFiles {
 		p = strings.TrimSpace(p)
 		if p == "" {
 			continue
@@ -140,19 +274,76 @@ func AuditBareRepo(ctx context.Context, bareRepoPath string, opts Options) (Repo
 		}
 	}
 
-	// 3) Forbidden strings in reachable blobs.
-	if len(opts.ForbiddenStrings) > 0 {
-		blobFindings, err := scanReachableBlobsForStrings(ctx, bareRepoPath, opts)
+	// 3) Forbidden strings and patterns in reachable blobs.
+	if len(opts.ForbiddenStrings) > 0 || len(regexes) > 0 {
+		var blobFindings, blobWarnings []Finding
+		blobFindings, blobWarnings, cachedBlobs, err = scanReachableBlobsForStrings(ctx, bareRepoPath, opts, regexes)
 		if err != nil {
 			return Report{}, err
 		}
 		findings = append(findings, blobFindings...)
+		warnings = append(warnings, blobWarnings...)
+	}
+
+	// 4) Forbidden strings and patterns in commit messages.
+	if len(opts.ForbiddenStrings) > 0 || len(regexes) > 0 {
+		msgFindings, err := scanCommitMessages(ctx, bareRepoPath, opts, regexes)
+		if err != nil {
+			return Report{}, err
+		}
+		findings = append(findings, msgFindings...)
+	}
+
+	// 5) Private identities on commits and tags.
+	if len(opts.ForbiddenStrings) > 0 || len(opts.ForbiddenIdentities) > 0 || len(opts.AllowedIdentities) > 0 {
+		idFindings, err := scanIdentities(ctx, bareRepoPath, opts)
+		if err != nil {
+			return Report{}, err
+		}
+		findings = append(findings, idFindings...)
+	}
+
+	// 6) Third-party secret detection.
+	if opts.Gitleaks {
+		leakFindings, leakWarnings, err := runGitleaks(ctx, bareRepoPath, opts)
+		if err != nil {
+			return Report{}, err
+		}
+		findings = append(findings, leakFindings...)
+		warnings = append(warnings, leakWarnings...)
+	}
+
+	// 7) Informational: the largest blobs.
+	var largest []LargeBlob
+	if opts.LargestBlobs > 0 {
+		if largest, err = largestBlobs(ctx, bareRepoPath, opts); err != nil {
+			return Report{}, err
+		}
+	}
+
+	// The hit limits apply to what is left after the baseline, so accepted
+	// findings cannot crowd out new ones.
+	findings, warnings = classify(findings, warnings, opts.Severities, opts.FailOn)
+	findings, suppressed := applyBaseline(findings, opts.Allow)
+	warnings, suppressedWarnings := applyBaseline(warnings, opts.Allow)
+	findings, warnings = found.apply(findings), warned.apply(warnings)
+	if err := addIntroducingCommits(ctx, bareRepoPath, opts, findings, warnings); err != nil {
+		return Report{}, err
 	}
 
 	return Report{
-		RepoPath:  bareRepoPath,
-		Findings:  findings,
-		Succeeded: len(findings) == 0,
+		RepoPath:     bareRepoPath,
+		StartedAt:    started,
+		FinishedAt:   time.Now().UTC(),
+		Findings:     findings,
+		Warnings:     warnings,
+		Suppressed:   suppressed + suppressedWarnings,
+		Omitted:      mergeOmitted(found, warned),
+		CachedBlobs:  cachedBlobs,
+		Succeeded:    len(findings) == 0 && len(found.omitted) == 0,
+		Tips:         tips,
+		Incremental:  len(opts.Since) > 0,
+		LargestBlobs: largest,
 	}, nil
 }
 
@@ -205,6 +396,18 @@ func normalizeOptions(opts Options) Options {
 	if opts.MaxHits <= 0 {
 		opts.MaxHits = DefaultOptions().MaxHits
 	}
+	if opts.MaxHitsPerKind <= 0 {
+		opts.MaxHitsPerKind = DefaultOptions().MaxHitsPerKind
+	}
+	if opts.MaxTotalHits <= 0 {
+		opts.MaxTotalHits = DefaultOptions().MaxTotalHits
+	}
+	if opts.MaxArchiveDepth <= 0 {
+		opts.MaxArchiveDepth = DefaultOptions().MaxArchiveDepth
+	}
+	if opts.MaxArchiveBytes <= 0 {
+		opts.MaxArchiveBytes = DefaultOptions().MaxArchiveBytes
+	}
 	// Normalize and de-dupe forbidden strings.
 	seen := map[string]bool{}
 	out := make([]string, 0, len(opts.ForbiddenStrings))
@@ -227,10 +430,25 @@ func normalizeOptions(opts Options) Options {
 
 	// Normalize file lists.
 	opts.ForbiddenPaths = normalizeStringList(opts.ForbiddenPaths)
+	opts.ForbiddenRegexes = normalizeStringList(opts.ForbiddenRegexes)
+	opts.ForbiddenIdentities = normalizeStringList(opts.ForbiddenIdentities)
+	opts.AllowedIdentities = normalizeStringList(opts.AllowedIdentities)
 	opts.ReplaceHistoryWithCurrentFiles = normalizeStringList(opts.ReplaceHistoryWithCurrentFiles)
 	return opts
 }
 
+func compileForbiddenRegexes(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forbidden regex %q: %w", p, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
 func normalizeStringList(xs []string) []string {
 	seen := map[string]bool{}
 	out := make([]string, 0, len(xs))
@@ -289,11 +507,14 @@ func showFileAt(ctx context.Context, repoPath, ref, p string) ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
-func scanReachableBlobsForStrings(ctx context.Context, repoPath string, opts Options) ([]Finding, error) {
+// scanReachableBlobsForStrings searches the audited blobs for forbidden
+// strings and patterns. It also returns how many blobs were skipped as
+// recorded clean in opts.BlobCacheDir.
+func scanReachableBlobsForStrings(ctx context.Context, repoPath string, opts Options, regexes []*regexp.Regexp) ([]Finding, []Finding, int, error) {
 	// Build sha->path map from `git rev-list --objects --all`.
-	rev, err := gitx.Run(ctx, repoPath, "rev-list", "--objects", "--all")
+	rev, err := gitx.Run(ctx, repoPath, historyCmd(opts, "rev-list", "--objects")...)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	objToPath := map[string]string{}
 	var objList strings.Builder
@@ -320,10 +541,24 @@ func scanReachableBlobsForStrings(ctx context.Context, repoPath string, opts Opt
 	}
 
 	// Filter to blobs using batch-check.
-	blobShas, err := listReachableBlobs(ctx, repoPath, objList.String(), opts.MaxBlobBytes)
+	listed, err := listReachableBlobs(ctx, repoPath, objList.String(), opts.MaxBlobBytes)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	clean, err := loadCleanBlobs(opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
+	var blobShas []string
+	for _, sha := range listed {
+		if opts.worktree && scrub.IsNonNegotiablePath(objToPath[sha]) {
+			continue
+		}
+		if !clean[sha] {
+			blobShas = append(blobShas, sha)
+		}
+	}
+	cached := len(listed) - len(blobShas)
 
 	needles := make([][]byte, 0, len(opts.ForbiddenStrings))
 	for _, s := range opts.ForbiddenStrings {
@@ -333,105 +568,128 @@ func scanReachableBlobsForStrings(ctx context.Context, repoPath string, opts Opt
 		needles = append(needles, []byte(s))
 	}
 
-	// Stream blob contents via `git cat-file --batch` and search.
-	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "cat-file", "--batch")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
+	// Search the blobs with several cat-file processes, each taking every
+	// workers-th blob. Hits are reported in blob order whatever the worker.
+	type hit struct {
+		index   int
+		finding Finding
+		warning bool
 	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	var (
+		mu   sync.Mutex
+		hits []hit
+	)
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := scrub.ScanWorkers(opts.Parallelism, len(blobShas))
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		var shard []string
+		index := map[string]int{}
+		for i := w; i < len(blobShas); i += workers {
+			shard = append(shard, blobShas[i])
+			index[blobShas[i]] = i
+		}
+		go func() {
+			errs <- gitx.ReadObjects(scanCtx, repoPath, shard, func(sha, typ string, payload []byte) error {
+				if typ != "blob" {
+					return nil
+				}
+				path := objToPath[sha]
+				pattern, detail, match := blobHit(payload, needles, regexes, opts)
+				if detail == "" && opts.ScanArchives && isArchive(payload) {
+					ah, truncated := scanArchive(payload, needles, regexes, opts)
+					if truncated {
+						mu.Lock()
+						hits = append(hits, hit{index: index[sha], warning: true, finding: Finding{
+							Kind: "archive-truncated", Path: path, Ref: sha,
+							Detail: fmt.Sprintf("archive expands beyond %d bytes; the rest was not searched", opts.MaxArchiveBytes),
+						}})
+						mu.Unlock()
+					}
+					if ah != nil {
+						path += "!/" + ah.entry
+						payload, pattern, detail, match = ah.content, ah.pattern, ah.detail, ah.match
+					}
+				}
+				if detail == "" {
+					return nil
+				}
+				h := hit{index: index[sha]}
+				binary := scrub.IsBinaryContent(payload)
+				if opts.BinaryHitsAsWarnings && binary {
+					h.warning = true
+					h.finding = Finding{Kind: "binary-string-hit", Path: path, Ref: sha, Pattern: pattern, Detail: "binary blob contains " + detail}
+				} else {
+					h.finding = Finding{Kind: "string-hit", Path: path, Ref: sha, Pattern: pattern, Detail: "contains " + detail}
+				}
+				if !binary {
+					h.finding.Lines, h.finding.Excerpt = lineContext(payload, match)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				hits = append(hits, h)
+				return nil
+			})
+		}()
 	}
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("git cat-file --batch start failed: %w\n%s", err, strings.TrimSpace(stderr.String()))
+	var first error
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	if first != nil {
+		return nil, nil, 0, first
 	}
 
-	go func() {
-		defer stdin.Close()
-		for _, sha := range blobShas {
-			_, _ = io.WriteString(stdin, sha+"\n")
+	hitBlobs := map[string]bool{}
+	for _, h := range hits {
+		hitBlobs[h.finding.Ref] = true
+	}
+	var scannedClean []string
+	for _, sha := range blobShas {
+		if !hitBlobs[sha] {
+			scannedClean = append(scannedClean, sha)
 		}
-	}()
+	}
+	if err := saveCleanBlobs(opts, scannedClean); err != nil {
+		return nil, nil, 0, err
+	}
 
-	r := bufio.NewReader(stdout)
+	sort.Slice(hits, func(i, j int) bool { return hits[i].index < hits[j].index })
 	findings := []Finding{}
-	hitCount := 0
-
-	for range blobShas {
-		// header: "<sha> <type> <size>\n" or "<sha> missing\n"
-		h, err := r.ReadString('\n')
-		if err != nil {
-			_ = cmd.Process.Kill()
-			return nil, fmt.Errorf("git cat-file --batch read header failed: %w", err)
-		}
-		h = strings.TrimSpace(h)
-		if strings.HasSuffix(h, " missing") {
-			continue
-		}
-		parts := strings.Split(h, " ")
-		if len(parts) < 3 {
-			_ = cmd.Process.Kill()
-			return nil, fmt.Errorf("unexpected cat-file header: %q", h)
-		}
-		sha := parts[0]
-		typ := parts[1]
-		sizeStr := parts[2]
-		if typ != "blob" {
-			// Consume payload + newline anyway.
-			sz := parseInt64(sizeStr)
-			if _, err := io.CopyN(io.Discard, r, sz+1); err != nil {
-				_ = cmd.Process.Kill()
-				return nil, err
-			}
-			continue
-		}
-		sz := parseInt64(sizeStr)
-		if sz < 0 {
-			_ = cmd.Process.Kill()
-			return nil, fmt.Errorf("invalid blob size in header: %q", h)
-		}
-
-		payload := make([]byte, sz)
-		if _, err := io.ReadFull(r, payload); err != nil {
-			_ = cmd.Process.Kill()
-			return nil, err
-		}
-		// trailing newline after payload
-		if _, err := r.ReadByte(); err != nil {
-			_ = cmd.Process.Kill()
-			return nil, err
+	warnings := []Finding{}
+	for _, h := range hits {
+		if h.warning {
+			warnings = append(warnings, h.finding)
+		} else {
+			findings = append(findings, h.finding)
 		}
+	}
+	return findings, warnings, cached, nil
+}
 
-		content := payload
-		if opts.CaseInsensitive {
-			content = bytes.ToLower(content)
-		}
-		for i, needle := range needles {
-			if len(needle) == 0 {
-				continue
-			}
-			if bytes.Contains(content, needle) {
-				path := objToPath[sha]
-				findings = append(findings, Finding{
-					Kind:   "string-hit",
-					Path:   path,
-					Ref:    sha,
-					Detail: fmt.Sprintf("contains forbidden string %q", opts.ForbiddenStrings[i]),
-				})
-				hitCount++
-				break
-			}
+// blobHit returns the first forbidden string or pattern in payload, a
+// description of it and a matcher finding it in a line, or returns "".
+func blobHit(payload []byte, needles [][]byte, regexes []*regexp.Regexp, opts Options) (string, string, matcher) {
+	content := payload
+	if opts.CaseInsensitive {
+		content = bytes.ToLower(content)
+	}
+	for i, needle := range needles {
+		if len(needle) > 0 && scrub.IndexWord(content, needle, opts.MatchWholeWord) >= 0 {
+			return opts.ForbiddenStrings[i], fmt.Sprintf("forbidden string %q", opts.ForbiddenStrings[i]), needleMatcher(needle, opts)
 		}
-		if hitCount >= opts.MaxHits {
-			break
+	}
+	for _, re := range regexes {
+		if re.Match(payload) {
+			return re.String(), fmt.Sprintf("forbidden pattern %q", re.String()), re.FindIndex
 		}
 	}
-
-	_ = cmd.Wait()
-	return findings, nil
+	return "", "", nil
 }
 
 func listReachableBlobs(ctx context.Context, repoPath, revListObjectsAllStdout string, maxBlobBytes int64) ([]string, error) {
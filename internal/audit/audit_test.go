@@ -1,11 +1,23 @@
 package audit
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/obinnaokechukwu/git-copy/internal/config"
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
 )
 
@@ -214,3 +226,1064 @@ func TestAuditBareRepo_ReplaceHistoryOK(t *testing.T) {
 	}
 }
 
+func TestAuditBareRepo_BinaryHitsAsWarnings(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+
+	commitFiles(t, src, map[string]string{"logo.png": "\x89PNG\x00obinnaokechukwu"}, "add logo")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	opts.BinaryHitsAsWarnings = true
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded {
+		t.Fatalf("expected binary hit not to fail the audit, got: %#v", rep.Findings)
+	}
+	if len(rep.Warnings) != 1 || rep.Warnings[0].Kind != "binary-string-hit" || rep.Warnings[0].Path != "logo.png" {
+		t.Fatalf("expected one binary-string-hit warning for logo.png, got: %#v", rep.Warnings)
+	}
+}
+
+func TestTargetReport_JSON(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tr := TargetReport{
+		Target:    "github",
+		Repo:      "/src/private",
+		StartedAt: at, FinishedAt: at,
+		Local: &Report{
+			RepoPath:  "/cache/github.git",
+			StartedAt: at, FinishedAt: at,
+			Warnings:  []Finding{{Kind: "binary-string-hit", Path: "logo.png", Ref: "abc123", Detail: "obinnaokechukwu"}},
+			Succeeded: true,
+		},
+		Succeeded: true,
+	}
+	b, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `{"target":"github","repo":"/src/private","started_at":"2024-05-01T12:00:00Z","finished_at":"2024-05-01T12:00:00Z","succeeded":true,` +
+		`"local":{"repo":"/cache/github.git","started_at":"2024-05-01T12:00:00Z","finished_at":"2024-05-01T12:00:00Z","findings":[],` +
+		`"warnings":[{"kind":"binary-string-hit","path":"logo.png","ref":"abc123","detail":"obinnaokechukwu"}],"succeeded":true}}`
+	if string(b) != want {
+		t.Errorf("JSON =\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	reports := []TargetReport{{
+		Target: "github",
+		Local: &Report{
+			Findings: []Finding{{Kind: "string-hit", Path: "src/a.go", Ref: "abc123", Detail: "contains forbidden string"}},
+			Warnings: []Finding{{Kind: "custom-kind", Detail: "no location"}},
+		},
+		Remote: &Report{Findings: []Finding{{Kind: "path-history", Path: ".env", Ref: "def456", Detail: "path exists in reachable history"}}},
+	}}
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, reports); err != nil {
+		t.Fatalf("WriteSARIF: %v", err)
+	}
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				RuleIndex int    `json:"ruleIndex"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				Properties map[string]string `json:"properties"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("Unmarshal: %v\n%s", err, buf.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+	run := log.Runs[0]
+	if len(run.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(run.Results))
+	}
+	for _, res := range run.Results {
+		if run.Tool.Driver.Rules[res.RuleIndex].ID != res.RuleID {
+			t.Errorf("result %s has rule index %d", res.RuleID, res.RuleIndex)
+		}
+	}
+	hit, custom, path := run.Results[0], run.Results[1], run.Results[2]
+	if hit.Level != "error" || hit.Locations[0].PhysicalLocation.ArtifactLocation.URI != "src/a.go" || hit.Properties["ref"] != "abc123" || hit.Properties["scope"] != "local" {
+		t.Errorf("string hit result = %+v", hit)
+	}
+	if custom.Level != "warning" || len(custom.Locations) != 0 {
+		t.Errorf("warning result = %+v", custom)
+	}
+	if path.Properties["scope"] != "remote" || path.Properties["target"] != "github" {
+		t.Errorf("remote result = %+v", path)
+	}
+}
+
+func TestAuditBareRepo_FindsForbiddenRegex(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{
+		"owners.txt": "alice@mycompany.internal\n",
+		"readme.txt": "mycompany.internal.example.com\n",
+	}, "add owners")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = nil
+	opts.ForbiddenRegexes = []string{`@mycompany\.internal\b`}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Succeeded || len(rep.Findings) != 1 {
+		t.Fatalf("expected one finding, got: %#v", rep.Findings)
+	}
+	if f := rep.Findings[0]; f.Kind != "string-hit" || f.Path != "owners.txt" || !strings.Contains(f.Detail, "forbidden pattern") {
+		t.Fatalf("unexpected finding: %#v", f)
+	}
+
+	opts.ForbiddenRegexes = []string{"("}
+	if _, err := AuditBareRepo(ctx, bare, opts); err == nil {
+		t.Fatalf("expected an error for an invalid regex")
+	}
+}
+
+func TestAuditBareRepo_FindsPrivateIdentities(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	_, _ = gitx.Run(ctx, src, "config", "user.name", "Public")
+	_, _ = gitx.Run(ctx, src, "config", "user.email", "public@example.com")
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "public commit")
+	if _, err := gitx.Run(ctx, src, "commit", "--allow-empty", "-m", "private author", "--author", "Alice <alice@corp.example>"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	_, _ = gitx.Run(ctx, src, "config", "user.name", "obinnaokechukwu")
+	if _, err := gitx.Run(ctx, src, "tag", "-a", "v1", "-m", "v1"); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	opts.ForbiddenIdentities = []string{"@corp.example"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	var details []string
+	for _, f := range rep.Findings {
+		if f.Kind == "identity-hit" {
+			details = append(details, f.Detail)
+		}
+	}
+	want := []string{
+		`author "Alice <alice@corp.example>" contains forbidden string "@corp.example"`,
+		`tagger "obinnaokechukwu <public@example.com>" contains forbidden string "obinnaokechukwu"`,
+	}
+	if strings.Join(details, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("identity findings = %q, want %q", details, want)
+	}
+
+	opts.ForbiddenStrings = nil
+	opts.ForbiddenIdentities = nil
+	opts.AllowedIdentities = []string{"public@example.com"}
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(rep.Findings) != 1 || rep.Findings[0].Detail != `author "Alice <alice@corp.example>" is not an allowed identity` {
+		t.Fatalf("expected one disallowed author, got: %#v", rep.Findings)
+	}
+}
+
+func TestAuditBareRepo_FindsForbiddenMessage(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "Add a\n\nAsked for by Obinnaokechukwu in the planning doc")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Succeeded || len(rep.Findings) != 1 {
+		t.Fatalf("expected one finding, got: %#v", rep.Findings)
+	}
+	f := rep.Findings[0]
+	want := `message contains forbidden string "obinnaokechukwu": "Asked for by [redacted] in the planning doc"`
+	if f.Kind != "message-hit" || f.Detail != want || len(f.Ref) != 40 {
+		t.Fatalf("finding = %#v, want message-hit %q", f, want)
+	}
+}
+
+func TestAuditBareRepo_Gitleaks(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	bare := filepath.Join(tmp, "bare.git")
+	if _, err := gitx.Run(ctx, "", "init", "--bare", bare); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.Gitleaks = true
+
+	// Without gitleaks on PATH the scan is skipped with a warning.
+	gitDir := filepath.Dir(mustLookPath(t, "git"))
+	t.Setenv("PATH", gitDir)
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded || len(rep.Warnings) != 1 || rep.Warnings[0].Kind != "gitleaks-unavailable" {
+		t.Fatalf("expected a gitleaks-unavailable warning, got: %#v", rep)
+	}
+
+	// A stub gitleaks writing a report to --report-path.
+	bin := filepath.Join(tmp, "bin")
+	if err := os.MkdirAll(bin, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--report-path" ]; then out="$2"; fi
+  shift
+done
+cat > "$out" <<'JSON'
+[{"RuleID": "aws-access-token", "Description": "AWS Access Token", "File": "config.env", "Commit": "0123456789abcdef0123456789abcdef01234567", "StartLine": 3, "Secret": "AKIA..."}]
+JSON
+`
+	if err := os.WriteFile(filepath.Join(bin, "gitleaks"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+gitDir+string(os.PathListSeparator)+"/bin")
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	want := Finding{Kind: "gitleaks", Path: "config.env", Ref: "0123456789abcdef0123456789abcdef01234567", Pattern: "aws-access-token", Detail: "AWS Access Token (rule aws-access-token, line 3)", Severity: SeverityHigh}
+	want.Fingerprint = FingerprintOf(want)
+	if rep.Succeeded || len(rep.Findings) != 1 || !reflect.DeepEqual(rep.Findings[0], want) {
+		t.Fatalf("findings = %#v, want %#v", rep.Findings, want)
+	}
+}
+
+func TestAuditBareRepo_Baseline(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"history.md": "formerly obinnaokechukwu/tool\n", "new.txt": "obinnaokechukwu\n"}, "add files")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(rep.Findings) != 2 {
+		t.Fatalf("expected two findings, got: %#v", rep.Findings)
+	}
+	byPath := map[string]Finding{}
+	for _, f := range rep.Findings {
+		byPath[f.Path] = f
+	}
+
+	bl, err := ParseBaseline([]byte(`{"allow": [{"path": "history.md", "ref": "` + byPath["history.md"].Ref + `"}]}`))
+	if err != nil {
+		t.Fatalf("ParseBaseline: %v", err)
+	}
+	opts.Allow = bl.Allow
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Succeeded || rep.Suppressed != 1 || len(rep.Findings) != 1 || rep.Findings[0].Path != "new.txt" {
+		t.Fatalf("expected only new.txt to be reported, got: %#v", rep)
+	}
+
+	// Baselined findings do not count against the hit limits.
+	opts.MaxHits = 1
+	for _, keep := range []string{"history.md", "new.txt"} {
+		other := map[string]string{"history.md": "new.txt", "new.txt": "history.md"}[keep]
+		opts.Allow = []AllowEntry{{Fingerprint: byPath[other].Fingerprint}}
+		rep, err = AuditBareRepo(ctx, bare, opts)
+		if err != nil {
+			t.Fatalf("AuditBareRepo: %v", err)
+		}
+		if rep.Succeeded || len(rep.Findings) != 1 || rep.Findings[0].Path != keep || len(rep.Omitted) != 0 {
+			t.Fatalf("expected %s to be reported with MaxHits 1, got: %#v", keep, rep)
+		}
+	}
+	opts.MaxHits = DefaultOptions().MaxHits
+
+	opts.Allow = append(bl.Allow, AllowEntry{Fingerprint: byPath["new.txt"].Fingerprint})
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded || rep.Suppressed != 2 {
+		t.Fatalf("expected every finding to be baselined, got: %#v", rep)
+	}
+
+	if _, err := ParseBaseline([]byte(`{"allow": [{"path": "history.md"}]}`)); err == nil {
+		t.Fatalf("expected an error for an entry without a ref")
+	}
+}
+
+// newTestRepo creates a repo at dir, on main, committing as dev.
+func newTestRepo(t *testing.T, dir string) string {
+	t.Helper()
+	ctx := context.Background()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := gitx.Run(ctx, dir, "init", "-b", "main"); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	_, _ = gitx.Run(ctx, dir, "config", "user.name", "dev")
+	_, _ = gitx.Run(ctx, dir, "config", "user.email", "dev@example.com")
+	return dir
+}
+
+// commitFiles writes files into repo and commits every change in the work
+// tree as msg.
+func commitFiles(t *testing.T, repo string, files map[string]string, msg string) {
+	t.Helper()
+	ctx := context.Background()
+	for p, content := range files {
+		full := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+	if _, err := gitx.Run(ctx, repo, "add", "-A"); err != nil {
+		t.Fatalf("git add: %v", err)
+	}
+	if _, err := gitx.Run(ctx, repo, "commit", "-m", msg); err != nil {
+		t.Fatalf("git commit: %v", err)
+	}
+}
+
+// cloneBare clones repo into a bare repo at dir, the shape audits read.
+func cloneBare(t *testing.T, repo, dir string) string {
+	t.Helper()
+	if _, err := gitx.Run(context.Background(), "", "clone", "--bare", repo, dir); err != nil {
+		t.Fatalf("clone --bare: %v", err)
+	}
+	return dir
+}
+
+func mustLookPath(t *testing.T, name string) string {
+	t.Helper()
+	p, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not installed", name)
+	}
+	return p
+}
+
+func TestAuditBareRepo_Incremental(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(name, content string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{name: content}, "add "+name)
+	}
+	commit("old.txt", "obinnaokechukwu\n")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = []string{".env"}
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	first, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(first.Tips) != 1 || first.Incremental {
+		t.Fatalf("unexpected first report: %#v", first)
+	}
+
+	// Only history added after the first audit is scanned.
+	commit(".env", "TOKEN=obinnaokechukwu\n")
+	if _, err := gitx.Run(ctx, bare, "fetch", src, "+refs/heads/*:refs/heads/*"); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	opts.Since = first.Tips
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Incremental {
+		t.Fatalf("expected an incremental audit")
+	}
+	paths := map[string]bool{}
+	for _, f := range rep.Findings {
+		paths[f.Kind+" "+f.Path] = true
+	}
+	if len(rep.Findings) != 2 || !paths["string-hit .env"] || !paths["path-history .env"] {
+		t.Fatalf("expected only the new .env findings, got: %#v", rep.Findings)
+	}
+
+	// Unknown objects are ignored, giving a full audit.
+	opts.Since = []string{"0123456789abcdef0123456789abcdef01234567"}
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Incremental || len(rep.Findings) != 3 {
+		t.Fatalf("expected a full audit, got: %#v", rep)
+	}
+	if OptionsHash(opts) != OptionsHash(Options{ForbiddenPaths: opts.ForbiddenPaths, ForbiddenStrings: opts.ForbiddenStrings, CaseInsensitive: true}) {
+		t.Fatalf("OptionsHash should ignore Since and defaults")
+	}
+}
+
+func TestAuditBareRepo_ParallelBlobScan(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	files := map[string]string{}
+	for i := 0; i < 3000; i++ {
+		content := fmt.Sprintf("file %d\n", i)
+		if i%1000 == 7 {
+			content += "obinnaokechukwu\n"
+		}
+		files[fmt.Sprintf("f%04d.txt", i)] = content
+	}
+	commitFiles(t, src, files, "add files")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	opts.Parallelism = 1
+	serial, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	opts.Parallelism = 3
+	parallel, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(serial.Findings) != 3 {
+		t.Fatalf("expected three findings, got: %#v", serial.Findings)
+	}
+	for i := range serial.Findings {
+		if !reflect.DeepEqual(serial.Findings[i], parallel.Findings[i]) {
+			t.Fatalf("parallel findings %#v differ from serial %#v", parallel.Findings, serial.Findings)
+		}
+	}
+
+	opts.MaxHits = 2
+	capped, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(capped.Findings) != 2 {
+		t.Fatalf("expected MaxHits to cap findings, got: %#v", capped.Findings)
+	}
+	if capped.Omitted["string-hit"] != 1 {
+		t.Fatalf("expected one omitted string-hit, got: %v", capped.Omitted)
+	}
+}
+
+func TestAuditBareRepo_FailOnSeverity(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for _, content := range []string{"v1\n", "v2\n"} {
+		commitFiles(t, src, map[string]string{"LICENSE": content}, "license")
+	}
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ReplaceHistoryWithCurrentFiles = []string{"LICENSE"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Succeeded || len(rep.Findings) != 1 || rep.Findings[0].Severity != SeverityMedium {
+		t.Fatalf("expected one medium finding, got: %#v", rep.Findings)
+	}
+
+	opts.FailOn = SeverityHigh
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded || len(rep.Warnings) != 1 || rep.Warnings[0].Kind != "replace-history-mismatch" {
+		t.Fatalf("expected the medium finding as a warning, got: %#v", rep)
+	}
+
+	opts.Severities = map[string]Severity{"replace-history-mismatch": SeverityHigh}
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if rep.Succeeded {
+		t.Fatalf("expected the overridden severity to fail the audit")
+	}
+
+	opts.Severities = map[string]Severity{"replace-history-mismatch": "urgent"}
+	if _, err := AuditBareRepo(ctx, bare, opts); err == nil {
+		t.Fatalf("expected an error for an invalid severity")
+	}
+}
+
+func TestAuditBareRepo_ForbiddenPathGlobs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	// The files share a blob; each path must still be reported.
+	commitFiles(t, src, map[string]string{"svc/api/.env.local": "same\n", "keys/id_rsa.pub": "same\n", "README.md": "same\n"}, "add files")
+	_, _ = gitx.Run(ctx, src, "rm", "-q", "svc/api/.env.local")
+	_, _ = gitx.Run(ctx, src, "commit", "-m", "remove env")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = []string{"**/.env*", "**/id_rsa*"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	got := map[string]int{}
+	for _, f := range rep.Findings {
+		if f.Kind == "path-history" {
+			got[f.Path]++
+		}
+	}
+	if len(got) != 2 || got["svc/api/.env.local"] != 2 || got["keys/id_rsa.pub"] != 1 {
+		t.Fatalf("unexpected path-history findings: %v (%#v)", got, rep.Findings)
+	}
+	if d := rep.Findings[0].Detail; !strings.Contains(d, "matches") {
+		t.Fatalf("expected the detail to name the glob, got %q", d)
+	}
+}
+
+func TestAuditBareRepo_StringHitContext(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	content := "# Setup\nclone https://github.com/Obinnaokechukwu/tool\n\nask obinnaokechukwu for access\n"
+	write := func(name string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{name: content}, "add "+name)
+	}
+	write("SETUP.md")
+	first, _ := gitx.Run(ctx, src, "rev-parse", "HEAD")
+	commitFiles(t, src, map[string]string{"other.txt": "x\n"}, "unrelated")
+	write("docs.md")
+	third, _ := gitx.Run(ctx, src, "rev-parse", "HEAD")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if len(rep.Findings) != 1 {
+		t.Fatalf("expected one finding, got: %#v", rep.Findings)
+	}
+	f := rep.Findings[0]
+	if !reflect.DeepEqual(f.Lines, []int{2, 4}) || f.Excerpt != "clone https://github.com/[redacted]/tool" {
+		t.Fatalf("lines = %v, excerpt = %q", f.Lines, f.Excerpt)
+	}
+	want := []string{strings.TrimSpace(third.Stdout), strings.TrimSpace(first.Stdout)}
+	if !reflect.DeepEqual(f.Commits, want) {
+		t.Fatalf("commits = %v, want %v", f.Commits, want)
+	}
+}
+
+func TestAuditBareRepo_NotesPullAndReplaceRefs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	run := func(args ...string) string {
+		t.Helper()
+		res, err := gitx.Run(ctx, src, args...)
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(res.Stdout)
+	}
+	commitFiles(t, src, map[string]string{"a.txt": "secret-in-replaced\n"}, "original")
+	original := run("rev-parse", "HEAD")
+	run("notes", "add", "-m", "secret-in-note", original)
+
+	// A pull request head, only reachable from refs/pull.
+	emptyTree := run("hash-object", "-t", "tree", "-w", "--stdin")
+	run("update-ref", "refs/pull/1/head", run("commit-tree", emptyTree, "-m", "secret-in-pr"))
+
+	// A replacement hiding the original commit's content.
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("clean\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	run("add", ".")
+	tree := run("write-tree")
+	replacement := run("commit-tree", tree, "-m", "original")
+	run("replace", original, replacement)
+	run("checkout", "-q", "-f", "main")
+
+	bare := filepath.Join(tmp, "bare.git")
+	if _, err := gitx.Run(ctx, "", "clone", "--mirror", src, bare); err != nil {
+		t.Fatalf("clone --mirror: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"secret-in-replaced", "secret-in-note", "secret-in-pr"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	var details []string
+	for _, f := range rep.Findings {
+		details = append(details, f.Detail)
+	}
+	all := strings.Join(details, "\n")
+	for _, s := range opts.ForbiddenStrings {
+		if !strings.Contains(all, s) {
+			t.Errorf("expected a finding for %q, got:\n%s", s, all)
+		}
+	}
+}
+
+func TestAuditRemoteRefs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	run := func(dir string, args ...string) string {
+		t.Helper()
+		res, err := gitx.Run(ctx, dir, args...)
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(res.Stdout)
+	}
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "one")
+	run(src, "tag", "-a", "v1", "-m", "v1")
+	run(src, "branch", "dev")
+
+	local := filepath.Join(tmp, "local.git")
+	remote := filepath.Join(tmp, "remote.git")
+	run("", "clone", "--mirror", src, local)
+	run("", "clone", "--mirror", src, remote)
+
+	opts := DefaultOptions()
+	rep, err := AuditRemoteRefs(ctx, local, remote, nil, opts)
+	if err != nil {
+		t.Fatalf("AuditRemoteRefs: %v", err)
+	}
+	if !rep.Succeeded {
+		t.Fatalf("expected matching refs to pass, got %+v", rep.Findings)
+	}
+
+	// Out-of-band pushes: a new branch, a moved branch and a pull request
+	// ref, which the forge creates itself.
+	emptyTree := run(remote, "hash-object", "-t", "tree", "-w", "--stdin")
+	other := run(remote, "-c", "user.name=dev", "-c", "user.email=dev@example.com", "commit-tree", emptyTree, "-m", "other")
+	run(remote, "update-ref", "refs/heads/extra", other)
+	run(remote, "update-ref", "refs/heads/dev", other)
+	run(remote, "update-ref", "refs/pull/1/head", other)
+
+	rep, err = AuditRemoteRefs(ctx, local, remote, nil, opts)
+	if err != nil {
+		t.Fatalf("AuditRemoteRefs: %v", err)
+	}
+	var got []string
+	for _, f := range rep.Findings {
+		got = append(got, f.Kind+" "+f.Detail)
+	}
+	want := []string{
+		"remote-ref-mismatch remote ref refs/heads/dev points at " + other + ", git-copy pushed " + run(local, "rev-parse", "refs/heads/dev"),
+		"unknown-remote-ref remote ref refs/heads/extra was not produced by git-copy",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("findings mismatch:\n got %q\nwant %q", got, want)
+	}
+
+	// Only the target's namespaces belong to git-copy.
+	rep, err = AuditRemoteRefs(ctx, local, remote, []string{"refs/tags/"}, opts)
+	if err != nil {
+		t.Fatalf("AuditRemoteRefs: %v", err)
+	}
+	if !rep.Succeeded {
+		t.Fatalf("expected refs outside the namespaces to be ignored, got %+v", rep.Findings)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	reports := []TargetReport{{
+		Target: "github",
+		Local: &Report{
+			RepoPath: "/cache/github.git",
+			Findings: []Finding{
+				{Kind: "string-hit", Path: "src/a.go", Ref: "blob1", Detail: "contains forbidden string \"<acme>\"", Commits: []string{"commit1"}},
+				{Kind: "path-history", Path: ".env", Ref: "commit2", Detail: "path exists in reachable history"},
+				{Kind: "string-hit", Path: "src/b.go", Ref: "blob2", Detail: "contains forbidden string"},
+			},
+		},
+	}}
+	link := func(target, sha string) string { return "https://example.com/" + target + "/commit/" + sha }
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, reports, link); err != nil {
+		t.Fatalf("WriteHTML: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<code>path-history</code> (1)",
+		"<code>string-hit</code> (2)",
+		`<a href="https://example.com/github/commit/commit1">`,
+		`<a href="https://example.com/github/commit/commit2">`,
+		"&lt;acme&gt;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+	// Blobs are not commits and are not linked.
+	if strings.Contains(out, "commit/blob1") {
+		t.Errorf("report links a blob:\n%s", out)
+	}
+	if strings.Index(out, "path-history") > strings.Index(out, "string-hit") {
+		t.Errorf("kinds are not sorted:\n%s", out)
+	}
+}
+
+func TestAuditBareRepo_ScanArchives(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+
+	// A jar nested in a zip, and a tarball.
+	zipOf := func(name string, content []byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		w.Write(content)
+		if err := zw.Close(); err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		return buf.Bytes()
+	}
+	bundle := zipOf("lib/inner.jar", zipOf("config.properties", []byte("a=1\nuser=obinnaokechukwu\n")))
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	readme := []byte("by obinnaokechukwu\n")
+	tw.WriteHeader(&tar.Header{Name: "pkg/README", Mode: 0o644, Size: int64(len(readme)), Typeflag: tar.TypeReg})
+	tw.Write(readme)
+	tw.Close()
+	gw.Close()
+	commitFiles(t, src, map[string]string{"bundle.zip": string(bundle), "pkg.tar.gz": tgz.String()}, "archives")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded {
+		t.Fatalf("expected compressed hits to be missed without ScanArchives, got %+v", rep.Findings)
+	}
+
+	opts.ScanArchives = true
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	var got []string
+	for _, f := range rep.Findings {
+		got = append(got, fmt.Sprintf("%s %s %v", f.Kind, f.Path, f.Lines))
+	}
+	want := []string{
+		"string-hit bundle.zip!/lib/inner.jar!/config.properties [2]",
+		"string-hit pkg.tar.gz!/pkg/README [1]",
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("findings mismatch:\n got %q\nwant %q", got, want)
+	}
+
+	// Below the size limit nothing is found, and the audit says so.
+	opts.MaxArchiveBytes = 8
+	rep, err = AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded || len(rep.Warnings) != 2 || rep.Warnings[0].Kind != "archive-truncated" {
+		t.Fatalf("expected two archive-truncated warnings, got findings %+v, warnings %+v", rep.Findings, rep.Warnings)
+	}
+}
+
+func TestHitLimits(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxHits, opts.MaxHitsPerKind, opts.MaxTotalHits = 2, 3, 4
+	l := newHitLimits(opts)
+	var allowed []string
+	for _, h := range []struct{ kind, pattern string }{
+		{"string-hit", "a"}, {"string-hit", "a"}, {"string-hit", "a"}, // third "a" is over MaxHits
+		{"string-hit", "b"}, {"string-hit", "b"}, // second "b" is over MaxHitsPerKind
+		{"message-hit", "a"}, {"path-history", ".env"}, // the path is over MaxTotalHits
+	} {
+		if l.allow(h.kind, h.pattern) {
+			allowed = append(allowed, h.kind+" "+h.pattern)
+		}
+	}
+	want := []string{"string-hit a", "string-hit a", "string-hit b", "message-hit a"}
+	if !reflect.DeepEqual(allowed, want) {
+		t.Fatalf("allowed %q, want %q", allowed, want)
+	}
+	if got := mergeOmitted(l); !reflect.DeepEqual(got, map[string]int{"string-hit": 2, "path-history": 1}) {
+		t.Fatalf("omitted = %v", got)
+	}
+}
+
+func TestAuditBareRepo_BlobCache(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "clean\n", "b.txt": "also clean\n", "c.txt": "obinnaokechukwu\n"}, "one")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.ForbiddenStrings = []string{"obinnaokechukwu"}
+	opts.BlobCacheDir = filepath.Join(tmp, "cache")
+	first, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	second, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if first.CachedBlobs != 0 || second.CachedBlobs != 2 {
+		t.Fatalf("cached blobs = %d then %d, want 0 then 2", first.CachedBlobs, second.CachedBlobs)
+	}
+	// Blobs with hits are searched every time.
+	if len(second.Findings) != 1 || second.Findings[0].Path != "c.txt" {
+		t.Fatalf("expected the hit in c.txt again, got %+v", second.Findings)
+	}
+
+	// Other strings invalidate the cache.
+	opts.ForbiddenStrings = []string{"also"}
+	third, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if third.CachedBlobs != 0 || len(third.Findings) != 1 || third.Findings[0].Path != "b.txt" {
+		t.Fatalf("expected a fresh scan finding b.txt, got %d cached, %+v", third.CachedBlobs, third.Findings)
+	}
+
+	// The cache does not change what an audit reports.
+	uncached := opts
+	uncached.BlobCacheDir = ""
+	if OptionsHash(opts) != OptionsHash(uncached) {
+		t.Fatalf("OptionsHash depends on BlobCacheDir")
+	}
+}
+
+func TestAuditBareRepo_LargestBlobs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	run := func(args ...string) string {
+		t.Helper()
+		res, err := gitx.Run(ctx, src, args...)
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(res.Stdout)
+	}
+	files := map[string]string{}
+	for name, size := range map[string]int{"small.txt": 10, "dump.sql": 5000, "build/app.bin": 20000} {
+		files[name] = strings.Repeat(name[:1], size)
+	}
+	commitFiles(t, src, files, "artifacts")
+	commit := run("rev-parse", "HEAD")
+
+	bare := cloneBare(t, src, filepath.Join(tmp, "bare.git"))
+
+	opts := DefaultOptions()
+	opts.ForbiddenPaths = nil
+	opts.LargestBlobs = 2
+	rep, err := AuditBareRepo(ctx, bare, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	if !rep.Succeeded {
+		t.Fatalf("large blobs must not fail the audit, got %+v", rep.Findings)
+	}
+	var got []string
+	for _, b := range rep.LargestBlobs {
+		got = append(got, fmt.Sprintf("%s %d %v", b.Path, b.Size, b.Commits))
+	}
+	want := []string{
+		fmt.Sprintf("build/app.bin 20000 [%s]", commit),
+		fmt.Sprintf("dump.sql 5000 [%s]", commit),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("largest blobs:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	cfg := config.RepoConfig{PrivateUsername: "alice"}
+	cfg.Defaults.Exclude = []string{".env"}
+	opts := DefaultOptions()
+	opts.ForbiddenRegexes = []string{`JIRA-\d+`}
+	findings := []Finding{
+		{Kind: "path-history", Path: ".env"}, // already excluded
+		{Kind: "path-history", Path: "config/.env.backup"},
+		{Kind: "string-hit", Path: "docs/a.md", Pattern: "acme-internal"},
+		{Kind: "string-hit", Path: "docs/b.md", Pattern: "acme-internal"},
+		{Kind: "string-hit", Path: "notes.txt", Pattern: "alice"},
+		{Kind: "string-hit", Path: "dist/app.jar!/config.properties", Pattern: "acme-internal"},
+		{Kind: "binary-string-hit", Path: "logo.png", Pattern: "alice"},
+		{Kind: "message-hit", Pattern: `JIRA-\d+`},
+		{Kind: "identity-hit", Pattern: "alice"},
+	}
+	var got []string
+	for _, s := range Suggest(cfg, opts, findings) {
+		got = append(got, s.String())
+	}
+	want := []string{
+		`add "config/.env.backup" to defaults.exclude (path-history)`,
+		`add "acme-internal": "REDACTED" to defaults.extra_replacements (string-hit)`,
+		`add "notes.txt" to defaults.redact (string-hit)`,
+		`add "dist/app.jar" to defaults.exclude (string-hit)`,
+		`add "logo.png" to defaults.exclude (binary-string-hit)`,
+		`add "JIRA-\\d+" to defaults.messages.strip_patterns (message-hit)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("suggestions:\n got %q\nwant %q", got, want)
+	}
+
+	for _, s := range Suggest(cfg, opts, findings) {
+		if !s.Apply(&cfg) {
+			t.Fatalf("%s: not applied", s)
+		}
+	}
+	if got := Suggest(cfg, opts, findings); len(got) != 0 {
+		t.Fatalf("suggestions after applying them: %v", got)
+	}
+}
+
+func TestAuditRepo_Worktree(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo(t, filepath.Join(t.TempDir(), "src"))
+	commitFiles(t, src, map[string]string{
+		"README.md":             "talks to acme-internal\n",
+		".env":                  "TOKEN=x\n",
+		".git-copy/config.json": `{"extra_replacements": {"acme-internal": "REDACTED"}}` + "\n",
+	}, "init")
+	// Only the reflog keeps the amended commit.
+	commitFiles(t, src, map[string]string{"notes.txt": "draft for acme-staging\n"}, "notes")
+	if err := os.WriteFile(filepath.Join(src, "notes.txt"), []byte("draft\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := gitx.Run(ctx, src, "commit", "-a", "--amend", "-m", "notes"); err != nil {
+		t.Fatalf("commit --amend: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.ForbiddenStrings = []string{"acme-internal", "acme-staging"}
+	rep, err := AuditRepo(ctx, filepath.Join(src, ".git-copy"), opts)
+	if err != nil {
+		t.Fatalf("AuditRepo: %v", err)
+	}
+	var got []string
+	for _, f := range rep.Findings {
+		got = append(got, f.Kind+" "+f.Path)
+	}
+	sort.Strings(got)
+	want := []string{"path-history .env", "string-hit README.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("findings %q, want %q", got, want)
+	}
+
+	// Audited as a mirror, the reflog and .git-copy count.
+	rep, err = AuditBareRepo(ctx, src, opts)
+	if err != nil {
+		t.Fatalf("AuditBareRepo: %v", err)
+	}
+	kinds := map[string]bool{}
+	for _, f := range rep.Findings {
+		kinds[f.Kind+" "+f.Path] = true
+	}
+	for _, k := range []string{"path-history .git-copy", "string-hit notes.txt"} {
+		if !kinds[k] {
+			t.Fatalf("AuditBareRepo findings lack %q: %v", k, rep.Findings)
+		}
+	}
+}
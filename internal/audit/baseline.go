@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// BaselineFile is the private repo path of the audit baseline, which lists
+// known-acceptable findings.
+const BaselineFile = ".git-copy/audit-allow.json"
+
+// Baseline is the content of BaselineFile.
+type Baseline struct {
+	Allow []AllowEntry `json:"allow"`
+}
+
+// AllowEntry accepts a finding, either by its Fingerprint or by its Path and
+// Ref (the blob sha of a string hit, the commit of a path-history finding),
+// optionally restricted to one Kind.
+type AllowEntry struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Ref         string `json:"ref,omitempty"`
+	Reason      string `json:"reason,omitempty"` // for humans; ignored
+}
+
+// ParseBaseline parses an audit baseline file.
+func ParseBaseline(b []byte) (Baseline, error) {
+	var bl Baseline
+	if err := json.Unmarshal(b, &bl); err != nil {
+		return Baseline{}, fmt.Errorf("invalid %s: %w", BaselineFile, err)
+	}
+	for i, e := range bl.Allow {
+		if e.Fingerprint == "" && (e.Path == "" || e.Ref == "") {
+			return Baseline{}, fmt.Errorf("invalid %s: entry %d needs a fingerprint, or a path and a ref", BaselineFile, i)
+		}
+	}
+	return bl, nil
+}
+
+// FingerprintOf returns a stable id for f, derived from its kind, path, ref
+// and detail, for use in a baseline.
+func FingerprintOf(f Finding) string {
+	sum := sha256.Sum256([]byte(f.Kind + "\x00" + f.Path + "\x00" + f.Ref + "\x00" + f.Detail))
+	return fmt.Sprintf("%x", sum[:16])
+}
+
+func (e AllowEntry) matches(f Finding) bool {
+	if e.Kind != "" && e.Kind != f.Kind {
+		return false
+	}
+	if e.Fingerprint != "" {
+		return e.Fingerprint == f.Fingerprint
+	}
+	return e.Path == f.Path && e.Ref == f.Ref
+}
+
+// applyBaseline fingerprints findings and drops those allowed by entries,
+// returning the remaining findings and the number dropped.
+func applyBaseline(findings []Finding, entries []AllowEntry) ([]Finding, int) {
+	out := findings[:0]
+	suppressed := 0
+	for _, f := range findings {
+		f.Fingerprint = FingerprintOf(f)
+		allowed := false
+		for _, e := range entries {
+			if e.matches(f) {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			suppressed++
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, suppressed
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// blobCacheKey hashes the options deciding whether a blob has a string
+// hit. Blobs scanned clean under one key need not be scanned again.
+func blobCacheKey(opts Options) string {
+	strs := append([]string{}, opts.ForbiddenStrings...)
+	res := append([]string{}, opts.ForbiddenRegexes...)
+	sort.Strings(strs)
+	sort.Strings(res)
+	b, _ := json.Marshal(struct {
+		Strings, Regexes                []string
+		CaseInsensitive, MatchWholeWord bool
+		MaxBlobBytes                    int64
+		ScanArchives                    bool
+		MaxArchiveDepth                 int
+		MaxArchiveBytes                 int64
+	}{strs, res, opts.CaseInsensitive, opts.MatchWholeWord, opts.MaxBlobBytes,
+		opts.ScanArchives, opts.MaxArchiveDepth, opts.MaxArchiveBytes})
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%x", sum[:16])
+}
+
+func blobCachePath(opts Options) string {
+	return filepath.Join(opts.BlobCacheDir, blobCacheKey(opts))
+}
+
+// loadCleanBlobs returns the blobs recorded in opts.BlobCacheDir as having
+// no hits under the current options. A missing cache is empty.
+func loadCleanBlobs(opts Options) (map[string]bool, error) {
+	clean := map[string]bool{}
+	if opts.BlobCacheDir == "" {
+		return clean, nil
+	}
+	b, err := os.ReadFile(blobCachePath(opts))
+	if os.IsNotExist(err) {
+		return clean, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range nonEmptyLines(string(b)) {
+		clean[sha] = true
+	}
+	return clean, nil
+}
+
+// saveCleanBlobs adds blobs to the cache of clean blobs. Entries written by
+// concurrent audits since loadCleanBlobs are kept.
+func saveCleanBlobs(opts Options, blobs []string) error {
+	if opts.BlobCacheDir == "" || len(blobs) == 0 {
+		return nil
+	}
+	clean, err := loadCleanBlobs(opts)
+	if err != nil {
+		return err
+	}
+	for _, sha := range blobs {
+		clean[sha] = true
+	}
+	all := make([]string, 0, len(clean))
+	for sha := range clean {
+		all = append(all, sha)
+	}
+	sort.Strings(all)
+
+	if err := os.MkdirAll(opts.BlobCacheDir, 0o755); err != nil {
+		return err
+	}
+	path := blobCachePath(opts)
+	tmp, err := os.CreateTemp(opts.BlobCacheDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(strings.Join(all, "\n") + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
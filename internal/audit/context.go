@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+const (
+	// maxContextLines caps the line numbers reported for one string hit.
+	maxContextLines = 10
+	// maxIntroducingCommits caps the commits reported for one string hit.
+	maxIntroducingCommits = 5
+)
+
+// matcher returns the bounds of the first match in b, or nil.
+type matcher func(b []byte) []int
+
+func needleMatcher(needle []byte, opts Options) matcher {
+	return func(b []byte) []int {
+		content := b
+		if opts.CaseInsensitive {
+			content = bytes.ToLower(b)
+		}
+		i := scrub.IndexWord(content, needle, opts.MatchWholeWord)
+		if i < 0 {
+			return nil
+		}
+		// Lowercasing can change the length of non-ASCII text.
+		return []int{min(i, len(b)), min(i+len(needle), len(b))}
+	}
+}
+
+// lineContext returns the numbers of the lines of content that match, and
+// an excerpt of the first with the match masked. Matches spanning lines are
+// not located.
+func lineContext(content []byte, match matcher) ([]int, string) {
+	var lines []int
+	excerpt := ""
+	for n, line := range bytes.Split(content, []byte("\n")) {
+		loc := match(line)
+		if loc == nil {
+			continue
+		}
+		if excerpt == "" {
+			text := strings.TrimSuffix(string(line), "\r")
+			excerpt = redactedExcerpt(text, min(loc[0], len(text)), min(loc[1], len(text)))
+		}
+		lines = append(lines, n+1)
+		if len(lines) >= maxContextLines {
+			break
+		}
+	}
+	return lines, excerpt
+}
+
+// introducingCommits returns the audited commits that set a file to blob,
+// newest first.
+func introducingCommits(ctx context.Context, repoPath, blob string, opts Options) ([]string, error) {
+	res, err := gitx.Run(ctx, repoPath, historyCmd(opts, "log", "--format=%x00%H", "--raw", "--no-abbrev", "-m", "--find-object="+blob)...)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, rec := range strings.Split(res.Stdout, "\x00") {
+		sha, raw, _ := strings.Cut(rec, "\n")
+		if sha == "" || seen[sha] {
+			continue
+		}
+		for _, line := range strings.Split(raw, "\n") {
+			// :<old mode> <new mode> <old oid> <new oid> <status>\t<path>
+			f := strings.Fields(strings.SplitN(line, "\t", 2)[0])
+			if len(f) == 5 && f[3] == blob {
+				seen[sha] = true
+				out = append(out, sha)
+				break
+			}
+		}
+		if len(out) >= maxIntroducingCommits {
+			break
+		}
+	}
+	return out, nil
+}
+
+// addIntroducingCommits sets the Commits of the string hits among findings
+// and warnings. It runs after the hit limits, as each lookup walks history.
+func addIntroducingCommits(ctx context.Context, repoPath string, opts Options, findings ...[]Finding) error {
+	for _, fs := range findings {
+		for i := range fs {
+			if fs[i].Kind != "string-hit" && fs[i].Kind != "binary-string-hit" {
+				continue
+			}
+			var err error
+			if fs[i].Commits, err = introducingCommits(ctx, repoPath, fs[i].Ref, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
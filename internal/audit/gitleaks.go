@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitleaksFinding is the part of a gitleaks JSON report entry that audits
+// use. The secret itself is deliberately not decoded.
+type gitleaksFinding struct {
+	RuleID      string `json:"RuleID"`
+	Description string `json:"Description"`
+	File        string `json:"File"`
+	Commit      string `json:"Commit"`
+	StartLine   int    `json:"StartLine"`
+}
+
+// runGitleaks scans the history of repoPath with gitleaks and returns its
+// leaks as "gitleaks" findings. If gitleaks is not installed the scan is
+// skipped with a warning.
+func runGitleaks(ctx context.Context, repoPath string, opts Options) ([]Finding, []Finding, error) {
+	bin, err := exec.LookPath("gitleaks")
+	if err != nil {
+		return nil, []Finding{{Kind: "gitleaks-unavailable", Detail: "gitleaks is not installed; secret scan skipped"}}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "git-copy-gitleaks-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	reportPath := filepath.Join(dir, "report.json")
+
+	cmd := exec.CommandContext(ctx, bin, "detect", "--source", repoPath, "--no-banner",
+		"--report-format", "json", "--report-path", reportPath, "--exit-code", "0",
+		"--log-opts", strings.Join(historyArgs(opts), " "))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("gitleaks failed: %w\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+	b, err := os.ReadFile(reportPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("gitleaks wrote no report: %w", err)
+	}
+	findings, err := parseGitleaksReport(b)
+	return findings, nil, err
+}
+
+func parseGitleaksReport(b []byte) ([]Finding, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+	var leaks []gitleaksFinding
+	if err := json.Unmarshal(b, &leaks); err != nil {
+		return nil, errors.New("invalid gitleaks report: " + err.Error())
+	}
+	var out []Finding
+	for _, l := range leaks {
+		desc := l.Description
+		if desc == "" {
+			desc = l.RuleID
+		}
+		out = append(out, Finding{
+			Kind:    "gitleaks",
+			Path:    l.File,
+			Ref:     l.Commit,
+			Pattern: l.RuleID,
+			Detail:  fmt.Sprintf("%s (rule %s, line %d)", desc, l.RuleID, l.StartLine),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// commitRefKinds are the finding kinds whose Ref is a commit.
+var commitRefKinds = map[string]bool{
+	"path-history":             true,
+	"replace-history-mismatch": true,
+	"message-hit":              true,
+	"gitleaks":                 true,
+}
+
+// CommitLinker returns the web URL of a commit of a target's mirror, or ""
+// if it has none.
+type CommitLinker func(target, sha string) string
+
+type htmlReport struct {
+	Generated time.Time
+	Targets   []htmlTarget
+}
+
+type htmlTarget struct {
+	TargetReport
+	Scopes []htmlScope
+}
+
+type htmlScope struct {
+	Name     string
+	Report   *Report
+	Findings []htmlGroup
+	Warnings []htmlGroup
+	Largest  []htmlLargeBlob
+}
+
+type htmlLargeBlob struct {
+	LargeBlob
+	Commits []htmlCommit
+}
+
+// htmlGroup is the findings of one kind.
+type htmlGroup struct {
+	Kind     string
+	Findings []htmlFinding
+}
+
+type htmlFinding struct {
+	Finding
+	RefURL  string
+	Commits []htmlCommit
+}
+
+type htmlCommit struct {
+	SHA string
+	URL string
+}
+
+// WriteHTML writes reports as a self-contained HTML page for sharing with a
+// reviewer, grouping the findings of each audited scope by kind. Commits are
+// linked with link, which may be nil.
+func WriteHTML(w io.Writer, reports []TargetReport, link CommitLinker) error {
+	if link == nil {
+		link = func(string, string) string { return "" }
+	}
+	doc := htmlReport{Generated: time.Now().UTC()}
+	for _, tr := range reports {
+		t := htmlTarget{TargetReport: tr}
+		for _, s := range []struct {
+			name string
+			rep  *Report
+		}{{"Local scrubbed cache", tr.Local}, {"Remote mirror", tr.Remote}, {"Remote refs", tr.Refs}} {
+			if s.rep == nil {
+				continue
+			}
+			t.Scopes = append(t.Scopes, htmlScope{
+				Name:     s.name,
+				Report:   s.rep,
+				Findings: groupByKind(s.rep.Findings, tr.Target, link),
+				Warnings: groupByKind(s.rep.Warnings, tr.Target, link),
+				Largest:  linkLargeBlobs(s.rep.LargestBlobs, tr.Target, link),
+			})
+		}
+		doc.Targets = append(doc.Targets, t)
+	}
+	return htmlTemplate.Execute(w, doc)
+}
+
+func linkLargeBlobs(blobs []LargeBlob, target string, link CommitLinker) []htmlLargeBlob {
+	var out []htmlLargeBlob
+	for _, b := range blobs {
+		hb := htmlLargeBlob{LargeBlob: b}
+		for _, sha := range b.Commits {
+			hb.Commits = append(hb.Commits, htmlCommit{SHA: sha, URL: link(target, sha)})
+		}
+		out = append(out, hb)
+	}
+	return out
+}
+
+func groupByKind(findings []Finding, target string, link CommitLinker) []htmlGroup {
+	index := map[string]int{}
+	var groups []htmlGroup
+	for _, f := range findings {
+		hf := htmlFinding{Finding: f}
+		if commitRefKinds[f.Kind] && f.Ref != "" {
+			hf.RefURL = link(target, f.Ref)
+		}
+		for _, sha := range f.Commits {
+			hf.Commits = append(hf.Commits, htmlCommit{SHA: sha, URL: link(target, sha)})
+		}
+		i, ok := index[f.Kind]
+		if !ok {
+			i = len(groups)
+			index[f.Kind] = i
+			groups = append(groups, htmlGroup{Kind: f.Kind})
+		}
+		groups[i].Findings = append(groups[i].Findings, hf)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Kind < groups[j].Kind })
+	return groups
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": scrub.FormatBytes,
+	"short": func(sha string) string {
+		if len(sha) > 12 {
+			return sha[:12]
+		}
+		return sha
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>git-copy audit report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #1f2328; }
+h1 { font-size: 1.6em; }
+h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1.5em; }
+th, td { border: 1px solid #d0d7de; padding: .4em .6em; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+code { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: .9em; }
+.ok { color: #1a7f37; }
+.failed { color: #cf222e; }
+.sev-high { color: #cf222e; font-weight: bold; }
+.sev-medium { color: #9a6700; font-weight: bold; }
+.sev-low { color: #57606a; }
+.meta { color: #57606a; }
+</style>
+</head>
+<body>
+<h1>git-copy audit report</h1>
+<p class="meta">Generated {{.Generated.Format "2006-01-02 15:04:05 UTC"}}</p>
+{{range .Targets}}
+<h2>Target {{.Target}} {{if .Succeeded}}<span class="ok">passed</span>{{else}}<span class="failed">failed</span>{{end}}</h2>
+<p class="meta">Private repo <code>{{.Repo}}</code>, audited {{.StartedAt.Format "2006-01-02 15:04:05 UTC"}}</p>
+{{if .Error}}<p class="failed">{{.Error}}</p>{{end}}
+{{range .Scopes}}
+<h3>{{.Name}}: <code>{{.Report.RepoPath}}</code></h3>
+{{if .Report.Succeeded}}<p class="ok">No findings.</p>{{end}}
+{{if .Report.Incremental}}<p class="meta">Incremental: only history added since the last clean audit was scanned.</p>{{end}}
+{{if .Report.Suppressed}}<p class="meta">{{.Report.Suppressed}} baselined finding(s) suppressed.</p>{{end}}
+{{range $kind, $n := .Report.Omitted}}<p class="meta">{{$n}} more <code>{{$kind}}</code> finding(s) suppressed by the hit limits.</p>{{end}}
+{{range .Findings}}{{template "group" .}}{{end}}
+{{if .Warnings}}<h4>Warnings</h4>{{range .Warnings}}{{template "group" .}}{{end}}{{end}}
+{{if .Largest}}<h4>Largest blobs</h4>
+<table>
+<tr><th>Size</th><th>Path</th><th>Blob</th><th>Commits</th></tr>
+{{range .Largest}}<tr><td>{{size .Size}}</td><td><code>{{.Path}}</code></td><td><code>{{short .SHA}}</code></td><td>{{template "commits" .Commits}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+{{define "group"}}
+<h4><code>{{.Kind}}</code> ({{len .Findings}})</h4>
+<table>
+<tr><th>Severity</th><th>Path</th><th>Object</th><th>Detail</th><th>Commits</th></tr>
+{{range .Findings}}<tr>
+<td class="sev-{{.Severity}}">{{.Severity}}</td>
+<td>{{if .Path}}<code>{{.Path}}</code>{{if .Lines}} line{{if gt (len .Lines) 1}}s{{end}} {{range $i, $n := .Lines}}{{if $i}}, {{end}}{{$n}}{{end}}{{end}}{{end}}</td>
+<td>{{if .RefURL}}<a href="{{.RefURL}}"><code>{{short .Ref}}</code></a>{{else if .Ref}}<code>{{short .Ref}}</code>{{end}}</td>
+<td>{{.Detail}}{{if .Excerpt}}<br><code>{{.Excerpt}}</code>{{end}}</td>
+<td>{{template "commits" .Commits}}</td>
+</tr>
+{{end}}</table>
+{{end}}
+{{define "commits"}}{{range $i, $c := .}}{{if $i}}, {{end}}{{if $c.URL}}<a href="{{$c.URL}}"><code>{{short $c.SHA}}</code></a>{{else}}<code>{{short $c.SHA}}</code>{{end}}{{end}}{{end}}`))
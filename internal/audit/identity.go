@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// identity is a name and email found on a commit or tag.
+type identity struct {
+	role  string // "author", "committer" or "tagger"
+	name  string
+	email string
+	ref   string // commit or tag sha
+}
+
+func (id identity) String() string {
+	return fmt.Sprintf("%s <%s>", id.name, id.email)
+}
+
+// scanIdentities checks the authors and committers of reachable commits and
+// the taggers of annotated tags against ForbiddenStrings,
+// ForbiddenIdentities and AllowedIdentities. Each offending identity is
+// reported once, at the first object carrying it.
+func scanIdentities(ctx context.Context, repoPath string, opts Options) ([]Finding, error) {
+	ids, err := listIdentities(ctx, repoPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	forbidden := append(append([]string{}, opts.ForbiddenStrings...), opts.ForbiddenIdentities...)
+	allowed := map[string]bool{}
+	for _, a := range opts.AllowedIdentities {
+		allowed[strings.ToLower(a)] = true
+	}
+
+	findings := []Finding{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		key := id.role + "\x00" + id.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		detail, pattern := "", ""
+		hay := id.String()
+		if opts.CaseInsensitive {
+			hay = strings.ToLower(hay)
+		}
+		for _, s := range forbidden {
+			needle := s
+			if opts.CaseInsensitive {
+				needle = strings.ToLower(needle)
+			}
+			if scrub.IndexWord([]byte(hay), []byte(needle), opts.MatchWholeWord) >= 0 {
+				detail, pattern = fmt.Sprintf("%s %q contains forbidden string %q", id.role, id.String(), s), s
+				break
+			}
+		}
+		if detail == "" && len(allowed) > 0 && !allowed[strings.ToLower(id.email)] && !allowed[strings.ToLower(id.String())] {
+			detail = fmt.Sprintf("%s %q is not an allowed identity", id.role, id.String())
+		}
+		if detail != "" {
+			findings = append(findings, Finding{Kind: "identity-hit", Ref: id.ref, Pattern: pattern, Detail: detail})
+		}
+	}
+	return findings, nil
+}
+
+// listIdentities returns the identities of reachable commits, newest first,
+// followed by those of annotated tags under any ref, leaving out what
+// opts.Since covers.
+func listIdentities(ctx context.Context, repoPath string, opts Options) ([]identity, error) {
+	res, err := gitx.Run(ctx, repoPath, historyCmd(opts, "log", "--format=%H%x00%an%x00%ae%x00%cn%x00%ce")...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []identity
+	for _, line := range nonEmptyLines(res.Stdout) {
+		f := strings.Split(line, "\x00")
+		if len(f) != 5 {
+			continue
+		}
+		ids = append(ids,
+			identity{role: "author", name: f[1], email: f[2], ref: f[0]},
+			identity{role: "committer", name: f[3], email: f[4], ref: f[0]},
+		)
+	}
+
+	res, err = gitx.Run(ctx, repoPath, "for-each-ref", "--format=%(objectname)%00%(objecttype)%00%(taggername)%00%(taggeremail)")
+	if err != nil {
+		return nil, err
+	}
+	audited := map[string]bool{}
+	for _, oid := range opts.Since {
+		audited[oid] = true
+	}
+	for _, line := range nonEmptyLines(res.Stdout) {
+		f := strings.Split(line, "\x00")
+		if len(f) != 4 || f[1] != "tag" || audited[f[0]] {
+			continue
+		}
+		email := strings.TrimSuffix(strings.TrimPrefix(f[3], "<"), ">")
+		ids = append(ids, identity{role: "tagger", name: f[2], email: email, ref: f[0]})
+	}
+	return ids, nil
+}
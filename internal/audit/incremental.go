@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// OptionsHash returns a hash of the options that affect what an audit
+// reports, ignoring Since, Parallelism, BlobCacheDir and LargestBlobs. An
+// incremental audit is only sound when the earlier audit used the same
+// options.
+func OptionsHash(opts Options) string {
+	opts = normalizeOptions(opts)
+	opts.Since, opts.Parallelism, opts.BlobCacheDir, opts.LargestBlobs = nil, 0, "", 0
+	b, _ := json.Marshal(opts)
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// historyArgs returns the rev-list arguments selecting the history to audit:
+// everything reachable from any ref (notes, pull request and replace refs
+// included, as a mirror push publishes them) or reflog entry, less what
+// opts.Since covers. The reflog of a working repo is left out: sync does
+// not read it.
+func historyArgs(opts Options) []string {
+	args := []string{"--all", "--reflog"}
+	if opts.worktree {
+		args = args[:1]
+	}
+	if len(opts.Since) > 0 {
+		args = append(append(args, "--not"), opts.Since...)
+	}
+	return args
+}
+
+// historyCmd returns the arguments of a git command walking the audited
+// history. Replacements are ignored, so that commits hidden by refs/replace
+// are scanned as published.
+func historyCmd(opts Options, args ...string) []string {
+	return append(append([]string{"--no-replace-objects"}, args...), historyArgs(opts)...)
+}
+
+// refTips returns the sorted, distinct objects the refs of repoPath point at.
+func refTips(ctx context.Context, repoPath string) ([]string, error) {
+	res, err := gitx.Run(ctx, repoPath, "for-each-ref", "--format=%(objectname)")
+	if err != nil {
+		return nil, err
+	}
+	return normalizeStringList(nonEmptyLines(res.Stdout)), nil
+}
+
+// existingObjects returns the oids present in repoPath, so that an audit
+// of a rebuilt cache falls back to scanning everything.
+func existingObjects(ctx context.Context, repoPath string, oids []string) ([]string, error) {
+	if len(oids) == 0 {
+		return nil, nil
+	}
+	res, err := gitx.RunInput(ctx, repoPath, []byte(strings.Join(oids, "\n")+"\n"), "cat-file", "--batch-check=%(objectname)")
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range nonEmptyLines(res.Stdout) {
+		if !strings.HasSuffix(line, " missing") {
+			out = append(out, line)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
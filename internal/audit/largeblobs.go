@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// LargeBlob is one of the largest blobs in the audited history. Large
+// blobs are informational: published build artifacts or dumps stand out
+// even when they hold no forbidden string.
+type LargeBlob struct {
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+	Path string `json:"path,omitempty"`
+	// Commits set a file to the blob, newest first.
+	Commits []string `json:"commits,omitempty"`
+}
+
+// largestBlobs returns the opts.LargestBlobs largest blobs of the audited
+// history, largest first.
+func largestBlobs(ctx context.Context, repoPath string, opts Options) ([]LargeBlob, error) {
+	rev, err := gitx.Run(ctx, repoPath, historyCmd(opts, "rev-list", "--objects")...)
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	var oids strings.Builder
+	for _, line := range nonEmptyLines(rev.Stdout) {
+		sha, path, _ := strings.Cut(line, " ")
+		oids.WriteString(sha + "\n")
+		if _, ok := paths[sha]; !ok && path != "" {
+			paths[sha] = path
+		}
+	}
+	if oids.Len() == 0 {
+		return nil, nil
+	}
+	res, err := gitx.RunInput(ctx, repoPath, []byte(oids.String()), "cat-file", "--batch-check=%(objectname) %(objecttype) %(objectsize)")
+	if err != nil {
+		return nil, err
+	}
+	var blobs []LargeBlob
+	for _, line := range nonEmptyLines(res.Stdout) {
+		f := strings.Fields(line)
+		if len(f) != 3 || f[1] != "blob" {
+			continue
+		}
+		blobs = append(blobs, LargeBlob{SHA: f[0], Size: parseInt64(f[2]), Path: paths[f[0]]})
+	}
+	sort.SliceStable(blobs, func(i, j int) bool {
+		if blobs[i].Size != blobs[j].Size {
+			return blobs[i].Size > blobs[j].Size
+		}
+		return blobs[i].SHA < blobs[j].SHA
+	})
+	if len(blobs) > opts.LargestBlobs {
+		blobs = blobs[:opts.LargestBlobs]
+	}
+	for i := range blobs {
+		if blobs[i].Commits, err = introducingCommits(ctx, repoPath, blobs[i].SHA, opts); err != nil {
+			return nil, err
+		}
+	}
+	return blobs, nil
+}
@@ -0,0 +1,67 @@
+package audit
+
+// hitLimits caps the findings (or warnings) of an audit at MaxHits per
+// pattern of a kind, MaxHitsPerKind per kind and MaxTotalHits overall, so
+// that one noisy pattern cannot crowd out the others. Findings over a cap
+// are counted by kind instead of reported. The limits apply after
+// classification and the baseline.
+type hitLimits struct {
+	opts      Options
+	byPattern map[string]int
+	byKind    map[string]int
+	total     int
+	omitted   map[string]int
+}
+
+func newHitLimits(opts Options) *hitLimits {
+	return &hitLimits{opts: opts, byPattern: map[string]int{}, byKind: map[string]int{}, omitted: map[string]int{}}
+}
+
+// allow reports whether another finding of kind, for the forbidden path,
+// string or pattern named by pattern, is within the limits, and counts it.
+func (l *hitLimits) allow(kind, pattern string) bool {
+	key := kind + "\x00" + pattern
+	if l.byPattern[key] >= l.opts.MaxHits || l.byKind[kind] >= l.opts.MaxHitsPerKind || l.total >= l.opts.MaxTotalHits {
+		l.omitted[kind]++
+		return false
+	}
+	l.byPattern[key]++
+	l.byKind[kind]++
+	l.total++
+	return true
+}
+
+// apply returns the findings within the limits, in order.
+func (l *hitLimits) apply(findings []Finding) []Finding {
+	out := findings[:0]
+	for _, f := range findings {
+		if l.allow(f.Kind, limitKey(f)) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// limitKey names the forbidden path, string or pattern a finding counts
+// against for MaxHits.
+func limitKey(f Finding) string {
+	switch f.Kind {
+	case "path-history", "replace-history-mismatch":
+		return f.Path
+	}
+	return f.Pattern
+}
+
+// mergeOmitted returns the omitted counts of limits, by kind, or nil.
+func mergeOmitted(limits ...*hitLimits) map[string]int {
+	var out map[string]int
+	for _, l := range limits {
+		for kind, n := range l.omitted {
+			if out == nil {
+				out = map[string]int{}
+			}
+			out[kind] += n
+		}
+	}
+	return out
+}
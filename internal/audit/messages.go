@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// excerptContext is how many bytes of the message line are kept on each side
+// of a hit in a message-hit excerpt.
+const excerptContext = 30
+
+// scanCommitMessages searches the messages of reachable commits for
+// ForbiddenStrings and forbidden regexes. Each finding carries the commit
+// sha and an excerpt of the offending line with the hit redacted.
+func scanCommitMessages(ctx context.Context, repoPath string, opts Options, regexes []*regexp.Regexp) ([]Finding, error) {
+	res, err := gitx.Run(ctx, repoPath, historyCmd(opts, "log", "-z", "--format=%H%n%B")...)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, rec := range strings.Split(res.Stdout, "\x00") {
+		sha, msg, ok := strings.Cut(strings.TrimLeft(rec, "\n"), "\n")
+		if !ok || sha == "" {
+			continue
+		}
+		pattern, hit, start, end := findMessageHit([]byte(msg), opts, regexes)
+		if hit == "" {
+			continue
+		}
+		findings = append(findings, Finding{
+			Kind:    "message-hit",
+			Ref:     sha,
+			Pattern: pattern,
+			Detail:  fmt.Sprintf("message contains %s: %q", hit, redactedExcerpt(msg, start, end)),
+		})
+	}
+	return findings, nil
+}
+
+// findMessageHit returns the first forbidden string or pattern found in
+// msg, a description of it and the bounds of the match.
+func findMessageHit(msg []byte, opts Options, regexes []*regexp.Regexp) (string, string, int, int) {
+	content := msg
+	if opts.CaseInsensitive {
+		content = bytes.ToLower(msg)
+	}
+	for _, s := range opts.ForbiddenStrings {
+		needle := s
+		if opts.CaseInsensitive {
+			needle = strings.ToLower(needle)
+		}
+		if i := scrub.IndexWord(content, []byte(needle), opts.MatchWholeWord); i >= 0 {
+			// Lowercasing can change the length of non-ASCII text.
+			return s, fmt.Sprintf("forbidden string %q", s), min(i, len(msg)), min(i+len(needle), len(msg))
+		}
+	}
+	for _, re := range regexes {
+		if loc := re.FindIndex(msg); loc != nil {
+			return re.String(), fmt.Sprintf("forbidden pattern %q", re.String()), loc[0], loc[1]
+		}
+	}
+	return "", "", 0, 0
+}
+
+// redactedExcerpt returns the line of msg holding msg[start:end], shortened
+// to excerptContext bytes around it, with the match replaced by [redacted].
+func redactedExcerpt(msg string, start, end int) string {
+	lineStart := strings.LastIndexByte(msg[:start], '\n') + 1
+	lineEnd := len(msg)
+	if i := strings.IndexByte(msg[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+	before, after := msg[lineStart:start], msg[end:lineEnd]
+	if len(before) > excerptContext {
+		before = "..." + before[len(before)-excerptContext:]
+	}
+	if len(after) > excerptContext {
+		after = after[:excerptContext] + "..."
+	}
+	return before + "[redacted]" + after
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// forbiddenPath is a path checked for in history, and the ForbiddenPaths
+// glob it was expanded from, if any.
+type forbiddenPath struct {
+	path    string
+	pattern string
+}
+
+// expandForbiddenPaths returns the exact ForbiddenPaths, followed by the
+// paths in the audited history matching a glob entry. Auditing a working
+// repo, paths that sync always drops are left out.
+func expandForbiddenPaths(ctx context.Context, repoPath string, opts Options) ([]forbiddenPath, error) {
+	var out []forbiddenPath
+	var globs []string
+	for _, p := range opts.ForbiddenPaths {
+		if opts.worktree && scrub.IsNonNegotiablePath(p) {
+			continue
+		}
+		if scrub.IsGlobPattern(p) {
+			globs = append(globs, p)
+		} else {
+			out = append(out, forbiddenPath{path: p})
+		}
+	}
+	if len(globs) == 0 {
+		return out, nil
+	}
+	paths, err := historyPaths(ctx, repoPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if opts.worktree && scrub.IsNonNegotiablePath(p) {
+			continue
+		}
+		for _, g := range globs {
+			if scrub.MatchGlob(g, p) {
+				out = append(out, forbiddenPath{path: p, pattern: g})
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// historyPaths returns every file path added, changed or deleted in the
+// audited history, and their parent directories, sorted. Unlike
+// `rev-list --objects`, which names each blob once, this lists every path
+// holding a shared blob.
+func historyPaths(ctx context.Context, repoPath string, opts Options) ([]string, error) {
+	res, err := gitx.Run(ctx, repoPath, historyCmd(opts, "-c", "core.quotePath=false", "log", "--format=", "--name-only", "--no-renames", "-m", "-z")...)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, p := range strings.Split(res.Stdout, "\x00") {
+		p = strings.Trim(p, "\n")
+		for p != "" && p != "." && !seen[p] {
+			seen[p] = true
+			p = path.Dir(p)
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out, nil
+}
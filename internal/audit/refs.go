@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// AuditRemoteRefs compares the refs advertised by remoteURL with those of
+// the local scrubbed cache at localBare. A remote ref that the cache does
+// not have, or that points elsewhere, was not pushed by git-copy: a sign of
+// out-of-band pushes or tampering. If prefixes is set only refs under them
+// belong to the mirror and are compared. Refs the cache has but the remote
+// lacks are not reported; the next sync pushes them.
+func AuditRemoteRefs(ctx context.Context, localBare, remoteURL string, prefixes []string, opts Options) (Report, error) {
+	if strings.TrimSpace(localBare) == "" || strings.TrimSpace(remoteURL) == "" {
+		return Report{}, errors.New("localBare and remoteURL are required")
+	}
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+	}
+	opts = normalizeOptions(opts)
+	started := time.Now().UTC()
+	if err := validateSeverities(opts.Severities, opts.FailOn); err != nil {
+		return Report{}, err
+	}
+
+	res, err := gitx.Run(ctx, localBare, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return Report{}, err
+	}
+	local := parseRefList(res.Stdout)
+	res, err = gitx.Run(ctx, localBare, "ls-remote", remoteURL)
+	if err != nil {
+		return Report{}, err
+	}
+	remote := parseRefList(res.Stdout)
+
+	names := make([]string, 0, len(remote))
+	for name := range remote {
+		if mirroredRef(name, prefixes) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var findings []Finding
+	for _, name := range names {
+		sha := remote[name]
+		switch want, ok := local[name]; {
+		case !ok:
+			findings = append(findings, Finding{
+				Kind:   "unknown-remote-ref",
+				Ref:    sha,
+				Detail: fmt.Sprintf("remote ref %s was not produced by git-copy", name),
+			})
+		case want != sha:
+			findings = append(findings, Finding{
+				Kind:   "remote-ref-mismatch",
+				Ref:    sha,
+				Detail: fmt.Sprintf("remote ref %s points at %s, git-copy pushed %s", name, sha, want),
+			})
+		}
+	}
+
+	findings, warnings := classify(findings, nil, opts.Severities, opts.FailOn)
+	findings, suppressed := applyBaseline(findings, opts.Allow)
+	warnings, suppressedWarnings := applyBaseline(warnings, opts.Allow)
+	limits := newHitLimits(opts)
+	findings, warnings = limits.apply(findings), limits.apply(warnings)
+	return Report{
+		RepoPath:   remoteURL,
+		StartedAt:  started,
+		FinishedAt: time.Now().UTC(),
+		Findings:   findings,
+		Warnings:   warnings,
+		Suppressed: suppressed + suppressedWarnings,
+		Omitted:    mergeOmitted(limits),
+		Succeeded:  len(findings) == 0 && len(limits.omitted) == 0,
+	}, nil
+}
+
+// parseRefList parses "<sha> <refname>" lines, as printed by for-each-ref
+// and ls-remote, into a map by ref name. HEAD and peeled tags are skipped.
+func parseRefList(out string) map[string]string {
+	refs := map[string]string{}
+	for _, line := range nonEmptyLines(out) {
+		f := strings.Fields(line)
+		if len(f) != 2 || f[1] == "HEAD" || strings.HasSuffix(f[1], "^{}") {
+			continue
+		}
+		refs[f[1]] = f[0]
+	}
+	return refs
+}
+
+func mirroredRef(name string, prefixes []string) bool {
+	for _, p := range gitx.ForgeRefPrefixes {
+		if strings.HasPrefix(name, p) {
+			return false
+		}
+	}
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TargetReport is the result of auditing one target: its local scrubbed
+// cache and, if requested, its remote mirror. It is the document written by
+// `git-copy audit --json`.
+type TargetReport struct {
+	Target     string    `json:"target"`
+	Repo       string    `json:"repo"` // the private repo
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Succeeded  bool      `json:"succeeded"`
+	// Local is nil when the target has no local scrubbed cache yet.
+	Local  *Report `json:"local"`
+	Remote *Report `json:"remote,omitempty"`
+	// Refs compares the remote's refs with the local cache's.
+	Refs *Report `json:"refs,omitempty"`
+	// Suggestions are config edits addressing the findings.
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+	// Error is set when the audit could not be completed, or failed.
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalJSON writes empty finding lists as [] rather than null.
+func (r Report) MarshalJSON() ([]byte, error) {
+	type plain Report
+	if r.Findings == nil {
+		r.Findings = []Finding{}
+	}
+	if r.Warnings == nil {
+		r.Warnings = []Finding{}
+	}
+	return json.Marshal(plain(r))
+}
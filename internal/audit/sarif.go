@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// sarifRules describes the finding kinds as SARIF rules. Kinds not listed
+// get a rule without a description.
+var sarifRules = []sarifRule{
+	{ID: "path-history", ShortDescription: &sarifText{"Forbidden path in the published history"}},
+	{ID: "replace-history-mismatch", ShortDescription: &sarifText{"Replaced file differs from HEAD where it was introduced"}},
+	{ID: "string-hit", ShortDescription: &sarifText{"Forbidden string in a published file"}},
+	{ID: "binary-string-hit", ShortDescription: &sarifText{"Forbidden string in a published binary file"}},
+	{ID: "message-hit", ShortDescription: &sarifText{"Forbidden string in a published commit message"}},
+	{ID: "identity-hit", ShortDescription: &sarifText{"Private identity on a published commit or tag"}},
+	{ID: "gitleaks", ShortDescription: &sarifText{"Secret detected by gitleaks"}},
+	{ID: "archive-truncated", ShortDescription: &sarifText{"Published archive too large to search completely"}},
+	{ID: "unknown-remote-ref", ShortDescription: &sarifText{"Remote ref not pushed by git-copy"}},
+	{ID: "remote-ref-mismatch", ShortDescription: &sarifText{"Remote ref differs from the one git-copy pushed"}},
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string     `json:"id"`
+	ShortDescription *sarifText `json:"shortDescription,omitempty"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    sarifText         `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// WriteSARIF writes the findings (as errors) and warnings of reports as a
+// SARIF 2.1.0 log, for upload to code scanning dashboards. Finding kinds are
+// the rule ids; each result names the audited target, the local cache or
+// remote mirror it was found in, and the commit or blob of the finding.
+func WriteSARIF(w io.Writer, reports []TargetReport) error {
+	rules := append([]sarifRule{}, sarifRules...)
+	index := map[string]int{}
+	for i, r := range rules {
+		index[r.ID] = i
+	}
+	results := []sarifResult{}
+	add := func(f Finding, level, target, scope string) {
+		i, ok := index[f.Kind]
+		if !ok {
+			i = len(rules)
+			index[f.Kind] = i
+			rules = append(rules, sarifRule{ID: f.Kind})
+		}
+		res := sarifResult{
+			RuleID:     f.Kind,
+			RuleIndex:  i,
+			Level:      level,
+			Message:    sarifText{f.Detail},
+			Properties: map[string]string{"target": target, "scope": scope},
+		}
+		if f.Path != "" {
+			res.Locations = []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: f.Path}}}}
+		}
+		if f.Ref != "" {
+			res.Properties["ref"] = f.Ref
+		}
+		if f.Severity != "" {
+			res.Properties["severity"] = string(f.Severity)
+		}
+		results = append(results, res)
+	}
+	for _, tr := range reports {
+		for _, s := range []struct {
+			scope string
+			rep   *Report
+		}{{"local", tr.Local}, {"remote", tr.Remote}, {"refs", tr.Refs}} {
+			if s.rep == nil {
+				continue
+			}
+			for _, f := range s.rep.Findings {
+				add(f, "error", tr.Target, s.scope)
+			}
+			for _, f := range s.rep.Warnings {
+				add(f, "warning", tr.Target, s.scope)
+			}
+		}
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "git-copy",
+				InformationURI: "https://github.com/obinnaokechukwu/git-copy",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
@@ -0,0 +1,84 @@
+package audit
+
+import "fmt"
+
+// Severity ranks findings: "low", "medium" or "high".
+type Severity string
+
+const (
+	SeverityLow    Severity = "low"
+	SeverityMedium Severity = "medium"
+	SeverityHigh   Severity = "high"
+)
+
+// defaultSeverities are the severities of the finding kinds. Kinds not
+// listed are high.
+var defaultSeverities = map[string]Severity{
+	"replace-history-mismatch": SeverityMedium,
+	"binary-string-hit":        SeverityLow,
+	"gitleaks-unavailable":     SeverityLow,
+	"archive-truncated":        SeverityLow,
+}
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	}
+	return 0
+}
+
+// ParseSeverity parses "low", "medium" or "high".
+func ParseSeverity(s string) (Severity, error) {
+	if sev := Severity(s); sev.rank() > 0 {
+		return sev, nil
+	}
+	return "", fmt.Errorf("invalid severity %q (want low, medium or high)", s)
+}
+
+// severityOf returns the severity of a finding kind, after the overrides.
+func severityOf(kind string, overrides map[string]Severity) Severity {
+	if s, ok := overrides[kind]; ok {
+		return s
+	}
+	if s, ok := defaultSeverities[kind]; ok {
+		return s
+	}
+	return SeverityHigh
+}
+
+// classify sets the severity of findings and warnings. Findings below
+// failOn are demoted to warnings.
+func classify(findings, warnings []Finding, overrides map[string]Severity, failOn Severity) ([]Finding, []Finding) {
+	var kept []Finding
+	for _, f := range findings {
+		f.Severity = severityOf(f.Kind, overrides)
+		if failOn != "" && f.Severity.rank() < failOn.rank() {
+			warnings = append(warnings, f)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	for i := range warnings {
+		if warnings[i].Severity == "" {
+			warnings[i].Severity = severityOf(warnings[i].Kind, overrides)
+		}
+	}
+	return kept, warnings
+}
+
+func validateSeverities(overrides map[string]Severity, failOn Severity) error {
+	for kind, s := range overrides {
+		if s.rank() == 0 {
+			return fmt.Errorf("invalid severity %q for %s (want low, medium or high)", s, kind)
+		}
+	}
+	if failOn != "" && failOn.rank() == 0 {
+		return fmt.Errorf("invalid fail-on severity %q (want low, medium or high)", failOn)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+// suggestedReplacement replaces forbidden strings that extra_replacements
+// is suggested for.
+const suggestedReplacement = "REDACTED"
+
+// Suggestion is a repo config edit that would keep a finding out of the
+// next sync.
+type Suggestion struct {
+	// Field is "exclude", "redact", "extra_replacements" or
+	// "messages.strip_patterns", under defaults.
+	Field string `json:"field"`
+	// Value is the path, string or pattern to add.
+	Value string `json:"value"`
+	// Replacement is the replacement of an extra_replacements entry.
+	Replacement string `json:"replacement,omitempty"`
+	// Kind is the finding kind it addresses.
+	Kind string `json:"kind"`
+}
+
+func (s Suggestion) String() string {
+	if s.Field == "extra_replacements" {
+		return fmt.Sprintf("add %q: %q to defaults.extra_replacements (%s)", s.Value, s.Replacement, s.Kind)
+	}
+	return fmt.Sprintf("add %q to defaults.%s (%s)", s.Value, s.Field, s.Kind)
+}
+
+// Apply makes the edit to cfg. It reports false if cfg already has it.
+func (s Suggestion) Apply(cfg *config.RepoConfig) bool {
+	if s.has(cfg.Defaults) {
+		return false
+	}
+	d := &cfg.Defaults
+	switch s.Field {
+	case "exclude":
+		d.Exclude = append(d.Exclude, s.Value)
+	case "redact":
+		d.Redact = append(d.Redact, s.Value)
+	case "extra_replacements":
+		if d.ExtraReplacementPairs == nil {
+			d.ExtraReplacementPairs = map[string]string{}
+		}
+		d.ExtraReplacementPairs[s.Value] = s.Replacement
+	case "messages.strip_patterns":
+		if d.Messages == nil {
+			d.Messages = &config.MessageRules{}
+		}
+		d.Messages.StripPatterns = append(d.Messages.StripPatterns, s.Value)
+	default:
+		return false
+	}
+	return true
+}
+
+// has reports whether d already has the edit.
+func (s Suggestion) has(d config.TargetDefaults) bool {
+	switch s.Field {
+	case "exclude":
+		return contains(d.Exclude, s.Value)
+	case "redact":
+		return contains(d.Redact, s.Value)
+	case "extra_replacements":
+		_, ok := d.ExtraReplacementPairs[s.Value]
+		return ok
+	case "messages.strip_patterns":
+		return d.Messages != nil && contains(d.Messages.StripPatterns, s.Value)
+	}
+	return false
+}
+
+// Suggest returns config edits addressing findings of an audit made with
+// opts, leaving out those cfg already has. Files holding forbidden content
+// are redacted, or excluded when they are binary or archives; plain
+// forbidden strings other than the private usernames, which are already
+// replaced, get extra_replacements; patterns in commit messages are
+// stripped. Identity findings and findings on the mirror's refs have no
+// config fix and get no suggestion.
+func Suggest(cfg config.RepoConfig, opts Options, findings []Finding) []Suggestion {
+	regexes := map[string]bool{}
+	for _, re := range opts.ForbiddenRegexes {
+		regexes[re] = true
+	}
+	usernames := map[string]bool{}
+	for _, u := range cfg.PrivateUsernames() {
+		usernames[strings.ToLower(u)] = true
+	}
+	plainString := func(f Finding) bool {
+		return f.Pattern != "" && !regexes[f.Pattern] && !usernames[strings.ToLower(f.Pattern)]
+	}
+
+	var out []Suggestion
+	seen := map[string]bool{}
+	add := func(s Suggestion) {
+		key := s.Field + "\x00" + s.Value
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if !s.has(cfg.Defaults) {
+			out = append(out, s)
+		}
+	}
+	for _, f := range findings {
+		// A hit inside an archive is fixed on the archive.
+		path, _, inArchive := strings.Cut(f.Path, "!/")
+		switch f.Kind {
+		case "path-history":
+			add(Suggestion{Field: "exclude", Value: f.Path, Kind: f.Kind})
+		case "string-hit":
+			switch {
+			case inArchive:
+				add(Suggestion{Field: "exclude", Value: path, Kind: f.Kind})
+			case plainString(f):
+				add(Suggestion{Field: "extra_replacements", Value: f.Pattern, Replacement: suggestedReplacement, Kind: f.Kind})
+			case path != "":
+				add(Suggestion{Field: "redact", Value: path, Kind: f.Kind})
+			}
+		case "binary-string-hit", "archive-truncated":
+			if path != "" {
+				add(Suggestion{Field: "exclude", Value: path, Kind: f.Kind})
+			}
+		case "gitleaks":
+			if path != "" {
+				add(Suggestion{Field: "redact", Value: path, Kind: f.Kind})
+			}
+		case "message-hit":
+			switch {
+			case plainString(f):
+				add(Suggestion{Field: "extra_replacements", Value: f.Pattern, Replacement: suggestedReplacement, Kind: f.Kind})
+			case regexes[f.Pattern]:
+				add(Suggestion{Field: "messages.strip_patterns", Value: f.Pattern, Kind: f.Kind})
+			}
+		}
+	}
+	return out
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
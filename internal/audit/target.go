@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+// TargetOptions returns the audit options implied by the repo config for
+// target t: the default checks plus its private usernames, replaced-history
+// files and the identities its author map is expected to publish.
+func TargetOptions(cfg config.RepoConfig, t config.Target) Options {
+	opts := DefaultOptions()
+	opts.ForbiddenStrings = append(opts.ForbiddenStrings, cfg.PrivateUsernames()...)
+	opts.MatchWholeWord = cfg.MatchWholeWord
+	opts.BinaryHitsAsWarnings = cfg.Defaults.SkipBinaryBlobs
+
+	opts.ReplaceHistoryWithCurrentFiles = append([]string{}, cfg.Defaults.ReplaceHistoryWithCurrent...)
+	opts.ReplaceHistoryWithCurrentFiles = append(opts.ReplaceHistoryWithCurrentFiles, t.ReplaceHistoryWithCurrent...)
+
+	// Published identities come from the author map or the target's public
+	// author; the domains of mapped private emails must not survive.
+	authorMap := append(append([]config.AuthorMapping{}, t.AuthorMap...), cfg.Defaults.AuthorMap...)
+	for _, am := range authorMap {
+		if _, domain, ok := strings.Cut(am.PrivateEmail, "@"); ok && domain != "" {
+			opts.ForbiddenIdentities = append(opts.ForbiddenIdentities, "@"+domain)
+		}
+	}
+	if t.PublicAuthorEmail != "" {
+		opts.AllowedIdentities = append(opts.AllowedIdentities, t.PublicAuthorEmail)
+		for _, am := range authorMap {
+			opts.AllowedIdentities = append(opts.AllowedIdentities, am.PublicEmail)
+		}
+	}
+	return opts
+}
@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// AuditRepo audits the repository at repoPath, which may be bare or a
+// working checkout, such as the private repo before any target is
+// configured. A checkout is audited as sync reads it: the history of its
+// refs, without reflog entries or uncommitted changes, and without the
+// files that sync always drops, like .git-copy.
+func AuditRepo(ctx context.Context, repoPath string, opts Options) (Report, error) {
+	if strings.TrimSpace(repoPath) == "" {
+		return Report{}, errors.New("repoPath is required")
+	}
+	res, err := gitx.Run(ctx, repoPath, "rev-parse", "--is-bare-repository")
+	if err != nil {
+		return Report{}, err
+	}
+	if strings.TrimSpace(res.Stdout) == "true" {
+		return AuditBareRepo(ctx, repoPath, opts)
+	}
+	top, err := gitx.RepoTopLevel(repoPath)
+	if err != nil {
+		return Report{}, err
+	}
+	opts.worktree = true
+	return AuditBareRepo(ctx, top, opts)
+}
@@ -3,16 +3,25 @@ package cli
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/obinnaokechukwu/git-copy/internal/audit"
 	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/provider"
 	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
 )
 
 type multiStringFlag []string
@@ -28,11 +37,27 @@ func (m *multiStringFlag) Set(v string) error {
 }
 
 type auditArgs struct {
-	repo   string
-	target string
-	remote bool
+	repo     string
+	target   string
+	remote   bool
+	refs     bool
+	all      bool
+	private  bool
+	json     bool
+	sarif    string
+	html     string
+	gitleaks bool
+	archives bool
+	full     bool
+	jobs     int
+	largest  int
+	failOn   string
+	apply    bool
 	// repeated
-	strings multiStringFlag
+	strings    multiStringFlag
+	regexes    multiStringFlag
+	paths      multiStringFlag
+	severities multiStringFlag
 }
 
 func parseAuditArgs(args []string) (auditArgs, error) {
@@ -41,58 +66,279 @@ func parseAuditArgs(args []string) (auditArgs, error) {
 	fs.StringVar(&a.repo, "repo", "", "path to repo (default: current directory)")
 	fs.StringVar(&a.target, "target", "", "audit only this target label")
 	fs.BoolVar(&a.remote, "remote", false, "also audit the remote mirror by cloning it")
+	fs.BoolVar(&a.refs, "refs", false, "also check that every remote ref was pushed by git-copy from the local cache")
+	fs.BoolVar(&a.all, "all", false, "audit every target")
+	fs.BoolVar(&a.private, "private", false, "audit the private repo itself, as sync would read it (no target needed)")
 	fs.Var(&a.strings, "string", "forbidden substring to search for (repeatable)")
+	fs.Var(&a.regexes, "regex", "forbidden regular expression to search for (repeatable)")
+	fs.Var(&a.paths, "path", "forbidden path or glob, e.g. '**/id_rsa*' (repeatable)")
+	fs.BoolVar(&a.json, "json", false, "print the audit report as JSON")
+	fs.StringVar(&a.sarif, "sarif", "", "also write the findings to this file in SARIF format")
+	fs.StringVar(&a.html, "html", "", "also write the report to this file as a self-contained HTML page")
+	fs.BoolVar(&a.gitleaks, "gitleaks", false, "also scan the history with gitleaks, if installed")
+	fs.BoolVar(&a.archives, "archives", false, "also search inside zip, jar, tar and gzip blobs")
+	fs.StringVar(&a.failOn, "fail-on", "", "fail only on findings of this severity or higher (low, medium, high)")
+	fs.Var(&a.severities, "severity", "override a finding kind's severity, as KIND=LEVEL (repeatable)")
+	fs.IntVar(&a.jobs, "jobs", 0, "number of processes searching blobs (default: one per CPU)")
+	fs.IntVar(&a.largest, "largest", 10, "list this many of the largest blobs (0 to skip)")
+	fs.BoolVar(&a.apply, "apply-suggestions", false, "add the suggested config changes to the repo config, after confirmation")
+	fs.BoolVar(&a.full, "full", false, "rescan the whole history, not just what changed since the last clean audit or blobs not yet scanned clean")
 	if err := fs.Parse(args); err != nil {
 		return auditArgs{}, err
 	}
+	if a.apply && a.json {
+		return auditArgs{}, errors.New("--apply-suggestions and --json are mutually exclusive")
+	}
+	if a.private && (a.all || a.target != "" || a.remote || a.refs || a.sarif != "" || a.html != "") {
+		return auditArgs{}, errors.New("--private cannot be combined with --all, --target, --remote, --refs, --sarif or --html")
+	}
+	if a.failOn != "" {
+		if _, err := audit.ParseSeverity(a.failOn); err != nil {
+			return auditArgs{}, err
+		}
+	}
+	for _, s := range a.severities {
+		kind, level, ok := strings.Cut(s, "=")
+		if !ok || kind == "" {
+			return auditArgs{}, fmt.Errorf("invalid --severity %q (want KIND=LEVEL)", s)
+		}
+		if _, err := audit.ParseSeverity(level); err != nil {
+			return auditArgs{}, err
+		}
+	}
 	return a, nil
 }
 
-func cmdAudit(repoFlag, target string, remote bool, extraStrings []string) error {
-	repoPath, err := resolveRepoPath(repoFlag)
+func cmdAudit(a auditArgs) error {
+	repoPath, err := resolveRepoPath(a.repo)
 	if err != nil {
 		return err
 	}
+	if a.private {
+		return cmdAuditPrivate(repoPath, a)
+	}
 	cfg, err := repo.LoadRepoConfigFromAnyBranch(context.Background(), repoPath)
 	if err != nil {
 		return err
 	}
-
-	t, err := selectTarget(cfg, target)
+	baseline, err := repo.LoadAuditBaseline(context.Background(), repoPath)
 	if err != nil {
 		return err
 	}
 
+	targets := cfg.Targets
+	if !a.all {
+		t, err := selectTarget(cfg, a.target)
+		if err != nil {
+			return err
+		}
+		targets = []config.Target{t}
+	} else if a.target != "" {
+		return errors.New("--all and --target are mutually exclusive")
+	}
+
+	// With --json the report is the only output.
+	var out io.Writer = os.Stdout
+	if a.json {
+		out = io.Discard
+	}
+	reports := []audit.TargetReport{}
+	var failed []string
+	var failure error
+	for _, t := range targets {
+		tr := audit.TargetReport{Target: t.Label, Repo: repoPath, StartedAt: time.Now().UTC()}
+		opts := auditOptions(cfg, t, a)
+		opts.Allow = baseline.Allow
+		err := auditTarget(out, repoPath, t, opts, a, &tr)
+		tr.FinishedAt = time.Now().UTC()
+		tr.Suggestions = audit.Suggest(cfg, opts, reportFindings(tr))
+		if err != nil {
+			tr.Error = err.Error()
+			failed = append(failed, t.Label)
+			failure = err
+			if a.all {
+				fmt.Fprintf(out, "  ERROR: %v\n", err)
+			}
+		}
+		reports = append(reports, tr)
+	}
+	if a.json {
+		var v any = reports[0]
+		if a.all {
+			v = reports
+		}
+		if err := writeJSON(os.Stdout, v); err != nil {
+			return err
+		}
+	}
+	if a.sarif != "" {
+		if err := writeSARIFFile(a.sarif, reports); err != nil {
+			return err
+		}
+	}
+	if a.html != "" {
+		if err := writeHTMLFile(a.html, reports, targets); err != nil {
+			return err
+		}
+	}
+	if suggestions := mergeSuggestions(reports); len(suggestions) > 0 {
+		fmt.Fprintln(out, "Suggested config changes:")
+		for _, s := range suggestions {
+			fmt.Fprintf(out, "  %s\n", s)
+		}
+		if a.apply {
+			if err := applySuggestions(repoPath, cfg, suggestions); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintln(out, "  Tip: rerun with --apply-suggestions to add them to the repo config.")
+		}
+	}
+	if a.all && len(failed) > 0 {
+		return fmt.Errorf("audit failed for %d of %d targets: %s", len(failed), len(targets), strings.Join(failed, ", "))
+	}
+	if failure != nil {
+		return failure
+	}
+	fmt.Fprintln(out, "Audit: OK")
+	return nil
+}
+
+// cmdAuditPrivate audits the private repo itself, which need not have a
+// git-copy config yet, to show what its targets will have to exclude or
+// replace.
+func cmdAuditPrivate(repoPath string, a auditArgs) error {
+	cfg, err := repo.LoadRepoConfigFromAnyBranch(context.Background(), repoPath)
+	configured := err == nil
+	if !configured {
+		if _, statErr := os.Stat(config.RepoConfigPath(repoPath)); statErr == nil {
+			return err
+		}
+		cfg = config.RepoConfig{}
+	}
+	// Private usernames are not searched for: sync replaces them anyway.
 	opts := audit.DefaultOptions()
-	opts.ForbiddenStrings = append(opts.ForbiddenStrings, cfg.PrivateUsername)
-	opts.ForbiddenStrings = append(opts.ForbiddenStrings, extraStrings...)
+	opts.MatchWholeWord = cfg.MatchWholeWord
+	opts = applyAuditFlags(opts, a)
+	if configured {
+		baseline, err := repo.LoadAuditBaseline(context.Background(), repoPath)
+		if err != nil {
+			return err
+		}
+		opts.Allow = baseline.Allow
+	}
+
+	var out io.Writer = os.Stdout
+	if a.json {
+		out = io.Discard
+	}
+	fmt.Fprintf(out, "Audit private repo %s\n", repoPath)
+	rep, err := audit.AuditRepo(context.Background(), repoPath, opts)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		if err := writeJSON(os.Stdout, rep); err != nil {
+			return err
+		}
+	}
+	printAuditReport(out, rep)
+	if suggestions := audit.Suggest(cfg, opts, rep.Findings); len(suggestions) > 0 {
+		fmt.Fprintln(out, "Suggested config changes:")
+		for _, s := range suggestions {
+			fmt.Fprintf(out, "  %s\n", s)
+		}
+		switch {
+		case a.apply && !configured:
+			return errors.New("--apply-suggestions needs a git-copy config; run `git-copy init` first")
+		case a.apply:
+			if err := applySuggestions(repoPath, cfg, suggestions); err != nil {
+				return err
+			}
+		default:
+			fmt.Fprintln(out, "  Tip: rerun with --apply-suggestions to add them to the repo config.")
+		}
+	}
+	if !rep.Succeeded {
+		return errors.New("audit failed (private)")
+	}
+	fmt.Fprintln(out, "Audit: OK")
+	return nil
+}
 
-	opts.ReplaceHistoryWithCurrentFiles = append([]string{}, cfg.Defaults.ReplaceHistoryWithCurrent...)
-	opts.ReplaceHistoryWithCurrentFiles = append(opts.ReplaceHistoryWithCurrentFiles, t.ReplaceHistoryWithCurrent...)
+// auditOptions returns the audit options for target t.
+func auditOptions(cfg config.RepoConfig, t config.Target, a auditArgs) audit.Options {
+	return applyAuditFlags(audit.TargetOptions(cfg, t), a)
+}
 
-	fmt.Printf("Audit target %q\n", t.Label)
+// applyAuditFlags adds the checks and settings given on the command line
+// to opts.
+func applyAuditFlags(opts audit.Options, a auditArgs) audit.Options {
+	opts.ForbiddenStrings = append(opts.ForbiddenStrings, a.strings...)
+	opts.ForbiddenRegexes = append(opts.ForbiddenRegexes, a.regexes...)
+	opts.ForbiddenPaths = append(opts.ForbiddenPaths, a.paths...)
+	opts.Gitleaks = a.gitleaks
+	opts.ScanArchives = a.archives
+	if !a.full {
+		opts.BlobCacheDir = filepath.Join(defaultCacheDir(), "audit-blobs")
+	}
+	opts.Parallelism = a.jobs
+	opts.LargestBlobs = a.largest
+	opts.FailOn = audit.Severity(a.failOn)
+	for _, s := range a.severities {
+		kind, level, _ := strings.Cut(s, "=")
+		if opts.Severities == nil {
+			opts.Severities = map[string]audit.Severity{}
+		}
+		opts.Severities[kind] = audit.Severity(level)
+	}
+	return opts
+}
+
+// auditTarget audits t's local scrubbed cache and, if a asks for them, its
+// mirror and the mirror's refs, recording the reports in tr and progress on
+// out. It stops at the first failed audit.
+func auditTarget(out io.Writer, repoPath string, t config.Target, opts audit.Options, a auditArgs, tr *audit.TargetReport) error {
+	fmt.Fprintf(out, "Audit target %q\n", t.Label)
 
 	// Local scrubbed bare repo location.
 	repoKey := repoCacheKey(repoPath)
 	localBare := filepath.Join(defaultCacheDir(), repoKey, t.Label+".git")
 
 	if _, err := os.Stat(localBare); err == nil {
-		fmt.Printf("- Local scrubbed repo: %s\n", localBare)
-		rep, err := audit.AuditBareRepo(context.Background(), localBare, opts)
+		fmt.Fprintf(out, "- Local scrubbed repo: %s\n", localBare)
+		rep, err := auditLocalCache(repoPath, t.Label, localBare, opts, a.full)
 		if err != nil {
 			return err
 		}
-		printAuditReport(rep)
+		tr.Local = &rep
+		printAuditReport(out, rep)
 		if !rep.Succeeded {
 			return errors.New("audit failed (local)")
 		}
 	} else {
-		fmt.Printf("- Local scrubbed repo: (missing) %s\n", localBare)
-		fmt.Println("  Tip: run `git-copy sync` first to generate the local scrubbed cache.")
+		fmt.Fprintf(out, "- Local scrubbed repo: (missing) %s\n", localBare)
+		fmt.Fprintln(out, "  Tip: run `git-copy sync` first to generate the local scrubbed cache.")
+		if a.refs {
+			return errors.New("--refs needs the local scrubbed cache")
+		}
 	}
 
-	if remote {
-		fmt.Printf("- Remote repo: %s\n", t.RepoURL)
+	if a.refs {
+		fmt.Fprintf(out, "- Remote refs: %s\n", t.RepoURL)
+		rep, err := audit.AuditRemoteRefs(context.Background(), localBare, t.RepoURL, sync.NamespacePrefixes(t.Namespaces()), opts)
+		if err != nil {
+			return err
+		}
+		tr.Refs = &rep
+		printAuditReport(out, rep)
+		if !rep.Succeeded {
+			return errors.New("audit failed (refs)")
+		}
+	}
+
+	if a.remote {
+		fmt.Fprintf(out, "- Remote repo: %s\n", t.RepoURL)
 		clonePath, cleanup, err := audit.CloneMirrorToTemp(context.Background(), t.RepoURL, audit.CloneOptions{})
 		if err != nil {
 			return err
@@ -102,22 +348,141 @@ func cmdAudit(repoFlag, target string, remote bool, extraStrings []string) error
 		if err != nil {
 			return err
 		}
-		printAuditReport(rep)
+		rep.RepoPath = t.RepoURL
+		tr.Remote = &rep
+		printAuditReport(out, rep)
 		if !rep.Succeeded {
 			return errors.New("audit failed (remote)")
 		}
 	}
+	tr.Succeeded = true
+	return nil
+}
+
+// auditLocalCache audits a target's local scrubbed cache. Unless full is
+// set, history already covered by the last clean audit made with the same
+// options is skipped. Clean audits are recorded in the repo state.
+func auditLocalCache(repoPath, label, localBare string, opts audit.Options, full bool) (audit.Report, error) {
+	st, err := state.Load(repoPath)
+	if err != nil {
+		return audit.Report{}, err
+	}
+	hash := audit.OptionsHash(opts)
+	ts := st.Targets[label]
+	if !full && ts != nil && ts.AuditOptionsHash == hash {
+		opts.Since = ts.AuditedTips
+	}
+	rep, err := audit.AuditBareRepo(context.Background(), localBare, opts)
+	if err != nil || !rep.Succeeded {
+		return rep, err
+	}
+	if ts == nil {
+		ts = &state.TargetState{}
+		st.Targets[label] = ts
+	}
+	ts.AuditedTips, ts.AuditOptionsHash = rep.Tips, hash
+	return rep, state.Save(repoPath, st)
+}
+
+// writeSARIFFile writes the findings of reports to path as a SARIF log.
+func writeSARIFFile(path string, reports []audit.TargetReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := audit.WriteSARIF(f, reports); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reportFindings returns the findings of every audit of tr.
+func reportFindings(tr audit.TargetReport) []audit.Finding {
+	var findings []audit.Finding
+	for _, rep := range []*audit.Report{tr.Local, tr.Refs, tr.Remote} {
+		if rep != nil {
+			findings = append(findings, rep.Findings...)
+		}
+	}
+	return findings
+}
 
-	fmt.Println("Audit: OK")
+// mergeSuggestions returns the suggestions of reports without duplicates.
+// They all edit the shared defaults.
+func mergeSuggestions(reports []audit.TargetReport) []audit.Suggestion {
+	var out []audit.Suggestion
+	seen := map[string]bool{}
+	for _, tr := range reports {
+		for _, s := range tr.Suggestions {
+			key := s.Field + "\x00" + s.Value
+			if !seen[key] {
+				seen[key] = true
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// applySuggestions adds suggestions to the repo config and commits it, once
+// confirmed.
+func applySuggestions(repoPath string, cfg config.RepoConfig, suggestions []audit.Suggestion) error {
+	ok, err := promptConfirm(fmt.Sprintf("Apply %d suggested config change(s)?", len(suggestions)), false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	for _, s := range suggestions {
+		s.Apply(&cfg)
+	}
+	if err := config.SaveRepoConfigToFile(config.RepoConfigPath(repoPath), cfg); err != nil {
+		return err
+	}
+	if err := commitConfigOnHeadBranch(repoPath, cfg.HeadBranch, "Update git-copy configuration"); err != nil {
+		return err
+	}
+	fmt.Println("Updated the repo config. Run `git-copy sync` to republish with the changes.")
 	return nil
 }
 
+// writeHTMLFile writes reports to path as an HTML page linking commits to
+// the targets' providers.
+func writeHTMLFile(path string, reports []audit.TargetReport, targets []config.Target) error {
+	byLabel := make(map[string]config.Target, len(targets))
+	for _, t := range targets {
+		byLabel[t.Label] = t
+	}
+	link := func(label, sha string) string {
+		t := byLabel[label]
+		return provider.CommitURL(t.Provider, t.RepoURL, sha)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := audit.WriteHTML(f, reports, link); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
 func selectTarget(cfg config.RepoConfig, label string) (config.Target, error) {
 	if label == "" {
 		if len(cfg.Targets) == 1 {
 			return cfg.Targets[0], nil
 		}
-		return config.Target{}, errors.New("usage: git-copy audit [--repo PATH] --target LABEL [--remote] [--string S ...]")
+		return config.Target{}, errors.New("usage: git-copy audit [--repo PATH] <--target LABEL|--all|--private> [--remote] [--refs] [--string S ...] [--regex RE ...] [--path GLOB ...] [--gitleaks] [--archives] [--full] [--jobs N] [--largest N] [--fail-on SEVERITY] [--severity KIND=LEVEL ...] [--json] [--sarif FILE] [--html FILE] [--apply-suggestions]")
 	}
 	for _, t := range cfg.Targets {
 		if t.Label == label {
@@ -137,9 +502,31 @@ func defaultCacheDir() string {
 	return filepath.Join(home, ".cache", "git-copy")
 }
 
-func printAuditReport(rep audit.Report) {
+func printAuditReport(w io.Writer, rep audit.Report) {
+	for _, f := range rep.Warnings {
+		fmt.Fprintf(w, "  WARN %-22s %s %s (%s)\n", f.Kind, f.Ref, f.Path, f.Detail)
+		printFindingContext(w, f)
+	}
+	if rep.Incremental {
+		fmt.Fprintln(w, "  (incremental: only history added since the last clean audit)")
+	}
+	if rep.CachedBlobs > 0 {
+		fmt.Fprintf(w, "  (%d blob(s) already scanned clean were skipped)\n", rep.CachedBlobs)
+	}
+	if rep.Suppressed > 0 {
+		fmt.Fprintf(w, "  %d baselined finding(s) suppressed\n", rep.Suppressed)
+	}
+	kinds := make([]string, 0, len(rep.Omitted))
+	for kind := range rep.Omitted {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %d more %s finding(s) suppressed by the hit limits\n", rep.Omitted[kind], kind)
+	}
+	printLargestBlobs(w, rep.LargestBlobs)
 	if rep.Succeeded {
-		fmt.Println("  OK (no findings)")
+		fmt.Fprintln(w, "  OK (no findings)")
 		return
 	}
 	for _, f := range rep.Findings {
@@ -151,7 +538,50 @@ func printAuditReport(rep audit.Report) {
 		if strings.TrimSpace(ref) == "" {
 			ref = "(unknown)"
 		}
-		fmt.Printf("  FAIL %-22s %s %s (%s)\n", f.Kind, ref, path, f.Detail)
+		fmt.Fprintf(w, "  FAIL %-22s %s %s (%s)\n", f.Kind, ref, path, f.Detail)
+		printFindingContext(w, f)
+	}
+}
+
+// printLargestBlobs lists the largest blobs of a report, for spotting build
+// artifacts or dumps.
+func printLargestBlobs(w io.Writer, blobs []audit.LargeBlob) {
+	if len(blobs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "  Largest blobs:")
+	for _, b := range blobs {
+		commit := "(unknown)"
+		if len(b.Commits) > 0 {
+			commit = b.Commits[len(b.Commits)-1]
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+		}
+		path := b.Path
+		if path == "" {
+			path = "(unknown)"
+		}
+		fmt.Fprintf(w, "    %10s  %s  %s (introduced in %s)\n", scrub.FormatBytes(b.Size), b.SHA[:min(12, len(b.SHA))], path, commit)
+	}
+}
+
+// printFindingContext prints where in the file a string hit is, and the
+// commits that introduced the blob.
+func printFindingContext(w io.Writer, f audit.Finding) {
+	if len(f.Lines) > 0 {
+		nums := make([]string, len(f.Lines))
+		for i, n := range f.Lines {
+			nums[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(w, "       line %s: %s\n", strings.Join(nums, ","), f.Excerpt)
+	}
+	if len(f.Commits) > 0 {
+		short := make([]string, len(f.Commits))
+		for i, c := range f.Commits {
+			short[i] = c[:min(len(c), 12)]
+		}
+		fmt.Fprintf(w, "       introduced in %s\n", strings.Join(short, ", "))
 	}
 }
 
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
+)
+
+const cacheUsage = "usage: git-copy cache gc [--cache-dir DIR] [--max-size SIZE] [--dry-run]"
+
+func cmdCache(args []string) error {
+	if len(args) == 0 || args[0] != "gc" {
+		return errors.New(cacheUsage)
+	}
+	dcfg, err := config.LoadDaemonConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("cache gc", flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", dcfg.CacheDir, "cache directory")
+	maxSize := fs.String("max-size", dcfg.CacheMaxSize, `cap on the total size of the caches, e.g. "20GB" (default: the daemon's cache_max_size)`)
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	max, err := config.ParseByteSize(*maxSize)
+	if err != nil {
+		return fmt.Errorf("--max-size: %w", err)
+	}
+	res, err := sync.GC(context.Background(), sync.GCOptions{CacheDir: *cacheDir, MaxSize: max, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	var freed int64
+	for _, r := range res.Removed {
+		fmt.Printf("%s %s (%s): %s\n", verb, r.Path, scrub.FormatBytes(r.Size), r.Reason)
+		freed += r.Size
+	}
+	for _, p := range res.Busy {
+		fmt.Printf("skipped %s: a sync is running\n", p)
+	}
+	fmt.Printf("%s %s; %s of caches kept\n", verb, scrub.FormatBytes(freed), scrub.FormatBytes(res.Size))
+	return nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
+)
+
+// cmdDoctor checks the repo's configuration against its private history
+// without syncing.
+func cmdDoctor(repoFlag, target string) error {
+	repoPath, err := resolveRepoPath(repoFlag)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cfg, err := repo.LoadRepoConfigFromAnyBranch(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Repo: %s\n", repoPath)
+	failed := false
+	for _, t := range cfg.Targets {
+		if target != "" && t.Label != target {
+			continue
+		}
+		problems, err := sync.CheckReplacements(ctx, repoPath, cfg, t)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Printf("- %s: ok\n", t.Label)
+			continue
+		}
+		for _, p := range problems {
+			fmt.Printf("- %s: %s\n", t.Label, p)
+		}
+		if cfg.Defaults.ReplacementCheck == "fail" {
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("doctor found problems that will block sync")
+	}
+	return nil
+}
@@ -16,7 +16,11 @@ func cmdListTargets(repoFlag string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Private username: %s\nHead branch: %s\n\nTargets:\n", cfg.PrivateUsername, cfg.HeadBranch)
+	fmt.Printf("Private username: %s\n", cfg.PrivateUsername)
+	for _, u := range cfg.AdditionalUsernames {
+		fmt.Printf("Additional username: %s\n", u.Username)
+	}
+	fmt.Printf("Head branch: %s\n\nTargets:\n", cfg.HeadBranch)
 	for _, t := range cfg.Targets {
 		fmt.Printf("- %s (%s) %s/%s -> %s\n", t.Label, t.Provider, t.Account, t.RepoName, t.RepoURL)
 	}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
+)
+
+const mapUsage = "usage: git-copy map [--repo PATH] [--target LABEL] <commit>"
+
+// cmdMap prints the public commits a private commit became, or the private
+// commit behind a public one, from the commit maps of the synced targets.
+func cmdMap(args []string) error {
+	fs := flag.NewFlagSet("map", flag.ContinueOnError)
+	repoFlag := fs.String("repo", "", "path to repo (default: current directory)")
+	target := fs.String("target", "", "look only in this target")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(mapUsage)
+	}
+	repoPath, err := resolveRepoPath(*repoFlag)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.LoadRepoConfigFromAnyBranch(context.Background(), repoPath)
+	if err != nil {
+		return err
+	}
+	// A private revision (a branch, a tag, HEAD~3) is resolved first; any
+	// other id is looked up as is, public ids included.
+	id := fs.Arg(0)
+	if sha, err := gitx.RevParse(repoPath, id); err == nil {
+		id = sha
+	}
+
+	found, searched := 0, 0
+	for _, t := range cfg.Targets {
+		if *target != "" && t.Label != *target {
+			continue
+		}
+		searched++
+		bare := filepath.Join(defaultCacheDir(), repoCacheKey(repoPath), t.Label+".git")
+		matches, err := sync.LookupCommit(bare, id)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("%s: no commit map (not synced yet)\n", t.Label)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			fmt.Printf("%s: private %s → public %s\n", t.Label, m.Private, m.Public)
+		}
+		found += len(matches)
+	}
+	if *target != "" && searched == 0 {
+		return fmt.Errorf("unknown target: %s", *target)
+	}
+	if found == 0 {
+		return fmt.Errorf("commit %s not found in any target's commit map", fs.Arg(0))
+	}
+	return nil
+}
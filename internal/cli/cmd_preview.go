@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
+)
+
+type previewArgs struct {
+	repo   string
+	target string
+	all    bool
+	paths  []string
+}
+
+// parsePreviewArgs accepts flags before, between and after the paths.
+func parsePreviewArgs(args []string) (previewArgs, error) {
+	fs := flag.NewFlagSet("preview", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var p previewArgs
+	fs.StringVar(&p.repo, "repo", "", "path to repo (default: current directory)")
+	fs.StringVar(&p.target, "target", "", "preview this target's rules")
+	fs.BoolVar(&p.all, "all", false, "preview every file in HEAD")
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return previewArgs{}, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		p.paths = append(p.paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if p.all == (len(p.paths) > 0) {
+		return previewArgs{}, errors.New("usage: git-copy preview [--repo PATH] [--target LABEL] <path ...|--all>")
+	}
+	return p, nil
+}
+
+// cmdPreview prints a diff between files in the private HEAD and their
+// scrubbed public version, followed by the files the target doesn't publish.
+func cmdPreview(a previewArgs) error {
+	repoPath, err := resolveRepoPath(a.repo)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cfg, err := repo.LoadRepoConfigFromAnyBranch(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	t, err := previewTarget(cfg, a.target)
+	if err != nil {
+		return err
+	}
+	files, err := sync.Preview(ctx, repoPath, cfg, t, a.paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no files in HEAD match the given paths")
+	}
+
+	tmp, err := os.MkdirTemp("", "git-copy-preview-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var excluded []string
+	for _, f := range files {
+		if f.PublicPath == "" {
+			excluded = append(excluded, f.Path)
+			continue
+		}
+		if err := writePreviewFile(filepath.Join(tmp, "private", f.Path), f.Mode, f.Content); err != nil {
+			return err
+		}
+		if err := writePreviewFile(filepath.Join(tmp, "public", f.PublicPath), f.PublicMode, f.PublicContent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmp, "private"), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, "public"), 0o755); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "diff", "--no-index", "--no-prefix", "-M", "private", "public")
+	cmd.Dir = tmp
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	changed := false
+	if err := cmd.Run(); err != nil {
+		// Exit status 1 means the trees differ.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return fmt.Errorf("git diff --no-index: %w", err)
+		}
+		changed = true
+	}
+	if !changed {
+		fmt.Println("Published files are unchanged.")
+	}
+	if len(excluded) > 0 {
+		fmt.Println("")
+		fmt.Println("Not published:")
+		for _, p := range excluded {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	return nil
+}
+
+// previewTarget picks the target to preview; label may be empty when only
+// one target is configured.
+func previewTarget(cfg config.RepoConfig, label string) (config.Target, error) {
+	if label == "" {
+		switch len(cfg.Targets) {
+		case 0:
+			return config.Target{}, errors.New("no targets configured")
+		case 1:
+			return cfg.Targets[0], nil
+		default:
+			return config.Target{}, errors.New("--target is required when several targets are configured")
+		}
+	}
+	for _, t := range cfg.Targets {
+		if t.Label == label {
+			return t, nil
+		}
+	}
+	return config.Target{}, fmt.Errorf("unknown target: %s", label)
+}
+
+func writePreviewFile(p, mode string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	perm := os.FileMode(0o644)
+	if mode == "100755" {
+		perm = 0o755
+	}
+	return os.WriteFile(p, content, perm)
+}
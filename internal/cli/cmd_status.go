@@ -3,12 +3,17 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
 	"github.com/obinnaokechukwu/git-copy/internal/state"
 )
 
-func cmdStatus(repoFlag string) error {
+// cmdStatus prints the state of each target and the metrics of its most
+// recent syncs, at most history of them.
+func cmdStatus(repoFlag string, history int) error {
 	repoPath, err := resolveRepoPath(repoFlag)
 	if err != nil {
 		return err
@@ -33,6 +38,59 @@ func cmdStatus(repoFlag string) error {
 		} else {
 			fmt.Printf("- %s: ok (last sync %s)\n", t.Label, ts.LastSyncAt.Format("2006-01-02 15:04:05"))
 		}
+		if r := ts.Remote; r != nil && (r.ID != "" || r.Head != "") {
+			fmt.Printf("    remote %s\n", formatRemoteIdentity(*r))
+		}
+		for _, m := range t.Mirrors {
+			switch ms := ts.Mirrors[m.URL]; {
+			case ms == nil:
+				fmt.Printf("    mirror %s: never synced\n", m.URL)
+			case ms.LastError != "":
+				msg, _, _ := strings.Cut(ms.LastError, "\n")
+				fmt.Printf("    mirror %s: ERROR (%s)\n", m.URL, msg)
+			default:
+				fmt.Printf("    mirror %s: ok (last sync %s)\n", m.URL, ms.LastSyncAt.Format("2006-01-02 15:04:05"))
+			}
+		}
+		recent := ts.History
+		if history < 0 {
+			history = 0
+		}
+		if len(recent) > history {
+			recent = recent[len(recent)-history:]
+		}
+		for _, r := range recent {
+			fmt.Printf("    %s\n", formatSyncRecord(r))
+		}
 	}
 	return nil
 }
+
+func formatRemoteIdentity(r state.RemoteIdentity) string {
+	var parts []string
+	if r.Name != "" {
+		parts = append(parts, r.Name)
+	}
+	if r.ID != "" {
+		parts = append(parts, "id "+r.ID)
+	}
+	if r.Head != "" {
+		parts = append(parts, "HEAD "+r.Head)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatSyncRecord(r state.SyncRecord) string {
+	kind := "full"
+	if r.Incremental {
+		kind = "incremental"
+	}
+	line := fmt.Sprintf("%s  %6s  %-11s  %d commit(s), %d blob(s) rewritten, %s pushed",
+		r.At.Format("2006-01-02 15:04:05"), (time.Duration(r.DurationMS) * time.Millisecond).Round(100*time.Millisecond),
+		kind, r.Commits, r.BlobsRewritten, scrub.FormatBytes(r.BytesPushed))
+	if r.Error != "" {
+		msg, _, _ := strings.Cut(r.Error, "\n")
+		line += "  ERROR: " + msg
+	}
+	return line
+}
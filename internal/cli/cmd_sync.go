@@ -4,20 +4,65 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
+	gosync "sync"
 
 	"github.com/obinnaokechukwu/git-copy/internal/audit"
 	"github.com/obinnaokechukwu/git-copy/internal/config"
 	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
 	"github.com/obinnaokechukwu/git-copy/internal/sync"
 )
 
 type syncCmdOptions struct {
 	AuditAfterSync bool
 	AuditRemote    bool
+	// DryRun filters without pushing, writing the stream to Output if set.
+	DryRun bool
+	Output string
+	// ValidateJobs is the validation parallelism (0 = one per CPU).
+	ValidateJobs int
+	// TargetJobs is the number of targets synced at once (0 = default).
+	TargetJobs int
+	// Verify checks that filtering the history is reproducible.
+	Verify bool
+	// Force rebuilds and pushes targets that are up to date.
+	Force bool
+	// IgnoreSchedule syncs targets whose schedule isn't due.
+	IgnoreSchedule bool
+	// AllowRemoteOverwrite pushes over refs changed on the target.
+	AllowRemoteOverwrite bool
+	// UpdatePrivate overrides the config's update_private.
+	UpdatePrivate string
+	// NoWait skips targets locked by another sync rather than waiting.
+	NoWait bool
+	// Since overrides the targets' history_cutoff, for later syncs too.
+	Since string
+	// CI never prompts, takes push tokens from the environment and writes
+	// a JSON report to stdout, everything else going to stderr.
+	CI bool
+	// DetailedExitCode makes a CI sync exit 2 when a target was synced.
+	DetailedExitCode bool
 }
 
 func cmdSync(repoFlag, target string, opts syncCmdOptions) error {
+	if !opts.CI {
+		return runSync(repoFlag, target, opts, nil)
+	}
+	setNonInteractive()
+	var rep ciReport
+	if err := runSync(repoFlag, target, opts, &rep); err != nil {
+		rep.Error = strings.TrimSpace(err.Error())
+	}
+	return rep.write(opts.DetailedExitCode)
+}
+
+// runSync syncs the repo's targets, printing their results, or recording
+// them in rep if it is set.
+func runSync(repoFlag, target string, opts syncCmdOptions, rep *ciReport) error {
 	repoPath, err := resolveRepoPath(repoFlag)
 	if err != nil {
 		return err
@@ -26,10 +71,50 @@ func cmdSync(repoFlag, target string, opts syncCmdOptions) error {
 	if err != nil {
 		return err
 	}
-	results, err := sync.SyncRepo(context.Background(), repoPath, cfg, target, sync.Options{Validate: true})
+	out := io.Writer(os.Stdout)
+	if rep != nil {
+		out = os.Stderr
+	}
+	if opts.Output != "" && target == "" && len(cfg.Targets) > 1 {
+		return errors.New("--output needs --target when several targets are configured")
+	}
+	results, err := sync.SyncRepo(context.Background(), repoPath, cfg, target, sync.Options{
+		Validate:             true,
+		ValidateParallelism:  opts.ValidateJobs,
+		TargetConcurrency:    opts.TargetJobs,
+		Progress:             printSyncProgress(),
+		DryRun:               opts.DryRun,
+		DryRunOutput:         opts.Output,
+		VerifyReproducible:   opts.Verify,
+		Force:                opts.Force,
+		IgnoreSchedule:       opts.IgnoreSchedule,
+		AllowRemoteOverwrite: opts.AllowRemoteOverwrite,
+		UpdatePrivate:        opts.UpdatePrivate,
+		WaitForLock:          !opts.NoWait,
+		CredentialsFromEnv:   opts.CI,
+		HistoryCutoff:        opts.Since,
+		LockWaiting: func(label string, pid int) {
+			if pid > 0 {
+				fmt.Fprintf(os.Stderr, "%s: waiting for another git-copy sync (pid %d)\n", label, pid)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: waiting for another git-copy sync\n", label)
+			}
+		},
+	})
 	if err != nil {
 		return err
 	}
+	if opts.DryRun {
+		printDryRun(results, opts.Output)
+		return nil
+	}
+
+	var baseline audit.Baseline
+	if opts.AuditAfterSync {
+		if baseline, err = repo.LoadAuditBaseline(context.Background(), repoPath); err != nil {
+			return err
+		}
+	}
 
 	targetByLabel := make(map[string]config.Target, len(cfg.Targets))
 	for _, t := range cfg.Targets {
@@ -37,64 +122,144 @@ func cmdSync(repoFlag, target string, opts syncCmdOptions) error {
 	}
 
 	for _, r := range results {
+		for _, w := range r.Warnings {
+			fmt.Fprintf(out, "%s: WARNING: %s\n", r.TargetLabel, w)
+		}
+		if r.PushAttempts > 1 {
+			fmt.Fprintf(out, "%s: push took %d attempts\n", r.TargetLabel, r.PushAttempts)
+		}
 		if r.Error != nil {
-			fmt.Printf("%s: ERROR: %v\n", r.TargetLabel, r.Error)
+			fmt.Fprintf(out, "%s: ERROR: %v\n", r.TargetLabel, r.Error)
+			printMirrorResults(out, r)
+			if rep != nil {
+				rep.add(r, nil)
+			}
 			continue
+		} else if r.DidWork && r.Incremental {
+			fmt.Fprintf(out, "%s: synced %s → %s (incremental, %d new commit(s))\n", r.TargetLabel, r.SourceCommit, r.TargetURL, r.Stats.Commits)
 		} else if r.DidWork {
-			fmt.Printf("%s: synced %s → %s\n", r.TargetLabel, r.SourceCommit, r.TargetURL)
+			fmt.Fprintf(out, "%s: synced %s → %s\n", r.TargetLabel, r.SourceCommit, r.TargetURL)
+		} else if !r.NextDue.IsZero() {
+			fmt.Fprintf(out, "%s: not due until %s (schedule)\n", r.TargetLabel, r.NextDue.Format("2006-01-02 15:04"))
 		} else {
-			fmt.Printf("%s: up to date (%s)\n", r.TargetLabel, r.SourceCommit)
+			fmt.Fprintf(out, "%s: up to date (%s)\n", r.TargetLabel, r.SourceCommit)
 		}
+		printMirrorResults(out, r)
 
-		if !opts.AuditAfterSync {
-			continue
+		var auditErr error
+		if opts.AuditAfterSync {
+			t, ok := targetByLabel[r.TargetLabel]
+			if !ok {
+				return fmt.Errorf("internal error: missing target config for %q", r.TargetLabel)
+			}
+			auditErr = auditSyncedTarget(out, repoPath, cfg, t, baseline, opts.AuditRemote)
 		}
-		t, ok := targetByLabel[r.TargetLabel]
-		if !ok {
-			return fmt.Errorf("internal error: missing target config for %q", r.TargetLabel)
+		if rep != nil {
+			// A failed audit fails the target, not the whole sync.
+			rep.add(r, auditErr)
+		} else if auditErr != nil {
+			return auditErr
 		}
+	}
+	return nil
+}
 
-		aopts := audit.DefaultOptions()
-		aopts.ForbiddenStrings = append(aopts.ForbiddenStrings, cfg.PrivateUsername)
-		aopts.ReplaceHistoryWithCurrentFiles = append([]string{}, cfg.Defaults.ReplaceHistoryWithCurrent...)
-		aopts.ReplaceHistoryWithCurrentFiles = append(aopts.ReplaceHistoryWithCurrentFiles, t.ReplaceHistoryWithCurrent...)
+func printMirrorResults(w io.Writer, r sync.Result) {
+	for _, m := range r.Mirrors {
+		if m.Error != nil {
+			fmt.Fprintf(w, "%s: mirror %s: ERROR: %v\n", r.TargetLabel, m.URL, m.Error)
+		} else {
+			fmt.Fprintf(w, "%s: mirror %s: synced (%d ref update(s))\n", r.TargetLabel, m.URL, len(m.RefUpdates))
+		}
+	}
+}
 
-		repoKey := repoCacheKey(repoPath)
-		localBare := filepath.Join(defaultCacheDir(), repoKey, t.Label+".git")
+// auditSyncedTarget audits the target's cached scrubbed repo, and its
+// remote if remote is set, writing the reports to w.
+func auditSyncedTarget(w io.Writer, repoPath string, cfg config.RepoConfig, t config.Target, baseline audit.Baseline, remote bool) error {
+	aopts := auditOptions(cfg, t, auditArgs{})
+	aopts.Allow = baseline.Allow
 
-		fmt.Printf("%s: audit (local)\n", r.TargetLabel)
-		rep, err := audit.AuditBareRepo(context.Background(), localBare, aopts)
-		if err != nil {
-			return err
-		}
-		printAuditReport(rep)
-		if !rep.Succeeded {
-			return errors.New("audit failed (local)")
+	repoKey := repoCacheKey(repoPath)
+	localBare := filepath.Join(defaultCacheDir(), repoKey, t.Label+".git")
+
+	fmt.Fprintf(w, "%s: audit (local)\n", t.Label)
+	rep, err := auditLocalCache(repoPath, t.Label, localBare, aopts, false)
+	if err != nil {
+		return err
+	}
+	printAuditReport(w, rep)
+	if !rep.Succeeded {
+		return errors.New("audit failed (local)")
+	}
+	if !remote {
+		return nil
+	}
+
+	fmt.Fprintf(w, "%s: audit (remote)\n", t.Label)
+	clonePath, cleanup, err := audit.CloneMirrorToTemp(context.Background(), t.RepoURL, audit.CloneOptions{})
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	rrep, err := audit.AuditBareRepo(context.Background(), clonePath, aopts)
+	if err != nil {
+		return err
+	}
+	printAuditReport(w, rrep)
+	if !rrep.Succeeded {
+		return errors.New("audit failed (remote)")
+	}
+	return nil
+}
+
+// printSyncProgress returns a sync progress callback writing one line per
+// report to stderr.
+func printSyncProgress() func(string, scrub.Progress) {
+	var mu gosync.Mutex
+	return func(label string, p scrub.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		state := "filtering"
+		if p.Done {
+			state = "filtered"
 		}
+		fmt.Fprintf(os.Stderr, "%s: %s %s\n", label, state, p)
+	}
+}
 
-		if opts.AuditRemote {
-			fmt.Printf("%s: audit (remote)\n", r.TargetLabel)
-			clonePath, cleanup, err := audit.CloneMirrorToTemp(context.Background(), t.RepoURL, audit.CloneOptions{})
-			if err != nil {
-				return err
-			}
-			var remoteErr error
-			func() {
-				defer cleanup()
-				rrep, rerr := audit.AuditBareRepo(context.Background(), clonePath, aopts)
-				if rerr != nil {
-					remoteErr = rerr
-					return
-				}
-				printAuditReport(rrep)
-				if !rrep.Succeeded {
-					remoteErr = errors.New("audit failed (remote)")
-				}
-			}()
-			if remoteErr != nil {
-				return remoteErr
+func printDryRun(results []sync.Result, output string) {
+	for _, r := range results {
+		if r.Error != nil {
+			fmt.Printf("%s: ERROR: %v\n", r.TargetLabel, r.Error)
+			continue
+		}
+		for _, w := range r.Warnings {
+			fmt.Printf("%s: WARNING: %s\n", r.TargetLabel, w)
+		}
+		if output != "" {
+			fmt.Printf("%s: dry run: %s, stream written to %s\n", r.TargetLabel, r.Stats, output)
+		} else {
+			fmt.Printf("%s: dry run: %s\n", r.TargetLabel, r.Stats)
+		}
+		if len(r.RefUpdates) == 0 {
+			fmt.Printf("%s: nothing to push\n", r.TargetLabel)
+			continue
+		}
+		fmt.Printf("%s: would push %d ref update(s), %d new commit(s):\n", r.TargetLabel, len(r.RefUpdates), r.NewCommits)
+		for _, u := range r.RefUpdates {
+			switch {
+			case u.Old == "":
+				fmt.Printf("  create %s %s\n", u.Ref, shortSHA(u.New))
+			case u.New == "":
+				fmt.Printf("  delete %s (was %s)\n", u.Ref, shortSHA(u.Old))
+			default:
+				fmt.Printf("  update %s %s..%s\n", u.Ref, shortSHA(u.Old), shortSHA(u.New))
 			}
 		}
 	}
-	return nil
+}
+
+func shortSHA(sha string) string {
+	return sha[:min(len(sha), 12)]
 }
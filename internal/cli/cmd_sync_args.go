@@ -1,15 +1,29 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"io"
 )
 
 type syncArgs struct {
-	repo        string
-	target      string
-	audit       bool
-	auditRemote bool
+	repo         string
+	target       string
+	audit        bool
+	auditRemote  bool
+	dryRun       bool
+	output       string
+	validateJobs int
+	verify       bool
+	force        bool
+	ignoreSched  bool
+	overwrite    bool
+	update       string
+	targetJobs   int
+	noWait       bool
+	ci           bool
+	detailedExit bool
+	since        string
 }
 
 func parseSyncArgs(args []string) (syncArgs, error) {
@@ -21,6 +35,19 @@ func parseSyncArgs(args []string) (syncArgs, error) {
 	fs.StringVar(&s.target, "target", "", "sync only this target label")
 	fs.BoolVar(&s.audit, "audit", true, "audit the scrubbed output after a successful sync")
 	fs.BoolVar(&s.auditRemote, "audit-remote", false, "also audit the remote mirror by cloning it (implies --audit)")
+	fs.BoolVar(&s.dryRun, "dry-run", false, "filter, import and validate without pushing, listing the refs a push would update")
+	fs.StringVar(&s.output, "output", "", "write the filtered fast-import stream to this file (implies --dry-run)")
+	fs.IntVar(&s.validateJobs, "validate-jobs", 0, "processes scanning objects during validation (default: one per CPU)")
+	fs.IntVar(&s.targetJobs, "target-jobs", 0, "targets synced at once (default 4)")
+	fs.BoolVar(&s.verify, "verify-reproducible", false, "filter the history twice and fail if the outputs differ")
+	fs.BoolVar(&s.force, "force", false, "rebuild and push every target even if nothing changed since the last sync")
+	fs.BoolVar(&s.ignoreSched, "ignore-schedule", false, "sync targets whose schedule isn't due yet, incrementally (--force does too, but rebuilds them)")
+	fs.BoolVar(&s.overwrite, "allow-remote-overwrite", false, "push even over target refs changed since git-copy last pushed them")
+	fs.BoolVar(&s.noWait, "no-wait", false, "skip targets another git-copy sync is working on instead of waiting for it")
+	fs.BoolVar(&s.ci, "ci", false, "for CI jobs: never prompt, take push tokens from the environment and print a JSON report; exits 1 if anything failed, else 0")
+	fs.BoolVar(&s.detailedExit, "detailed-exit-code", false, "with --ci, exit 2 instead of 0 when a target was synced")
+	fs.StringVar(&s.since, "since", "", "publish only history after this date, tag or commit, older history becoming one baseline commit (overrides history_cutoff, for this and later syncs; none removes the override)")
+	fs.StringVar(&s.update, "update-private", "", "how to update the private repo first: pull, fetch or never (default: the config's update_private)")
 
 	if err := fs.Parse(args); err != nil {
 		return syncArgs{}, err
@@ -28,5 +55,14 @@ func parseSyncArgs(args []string) (syncArgs, error) {
 	if s.auditRemote {
 		s.audit = true
 	}
+	if s.output != "" {
+		s.dryRun = true
+	}
+	if s.ci && s.dryRun {
+		return syncArgs{}, errors.New("--ci and --dry-run are mutually exclusive")
+	}
+	if s.detailedExit && !s.ci {
+		return syncArgs{}, errors.New("--detailed-exit-code requires --ci")
+	}
 	return s, nil
 }
@@ -40,3 +40,85 @@ func TestParseSyncArgs_AuditRemoteImpliesAudit(t *testing.T) {
 		t.Fatalf("expected auditRemote true")
 	}
 }
+
+func TestParseSyncArgs_OutputImpliesDryRun(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--output", "stream.fi"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if !a.dryRun || a.output != "stream.fi" {
+		t.Fatalf("expected dry run to stream.fi, got dryRun=%v output=%q", a.dryRun, a.output)
+	}
+}
+
+func TestParseSyncArgs_Force(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--force"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if !a.force {
+		t.Fatalf("expected force true")
+	}
+	if a.ignoreSched {
+		t.Fatalf("expected ignoreSched false with --force")
+	}
+}
+
+func TestParseSyncArgs_AllowRemoteOverwrite(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--allow-remote-overwrite"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if !a.overwrite || a.force {
+		t.Fatalf("expected overwrite without force, got overwrite=%v force=%v", a.overwrite, a.force)
+	}
+}
+
+func TestParseSyncArgs_UpdatePrivate(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--update-private", "never"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if a.update != "never" {
+		t.Fatalf("expected update never, got %q", a.update)
+	}
+}
+
+func TestParseSyncArgs_TargetJobs(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--target-jobs", "2"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if a.targetJobs != 2 {
+		t.Fatalf("expected targetJobs 2, got %d", a.targetJobs)
+	}
+}
+
+func TestParseSyncArgs_CI(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--ci"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if !a.ci {
+		t.Fatalf("expected ci true")
+	}
+	if _, err := parseSyncArgs([]string{"--ci", "--output", "stream.fi"}); err == nil {
+		t.Fatalf("expected --ci with --output to be rejected")
+	}
+	if a, err := parseSyncArgs([]string{"--ci", "--detailed-exit-code"}); err != nil || !a.detailedExit {
+		t.Fatalf("parseSyncArgs --detailed-exit-code: %+v %v", a, err)
+	}
+	if _, err := parseSyncArgs([]string{"--detailed-exit-code"}); err == nil {
+		t.Fatalf("expected --detailed-exit-code without --ci to be rejected")
+	}
+}
+
+func TestParseSyncArgs_Since(t *testing.T) {
+	a, err := parseSyncArgs([]string{"--since", "2024-01-31"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs: %v", err)
+	}
+	if a.since != "2024-01-31" {
+		t.Fatalf("expected since 2024-01-31, got %q", a.since)
+	}
+}
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/sync"
+)
+
+// Exit codes of sync --ci, which otherwise exits 0. ciExitSynced is opt-in
+// with --detailed-exit-code, like terraform plan's: CI steps fail on any
+// non-zero exit, so by default a sync that pushed exits 0 and only the
+// report's status tells it from one with nothing to do.
+const (
+	ciExitFailed = 1
+	ciExitSynced = 2
+)
+
+// ExitError makes the process exit with Code without printing anything: the
+// command has reported its outcome already.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ciReport is what sync --ci writes to stdout.
+type ciReport struct {
+	// Status is "failed" if the sync or any target failed, "synced" if a
+	// target was synced, and "up_to_date" otherwise.
+	Status string `json:"status"`
+	// Error is set when the sync failed before syncing any target.
+	Error   string     `json:"error,omitempty"`
+	Targets []ciTarget `json:"targets"`
+}
+
+type ciTarget struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+	// Status is "synced", "up_to_date", "not_due" (its schedule skipped
+	// it) or "failed".
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+	Warnings     []string      `json:"warnings,omitempty"`
+	SourceCommit string        `json:"source_commit,omitempty"`
+	Incremental  bool          `json:"incremental"`
+	Commits      int           `json:"commits"`
+	NewCommits   int           `json:"new_commits"`
+	RefUpdates   []ciRefUpdate `json:"ref_updates,omitempty"`
+	PushedBytes  int64         `json:"pushed_bytes"`
+	DurationMS   int64         `json:"duration_ms"`
+	NextDue      *time.Time    `json:"next_due,omitempty"`
+	Mirrors      []ciMirror    `json:"mirrors,omitempty"`
+}
+
+// ciMirror is the push to one of a target's mirrors; a failed one fails
+// the report, not its target.
+type ciMirror struct {
+	URL string `json:"url"`
+	// Status is "synced" or "failed".
+	Status      string        `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	NewCommits  int           `json:"new_commits"`
+	RefUpdates  []ciRefUpdate `json:"ref_updates,omitempty"`
+	PushedBytes int64         `json:"pushed_bytes"`
+}
+
+type ciRefUpdate struct {
+	Ref string `json:"ref"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// add records the target's result; err is a failure after the sync, such as
+// a failed audit.
+func (rep *ciReport) add(r sync.Result, err error) {
+	t := ciTarget{
+		Label:        r.TargetLabel,
+		URL:          r.TargetURL,
+		Status:       "up_to_date",
+		Warnings:     r.Warnings,
+		SourceCommit: r.SourceCommit,
+		Incremental:  r.Incremental,
+		Commits:      r.Stats.Commits,
+		NewCommits:   r.NewCommits,
+		PushedBytes:  r.PushedBytes,
+		DurationMS:   r.Duration.Milliseconds(),
+	}
+	for _, u := range r.RefUpdates {
+		t.RefUpdates = append(t.RefUpdates, ciRefUpdate{Ref: u.Ref, Old: u.Old, New: u.New})
+	}
+	for _, m := range r.Mirrors {
+		cm := ciMirror{URL: m.URL, Status: "synced", NewCommits: m.NewCommits, PushedBytes: m.PushedBytes}
+		for _, u := range m.RefUpdates {
+			cm.RefUpdates = append(cm.RefUpdates, ciRefUpdate{Ref: u.Ref, Old: u.Old, New: u.New})
+		}
+		if m.Error != nil {
+			cm.Status, cm.Error = "failed", m.Error.Error()
+		}
+		t.Mirrors = append(t.Mirrors, cm)
+	}
+	if r.Error != nil {
+		err = r.Error
+	}
+	switch {
+	case err != nil:
+		t.Status, t.Error = "failed", err.Error()
+	case r.DidWork:
+		t.Status = "synced"
+	case !r.NextDue.IsZero():
+		t.Status = "not_due"
+		due := r.NextDue.UTC()
+		t.NextDue = &due
+	}
+	rep.Targets = append(rep.Targets, t)
+}
+
+// write writes the report to stdout and returns the *ExitError for its
+// status: always for "failed", and for "synced" if detailed is set.
+func (rep *ciReport) write(detailed bool) error {
+	rep.Status = "up_to_date"
+	if rep.Error != "" {
+		rep.Status = "failed"
+	}
+	for _, t := range rep.Targets {
+		for _, m := range t.Mirrors {
+			if m.Status == "failed" {
+				rep.Status = "failed"
+			}
+		}
+		if t.Status == "failed" {
+			rep.Status = "failed"
+		} else if t.Status == "synced" && rep.Status != "failed" {
+			rep.Status = "synced"
+		}
+	}
+	if rep.Targets == nil {
+		rep.Targets = []ciTarget{}
+	}
+	if err := writeJSON(os.Stdout, rep); err != nil {
+		return err
+	}
+	switch {
+	case rep.Status == "failed":
+		return &ExitError{Code: ciExitFailed}
+	case rep.Status == "synced" && detailed:
+		return &ExitError{Code: ciExitSynced}
+	}
+	return nil
+}
+
+// setNonInteractive makes the git and ssh processes of the sync fail rather
+// than prompt for credentials or host keys.
+func setNonInteractive() {
+	_ = os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	_ = os.Setenv("GCM_INTERACTIVE", "never")
+	if os.Getenv("GIT_SSH_COMMAND") == "" {
+		_ = os.Setenv("GIT_SSH_COMMAND", "ssh -o BatchMode=yes")
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/obinnaokechukwu/git-copy/internal/throttle"
+)
+
+// cmdThrottlePipe runs a command with its input limited to a rate, in bytes
+// per second, or paced by the throttle.Pacer at a loopback address:
+// "throttle-pipe RATE|ADDR COMMAND [ARGS...]". Throttled pushes over SSH run
+// ssh through it, so ssh uploads the pack no faster than that.
+func cmdThrottlePipe(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: throttle-pipe RATE|ADDR COMMAND [ARGS...]")
+	}
+	var copyInput func(w io.Writer)
+	if rate, err := strconv.ParseInt(args[0], 10, 64); err == nil && rate > 0 {
+		copyInput = func(w io.Writer) {
+			_, _ = io.Copy(w, throttle.NewReader(os.Stdin, throttle.NewLimiter(rate)))
+		}
+	} else if _, _, err := net.SplitHostPort(args[0]); err == nil {
+		copyInput = func(w io.Writer) {
+			_ = throttle.Pipe(args[0], w, os.Stdin)
+		}
+	} else {
+		return fmt.Errorf("throttle-pipe: invalid rate or address %q", args[0])
+	}
+	cmd := exec.Command(args[1], args[2:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Not waited for: git may keep its end open until the command exits.
+	go func() {
+		copyInput(stdin)
+		stdin.Close()
+	}()
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return err
+	}
+	return nil
+}
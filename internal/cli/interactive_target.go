@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,7 +19,7 @@ func interactiveTargetSetup(cfg config.RepoConfig, repoPath string) (config.Targ
 	globalPrefs := config.LoadGlobalPrefs()
 
 	provChoice, err := promptSelect("Target hosting provider:", []string{
-		"github", "gitlab", "gitea/forgejo", "custom (existing repo)",
+		"github", "gitlab", "gitea/forgejo", "custom (existing repo)", "local (directory or .bundle)",
 	}, 0)
 	if err != nil {
 		return config.Target{}, err
@@ -26,8 +27,11 @@ func interactiveTargetSetup(cfg config.RepoConfig, repoPath string) (config.Targ
 
 	// Default label to provider name
 	defaultLabel := strings.Split(provChoice, "/")[0] // "gitea/forgejo" -> "gitea"
-	if defaultLabel == "custom (existing repo)" {
+	switch defaultLabel {
+	case "custom (existing repo)":
 		defaultLabel = "custom"
+	case "local (directory or .bundle)":
+		defaultLabel = "local"
 	}
 	label, err := promptString("Target label (alias used in commands)", defaultLabel, true)
 	if err != nil {
@@ -183,11 +187,19 @@ func interactiveTargetSetup(cfg config.RepoConfig, repoPath string) (config.Targ
 			}
 			fmt.Printf("Using URL: %s\n", repoURL)
 		}
+	case "local (directory or .bundle)":
+		provName = "local"
+		auth = config.AuthRef{Method: "none"}
+		path, _ := promptString("Local bare repo directory, or .bundle file, to write (relative to the repo)", filepath.Join("..", repoName+".git"), true)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(repoPath, path)
+		}
+		repoURL = filepath.Clean(path)
 	default:
 		return config.Target{}, provider.ErrUnsupportedProvider(provChoice)
 	}
 
-	if provName != "custom" {
+	if provName != "custom" && provName != "local" {
 		urlType, _ := promptSelect("Git URL to use for pushing:", []string{"ssh", "https"}, 0)
 		if urlType == "https" && urls.HTTPS != "" {
 			repoURL = urls.HTTPS
@@ -50,20 +50,48 @@ func Run(args []string) error {
 			return err
 		}
 		return cmdSync(s.repo, s.target, syncCmdOptions{
-			AuditAfterSync: s.audit,
-			AuditRemote:    s.auditRemote,
+			AuditAfterSync:       s.audit,
+			AuditRemote:          s.auditRemote,
+			DryRun:               s.dryRun,
+			Output:               s.output,
+			ValidateJobs:         s.validateJobs,
+			TargetJobs:           s.targetJobs,
+			Verify:               s.verify,
+			Force:                s.force,
+			IgnoreSchedule:       s.ignoreSched,
+			AllowRemoteOverwrite: s.overwrite,
+			UpdatePrivate:        s.update,
+			NoWait:               s.noWait,
+			CI:                   s.ci,
+			DetailedExitCode:     s.detailedExit,
+			Since:                s.since,
 		})
+	case "doctor":
+		fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+		repo := fs.String("repo", "", "path to repo (default: current directory)")
+		target := fs.String("target", "", "check only this target")
+		_ = fs.Parse(args[1:])
+		return cmdDoctor(*repo, *target)
+	case "preview":
+		p, err := parsePreviewArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		return cmdPreview(p)
+	case "map":
+		return cmdMap(args[1:])
 	case "status":
 		fs := flag.NewFlagSet("status", flag.ExitOnError)
 		repo := fs.String("repo", "", "path to repo (default: current directory)")
+		history := fs.Int("history", 5, "recent syncs to show per target, with their metrics")
 		_ = fs.Parse(args[1:])
-		return cmdStatus(*repo)
+		return cmdStatus(*repo, *history)
 	case "audit":
 		a, err := parseAuditArgs(args[1:])
 		if err != nil {
 			return err
 		}
-		return cmdAudit(a.repo, a.target, a.remote, []string(a.strings))
+		return cmdAudit(a)
 	case "serve":
 		fs := flag.NewFlagSet("serve", flag.ExitOnError)
 		_ = fs.Parse(args[1:])
@@ -75,6 +103,8 @@ func Run(args []string) error {
 		return cmdRoots(args[1:])
 	case "repos":
 		return cmdRepos()
+	case "cache":
+		return cmdCache(args[1:])
 	case "install":
 		fs := flag.NewFlagSet("install", flag.ExitOnError)
 		uninstall := fs.Bool("uninstall", false, "uninstall the daemon service")
@@ -84,6 +114,9 @@ func Run(args []string) error {
 		return cmdInstall(true)
 	case "show-defaults":
 		return cmdShowDefaults()
+	case "throttle-pipe":
+		// Not for users: the SSH command of throttled pushes.
+		return cmdThrottlePipe(args[1:])
 	default:
 		return fmt.Errorf("unknown command: %s", args[0])
 	}
@@ -98,15 +131,19 @@ Usage:
   %s add-target [--repo PATH]
   %s remove-target <label> [--repo PATH]
   %s list-targets [--repo PATH]
-  %s sync [--repo PATH] [--target LABEL] [--audit] [--audit-remote]
-  %s status [--repo PATH]
-  %s audit [--repo PATH] --target LABEL [--remote] [--string S ...]
+  %s sync [--repo PATH] [--target LABEL] [--audit] [--audit-remote] [--dry-run] [--output FILE] [--validate-jobs N] [--target-jobs N] [--verify-reproducible] [--force] [--ignore-schedule] [--allow-remote-overwrite] [--update-private MODE] [--no-wait] [--ci [--detailed-exit-code]] [--since DATE|TAG|COMMIT]
+  %s status [--repo PATH] [--history N]
+  %s map [--repo PATH] [--target LABEL] <commit>
+  %s doctor [--repo PATH] [--target LABEL]
+  %s preview [--repo PATH] [--target LABEL] <path ...|--all>
+  %s audit [--repo PATH] <--target LABEL|--all|--private> [--remote] [--refs] [--string S ...] [--regex RE ...] [--path GLOB ...] [--gitleaks] [--archives] [--full] [--jobs N] [--largest N] [--fail-on SEVERITY] [--severity KIND=LEVEL ...] [--json] [--sarif FILE] [--html FILE] [--apply-suggestions]
 
 Daemon:
   %s roots add <path>
   %s roots remove <path>
   %s roots list
   %s repos
+  %s cache gc [--cache-dir DIR] [--max-size SIZE] [--dry-run]
   %s serve
   %s install [--uninstall]
   %s uninstall
@@ -114,7 +151,7 @@ Daemon:
 Info:
   %s show-defaults
 
-`, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe)
+`, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe)
 }
 
 func cmdShowDefaults() error {
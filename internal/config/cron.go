@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron schedule: minute, hour, day of month, month and day
+// of week.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit i set: value i matches
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a day matching either one matches.
+	domAny, dowAny bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@nightly":  "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// ParseCron parses a five-field cron expression such as "0 3 * * 1-5" or
+// "*/15 * * * *", or one of @hourly, @daily (also @nightly), @weekly,
+// @monthly and @yearly. Fields accept "*", numbers, ranges "a-b", lists
+// "a,b" and steps "*/n" or "a-b/n"; day of week 0 and 7 are Sunday.
+func ParseCron(expr string) (Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return Cron{}, fmt.Errorf("invalid cron schedule %q: want 5 fields", expr)
+	}
+	var c Cron
+	var err error
+	for i, field := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		if *field.bits, err = parseCronField(f[i], field.min, field.max); err != nil {
+			return Cron{}, fmt.Errorf("invalid cron schedule %q: %v", expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = f[2] == "*", f[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t, to the minute, that the schedule
+// matches, in t's location. It is zero if there is none within five years
+// (e.g. "0 0 30 2 *").
+func (c Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if !c.domAny && !c.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2026, 3, 4, 10, 17, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{"@nightly", time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2026, 3, 5, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2026, 3, 8, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches.
+		{"0 12 20 * 5", time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)},
+		{"17 10 * * *", time.Date(2026, 3, 5, 10, 17, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+		}
+		if got := c.Next(from); !got.Equal(tc.want) {
+			t.Errorf("ParseCron(%q).Next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected error", expr)
+		}
+	}
+}
@@ -13,6 +13,15 @@ type DaemonConfig struct {
 	CacheDir      string        `json:"cache_dir"`
 	MaxConcurrent int           `json:"max_concurrent"`
 	NotifyOnError bool          `json:"notify_on_error"`
+	// AuditInterval is how often each target's remote mirror is cloned and
+	// audited. Zero disables remote audits.
+	AuditInterval time.Duration `json:"audit_interval,omitempty"`
+	// CacheMaxSize, e.g. "20GB", caps the size of the scrubbed repo caches
+	// and makes the daemon collect the cache's garbage hourly. Empty: no gc.
+	CacheMaxSize string `json:"cache_max_size,omitempty"`
+	// MaxUploadRate, e.g. "1MB", limits the total upload rate of each
+	// repo's sync, on top of the targets' own push.max_upload_rate.
+	MaxUploadRate string `json:"max_upload_rate,omitempty"`
 }
 
 func DefaultDaemonConfig() DaemonConfig {
@@ -18,6 +18,7 @@ func TestDaemonConfig_SaveLoad_IsolatedByXDG(t *testing.T) {
 	cfg.CacheDir = filepath.Join(tmp, "cache")
 	cfg.MaxConcurrent = 7
 	cfg.NotifyOnError = false
+	cfg.AuditInterval = 24 * time.Hour
 
 	if err := SaveDaemonConfig(cfg); err != nil {
 		t.Fatalf("SaveDaemonConfig: %v", err)
@@ -45,4 +46,7 @@ func TestDaemonConfig_SaveLoad_IsolatedByXDG(t *testing.T) {
 	if cfg2.NotifyOnError != false {
 		t.Fatalf("notify mismatch: %v", cfg2.NotifyOnError)
 	}
+	if cfg2.AuditInterval != cfg.AuditInterval {
+		t.Fatalf("audit interval mismatch: %v != %v", cfg2.AuditInterval, cfg.AuditInterval)
+	}
 }
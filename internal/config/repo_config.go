@@ -4,45 +4,340 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const RepoConfigVersion = 1
 
 type RepoConfig struct {
-	Version         int            `json:"version"`
-	PrivateUsername string         `json:"private_username"`
-	HeadBranch      string         `json:"head_branch"`
-	Defaults        TargetDefaults `json:"defaults"`
-	Targets         []Target       `json:"targets"`
+	Version             int                  `json:"version"`
+	PrivateUsername     string               `json:"private_username"`
+	AdditionalUsernames []AdditionalUsername `json:"additional_usernames,omitempty"` // more private accounts, each with its own replacement
+	MatchWholeWord      bool                 `json:"match_whole_word,omitempty"`     // only rewrite standalone occurrences
+	HeadBranch          string               `json:"head_branch"`
+	Defaults            TargetDefaults       `json:"defaults"`
+	Targets             []Target             `json:"targets"`
+	Webhooks            []string             `json:"webhooks,omitempty"`       // URLs receiving a JSON POST after each target's sync
+	UpdatePrivate       string               `json:"update_private,omitempty"` // "pull" (default), "fetch" or "never"
+}
+
+// UpdatePrivate modes: how a sync updates the private repo before reading
+// its refs. "pull" rebases a clean checkout onto its upstream and only
+// fetches a dirty one; "fetch" only fetches; "never" leaves the repo alone.
+const (
+	UpdatePrivatePull  = "pull"
+	UpdatePrivateFetch = "fetch"
+	UpdatePrivateNever = "never"
+)
+
+// AdditionalUsername is an extra private username and its replacement. An
+// empty Replacement uses the target's replacement.
+type AdditionalUsername struct {
+	Username    string `json:"username"`
+	Replacement string `json:"replacement,omitempty"`
 }
 
 type TargetDefaults struct {
 	Exclude                   []string          `json:"exclude"`
 	OptIn                     []string          `json:"opt_in"`
+	Redact                    []string          `json:"redact,omitempty"`             // keep these files but publish placeholder content
+	RedactPlaceholder         string            `json:"redact_placeholder,omitempty"` // default "This file has been removed for privacy."
 	ReplaceHistoryWithCurrent []string          `json:"replace_history_with_current,omitempty"`
 	ExtraReplacementPairs     map[string]string `json:"extra_replacements,omitempty"`
+	PathReplacements          []PathReplacement `json:"path_replacements,omitempty"`
+	PathMappings              []PathMapping     `json:"path_mappings,omitempty"`
+	SkipBinaryBlobs           bool              `json:"skip_binary_blobs,omitempty"`      // publish binary blobs without rewriting
+	BinaryExtensions          []string          `json:"binary_extensions,omitempty"`      // extra extensions treated as binary
+	MaxRewriteBlobBytes       int64             `json:"max_rewrite_blob_bytes,omitempty"` // larger blobs are published unmodified
+	RedactSecrets             bool              `json:"redact_secrets,omitempty"`         // replace well-known credential formats with REDACTED
+	EntropyCheck              *EntropyCheck     `json:"entropy_check,omitempty"`          // if set, block syncs that would publish likely credentials
+	AuthorMap                 []AuthorMapping   `json:"author_map,omitempty"`
+	Messages                  *MessageRules     `json:"messages,omitempty"`            // commit message transforms
+	SkipCommits               []string          `json:"skip_commits,omitempty"`        // regexes; matching commits are folded into their child
+	PruneEmptyMerges          bool              `json:"prune_empty_merges,omitempty"`  // collapse merges whose parents become identical
+	Trailers                  *TrailerRules     `json:"trailers,omitempty"`            // drop or rewrite Co-authored-by and similar
+	ReplacementCheck          string            `json:"replacement_check,omitempty"`   // "warn" (default), "fail" or "off" if a replacement already occurs in history
+	ForbiddenPatterns         []string          `json:"forbidden_patterns,omitempty"`  // regexes that must not match any published object
+	Symlinks                  *SymlinkPolicy    `json:"symlinks,omitempty"`            // symlinks pointing at private paths
+	ExportIgnore              bool              `json:"export_ignore,omitempty"`       // also exclude .gitattributes export-ignore paths
+	ExcludeLargerThan         string            `json:"exclude_larger_than,omitempty"` // drop blobs above this size, e.g. "10MB"
+	ExcludeExtensions         []string          `json:"exclude_extensions,omitempty"`  // drop files with these extensions, e.g. ".psd"
+}
+
+// TrailerRules drop ("drop") or rewrite to public identities ("rewrite")
+// commit message trailers. Keys defaults to Co-authored-by, Signed-off-by,
+// Reviewed-by, Acked-by, Tested-by and Reported-by.
+type TrailerRules struct {
+	Mode string   `json:"mode"`
+	Keys []string `json:"keys,omitempty"`
+}
+
+// MessageRules transform published commit messages: StripPatterns are regexes
+// removed from the message, MaxBodyLines truncates the body, and Template
+// (with {subject} and {body}) reformats it.
+type MessageRules struct {
+	StripPatterns []string       `json:"strip_patterns,omitempty"`
+	MaxBodyLines  int            `json:"max_body_lines,omitempty"`
+	Template      string         `json:"template,omitempty"`
+	IssueRefs     *IssueRefRules `json:"issue_refs,omitempty"` // remap or remove private issue references
+}
+
+// IssueRefRules rewrite issue references (Pattern, default `\B#([0-9]+)\b`,
+// with the number in group 1). Numbers listed in Map, or in MapFile (lines of
+// "private public" numbers, read from HEAD), are replaced; other references
+// are replaced with Replacement, which removes them when empty.
+type IssueRefRules struct {
+	Pattern     string            `json:"pattern,omitempty"`
+	Replacement string            `json:"replacement,omitempty"`
+	Map         map[string]string `json:"map,omitempty"`
+	MapFile     string            `json:"map_file,omitempty"`
+}
+
+// AuthorMapping gives a private identity (matched by email, or by name when
+// no email is given) its own public name and email.
+type AuthorMapping struct {
+	PrivateName  string `json:"private_name,omitempty"`
+	PrivateEmail string `json:"private_email,omitempty"`
+	PublicName   string `json:"public_name"`
+	PublicEmail  string `json:"public_email"`
+}
+
+// TimestampRule rewrites published commit and tag timestamps. Mode is
+// "keep" (default), "day", "shift" or "respace"; Shift and Interval are Go
+// durations such as "-3h" or "30m".
+type TimestampRule struct {
+	Mode     string `json:"mode"`
+	Shift    string `json:"shift,omitempty"`    // added to every timestamp in "shift" mode
+	Interval string `json:"interval,omitempty"` // gap between commits in "respace" mode, default 1h
+	Timezone string `json:"timezone,omitempty"` // publish every time with this offset, e.g. "+0000"
+}
+
+// BranchRename publishes the private branch From as To. Both are branch
+// names without refs/heads/; "prefix/*" on both sides renames a prefix.
+type BranchRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RefNamespace publishes the refs under From (a full ref prefix such as
+// "refs/heads/*") under To (e.g. "refs/heads/mirror/*").
+type RefNamespace struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TagPolicy selects the published tags. Mode is "all" (default), "none" or
+// "annotated"; Patterns, if set, keep only tags whose name matches a glob
+// such as "v*", and StripMessages empties annotated tag messages.
+type TagPolicy struct {
+	Mode          string   `json:"mode,omitempty"`
+	Patterns      []string `json:"patterns,omitempty"`
+	StripMessages bool     `json:"strip_messages,omitempty"`
+}
+
+// SymlinkPolicy handles symlinks whose target matches one of Patterns
+// (regexes, default: absolute targets). Mode is "rewrite" (replace each match
+// with Replacement), "drop" or "fail".
+type SymlinkPolicy struct {
+	Mode        string   `json:"mode"`
+	Patterns    []string `json:"patterns,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+}
+
+// EntropyCheck configures high-entropy secret detection. Zero values use defaults.
+type EntropyCheck struct {
+	Threshold    float64  `json:"threshold,omitempty"`     // bits per character, default 4.0
+	HexThreshold float64  `json:"hex_threshold,omitempty"` // for tokens of hex digits, default 3.0
+	MinLength    int      `json:"min_length,omitempty"`    // default 20
+	Allow        []string `json:"allow,omitempty"`         // regexes for tokens to ignore
+	AllowPaths   []string `json:"allow_paths,omitempty"`   // globs for files to skip
+}
+
+// PathMapping publishes files under the From prefix at the To prefix instead.
+// An empty To publishes the directory as the repository root.
+type PathMapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PathReplacement applies replacements only to files matching Paths (globs).
+type PathReplacement struct {
+	Paths        []string          `json:"paths"`
+	Replacements map[string]string `json:"replacements"`
 }
 
 type Target struct {
-	Label                     string   `json:"label"`
-	Provider                  string   `json:"provider"`
-	Account                   string   `json:"account"`
-	RepoName                  string   `json:"repo_name"`
-	RepoURL                   string   `json:"repo_url"`
-	Description               string   `json:"description,omitempty"`
-	Topics                    []string `json:"topics,omitempty"`
-	Replacement               string   `json:"replacement,omitempty"`
-	PublicAuthorName          string   `json:"public_author_name,omitempty"`
-	PublicAuthorEmail         string   `json:"public_author_email,omitempty"`
-	Exclude                   []string `json:"exclude,omitempty"`
-	OptIn                     []string `json:"opt_in,omitempty"`
-	ReplaceHistoryWithCurrent []string `json:"replace_history_with_current,omitempty"`
-	Auth                      AuthRef  `json:"auth,omitempty"`
-	InitialHistoryMode        string   `json:"initial_history_mode,omitempty"` // "full" or "future"
-	InitialSyncAt             string   `json:"initial_sync_at,omitempty"`
+	Label                     string          `json:"label"`
+	Provider                  string          `json:"provider"`
+	Account                   string          `json:"account"`
+	RepoName                  string          `json:"repo_name"`
+	RepoURL                   string          `json:"repo_url"`
+	Description               string          `json:"description,omitempty"`
+	Topics                    []string        `json:"topics,omitempty"`
+	Replacement               string          `json:"replacement,omitempty"`
+	PublicAuthorName          string          `json:"public_author_name,omitempty"`
+	PublicAuthorEmail         string          `json:"public_author_email,omitempty"`
+	AuthorMap                 []AuthorMapping `json:"author_map,omitempty"` // takes precedence over defaults.author_map
+	Exclude                   []string        `json:"exclude,omitempty"`
+	OptIn                     []string        `json:"opt_in,omitempty"`
+	Redact                    []string        `json:"redact,omitempty"`      // merged with defaults.redact
+	Include                   []string        `json:"include,omitempty"`     // if set, publish only matching paths
+	RefInclude                []string        `json:"ref_include,omitempty"` // if set, publish only matching refs
+	RefExclude                []string        `json:"ref_exclude,omitempty"`
+	Tags                      *TagPolicy      `json:"tags,omitempty"`
+	BranchRenames             []BranchRename  `json:"branch_renames,omitempty"`
+	RefNamespaces             []RefNamespace  `json:"ref_namespaces,omitempty"` // push only these prefixes instead of mirroring
+	Push                      *PushPolicy     `json:"push,omitempty"`           // how refs are pushed; default mirrors
+	Retry                     *RetryPolicy    `json:"retry,omitempty"`          // retries of failed pushes; default 3 attempts
+	Schedule                  *SyncSchedule   `json:"schedule,omitempty"`       // sync no more often than this
+	Hooks                     *SyncHooks      `json:"hooks,omitempty"`          // commands run around the push
+	Adopt                     *AdoptPolicy    `json:"adopt,omitempty"`          // keep history the target had before git-copy
+	Repack                    *RepackPolicy   `json:"repack,omitempty"`         // how the cache is repacked after a full import
+	Mirrors                   []Mirror        `json:"mirrors,omitempty"`        // more URLs the same scrubbed repo is pushed to
+	ReplaceHistoryWithCurrent []string        `json:"replace_history_with_current,omitempty"`
+	PathMappings              []PathMapping   `json:"path_mappings,omitempty"`
+	Subtree                   string          `json:"subtree,omitempty"`            // publish only this directory, as the repo root
+	LFS                       string          `json:"lfs,omitempty"`                // "keep" (default), "push", "exclude" or "error"
+	Submodules                string          `json:"submodules,omitempty"`         // "rewrite" (default), "keep" or "drop"
+	GoModule                  string          `json:"go_module,omitempty"`          // "auto" or the public Go module path
+	DropNotes                 bool            `json:"drop_notes,omitempty"`         // don't publish refs/notes/*
+	ReplaceRefs               bool            `json:"replace_refs,omitempty"`       // publish refs/replace/*, renamed to the public ids
+	KeepOriginalOIDs          bool            `json:"keep_original_oids,omitempty"` // pass original-oid lines to fast-import
+	Timestamps                *TimestampRule  `json:"timestamps,omitempty"`         // hide private working hours
+	Messages                  *MessageRules   `json:"messages,omitempty"`           // replaces defaults.messages
+	Trailers                  *TrailerRules   `json:"trailers,omitempty"`           // replaces defaults.trailers
+	Signing                   *SigningKey     `json:"signing,omitempty"`            // re-sign published tags (and commits)
+	ContentFilters            []ContentFilter `json:"content_filters,omitempty"`
+	Inject                    []InjectedFile  `json:"inject,omitempty"` // files that exist only in the public repo
+	Auth                      AuthRef         `json:"auth,omitempty"`
+	InitialHistoryMode        string          `json:"initial_history_mode,omitempty"` // "full", "future" or "squash"
+	HistoryCutoff             string          `json:"history_cutoff,omitempty"`       // date or commit; older history is squashed
+	InitialSyncAt             string          `json:"initial_sync_at,omitempty"`
+}
+
+// ContentFilter pipes published files matching Paths (globs) through
+// Command, a shell command that reads the content on stdin and writes the
+// filtered content to stdout, like a git clean filter.
+type ContentFilter struct {
+	Paths   []string `json:"paths"`
+	Command string   `json:"command"`
+}
+
+// InjectedFile is a file published at Path in every root commit of the
+// public repo, with Content, and never changed by private commits.
+type InjectedFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Signing formats for SigningKey.Format.
+const (
+	SigningOpenPGP = "openpgp"
+	SigningSSH     = "ssh"
+)
+
+// SigningKey re-signs the published tags, and the published commits when
+// Commits is set, with the public identity's key. Key is a gpg key id for
+// "openpgp" (the default) or a private key file for "ssh".
+type SigningKey struct {
+	Format  string `json:"format,omitempty"`
+	Key     string `json:"key"`
+	Commits bool   `json:"commits,omitempty"`
+}
+
+// Push modes for PushPolicy.Mode.
+const (
+	PushModeMirror = "mirror"
+	PushModeRefs   = "refs"
+)
+
+// PushPolicy selects how the scrubbed refs are pushed. "mirror" (the
+// default) force-pushes every ref and deletes remote refs the mirror lacks;
+// "refs" pushes only branches, tags and notes, and deletes refs git-copy
+// pushed before only when Prune is set, so refs created on the remote
+// survive. With ForceWithLease a ref is only overwritten or deleted while
+// the remote still has it where git-copy last pushed it. MaxUploadRate, a
+// size per second such as "512KB", limits the rate pushes over SSH and
+// HTTPS upload at.
+type PushPolicy struct {
+	Mode           string `json:"mode,omitempty"`
+	Prune          bool   `json:"prune,omitempty"`
+	ForceWithLease bool   `json:"force_with_lease,omitempty"`
+	MaxUploadRate  string `json:"max_upload_rate,omitempty"`
+}
+
+// RetryPolicy retries pushes failing for network or server errors. Attempts
+// counts the first try (1 disables retries); Delay, the wait before the
+// second try, doubles after every further failure up to MaxDelay. Both are
+// Go durations, by default "2s" and "1m".
+type RetryPolicy struct {
+	Attempts int    `json:"attempts,omitempty"`
+	Delay    string `json:"delay,omitempty"`
+	MaxDelay string `json:"max_delay,omitempty"`
+}
+
+// SyncSchedule limits how often a target is synced. With Interval (a Go
+// duration such as "1h") it is synced at most once per interval; with Cron
+// (see ParseCron, in local time) only once a scheduled time has passed
+// since its last successful sync.
+type SyncSchedule struct {
+	Interval string `json:"interval,omitempty"`
+	Cron     string `json:"cron,omitempty"`
+}
+
+// SyncHooks are shell commands run in the private repo around a target's
+// sync. PreSync runs once the target was filtered and validated, before the
+// push, and stops the sync if it fails; PostSync runs after the sync, failed
+// or not.
+type SyncHooks struct {
+	PreSync  string `json:"pre_sync,omitempty"`
+	PostSync string `json:"post_sync,omitempty"`
+}
+
+// RepackPolicy tunes the repack of a target's scrubbed cache after a full
+// import, which the first push and later pushes send from. Window and Depth
+// are git repack's --window and --depth (0: git's defaults); Force (-f)
+// recomputes every delta instead of reusing fast-import's, which is slower
+// but packs tighter. Bitmaps, if set, says whether a bitmap index is
+// written; git writes one by default, as the cache is a bare repo.
+type RepackPolicy struct {
+	Window  int   `json:"window,omitempty"`
+	Depth   int   `json:"depth,omitempty"`
+	Force   bool  `json:"force,omitempty"`
+	Bitmaps *bool `json:"bitmaps,omitempty"`
+}
+
+// Adopt modes for AdoptPolicy.Mode.
+const (
+	AdoptGraft  = "graft"
+	AdoptBranch = "branch"
+)
+
+// AdoptPolicy keeps the history a target already had, e.g. from publishing
+// by hand, instead of overwriting it on the first sync. Mode "graft" makes
+// the root commits of the scrubbed history children of the tip of the
+// target's Branch (default: the head branch's name) as of the first sync,
+// so the first push fast-forwards it. Mode "branch" publishes the scrubbed
+// branches under refs/heads/<Branch>/ (default "git-copy") and leaves every
+// other ref of the target alone.
+type AdoptPolicy struct {
+	Mode   string `json:"mode"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// Mirror is another URL a target's scrubbed repo is pushed to, after the
+// target's own, with the target's push policy. Provider and TokenEnv play
+// the part of the target's for the mirror's credentials.
+type Mirror struct {
+	URL      string `json:"url"`
+	Provider string `json:"provider,omitempty"`
+	TokenEnv string `json:"token_env,omitempty"`
 }
 
 type AuthRef struct {
@@ -95,6 +390,9 @@ func DefaultConfig(privateUsername, headBranch string) RepoConfig {
 	}
 }
 
+// timezoneOffset matches a git zone offset such as "+0000" or "-0530".
+var timezoneOffset = regexp.MustCompile(`^[+-]([01][0-9]|2[0-3])[0-5][0-9]$`)
+
 func (c *RepoConfig) Validate() error {
 	if c.Version != RepoConfigVersion && c.Version != 0 {
 		return fmt.Errorf("unsupported config version: %d", c.Version)
@@ -102,9 +400,42 @@ func (c *RepoConfig) Validate() error {
 	if strings.TrimSpace(c.PrivateUsername) == "" {
 		return errors.New("private_username is required")
 	}
+	for i, u := range c.AdditionalUsernames {
+		if strings.TrimSpace(u.Username) == "" {
+			return fmt.Errorf("additional_usernames[%d].username is required", i)
+		}
+	}
 	if strings.TrimSpace(c.HeadBranch) == "" {
 		c.HeadBranch = "main"
 	}
+	switch c.UpdatePrivate {
+	case "", UpdatePrivatePull, UpdatePrivateFetch, UpdatePrivateNever:
+	default:
+		return fmt.Errorf("update_private must be %s, %s or %s, not %q", UpdatePrivatePull, UpdatePrivateFetch, UpdatePrivateNever, c.UpdatePrivate)
+	}
+	for i, w := range c.Webhooks {
+		if u, err := url.Parse(w); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d]: %q is not an http(s) URL", i, w)
+		}
+	}
+	switch c.Defaults.ReplacementCheck {
+	case "", "warn", "fail", "off":
+	default:
+		return fmt.Errorf("defaults.replacement_check must be warn, fail or off, not %q", c.Defaults.ReplacementCheck)
+	}
+	if _, err := ParseByteSize(c.Defaults.ExcludeLargerThan); err != nil {
+		return fmt.Errorf("defaults.exclude_larger_than: %w", err)
+	}
+	for _, p := range c.Defaults.ForbiddenPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("defaults.forbidden_patterns: %w", err)
+		}
+	}
+	for _, p := range c.Defaults.SkipCommits {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("defaults.skip_commits: %w", err)
+		}
+	}
 	seen := map[string]bool{}
 	for i := range c.Targets {
 		t := &c.Targets[i]
@@ -129,10 +460,161 @@ func (c *RepoConfig) Validate() error {
 		if t.InitialHistoryMode == "" {
 			t.InitialHistoryMode = "full"
 		}
+		for _, inj := range t.Inject {
+			if strings.TrimSpace(inj.Path) == "" {
+				return fmt.Errorf("target[%s].inject: path is required", t.Label)
+			}
+		}
+		for _, cf := range t.ContentFilters {
+			if strings.TrimSpace(cf.Command) == "" || len(cf.Paths) == 0 {
+				return fmt.Errorf("target[%s].content_filters: each filter needs paths and a command", t.Label)
+			}
+		}
+		if s := t.Signing; s != nil {
+			if s.Format == "" {
+				s.Format = SigningOpenPGP
+			}
+			if s.Format != SigningOpenPGP && s.Format != SigningSSH {
+				return fmt.Errorf("target[%s].signing.format must be %q or %q", t.Label, SigningOpenPGP, SigningSSH)
+			}
+			if strings.TrimSpace(s.Key) == "" {
+				return fmt.Errorf("target[%s].signing.key is required", t.Label)
+			}
+		}
+		if p := t.Push; p != nil {
+			switch p.Mode {
+			case "", PushModeMirror, PushModeRefs:
+			default:
+				return fmt.Errorf("target[%s].push.mode must be %q or %q, not %q", t.Label, PushModeMirror, PushModeRefs, p.Mode)
+			}
+			if _, err := ParseByteSize(p.MaxUploadRate); err != nil {
+				return fmt.Errorf("target[%s].push.max_upload_rate: %w", t.Label, err)
+			}
+		}
+		if r := t.Retry; r != nil {
+			if r.Attempts < 0 {
+				return fmt.Errorf("target[%s].retry.attempts must not be negative", t.Label)
+			}
+			for _, d := range []string{r.Delay, r.MaxDelay} {
+				if d == "" {
+					continue
+				}
+				if v, err := time.ParseDuration(d); err != nil || v < 0 {
+					return fmt.Errorf("target[%s].retry: invalid duration %q", t.Label, d)
+				}
+			}
+		}
+		if r := t.Repack; r != nil && (r.Window < 0 || r.Depth < 0) {
+			return fmt.Errorf("target[%s].repack: window and depth must not be negative", t.Label)
+		}
+		mirrorURLs := map[string]bool{t.RepoURL: true}
+		for _, m := range t.Mirrors {
+			if strings.TrimSpace(m.URL) == "" {
+				return fmt.Errorf("target[%s].mirrors: url is required", t.Label)
+			}
+			if mirrorURLs[m.URL] {
+				return fmt.Errorf("target[%s].mirrors: %s is pushed to twice", t.Label, m.URL)
+			}
+			mirrorURLs[m.URL] = true
+		}
+		if a := t.Adopt; a != nil {
+			switch a.Mode {
+			case AdoptGraft:
+			case AdoptBranch:
+				if len(t.RefNamespaces) > 0 {
+					return fmt.Errorf("target[%s].adopt: mode %q can't be combined with ref_namespaces", t.Label, AdoptBranch)
+				}
+			default:
+				return fmt.Errorf("target[%s].adopt.mode must be %q or %q, not %q", t.Label, AdoptGraft, AdoptBranch, a.Mode)
+			}
+			if strings.ContainsAny(a.Branch, "*: ") {
+				return fmt.Errorf("target[%s].adopt: invalid branch %q", t.Label, a.Branch)
+			}
+		}
+		if s := t.Schedule; s != nil {
+			if (s.Interval == "") == (s.Cron == "") {
+				return fmt.Errorf("target[%s].schedule needs exactly one of interval and cron", t.Label)
+			}
+			if s.Interval != "" {
+				if d, err := time.ParseDuration(s.Interval); err != nil || d <= 0 {
+					return fmt.Errorf("target[%s].schedule: invalid interval %q", t.Label, s.Interval)
+				}
+			}
+			if s.Cron != "" {
+				if _, err := ParseCron(s.Cron); err != nil {
+					return fmt.Errorf("target[%s].schedule: %w", t.Label, err)
+				}
+			}
+		}
+		if ts := t.Timestamps; ts != nil {
+			for _, d := range []string{ts.Shift, ts.Interval} {
+				if d == "" {
+					continue
+				}
+				if _, err := time.ParseDuration(d); err != nil {
+					return fmt.Errorf("target[%s].timestamps: invalid duration %q", t.Label, d)
+				}
+			}
+			if tz := ts.Timezone; tz != "" && !timezoneOffset.MatchString(tz) {
+				return fmt.Errorf("target[%s].timestamps: invalid timezone %q (want +hhmm or -hhmm)", t.Label, tz)
+			}
+		}
 	}
 	return nil
 }
 
+// ParseByteSize parses a size such as "10MB", "512k" or "1048576". Units
+// (K, M, G, optionally followed by B or iB, case-insensitive) are powers of
+// 1024, as in git. An empty string is 0.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.TrimRight(s, "bBiIkKmMgG ")
+	unit := strings.ToLower(strings.TrimSpace(s[len(num):]))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "b"), "i")
+	mult := int64(1)
+	switch unit {
+	case "":
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// PrivateUsernames returns PrivateUsername followed by the additional usernames.
+func (c RepoConfig) PrivateUsernames() []string {
+	names := []string{c.PrivateUsername}
+	for _, u := range c.AdditionalUsernames {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+// Namespaces returns the target's ref namespaces: RefNamespaces, or the
+// branch namespace of adopt mode "branch".
+func (t Target) Namespaces() []RefNamespace {
+	if a := t.Adopt; a != nil && a.Mode == AdoptBranch {
+		branch := strings.Trim(a.Branch, "/")
+		if branch == "" {
+			branch = "git-copy"
+		}
+		return []RefNamespace{{From: "refs/heads/*", To: "refs/heads/" + branch + "/*"}}
+	}
+	return t.RefNamespaces
+}
+
 func RepoConfigPath(repoPath string) string {
 	return filepath.Join(repoPath, ".git-copy", "config.json")
 }
@@ -55,6 +55,24 @@ func TestRepoConfig_ValidateRejectsMissingFields(t *testing.T) {
 	}
 }
 
+func TestRepoConfig_ValidateMirrors(t *testing.T) {
+	cfg := DefaultConfig("obinnaokechukwu", "main")
+	cfg.Targets = []Target{{
+		Label: "public", Provider: "github", Account: "johndoe", RepoName: "repo",
+		RepoURL: "https://github.com/johndoe/repo.git",
+		Mirrors: []Mirror{{URL: "https://codeberg.org/johndoe/repo.git", Provider: "gitea", TokenEnv: "CODEBERG_TOKEN"}},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	for _, m := range []Mirror{{}, {URL: "https://github.com/johndoe/repo.git"}, {URL: "https://codeberg.org/johndoe/repo.git"}} {
+		cfg.Targets[0].Mirrors = []Mirror{{URL: "https://codeberg.org/johndoe/repo.git"}, m}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate with mirror %q: expected error", m.URL)
+		}
+	}
+}
+
 func TestDefaultConfig_ContainsExpectedExclusions(t *testing.T) {
 	cfg := DefaultConfig("test", "main")
 
@@ -185,3 +203,25 @@ func TestRepoConfig_ReplaceHistoryWithCurrent(t *testing.T) {
 		t.Errorf("Target.ReplaceHistoryWithCurrent mismatch: %v", cfg2.Targets[0].ReplaceHistoryWithCurrent)
 	}
 }
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":        0,
+		"1048576": 1 << 20,
+		"512k":    512 << 10,
+		"10MB":    10 << 20,
+		"10 MiB":  10 << 20,
+		"2g":      2 << 30,
+		"7B":      7,
+	} {
+		got, err := ParseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"MB", "10TB", "-1", "1.5M"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q): expected error", in)
+		}
+	}
+}
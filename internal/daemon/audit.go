@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/audit"
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/notify"
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
+)
+
+// auditDue reports whether the remote audit of a target is due, and if so
+// marks it as started now.
+func (s *Server) auditDue(key string, now time.Time) bool {
+	if s.Config.AuditInterval <= 0 {
+		return false
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	if s.lastAudit == nil {
+		s.lastAudit = map[string]time.Time{}
+	}
+	if last, ok := s.lastAudit[key]; ok && now.Sub(last) < s.Config.AuditInterval {
+		return false
+	}
+	s.lastAudit[key] = now
+	return true
+}
+
+// auditRemotes clones the mirror of each target of rp whose remote audit is
+// due and audits it, logging the findings and notifying about failures.
+func (s *Server) auditRemotes(ctx context.Context, rp string, cfg config.RepoConfig) {
+	var due []config.Target
+	for _, t := range cfg.Targets {
+		if s.auditDue(rp+"\x00"+t.Label, time.Now()) {
+			due = append(due, t)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	baseline, err := repo.LoadAuditBaseline(ctx, rp)
+	if err != nil {
+		log.Printf("audit baseline error [%s]: %v", rp, err)
+		if s.Config.NotifyOnError {
+			notify.Error("git-copy: audit error", fmt.Sprintf("%s: %v", rp, err))
+		}
+		return
+	}
+	for _, t := range due {
+		opts := audit.TargetOptions(cfg, t)
+		opts.Allow = baseline.Allow
+		opts.BlobCacheDir = filepath.Join(s.Config.CacheDir, "audit-blobs")
+		rep, err := auditRemote(ctx, t.RepoURL, opts)
+		if err != nil {
+			log.Printf("[%s] %s: audit error: %v", rp, t.Label, err)
+			if s.Config.NotifyOnError {
+				notify.Error("git-copy: audit error", fmt.Sprintf("%s (%s): %v", rp, t.Label, err))
+			}
+			continue
+		}
+		for _, f := range rep.Warnings {
+			log.Printf("[%s] %s: audit WARN %s %s %s (%s)", rp, t.Label, f.Kind, f.Ref, f.Path, f.Detail)
+		}
+		if rep.Succeeded {
+			log.Printf("[%s] %s: audit OK (%s)", rp, t.Label, t.RepoURL)
+			continue
+		}
+		for _, f := range rep.Findings {
+			log.Printf("[%s] %s: audit FAIL %s %s %s (%s)", rp, t.Label, f.Kind, f.Ref, f.Path, f.Detail)
+		}
+		if s.Config.NotifyOnError {
+			notify.Error("git-copy: audit failed", fmt.Sprintf("%s (%s): %d finding(s) in %s", rp, t.Label, len(rep.Findings), t.RepoURL))
+		}
+	}
+}
+
+func auditRemote(ctx context.Context, url string, opts audit.Options) (audit.Report, error) {
+	clonePath, cleanup, err := audit.CloneMirrorToTemp(ctx, url, audit.CloneOptions{})
+	if err != nil {
+		return audit.Report{}, err
+	}
+	defer cleanup()
+	return audit.AuditBareRepo(ctx, clonePath, opts)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
 )
 
 type DiscoverOptions struct {
@@ -38,7 +39,7 @@ func DiscoverRepos(ctx context.Context, opts DiscoverOptions) ([]string, error)
 						return filepath.SkipDir
 					}
 					ok, _ := gitx.IsGitRepo(repoRoot)
-					if ok && hasGitCopyConfig(ctx, repoRoot) {
+					if ok && repo.HasConfig(ctx, repoRoot) {
 						seen[repoRoot] = true
 						repos = append(repos, repoRoot)
 					}
@@ -62,21 +63,6 @@ func DiscoverRepos(ctx context.Context, opts DiscoverOptions) ([]string, error)
 	return repos, nil
 }
 
-func hasGitCopyConfig(ctx context.Context, repoRoot string) bool {
-	// working tree
-	if _, err := os.Stat(filepath.Join(repoRoot, ".git-copy", "config.json")); err == nil {
-		return true
-	}
-	// try main/master without needing the config itself
-	for _, b := range []string{"main", "master"} {
-		_, err := gitx.Run(ctx, repoRoot, "show", b+":.git-copy/config.json")
-		if err == nil {
-			return true
-		}
-	}
-	return false
-}
-
 func expandHome(p string) string {
 	if strings.HasPrefix(p, "~/") {
 		home, _ := os.UserHomeDir()
@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -10,11 +11,15 @@ import (
 	"github.com/obinnaokechukwu/git-copy/internal/config"
 	"github.com/obinnaokechukwu/git-copy/internal/notify"
 	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
 	syncer "github.com/obinnaokechukwu/git-copy/internal/sync"
 )
 
 type Server struct {
 	Config config.DaemonConfig
+
+	auditMu   sync.Mutex
+	lastAudit map[string]time.Time // by repo path and target label
 }
 
 func (s *Server) Run(ctx context.Context) error {
@@ -28,6 +33,9 @@ func (s *Server) Run(ctx context.Context) error {
 	log.Println("========================================")
 	log.Println("git-copy daemon starting")
 	log.Printf("  Poll interval: %s", s.Config.PollInterval)
+	if s.Config.AuditInterval > 0 {
+		log.Printf("  Remote audit interval: %s", s.Config.AuditInterval)
+	}
 	log.Printf("  Cache dir: %s", s.Config.CacheDir)
 	log.Printf("  Watch roots: %v", s.Config.Roots)
 	if len(s.Config.Roots) == 0 {
@@ -46,6 +54,7 @@ func (s *Server) Run(ctx context.Context) error {
 
 	ticker := time.NewTicker(s.Config.PollInterval)
 	defer ticker.Stop()
+	var lastGC time.Time
 
 	sem := make(chan struct{}, s.Config.MaxConcurrent)
 	for {
@@ -58,6 +67,14 @@ func (s *Server) Run(ctx context.Context) error {
 			if newCfg, err := config.LoadDaemonConfig(); err == nil {
 				s.Config = newCfg
 			}
+			if s.Config.CacheMaxSize != "" && time.Since(lastGC) >= cacheGCInterval {
+				lastGC = time.Now()
+				s.collectCache(ctx)
+			}
+			uploadRate, err := config.ParseByteSize(s.Config.MaxUploadRate)
+			if err != nil {
+				log.Printf("max_upload_rate: %v; pushes are not throttled", err)
+			}
 			repos, err := DiscoverRepos(ctx, DiscoverOptions{Roots: s.Config.Roots})
 			if err != nil {
 				log.Printf("discover error: %v", err)
@@ -79,7 +96,15 @@ func (s *Server) Run(ctx context.Context) error {
 						}
 						return
 					}
-					results, err := syncer.SyncRepo(ctx, rp, cfg, "", syncer.Options{CacheDir: s.Config.CacheDir, Validate: true})
+					results, err := syncer.SyncRepo(ctx, rp, cfg, "", syncer.Options{
+						CacheDir:      s.Config.CacheDir,
+						Validate:      true,
+						MaxUploadRate: uploadRate,
+						Progress: func(label string, p scrub.Progress) {
+							log.Printf("[%s] %s: %s", rp, label, p)
+						},
+						ProgressInterval: 30 * time.Second,
+					})
 					if err != nil {
 						log.Printf("sync error [%s]: %v", rp, err)
 						if s.Config.NotifyOnError {
@@ -88,15 +113,49 @@ func (s *Server) Run(ctx context.Context) error {
 						return
 					}
 					for _, r := range results {
-						if r.Error != nil {
+						var locked *syncer.LockedError
+						if errors.As(r.Error, &locked) {
+							// A manual sync is running; the next poll retries.
+							log.Printf("[%s] %v", rp, r.Error)
+						} else if r.Error != nil {
 							log.Printf("[%s] %s: ERROR %v", rp, r.TargetLabel, r.Error)
 						} else if r.DidWork {
 							log.Printf("[%s] %s: synced %s → %s", rp, r.TargetLabel, r.SourceCommit, r.TargetURL)
 						}
+						for _, m := range r.Mirrors {
+							if m.Error != nil {
+								log.Printf("[%s] %s: mirror %s: ERROR %v", rp, r.TargetLabel, m.URL, m.Error)
+							}
+						}
 					}
+					s.auditRemotes(ctx, rp, cfg)
 				}()
 			}
 			wg.Wait()
 		}
 	}
 }
+
+// cacheGCInterval is how often the cache's garbage is collected when
+// cache_max_size is set.
+const cacheGCInterval = time.Hour
+
+// collectCache removes orphaned and excess scrubbed repo caches.
+func (s *Server) collectCache(ctx context.Context) {
+	max, err := config.ParseByteSize(s.Config.CacheMaxSize)
+	if err != nil {
+		log.Printf("cache gc: cache_max_size: %v", err)
+		return
+	}
+	res, err := syncer.GC(ctx, syncer.GCOptions{CacheDir: s.Config.CacheDir, MaxSize: max})
+	if err != nil {
+		log.Printf("cache gc error: %v", err)
+		return
+	}
+	for _, r := range res.Removed {
+		log.Printf("cache gc: removed %s (%s, %s)", r.Path, scrub.FormatBytes(r.Size), r.Reason)
+	}
+	if len(res.Removed) > 0 {
+		log.Printf("cache gc: %s of caches kept", scrub.FormatBytes(res.Size))
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -39,6 +44,127 @@ func Run(ctx context.Context, dir string, args ...string) (CmdResult, error) {
 	return res, nil
 }
 
+// RunInput is Run with stdin read from input.
+func RunInput(ctx context.Context, dir string, input []byte, args ...string) (CmdResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	res := CmdResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil {
+		return res, fmt.Errorf("git %s failed: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(res.Stderr))
+	}
+	return res, nil
+}
+
+// WriteObject stores an object of the given type and returns its id.
+func WriteObject(ctx context.Context, repoPath, typ string, content []byte) (string, error) {
+	res, err := RunInput(ctx, repoPath, content, "hash-object", "-t", typ, "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// ObjectWriter stores objects through one git hash-object process per
+// object type, for callers writing many objects.
+type ObjectWriter struct {
+	ctx      context.Context
+	repoPath string
+	dir      string
+	procs    map[string]*hashObjectProc
+}
+
+type hashObjectProc struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+}
+
+// NewObjectWriter returns a writer for the objects of repoPath. It must be
+// closed.
+func NewObjectWriter(ctx context.Context, repoPath string) (*ObjectWriter, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	dir, err := os.MkdirTemp("", "git-copy-objects-")
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectWriter{ctx: ctx, repoPath: repoPath, dir: dir, procs: map[string]*hashObjectProc{}}, nil
+}
+
+// Write stores an object of the given type and returns its id.
+func (w *ObjectWriter) Write(typ string, content []byte) (string, error) {
+	p, err := w.proc(typ)
+	if err != nil {
+		return "", err
+	}
+	// hash-object reads paths, so the content goes through a file.
+	path := filepath.Join(w.dir, "object")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(p.stdin, path+"\n"); err != nil {
+		return "", fmt.Errorf("git hash-object: %w\n%s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	line, err := p.stdout.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("git hash-object: %w\n%s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (w *ObjectWriter) proc(typ string) (*hashObjectProc, error) {
+	if p, ok := w.procs[typ]; ok {
+		return p, nil
+	}
+	p := &hashObjectProc{cmd: exec.CommandContext(w.ctx, "git", "hash-object", "-w", "-t", typ, "--no-filters", "--stdin-paths")}
+	p.cmd.Dir = w.repoPath
+	p.cmd.Env = append(os.Environ(), "GIT_FLUSH=1")
+	p.cmd.Stderr = &p.stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	p.stdin, p.stdout = stdin, bufio.NewReader(stdout)
+	w.procs[typ] = p
+	return p, nil
+}
+
+// Close waits for the hash-object processes and removes the writer's
+// temporary files.
+func (w *ObjectWriter) Close() error {
+	var first error
+	for _, p := range w.procs {
+		_ = p.stdin.Close()
+		if err := p.cmd.Wait(); err != nil && first == nil {
+			first = fmt.Errorf("git hash-object failed: %w\n%s", err, strings.TrimSpace(p.stderr.String()))
+		}
+	}
+	w.procs = nil
+	if err := os.RemoveAll(w.dir); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
 func IsGitRepo(path string) (bool, error) {
 	_, err := Run(nil, path, "rev-parse", "--is-inside-work-tree")
 	if err != nil {
@@ -91,8 +217,9 @@ func FastExportCmd(repoPath string, args ...string) *exec.Cmd {
 	return cmd
 }
 
-func FastImportCmd(bareRepoPath string) *exec.Cmd {
-	cmd := exec.Command("git", "fast-import", "--force", "--quiet")
+func FastImportCmd(bareRepoPath string, args ...string) *exec.Cmd {
+	a := append([]string{"fast-import", "--force", "--quiet"}, args...)
+	cmd := exec.Command("git", a...)
 	cmd.Dir = bareRepoPath
 	return cmd
 }
@@ -102,21 +229,289 @@ func InitEmptyBare(path string) error {
 	return err
 }
 
-func PushMirror(ctx context.Context, bareRepoPath, remoteURL string, env []string) error {
+// LocalPath returns the filesystem path remoteURL names, and whether it
+// names one: a file:// URL or a path, as opposed to a network URL or
+// scp-style "host:path".
+func LocalPath(remoteURL string) (string, bool) {
+	if p, ok := strings.CutPrefix(remoteURL, "file://"); ok {
+		return p, true
+	}
+	if strings.Contains(remoteURL, "://") {
+		return "", false
+	}
+	// As in git, a colon before any slash makes it scp-style.
+	if i := strings.Index(remoteURL, ":"); i >= 0 && !strings.Contains(remoteURL[:i], "/") {
+		return "", false
+	}
+	return remoteURL, true
+}
+
+// IsBundle reports whether remoteURL names a local .bundle file.
+func IsBundle(remoteURL string) bool {
+	p, ok := LocalPath(remoteURL)
+	return ok && strings.HasSuffix(p, ".bundle")
+}
+
+// WriteBundle replaces the bundle file at path with one holding refs of
+// bareRepoPath. The bundle is written next to path and renamed into place,
+// so readers never see a partial file.
+func WriteBundle(ctx context.Context, bareRepoPath, path string, refs []string) error {
+	if len(refs) == 0 {
+		return errors.New("no refs to write to the bundle")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	tmp := abs + ".tmp"
+	input := strings.Join(refs, "\n") + "\n"
+	if _, err := RunInput(ctx, bareRepoPath, []byte(input), "bundle", "create", tmp, "--stdin"); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, abs)
+}
+
+// PushMirror force-pushes every ref and deletes remote refs that don't
+// exist locally. Like the other pushes, it returns the size of the pack
+// git sent.
+func PushMirror(ctx context.Context, bareRepoPath, remoteURL string, env []string) (int64, error) {
+	return push(ctx, bareRepoPath, env, "--mirror", "--force", remoteURL)
+}
+
+// PushPrefixes force-pushes the refs under each prefix (e.g.
+// "refs/heads/mirror/") and deletes remote refs under them that no longer
+// exist locally. Remote refs outside the prefixes are left alone.
+func PushPrefixes(ctx context.Context, bareRepoPath, remoteURL string, prefixes []string, env []string) (int64, error) {
+	args := []string{"--force", "--prune", remoteURL}
+	for _, p := range prefixes {
+		args = append(args, p+"*:"+p+"*")
+	}
+	return push(ctx, bareRepoPath, env, args...)
+}
+
+// PushRefspecs pushes refspecs ("ref:ref", or ":ref" to delete). Without
+// lease the push is forced. With lease nothing is forced: each ref named in
+// lease is only changed while the remote has it at lease[ref], or lacks it
+// if that is "", and git rejects it otherwise.
+func PushRefspecs(ctx context.Context, bareRepoPath, remoteURL string, refspecs []string, lease map[string]string, env []string) (int64, error) {
+	if len(refspecs) == 0 {
+		return 0, nil
+	}
+	var args []string
+	if lease == nil {
+		args = append(args, "--force")
+	}
+	refs := make([]string, 0, len(lease))
+	for ref := range lease {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		args = append(args, "--force-with-lease="+ref+":"+lease[ref])
+	}
+	args = append(append(args, remoteURL), refspecs...)
+	return push(ctx, bareRepoPath, env, args...)
+}
+
+// ForgeRefPrefixes are refs that hosting services create themselves and
+// that cannot be pushed, so a mirror never produces them.
+var ForgeRefPrefixes = []string{"refs/pull/", "refs/merge-requests/"}
+
+// LsRemote returns the refs remoteURL advertises, by name. HEAD and peeled
+// tags are left out.
+func LsRemote(ctx context.Context, dir, remoteURL string, env []string) (map[string]string, error) {
+	refs, _, err := LsRemoteHead(ctx, dir, remoteURL, env)
+	return refs, err
+}
+
+// LsRemoteHead is LsRemote that also returns the ref remoteURL's HEAD
+// points to, if it advertises one.
+func LsRemoteHead(ctx context.Context, dir, remoteURL string, env []string) (map[string]string, string, error) {
 	if ctx == nil {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Minute)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
 		defer cancel()
 	}
-	cmd := exec.CommandContext(ctx, "git", "push", "--mirror", "--force", remoteURL)
-	cmd.Dir = bareRepoPath
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--symref", remoteURL)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, "", fmt.Errorf("git ls-remote %s failed: %w\n%s", remoteURL, err, strings.TrimSpace(stderr.String()))
+	}
+	refs, head := map[string]string{}, ""
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 3 && f[0] == "ref:" && f[2] == "HEAD" {
+			head = f[1]
+		}
+		if len(f) != 2 || f[1] == "HEAD" || strings.HasSuffix(f[1], "^{}") {
+			continue
+		}
+		refs[f[1]] = f[0]
+	}
+	return refs, head, nil
+}
+
+// FetchObjects fetches the history of remoteURL's refs into repoPath
+// without creating any ref (bar FETCH_HEAD).
+func FetchObjects(ctx context.Context, repoPath, remoteURL string, refs []string, env []string) error {
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+	}
+	args := append([]string{"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", remoteURL}, refs...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoPath
 	if len(env) > 0 {
 		cmd.Env = append(os.Environ(), env...)
 	}
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git push --mirror failed: %w\n%s", err, strings.TrimSpace(stderr.String()))
+		return fmt.Errorf("git fetch %s failed: %w\n%s", remoteURL, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// transientMessages are git's messages for failures talking to a remote
+// that may well pass on a second try.
+var transientMessages = []string{
+	"could not resolve host",
+	"connection timed out",
+	"connection reset",
+	"connection refused",
+	"operation timed out",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"gnutls recv error",
+	"ssl_read",
+	"the requested url returned error: 429",
+	"the requested url returned error: 5",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+	"temporary failure",
+}
+
+// IsTransient reports whether err, from a push or ls-remote, looks like a
+// network or server failure worth retrying, as opposed to e.g. rejected
+// credentials or refs.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushWritten matches git's report of the pack it sent, e.g. "Writing
+// objects: 100% (3/3), 1.21 KiB | 1.21 MiB/s, done.".
+var pushWritten = regexp.MustCompile(`Writing objects: 100% \(\d+/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`)
+
+// pushedBytes returns the pack size reported in a push's progress output,
+// or 0 if nothing was written.
+func pushedBytes(progress string) int64 {
+	m := pushWritten.FindAllStringSubmatch(progress, -1)
+	if m == nil {
+		return 0
+	}
+	last := m[len(m)-1]
+	n, err := strconv.ParseFloat(last[1], 64)
+	if err != nil {
+		return 0
+	}
+	switch last[2] {
+	case "KiB":
+		n *= 1 << 10
+	case "MiB":
+		n *= 1 << 20
+	case "GiB":
+		n *= 1 << 30
+	}
+	return int64(n)
+}
+
+// progressLine matches git's progress reports, e.g. "Counting objects:
+// 50% (1/2)", "Enumerating objects: 5, done." and the final totals.
+var progressLine = regexp.MustCompile(`^(remote: )?([A-Za-z ]+: +(\d+% \(|\d+, done\.)|Total \d+ )`)
+
+// stripProgress drops the progress reports from git's stderr, leaving its
+// messages.
+func stripProgress(stderr string) string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(stderr, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if !progressLine.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func push(ctx context.Context, bareRepoPath string, env []string, args ...string) (int64, error) {
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Minute)
+		defer cancel()
+	}
+	// --progress reports the pack size even though stderr isn't a terminal.
+	cmd := exec.CommandContext(ctx, "git", append([]string{"push", "--progress"}, args...)...)
+	cmd.Dir = bareRepoPath
+	// pushedBytes and stripProgress read git's English progress messages.
+	cmd.Env = append(append(os.Environ(), env...), "LC_ALL=C")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("git push %s failed: %w\n%s", strings.Join(args, " "), err, stripProgress(stderr.String()))
+	}
+	return pushedBytes(stderr.String()), nil
+}
+
+// LFSPushObjects uploads the given LFS objects from repoPath's LFS store to
+// remoteURL. Objects missing locally are fetched first on a best-effort basis.
+func LFSPushObjects(ctx context.Context, repoPath, remoteURL string, oids []string, env []string) error {
+	if len(oids) == 0 {
+		return nil
+	}
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 60*time.Minute)
+		defer cancel()
+	}
+	_, _ = Run(ctx, repoPath, "lfs", "fetch", "--all")
+	const batch = 100
+	for i := 0; i < len(oids); i += batch {
+		end := i + batch
+		if end > len(oids) {
+			end = len(oids)
+		}
+		args := append([]string{"lfs", "push", "--object-id", remoteURL}, oids[i:end]...)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = repoPath
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git lfs push failed: %w\n%s", err, strings.TrimSpace(stderr.String()))
+		}
 	}
 	return nil
 }
@@ -130,6 +525,169 @@ func HeadShort(repoPath string) string {
 	return strings.TrimSpace(res.Stdout)
 }
 
+// RevParse resolves rev to a full commit id.
+func RevParse(repoPath, rev string) (string, error) {
+	res, err := Run(nil, repoPath, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown commit %q", rev)
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// LastCommitBefore returns the newest first-parent commit of ref committed
+// before t, or "" if there is none.
+func LastCommitBefore(repoPath, ref string, t time.Time) (string, error) {
+	res, err := Run(nil, repoPath, "rev-list", "-1", "--first-parent", "--before="+t.Format(time.RFC3339), ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// RevList returns the commit ids listed by git rev-list args.
+func RevList(repoPath string, args ...string) ([]string, error) {
+	res, err := Run(nil, repoPath, append([]string{"rev-list"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(res.Stdout), nil
+}
+
+// CommitParents returns the parent ids of commit.
+func CommitParents(repoPath, commit string) ([]string, error) {
+	res, err := Run(nil, repoPath, "rev-list", "--parents", "-n", "1", commit)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(res.Stdout)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("unknown commit %q", commit)
+	}
+	return fields[1:], nil
+}
+
+// ListFiles returns the paths of all files in the tree of rev.
+func ListFiles(repoPath, rev string) ([]string, error) {
+	res, err := Run(nil, repoPath, "ls-tree", "-r", "-z", "--name-only", "--full-tree", rev)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, p := range strings.Split(res.Stdout, "\x00") {
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// TreeEntry is one file of a recursive tree listing.
+type TreeEntry struct {
+	Mode string
+	OID  string
+	Path string
+}
+
+// ListTree returns the files (blobs and submodule commits) in the tree of rev.
+func ListTree(repoPath, rev string) ([]TreeEntry, error) {
+	res, err := Run(nil, repoPath, "ls-tree", "-r", "-z", "--full-tree", rev)
+	if err != nil {
+		return nil, err
+	}
+	var entries []TreeEntry
+	for _, rec := range strings.Split(res.Stdout, "\x00") {
+		// "<mode> SP <type> SP <oid> TAB <path>"
+		meta, p, ok := strings.Cut(rec, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 {
+			continue
+		}
+		entries = append(entries, TreeEntry{Mode: fields[0], OID: fields[2], Path: p})
+	}
+	return entries, nil
+}
+
+// ListObjects returns the ids of all objects in the repository, in no
+// particular order.
+func ListObjects(ctx context.Context, repoPath string) ([]string, error) {
+	res, err := Run(ctx, repoPath, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)", "--unordered")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(res.Stdout), nil
+}
+
+// ReadObjects calls fn with the type and content of each object in oids, in
+// order, using a single git cat-file process.
+func ReadObjects(ctx context.Context, repoPath string, oids []string, fn func(oid, typ string, content []byte) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, "git", "cat-file", "--batch")
+	cmd.Dir = repoPath
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		w := bufio.NewWriter(stdin)
+		for _, oid := range oids {
+			_, _ = w.WriteString(oid + "\n")
+		}
+		_ = w.Flush()
+		_ = stdin.Close()
+	}()
+	br := bufio.NewReader(stdout)
+	var readErr error
+	for _, oid := range oids {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			readErr = err
+			break
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			readErr = fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+			break
+		}
+		var size int
+		if _, err := fmt.Sscanf(fields[2], "%d", &size); err != nil {
+			readErr = fmt.Errorf("git cat-file: bad size in %q", strings.TrimSpace(header))
+			break
+		}
+		content := make([]byte, size+1) // payload and its trailing LF
+		if _, err := io.ReadFull(br, content); err != nil {
+			readErr = err
+			break
+		}
+		if err := fn(oid, fields[1], content[:size]); err != nil {
+			readErr = err
+			break
+		}
+	}
+	if readErr != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git cat-file --batch failed: %w\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
 func ListRefs(repoPath string) (map[string]string, error) {
 	res, err := Run(nil, repoPath, "show-ref")
 	if err != nil {
@@ -1,6 +1,9 @@
 package git
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -59,3 +62,62 @@ func TestRepoTopLevelAndIsGitRepo(t *testing.T) {
 		t.Fatalf("expected repo")
 	}
 }
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("git push --mirror failed: exit status 128\nfatal: unable to access 'https://github.com/a/b.git/': Could not resolve host: github.com"), true},
+		{errors.New("git push failed: exit status 1\nerror: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502"), true},
+		{errors.New("fatal: the remote end hung up unexpectedly"), true},
+		{errors.New("remote: Permission to a/b.git denied to c.\nfatal: unable to access: The requested URL returned error: 403"), false},
+		{errors.New(" ! [rejected]        main -> main (stale info)"), false},
+		{fmt.Errorf("push: %w", context.Canceled), false},
+		{nil, false},
+	} {
+		if got := IsTransient(tc.err); got != tc.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for _, tc := range []struct {
+		url, path string
+		local     bool
+	}{
+		{"/srv/mirror.git", "/srv/mirror.git", true},
+		{"../mirror.git", "../mirror.git", true},
+		{"file:///srv/out/repo.bundle", "/srv/out/repo.bundle", true},
+		{"./a:b/repo.git", "./a:b/repo.git", true},
+		{"git@github.com:acct/repo.git", "", false},
+		{"https://github.com/acct/repo.git", "", false},
+		{"ssh://git@host/repo.git", "", false},
+	} {
+		path, local := LocalPath(tc.url)
+		if path != tc.path || local != tc.local {
+			t.Errorf("LocalPath(%q) = %q, %v; want %q, %v", tc.url, path, local, tc.path, tc.local)
+		}
+	}
+	if !IsBundle("/tmp/out/repo.bundle") || IsBundle("/tmp/out/repo.git") || IsBundle("host:repo.bundle") {
+		t.Errorf("IsBundle misclassifies")
+	}
+}
+
+func TestPushProgress(t *testing.T) {
+	stderr := "Enumerating objects: 5, done.\n" +
+		"Counting objects:  50% (1/2)\rCounting objects: 100% (2/2), done.\n" +
+		"Writing objects:  50% (1/2)\rWriting objects: 100% (2/2), 1.50 KiB | 1.50 MiB/s, done.\n" +
+		"Total 2 (delta 0), reused 0 (delta 0), pack-reused 0\n" +
+		" ! [rejected]        main -> main (fetch first)\n"
+	if got := pushedBytes(stderr); got != 1536 {
+		t.Errorf("pushedBytes = %d, want 1536", got)
+	}
+	if got := pushedBytes("Everything up-to-date\n"); got != 0 {
+		t.Errorf("pushedBytes (nothing written) = %d, want 0", got)
+	}
+	if got, want := stripProgress(stderr), "! [rejected]        main -> main (fetch first)"; got != want {
+		t.Errorf("stripProgress = %q, want %q", got, want)
+	}
+}
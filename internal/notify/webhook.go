@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
+)
+
+// SyncEvent is the JSON payload posted to webhooks after a target's sync.
+type SyncEvent struct {
+	// Repo is git-copy's key of the private repo (its cache directory's
+	// name), which doesn't give away where it lives.
+	Repo         string `json:"repo"`
+	Target       string `json:"target"`
+	TargetURL    string `json:"target_url"`
+	SourceCommit string `json:"source_commit"`
+	// Status is "ok" or "error", with Error set.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// PushedRefs are the target's refs after the push, by name.
+	PushedRefs map[string]string `json:"pushed_refs,omitempty"`
+	NewCommits int               `json:"new_commits"`
+	DurationMS int64             `json:"duration_ms"`
+	FinishedAt time.Time         `json:"finished_at"`
+}
+
+// webhookTimeout bounds each delivery attempt, and webhookDeadline a
+// delivery with its retries, so a slow receiver holds up the sync for no
+// longer.
+const webhookTimeout = 10 * time.Second
+
+var webhookDeadline = 30 * time.Second
+
+// webhookRetry is the retry policy of deliveries.
+var webhookRetry = retry.Default
+
+// PostWebhook posts ev as JSON to url, retrying connection failures and 5xx
+// or 429 responses for up to webhookDeadline.
+func PostWebhook(ctx context.Context, url string, ev SyncEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookDeadline)
+	defer cancel()
+	retryable := func(err error) bool {
+		if se, ok := err.(*webhookStatusError); ok {
+			return se.code == http.StatusTooManyRequests || se.code >= 500
+		}
+		return true
+	}
+	_, err = retry.Do(ctx, webhookRetry, retryable, func() error {
+		actx, cancel := context.WithTimeout(ctx, webhookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(actx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "git-copy")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return &webhookStatusError{url: url, status: resp.Status, code: resp.StatusCode}
+		}
+		return nil
+	})
+	return err
+}
+
+type webhookStatusError struct {
+	url, status string
+	code        int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook %s: %s", e.url, e.status)
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
+)
+
+func TestPostWebhook(t *testing.T) {
+	defer func(p retry.Policy) { webhookRetry = p }(webhookRetry)
+	webhookRetry = retry.Policy{Attempts: 3}
+
+	var got []SyncEvent
+	codes := []int{503, 200}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev SyncEvent
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&ev) != nil {
+			w.WriteHeader(400)
+			return
+		}
+		got = append(got, ev)
+		code := codes[0]
+		if len(codes) > 1 {
+			codes = codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	ev := SyncEvent{Repo: "/src", Target: "github", Status: "ok", PushedRefs: map[string]string{"refs/heads/main": "abc"}, NewCommits: 2}
+	if err := PostWebhook(context.Background(), srv.URL, ev); err != nil {
+		t.Fatalf("PostWebhook: %v", err)
+	}
+	if len(got) != 2 || got[1].Target != "github" || got[1].PushedRefs["refs/heads/main"] != "abc" || got[1].NewCommits != 2 {
+		t.Errorf("deliveries = %+v, want the event twice (one retry)", got)
+	}
+
+	got, codes = nil, []int{404}
+	if err := PostWebhook(context.Background(), srv.URL, ev); err == nil || len(got) != 1 {
+		t.Errorf("PostWebhook to a 404: error %v after %d deliveries, want an error and no retry", err, len(got))
+	}
+}
+
+func TestPostWebhook_Deadline(t *testing.T) {
+	defer func(p retry.Policy, d time.Duration) { webhookRetry, webhookDeadline = p, d }(webhookRetry, webhookDeadline)
+	webhookRetry, webhookDeadline = retry.Policy{Attempts: 3}, 100*time.Millisecond
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	if err := PostWebhook(context.Background(), srv.URL, SyncEvent{Target: "github"}); err == nil {
+		t.Errorf("PostWebhook to a receiver that never answers: no error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("PostWebhook took %v, want about the deadline", d)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -35,7 +36,7 @@ func (p GiteaProvider) RepoExists(ctx context.Context, account, name string) (bo
 	}
 	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/repos/%s/%s", p.apiBase(), account, name), nil)
 	req.Header.Set("Authorization", "token "+p.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return false, err
 	}
@@ -49,11 +50,40 @@ func (p GiteaProvider) RepoExists(ctx context.Context, account, name string) (bo
 	return true, nil
 }
 
+// RepoInfo returns the repo's numeric id, which Gitea keeps across renames
+// and transfers.
+func (p GiteaProvider) RepoInfo(ctx context.Context, account, name string) (RepoInfo, error) {
+	if p.Token == "" {
+		return RepoInfo{}, errors.New("gitea token is required")
+	}
+	if p.apiBase() == "" {
+		return RepoInfo{}, errors.New("gitea base_url is required")
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/repos/%s/%s", p.apiBase(), account, name), nil)
+	req.Header.Set("Authorization", "token "+p.Token)
+	resp, err := doHTTP(req)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return RepoInfo{}, fmt.Errorf("gitea api error: %s", resp.Status)
+	}
+	var out struct {
+		ID       int64  `json:"id"`
+		FullName string `json:"full_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return RepoInfo{}, err
+	}
+	return RepoInfo{ID: strconv.FormatInt(out.ID, 10), FullName: out.FullName}, nil
+}
+
 // isOrganization checks if the account is an organization.
 func (p GiteaProvider) isOrganization(ctx context.Context, account string) bool {
 	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/orgs/%s", p.apiBase(), account), nil)
 	req.Header.Set("Authorization", "token "+p.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return false
 	}
@@ -65,7 +95,7 @@ func (p GiteaProvider) isOrganization(ctx context.Context, account string) bool
 func (p GiteaProvider) getAuthenticatedUser(ctx context.Context) string {
 	req, _ := http.NewRequestWithContext(ctx, "GET", p.apiBase()+"/user", nil)
 	req.Header.Set("Authorization", "token "+p.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return ""
 	}
@@ -109,7 +139,7 @@ func (p GiteaProvider) CreatePrivateRepo(ctx context.Context, account, name, des
 	req, _ := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
 	req.Header.Set("Authorization", "token "+p.Token)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return RepoURLs{}, err
 	}
@@ -147,7 +177,7 @@ func (p GiteaProvider) SetRepoTopics(ctx context.Context, account, name string,
 	req, _ := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/repos/%s/%s/topics", p.apiBase(), account, name), bytes.NewReader(b))
 	req.Header.Set("Authorization", "token "+p.Token)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return err
 	}
@@ -39,6 +39,21 @@ func (p GitHubProvider) CreatePrivateRepo(ctx context.Context, account, name, de
 	return p.apiCreatePrivateRepo(ctx, account, name, description)
 }
 
+// RepoInfo returns the repo's GraphQL node id, which GitHub keeps across
+// renames and transfers.
+func (p GitHubProvider) RepoInfo(ctx context.Context, account, name string) (RepoInfo, error) {
+	if p.UseGHCLI && ghAvailable() {
+		return ghRepoInfo(ctx, account, name)
+	}
+	return p.apiRepoInfo(ctx, account, name)
+}
+
+// githubRepo is the part of GitHub's repo object RepoInfo reads.
+type githubRepo struct {
+	NodeID   string `json:"node_id"`
+	FullName string `json:"full_name"`
+}
+
 func ghAvailable() bool {
 	_, err := exec.LookPath("gh")
 	return err == nil
@@ -81,6 +96,21 @@ func ghRepoExists(ctx context.Context, account, name string) (bool, error) {
 	return true, nil
 }
 
+func ghRepoInfo(ctx context.Context, account, name string) (RepoInfo, error) {
+	cmd := ghCommandForAccount(ctx, account, "api", fmt.Sprintf("repos/%s/%s", account, name))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return RepoInfo{}, fmt.Errorf("gh api failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	var r githubRepo
+	if err := json.Unmarshal(out, &r); err != nil {
+		return RepoInfo{}, err
+	}
+	return RepoInfo{ID: r.NodeID, FullName: r.FullName}, nil
+}
+
 func ghCreatePrivateRepo(ctx context.Context, account, name, description string) (RepoURLs, error) {
 	full := fmt.Sprintf("%s/%s", account, name)
 	args := []string{"repo", "create", full, "--private"}
@@ -111,7 +141,7 @@ func (p GitHubProvider) apiRepoExists(ctx context.Context, account, name string)
 	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/repos/%s/%s", strings.TrimRight(base, "/"), account, name), nil)
 	req.Header.Set("Authorization", "token "+p.Token)
 	req.Header.Set("Accept", "application/vnd.github+json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return false, err
 	}
@@ -125,6 +155,32 @@ func (p GitHubProvider) apiRepoExists(ctx context.Context, account, name string)
 	return true, nil
 }
 
+func (p GitHubProvider) apiRepoInfo(ctx context.Context, account, name string) (RepoInfo, error) {
+	if p.Token == "" {
+		return RepoInfo{}, errors.New("github token is required when gh is not available/authenticated")
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/repos/%s/%s", strings.TrimRight(base, "/"), account, name), nil)
+	req.Header.Set("Authorization", "token "+p.Token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := doHTTP(req)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return RepoInfo{}, fmt.Errorf("github api error: %s", resp.Status)
+	}
+	var r githubRepo
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return RepoInfo{}, err
+	}
+	return RepoInfo{ID: r.NodeID, FullName: r.FullName}, nil
+}
+
 func (p GitHubProvider) apiCreatePrivateRepo(ctx context.Context, account, name, description string) (RepoURLs, error) {
 	if p.Token == "" {
 		return RepoURLs{}, errors.New("github token is required when gh is not available/authenticated")
@@ -144,7 +200,7 @@ func (p GitHubProvider) apiCreatePrivateRepo(ctx context.Context, account, name,
 	req.Header.Set("Authorization", "token "+p.Token)
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return RepoURLs{}, err
 	}
@@ -64,3 +64,26 @@ func TestGitHubProvider_APIRepoExistsAndCreate(t *testing.T) {
 		t.Fatalf("unexpected urls: %#v", urls)
 	}
 }
+
+func TestGitHubProvider_APIRepoInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acct/repo" || r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(404)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"node_id": "R_kgDOabc", "full_name": "acct/renamed"})
+	}))
+	defer srv.Close()
+
+	p := GitHubProvider{Token: "TOKEN", BaseURL: srv.URL}
+	info, err := p.RepoInfo(context.Background(), "acct", "repo")
+	if err != nil {
+		t.Fatalf("RepoInfo: %v", err)
+	}
+	if info.ID != "R_kgDOabc" || info.FullName != "acct/renamed" {
+		t.Fatalf("unexpected info: %#v", info)
+	}
+	if _, err := p.RepoInfo(context.Background(), "acct", "missing"); err == nil {
+		t.Fatal("RepoInfo of a missing repo: expected error")
+	}
+}
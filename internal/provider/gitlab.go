@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -35,7 +36,7 @@ func (p GitLabProvider) RepoExists(ctx context.Context, account, name string) (b
 	id := url.PathEscape(account + "/" + name)
 	req, _ := http.NewRequestWithContext(ctx, "GET", p.apiBase()+"/projects/"+id, nil)
 	req.Header.Set("PRIVATE-TOKEN", p.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return false, err
 	}
@@ -49,12 +50,38 @@ func (p GitLabProvider) RepoExists(ctx context.Context, account, name string) (b
 	return true, nil
 }
 
+// RepoInfo returns the project's numeric id, which GitLab keeps across
+// renames and transfers.
+func (p GitLabProvider) RepoInfo(ctx context.Context, account, name string) (RepoInfo, error) {
+	if p.Token == "" {
+		return RepoInfo{}, errors.New("gitlab token is required")
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", p.apiBase()+"/projects/"+url.PathEscape(account+"/"+name), nil)
+	req.Header.Set("PRIVATE-TOKEN", p.Token)
+	resp, err := doHTTP(req)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return RepoInfo{}, fmt.Errorf("gitlab api error: %s", resp.Status)
+	}
+	var out struct {
+		ID         int    `json:"id"`
+		PathWithNS string `json:"path_with_namespace"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return RepoInfo{}, err
+	}
+	return RepoInfo{ID: strconv.Itoa(out.ID), FullName: out.PathWithNS}, nil
+}
+
 // getNamespaceID looks up the namespace ID for the given account (user or group).
 func (p GitLabProvider) getNamespaceID(ctx context.Context, account string) (int, error) {
 	// Try as group first
 	req, _ := http.NewRequestWithContext(ctx, "GET", p.apiBase()+"/groups/"+url.PathEscape(account), nil)
 	req.Header.Set("PRIVATE-TOKEN", p.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return 0, err
 	}
@@ -71,7 +98,7 @@ func (p GitLabProvider) getNamespaceID(ctx context.Context, account string) (int
 	// Try as user
 	req2, _ := http.NewRequestWithContext(ctx, "GET", p.apiBase()+"/users?username="+url.QueryEscape(account), nil)
 	req2.Header.Set("PRIVATE-TOKEN", p.Token)
-	resp2, err := http.DefaultClient.Do(req2)
+	resp2, err := doHTTP(req2)
 	if err != nil {
 		return 0, err
 	}
@@ -109,7 +136,7 @@ func (p GitLabProvider) CreatePrivateRepo(ctx context.Context, account, name, de
 	req, _ := http.NewRequestWithContext(ctx, "POST", p.apiBase()+"/projects", bytes.NewReader(b))
 	req.Header.Set("PRIVATE-TOKEN", p.Token)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return RepoURLs{}, err
 	}
@@ -147,7 +174,7 @@ func (p GitLabProvider) SetRepoTopics(ctx context.Context, account, name string,
 	req, _ := http.NewRequestWithContext(ctx, "PUT", p.apiBase()+"/projects/"+id, bytes.NewReader(b))
 	req.Header.Set("PRIVATE-TOKEN", p.Token)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doHTTP(req)
 	if err != nil {
 		return err
 	}
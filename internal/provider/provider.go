@@ -2,7 +2,13 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
 )
 
 type RepoURLs struct {
@@ -16,6 +22,114 @@ type Provider interface {
 	CreatePrivateRepo(ctx context.Context, account, name, description string) (RepoURLs, error)
 }
 
+// RepoInfo identifies a hosted repo.
+type RepoInfo struct {
+	// ID is the host's id of the repo. It survives renames, but not deleting
+	// and recreating the repo.
+	ID string
+	// FullName is the repo's current "account/name".
+	FullName string
+}
+
+// RepoIdentifier is implemented by providers that can look up a repo's
+// identity.
+type RepoIdentifier interface {
+	RepoInfo(ctx context.Context, account, name string) (RepoInfo, error)
+}
+
 func ErrUnsupportedProvider(p string) error {
 	return fmt.Errorf("unsupported provider: %s", p)
 }
+
+// httpRetry is the retry policy of API calls.
+var httpRetry = retry.Default
+
+// statusError is a response worth retrying.
+type statusError struct{ resp *http.Response }
+
+func (e statusError) Error() string { return e.resp.Status }
+
+// doHTTP sends req with http.DefaultClient, retrying rate-limited (429)
+// responses and, for idempotent requests, connection failures and 5xx
+// responses. A creating POST is otherwise not repeated, as the server may
+// have acted on it. The last response is returned for the caller to handle.
+func doHTTP(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodPut
+	retryable := func(err error) bool {
+		var se statusError
+		if errors.As(err, &se) {
+			return se.resp.StatusCode == http.StatusTooManyRequests || idempotent
+		}
+		return idempotent && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	var resp *http.Response
+	tries := 0
+	_, err := retry.Do(req.Context(), httpRetry, retryable, func() error {
+		if resp != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		r := req
+		if tries++; tries > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		var err error
+		if resp, err = http.DefaultClient.Do(r); err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return statusError{resp}
+		}
+		return nil
+	})
+	var se statusError
+	if errors.As(err, &se) {
+		return se.resp, nil
+	}
+	return resp, err
+}
+
+// CommitURL returns the web page of commit sha in the repo cloned from
+// repoURL (an HTTPS, ssh:// or scp-style URL), or "" if repoURL is not
+// recognized. providerName picks the provider's URL layout; GitHub's is
+// assumed when it is empty or unknown.
+func CommitURL(providerName, repoURL, sha string) string {
+	host, path := "", ""
+	switch {
+	case strings.HasPrefix(repoURL, "https://") || strings.HasPrefix(repoURL, "http://") || strings.HasPrefix(repoURL, "ssh://"):
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return ""
+		}
+		// The web server does not listen on the SSH port.
+		host, path = u.Host, u.Path
+		if u.Scheme == "ssh" {
+			host = u.Hostname()
+		}
+	case strings.Contains(repoURL, ":"):
+		// user@host:owner/repo.git
+		h, p, _ := strings.Cut(repoURL, ":")
+		if i := strings.LastIndex(h, "@"); i >= 0 {
+			h = h[i+1:]
+		}
+		host, path = h, p
+	}
+	path = strings.Trim(strings.TrimSuffix(strings.Trim(path, "/"), ".git"), "/")
+	if host == "" || path == "" || sha == "" {
+		return ""
+	}
+	scheme := "https"
+	if strings.HasPrefix(repoURL, "http://") {
+		scheme = "http"
+	}
+	commit := "/commit/"
+	if providerName == "gitlab" {
+		commit = "/-/commit/"
+	}
+	return scheme + "://" + host + "/" + path + commit + sha
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
+)
+
+func TestCommitURL(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	for _, tc := range []struct {
+		provider, repoURL, want string
+	}{
+		{"github", "https://github.com/acct/repo.git", "https://github.com/acct/repo/commit/" + sha},
+		{"github", "git@github.com:acct/repo.git", "https://github.com/acct/repo/commit/" + sha},
+		{"", "ssh://git@github.com/acct/repo", "https://github.com/acct/repo/commit/" + sha},
+		{"gitlab", "git@gitlab.com:group/sub/repo.git", "https://gitlab.com/group/sub/repo/-/commit/" + sha},
+		{"gitea", "ssh://git@git.example.com:2222/acct/repo.git", "https://git.example.com/acct/repo/commit/" + sha},
+		{"gitea", "http://localhost:3000/acct/repo.git", "http://localhost:3000/acct/repo/commit/" + sha},
+		{"", "/srv/git/repo.git", ""},
+	} {
+		if got := CommitURL(tc.provider, tc.repoURL, sha); got != tc.want {
+			t.Errorf("CommitURL(%q, %q) = %q, want %q", tc.provider, tc.repoURL, got, tc.want)
+		}
+	}
+}
+
+func TestDoHTTP_Retries(t *testing.T) {
+	defer func(p retry.Policy) { httpRetry = p }(httpRetry)
+	httpRetry = retry.Policy{Attempts: 3}
+
+	var calls int
+	var bodies []string
+	status := func(codes ...int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			code := codes[len(codes)-1]
+			if calls < len(codes) {
+				code = codes[calls]
+			}
+			calls++
+			w.WriteHeader(code)
+		}
+	}
+	send := func(h http.HandlerFunc, method, body string) int {
+		t.Helper()
+		calls, bodies = 0, nil
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		req, _ := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		resp, err := doHTTP(req)
+		if err != nil {
+			t.Fatalf("doHTTP: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := send(status(503, 502, 200), "GET", ""); code != 200 || calls != 3 {
+		t.Errorf("GET through two server errors: status %d after %d calls", code, calls)
+	}
+	if code := send(status(500), "GET", ""); code != 500 || calls != 3 {
+		t.Errorf("GET failing every time: status %d after %d calls, want 500 after 3", code, calls)
+	}
+	if code := send(status(503, 201), "POST", "{}"); code != 503 || calls != 1 {
+		t.Errorf("POST with a server error: status %d after %d calls, want no retry", code, calls)
+	}
+	if code := send(status(429, 201), "POST", "{}"); code != 201 || calls != 2 || bodies[1] != "{}" {
+		t.Errorf("rate-limited POST: status %d after %d calls, bodies %q", code, calls, bodies)
+	}
+}
@@ -7,10 +7,25 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/obinnaokechukwu/git-copy/internal/audit"
 	"github.com/obinnaokechukwu/git-copy/internal/config"
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
 )
 
+// HasConfig reports whether the repo has a git-copy config in the working
+// tree or on main/master, valid or not.
+func HasConfig(ctx context.Context, repoPath string) bool {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git-copy", "config.json")); err == nil {
+		return true
+	}
+	for _, b := range []string{"main", "master"} {
+		if _, err := gitx.Run(ctx, repoPath, "show", b+":.git-copy/config.json"); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // LoadRepoConfigFromAnyBranch loads .git-copy/config.json from:
 // 1) working tree, if present
 // 2) head branch candidates main/master (via git show)
@@ -44,3 +59,19 @@ func LoadRepoConfigFromAnyBranch(ctx context.Context, repoPath string) (config.R
 
 	return config.RepoConfig{}, fmt.Errorf("git-copy config not found in working tree or main/master")
 }
+
+// LoadAuditBaseline loads .git-copy/audit-allow.json from the working tree
+// or, failing that, the main/master branch. A missing baseline is empty.
+func LoadAuditBaseline(ctx context.Context, repoPath string) (audit.Baseline, error) {
+	if b, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(audit.BaselineFile))); err == nil {
+		return audit.ParseBaseline(b)
+	}
+	for _, b := range []string{"main", "master"} {
+		res, err := gitx.Run(ctx, repoPath, "show", b+":"+audit.BaselineFile)
+		if err != nil {
+			continue
+		}
+		return audit.ParseBaseline([]byte(res.Stdout))
+	}
+	return audit.Baseline{}, nil
+}
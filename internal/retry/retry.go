@@ -0,0 +1,60 @@
+// Package retry retries operations that fail for transient reasons, such as
+// network errors, with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Policy says how often and how patiently a failing operation is retried.
+type Policy struct {
+	// Attempts is the number of tries, the first included (at least 1).
+	Attempts int
+	// Delay is the wait before the second try. It doubles after every
+	// further failure, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Default retries a failure twice, after about 2s and 4s.
+var Default = Policy{Attempts: 3, Delay: 2 * time.Second, MaxDelay: time.Minute}
+
+// sleep waits between tries. Tests replace it.
+var sleep = defaultSleep
+
+// defaultSleep waits for d or until ctx is done.
+func defaultSleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Do calls fn until it succeeds, it fails with an error retryable rejects,
+// or p.Attempts tries were made. Each wait is a random duration between
+// half and all of the current delay, so concurrent clients spread out. It
+// returns the number of tries and fn's last error.
+func Do(ctx context.Context, p Policy, retryable func(error) bool, fn func() error) (int, error) {
+	delay := p.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !retryable(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+		if delay > 0 {
+			wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			if serr := sleep(ctx, wait); serr != nil {
+				return attempt, err
+			}
+		}
+		if delay *= 2; p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
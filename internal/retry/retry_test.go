@@ -0,0 +1,63 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDo_BacksOffUntilSuccess(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	defer func() { sleep = defaultSleep }()
+
+	flaky := errors.New("connection reset")
+	calls := 0
+	attempts, err := Do(context.Background(), Policy{Attempts: 5, Delay: time.Second, MaxDelay: 3 * time.Second},
+		func(error) bool { return true },
+		func() error {
+			if calls++; calls < 4 {
+				return flaky
+			}
+			return nil
+		})
+	if err != nil || attempts != 4 {
+		t.Fatalf("Do = %d, %v; want 4 attempts and no error", attempts, err)
+	}
+	// Delays of 1s, 2s and 3s (capped), each jittered into [d/2, d].
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if len(waits) != len(want) {
+		t.Fatalf("waits = %v", waits)
+	}
+	for i, w := range waits {
+		if w < want[i]/2 || w > want[i] {
+			t.Errorf("wait %d = %v, want within [%v, %v]", i, w, want[i]/2, want[i])
+		}
+	}
+}
+
+func TestDo_StopsOnPermanentErrorAndAfterAttempts(t *testing.T) {
+	sleep = func(context.Context, time.Duration) error { return nil }
+	defer func() { sleep = defaultSleep }()
+
+	permanent := errors.New("authentication failed")
+	calls := 0
+	attempts, err := Do(context.Background(), Default,
+		func(err error) bool { return err != permanent },
+		func() error { calls++; return permanent })
+	if !errors.Is(err, permanent) || attempts != 1 || calls != 1 {
+		t.Errorf("permanent error: Do = %d, %v after %d calls", attempts, err, calls)
+	}
+
+	calls = 0
+	attempts, err = Do(context.Background(), Policy{Attempts: 3},
+		func(error) bool { return true },
+		func() error { calls++; return errors.New("timeout") })
+	if err == nil || attempts != 3 || calls != 3 {
+		t.Errorf("transient error: Do = %d, %v after %d calls, want 3 attempts", attempts, err, calls)
+	}
+}
@@ -0,0 +1,146 @@
+package scrub
+
+// acMatcher finds many literal patterns in one pass (Aho–Corasick), folding
+// ASCII case. Matches are leftmost-longest and non-overlapping, the same
+// result as a regexp alternation of the patterns sorted longest first.
+type acMatcher struct {
+	// classes maps each (lowercased) byte to its column in next; bytes that
+	// occur in no pattern share class 0.
+	classes [256]uint8
+	width   int
+	// next is the full transition table, len(states)*width entries.
+	next []int32
+	// depth is the length of the prefix each state represents.
+	depth []int32
+	// out is the longest pattern ending at each state, or -1.
+	out []int32
+	// lens are the pattern lengths, indexed like the patterns.
+	lens []int32
+}
+
+// acSupported reports whether pattern s can be matched by an acMatcher:
+// non-empty and ASCII, so byte-wise case folding agrees with (?i).
+func acSupported(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func foldByte(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// newACMatcher builds a matcher for patterns, which must all satisfy
+// acSupported. Match results refer to patterns by index.
+func newACMatcher(patterns []string) *acMatcher {
+	m := &acMatcher{lens: make([]int32, len(patterns))}
+	n := 1
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			c := foldByte(p[i])
+			if m.classes[c] == 0 {
+				m.classes[c] = uint8(n)
+				n++
+			}
+		}
+	}
+	for b := 'A'; b <= 'Z'; b++ {
+		m.classes[b] = m.classes[b+'a'-'A']
+	}
+	m.width = n
+
+	// Build the trie; 0 in next means "no edge" until failures are filled in.
+	m.next = make([]int32, m.width)
+	m.depth = []int32{0}
+	m.out = []int32{-1}
+	for i, p := range patterns {
+		m.lens[i] = int32(len(p))
+		s := int32(0)
+		for j := 0; j < len(p); j++ {
+			col := int32(s)*int32(m.width) + int32(m.classes[p[j]])
+			if m.next[col] == 0 {
+				m.next[col] = int32(len(m.depth))
+				m.next = append(m.next, make([]int32, m.width)...)
+				m.depth = append(m.depth, int32(j+1))
+				m.out = append(m.out, -1)
+			}
+			s = m.next[col]
+		}
+		if m.out[s] < 0 {
+			m.out[s] = int32(i)
+		}
+	}
+
+	// Breadth-first, turn missing edges into failure transitions and inherit
+	// the failure state's output when a state ends no pattern itself.
+	fail := make([]int32, len(m.depth))
+	queue := make([]int32, 0, len(m.depth))
+	for c := 1; c < m.width; c++ {
+		if t := m.next[c]; t != 0 {
+			queue = append(queue, t)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if m.out[s] < 0 {
+			m.out[s] = m.out[fail[s]]
+		}
+		for c := 0; c < m.width; c++ {
+			col := int(s)*m.width + c
+			t := m.next[col]
+			f := m.next[int(fail[s])*m.width+c]
+			if t == 0 {
+				m.next[col] = f
+				continue
+			}
+			fail[t] = f
+			queue = append(queue, t)
+		}
+	}
+	return m
+}
+
+// findAll calls fn with the pattern index and [start, end) of every
+// leftmost-longest, non-overlapping match in b.
+func (m *acMatcher) findAll(b []byte, fn func(pat, start, end int)) {
+	for pos := 0; pos < len(b); {
+		pat, start, end := m.findFrom(b, pos)
+		if pat < 0 {
+			return
+		}
+		fn(pat, start, end)
+		pos = end
+	}
+}
+
+// findFrom returns the leftmost-longest match in b at or after pos, or a
+// negative pattern index if there is none.
+func (m *acMatcher) findFrom(b []byte, pos int) (pat, start, end int) {
+	s := int32(0)
+	pat = -1
+	for i := pos; i < len(b); i++ {
+		s = m.next[int(s)*m.width+int(m.classes[b[i]])]
+		if p := m.out[s]; p >= 0 {
+			st := i + 1 - int(m.lens[p])
+			if pat < 0 || st < start || (st == start && i+1 > end) {
+				pat, start, end = int(p), st, i+1
+			}
+		}
+		// Once no match in progress can start at or before the candidate,
+		// the candidate is final.
+		if pat >= 0 && i+1-int(m.depth[s]) > start {
+			return pat, start, end
+		}
+	}
+	return pat, start, end
+}
@@ -0,0 +1,77 @@
+package scrub
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestACMatcher_MatchesRegexpAlternation(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "a", "ab", "abc", "bcd", "Cd", "x.y"}
+	sorted := append([]string{}, patterns...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var quoted []string
+	for _, p := range sorted {
+		quoted = append(quoted, regexp.QuoteMeta(p))
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	m := newACMatcher(patterns)
+
+	rng := rand.New(rand.NewSource(1))
+	alphabet := "abcdehirsxyHSCD. "
+	for n := 0; n < 2000; n++ {
+		b := make([]byte, rng.Intn(40))
+		for i := range b {
+			b[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		var got [][]int
+		m.findAll(b, func(_, start, end int) { got = append(got, []int{start, end}) })
+		want := re.FindAllIndex(b, -1)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("findAll(%q) = %v, want %v", b, got, want)
+		}
+	}
+}
+
+func TestExtraReplacements_SinglePass(t *testing.T) {
+	c, err := Compile(Rules{
+		PrivateUsername: "alice",
+		Replacement:     "bob",
+		ExtraReplacements: map[string]string{
+			"acme":        "example",
+			"acme-widget": "gadget",
+			"example":     "sample",
+			"café":        "cafe",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got := string(c.RewriteBytes([]byte("ACME acme-widget Acme example Café")))
+	// The longest key wins and replacements aren't rewritten again; the
+	// non-ASCII key still folds case.
+	want := "EXAMPLE gadget Example sample Cafe"
+	if got != want {
+		t.Errorf("RewriteBytes = %q, want %q", got, want)
+	}
+}
+
+func BenchmarkRewriteBytes_ManyExtraReplacements(b *testing.B) {
+	extra := map[string]string{}
+	for i := 0; i < 200; i++ {
+		extra[fmt.Sprintf("internal-host-%03d.corp", i)] = fmt.Sprintf("host-%03d.example", i)
+	}
+	c, err := Compile(Rules{PrivateUsername: "alice", Replacement: "bob", ExtraReplacements: extra})
+	if err != nil {
+		b.Fatalf("Compile: %v", err)
+	}
+	blob := []byte(strings.Repeat("func main() { connect(\"internal-host-042.corp\") } // nothing to see\n", 2000))
+	b.SetBytes(int64(len(blob)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.RewriteBytes(blob)
+	}
+}
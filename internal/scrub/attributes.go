@@ -0,0 +1,89 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+)
+
+// exportIgnorePatterns returns exclude globs for the export-ignore entries of
+// the given .gitattributes files (path -> content), matching what git archive
+// leaves out. An entry naming a directory excludes everything below it.
+// Unset (-export-ignore) and unspecified (!export-ignore) overrides are not
+// supported and are ignored, as are macro definitions.
+func exportIgnorePatterns(attributes map[string][]byte) []string {
+	files := make([]string, 0, len(attributes))
+	for p := range attributes {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+
+	var out []string
+	for _, file := range files {
+		dir := path.Dir(normPath(file))
+		if dir == "." {
+			dir = ""
+		}
+		sc := bufio.NewScanner(bytes.NewReader(attributes[file]))
+		for sc.Scan() {
+			pat, ok := exportIgnoreEntry(sc.Text())
+			if !ok {
+				continue
+			}
+			glob := attributeGlob(dir, pat)
+			out = append(out, glob, glob+"/**")
+		}
+	}
+	return out
+}
+
+// exportIgnoreEntry returns the pattern of a .gitattributes line that sets
+// export-ignore.
+func exportIgnoreEntry(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[attr]") {
+		return "", false
+	}
+	var pat, rest string
+	if strings.HasPrefix(line, `"`) {
+		p, n, err := unquotePath(line)
+		if err != nil {
+			return "", false
+		}
+		pat, rest = p, line[n:]
+	} else {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return "", false
+		}
+		pat, rest = line[:i], line[i:]
+	}
+	set := false
+	for _, attr := range strings.Fields(rest) {
+		switch attr {
+		case "export-ignore", "export-ignore=true":
+			set = true
+		case "-export-ignore", "!export-ignore":
+			set = false
+		}
+	}
+	return pat, set && pat != ""
+}
+
+// attributeGlob converts a .gitattributes pattern in directory dir to an
+// exclude glob anchored at the repository root. As in .gitignore, a pattern
+// without a slash matches at any depth below dir.
+func attributeGlob(dir, pat string) string {
+	pat = strings.TrimSuffix(pat, "/")
+	anchored := strings.Contains(pat, "/")
+	pat = strings.TrimPrefix(pat, "/")
+	if !anchored && !strings.HasPrefix(pat, "**") {
+		pat = "**/" + pat
+	}
+	if dir == "" {
+		return pat
+	}
+	return dir + "/" + pat
+}
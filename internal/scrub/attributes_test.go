@@ -0,0 +1,41 @@
+package scrub
+
+import "testing"
+
+func TestCompile_ExportIgnoreAttributes(t *testing.T) {
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		GitAttributes: map[string][]byte{
+			".gitattributes": []byte("# release tarballs\n" +
+				"*.go text eol=lf\n" +
+				"/testdata export-ignore\n" +
+				"*.psd export-ignore binary\n" +
+				"\"with space.txt\" export-ignore\n" +
+				"docs/internal/ export-ignore\n" +
+				"kept.txt export-ignore -export-ignore\n"),
+			"tools/.gitattributes": []byte("scratch.sh export-ignore\n"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	for p, want := range map[string]bool{
+		"testdata/big.bin":      true,
+		"pkg/testdata/x.go":     false,
+		"art/logo.psd":          true,
+		"logo.psd":              true,
+		"with space.txt":        true,
+		"docs/internal/plan.md": true,
+		"docs/guide.md":         false,
+		"kept.txt":              false,
+		"tools/scratch.sh":      true,
+		"tools/sub/scratch.sh":  true,
+		"scratch.sh":            false,
+		"main.go":               false,
+	} {
+		if got := rules.ShouldExclude(p); got != want {
+			t.Errorf("ShouldExclude(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package scrub
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// compoundSeparators may appear between the words of a compound token such
+// as OBINNA_OKECHUKWU_TOKEN or obinna-okechukwu (see compoundPattern).
+const compoundSeparators = "_-."
+
+// minCompoundWord is the shortest word compoundPattern splits off a
+// single-word name, so "alice" doesn't match "a-l-i-c-e" or "al_ice".
+const minCompoundWord = 3
+
+// compoundPattern returns a regexp matching name with at most one compound
+// separator between two of its words, as split by splitCompound: a name
+// written "obinna_okechukwu" or "obinnaOkechukwu" also matches
+// "obinnaokechukwu" and "Obinna-Okechukwu". A single-word name has no
+// boundaries to go by, so it matches with one separator anywhere that leaves
+// minCompoundWord characters on each side: "obinnaokechukwu" matches
+// "OBINNA_OKECHUKWU", but "alice" doesn't match "a-l-i-c-e".
+func compoundPattern(name string) string {
+	words, _ := splitCompound(name)
+	if len(words) == 0 {
+		return regexp.QuoteMeta(name)
+	}
+	if len(words) == 1 {
+		runes := []rune(words[0])
+		alts := []string{regexp.QuoteMeta(words[0])}
+		for i := minCompoundWord; i <= len(runes)-minCompoundWord; i++ {
+			alts = append(alts, regexp.QuoteMeta(string(runes[:i]))+`[_\-.]`+regexp.QuoteMeta(string(runes[i:])))
+		}
+		return "(?:" + strings.Join(alts, "|") + ")"
+	}
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return strings.Join(words, `[_\-.]?`)
+}
+
+// splitCompound splits a token into words at separators and at lower-to-upper
+// case transitions, returning the words and the first separator used.
+func splitCompound(s string) (words []string, sep string) {
+	var cur []rune
+	var prev rune
+	for _, r := range s {
+		if strings.ContainsRune(compoundSeparators, r) {
+			if sep == "" {
+				sep = string(r)
+			}
+			if len(cur) > 0 {
+				words = append(words, string(cur))
+			}
+			cur, prev = nil, 0
+			continue
+		}
+		if len(cur) > 0 && unicode.IsLower(prev) && unicode.IsUpper(r) {
+			words = append(words, string(cur))
+			cur = nil
+		}
+		cur = append(cur, r)
+		prev = r
+	}
+	if len(cur) > 0 {
+		words = append(words, string(cur))
+	}
+	return words, sep
+}
+
+// applyCompoundCase is applyCasePattern for matches that may be compound
+// tokens. For a compound match (obinnaOkechukwu, OBINNA_OKECHUKWU) the
+// replacement's own words (john_doe, johnDoe) are joined with the match's
+// separator and each cased like the corresponding word of the match; a
+// single-word replacement takes the case of the match's first word.
+func applyCompoundCase(match, replacement string) string {
+	segs, sep := splitCompound(match)
+	if len(segs) <= 1 {
+		return applyCasePattern(match, replacement)
+	}
+	words, _ := splitCompound(replacement)
+	if len(words) <= 1 {
+		return applyCasePattern(segs[0], replacement)
+	}
+	out := make([]string, len(words))
+	for i, w := range words {
+		seg := segs[len(segs)-1]
+		if i < len(segs) {
+			seg = segs[i]
+		}
+		out[i] = applyCasePattern(seg, w)
+	}
+	return strings.Join(out, sep)
+}
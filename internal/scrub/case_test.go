@@ -0,0 +1,62 @@
+package scrub
+
+import "testing"
+
+func TestRules_CompoundTokenCase(t *testing.T) {
+	cases := []struct {
+		username, repl, input, want string
+	}{
+		{"obinnaokechukwu", "johndoe", "obinnaOkechukwuClient", "johndoeClient"},
+		{"obinnaokechukwu", "johndoe", "ObinnaOkechukwuClient", "JohndoeClient"},
+		{"obinnaokechukwu", "john_doe", "obinnaOkechukwuClient", "johnDoeClient"},
+		{"obinnaokechukwu", "john_doe", "ObinnaOkechukwuClient", "JohnDoeClient"},
+		// A single-word username matches with one separator between words
+		// of at least three letters.
+		{"obinnaokechukwu", "johndoe", "OBINNA_OKECHUKWU_TOKEN", "JOHNDOE_TOKEN"},
+		{"obinnaokechukwu", "john_doe", "OBINNA_OKECHUKWU_TOKEN", "JOHN_DOE_TOKEN"},
+		{"obinnaokechukwu", "john_doe", "obinna-okechukwu.dev", "john-doe.dev"},
+		{"obinnaokechukwu", "johndoe", "Obinna.Okechukwu", "Johndoe"},
+		// Separators need the username's own word boundaries.
+		{"obinna_okechukwu", "johndoe", "OBINNA_OKECHUKWU_TOKEN", "JOHNDOE_TOKEN"},
+		{"obinna_okechukwu", "john_doe", "OBINNA_OKECHUKWU_TOKEN", "JOHN_DOE_TOKEN"},
+		{"obinnaOkechukwu", "john_doe", "obinna-okechukwu.dev", "john-doe.dev"},
+		{"obinna-okechukwu", "john_doe", "obinna_okechukwu", "john_doe"},
+		{"obinna_okechukwu", "john_doe", "obinnaokechukwu", "john_doe"},
+		// Plain tokens keep the existing behaviour.
+		{"obinnaokechukwu", "john_doe", "obinnaokechukwu", "john_doe"},
+		{"obinnaokechukwu", "johndoe", "OBINNAOKECHUKWU", "JOHNDOE"},
+	}
+	for _, tc := range cases {
+		r, err := Compile(Rules{PrivateUsername: tc.username, Replacement: tc.repl})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		if got := r.RewriteString(tc.input); got != tc.want {
+			t.Errorf("username %q, replacement %q: RewriteString(%q) = %q, want %q", tc.username, tc.repl, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRules_CompoundSeparatorsOnlyBetweenWords(t *testing.T) {
+	cases := []struct {
+		username, input string
+	}{
+		{"alice", "a-l-i-c-e"},
+		{"alice", "al_ice"},
+		{"alice", "ali.ce"},
+		{"alice", "alic-e"},
+		{"obinnaokechukwu", "obinna_oke_chukwu"},
+		{"obinnaokechukwu", "obinna__okechukwu"},
+		{"obinna_okechukwu", "obinn_aokechukwu"},
+		{"obinna_okechukwu", "obinna__okechukwu"},
+	}
+	for _, tc := range cases {
+		r, err := Compile(Rules{PrivateUsername: tc.username, Replacement: "johndoe"})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		if got := r.RewriteString(tc.input); got != tc.input {
+			t.Errorf("username %q: RewriteString(%q) = %q, want it unchanged", tc.username, tc.input, got)
+		}
+	}
+}
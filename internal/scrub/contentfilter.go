@@ -0,0 +1,136 @@
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ContentFilter pipes the content of published files matching Patterns
+// through an external command, like a git clean filter, before replacement
+// rules apply.
+type ContentFilter struct {
+	Patterns []string
+	// Command is run with sh -c, reading the file on stdin and writing the
+	// filtered content to stdout. GIT_COPY_PATH holds the file's path.
+	Command string
+}
+
+type compiledContentFilter struct {
+	patterns []string
+	command  string
+}
+
+func compileContentFilters(filters []ContentFilter) ([]compiledContentFilter, error) {
+	var out []compiledContentFilter
+	for _, cf := range filters {
+		if strings.TrimSpace(cf.Command) == "" {
+			return nil, errors.New("content filter command is required")
+		}
+		c := compiledContentFilter{command: cf.Command}
+		for _, p := range cf.Patterns {
+			if p = normPath(p); p != "" {
+				c.patterns = append(c.patterns, p)
+			}
+		}
+		if len(c.patterns) == 0 {
+			return nil, fmt.Errorf("content filter %q has no paths", cf.Command)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// ContentFilterError reports a content filter command that failed.
+type ContentFilterError struct {
+	Path    string
+	Commit  string
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e ContentFilterError) Error() string {
+	msg := fmt.Sprintf("content filter %q failed on %s at commit %s: %v", e.Command, e.Path, e.Commit, e.Err)
+	if e.Stderr != "" {
+		msg += "\n" + e.Stderr
+	}
+	return msg
+}
+
+func (e ContentFilterError) Unwrap() error { return e.Err }
+
+// contentFiltersFor returns the content filters applying to path p, in
+// configuration order.
+func (c CompiledRules) contentFiltersFor(p string) []compiledContentFilter {
+	if len(c.contentFilters) == 0 || c.Verbatim(p) {
+		return nil
+	}
+	p = normPath(p)
+	var out []compiledContentFilter
+	for _, cf := range c.contentFilters {
+		for _, pat := range cf.patterns {
+			if matchGlob(pat, p) {
+				out = append(out, cf)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// filterContent runs the content filters for path p over raw. LFS pointers
+// are left alone.
+func (f *ExportFilter) filterContent(raw []byte, p string) ([]byte, error) {
+	filters := f.rules.contentFiltersFor(p)
+	if len(filters) == 0 {
+		return raw, nil
+	}
+	if _, ok := LFSPointerOID(raw); ok {
+		return raw, nil
+	}
+	for _, cf := range filters {
+		var out bytes.Buffer
+		if err := f.runContentFilter(cf, bytes.NewReader(raw), &out, p); err != nil {
+			return nil, err
+		}
+		raw = out.Bytes()
+	}
+	return raw, nil
+}
+
+// filterSpooledContent is filterContent for a payload held in a temp file.
+// It returns s itself when no filter applies; otherwise the caller must close
+// the returned file.
+func (f *ExportFilter) filterSpooledContent(s *spoolFile, p string) (*spoolFile, error) {
+	filters := f.rules.contentFiltersFor(p)
+	cur := s
+	for _, cf := range filters {
+		next, err := spool(func(w io.Writer) error { return f.runContentFilter(cf, cur.reader(), w, p) })
+		if cur != s {
+			cur.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func (f *ExportFilter) runContentFilter(cf compiledContentFilter, in io.Reader, out io.Writer, p string) error {
+	cmd := exec.Command("sh", "-c", cf.command)
+	cmd.Env = append(os.Environ(), "GIT_COPY_PATH="+p)
+	var stderr bytes.Buffer
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return ContentFilterError{Path: p, Commit: f.curCommit, Command: cf.command, Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_ContentFilters(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	// Identical content => one blob, filtered for the notebook path only.
+	content := "cell by obinnaokechukwu\noutput: secret-output\n"
+	commitFiles(t, repo, map[string]string{"nb/analysis.ipynb": content, "notes.txt": content}, "Add notebook")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		ContentFilters:  []ContentFilter{{Patterns: []string{"**/*.ipynb"}, Command: `grep -v '^output:'; echo "# $GIT_COPY_PATH"`}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	for p, want := range map[string]string{
+		"nb/analysis.ipynb": "cell by johndoe\n# nb/analysis.ipynb\n",
+		"notes.txt":         "cell by johndoe\noutput: secret-output\n",
+	} {
+		show, err := gitx.Run(nil, bare, "show", "refs/heads/main:"+p)
+		if err != nil {
+			t.Fatalf("show %s: %v", p, err)
+		}
+		if show.Stdout != want {
+			t.Errorf("%s = %q, want %q", p, show.Stdout, want)
+		}
+	}
+}
+
+func TestExportFilter_ContentFilterFailure(t *testing.T) {
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		ContentFilters:  []ContentFilter{{Patterns: []string{"*.png"}, Command: "echo broken >&2; exit 3"}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	in := strings.Join([]string{
+		"blob", "mark :1", "data 3", "png",
+		"commit refs/heads/main", "mark :2",
+		"author A <a@example.com> 0 +0000", "committer A <a@example.com> 0 +0000",
+		"data 0", "M 100644 :1 logo.png", "", "",
+	}, "\n")
+	var out bytes.Buffer
+	err = NewExportFilter(rules).Filter(strings.NewReader(in), &out)
+	var cfe ContentFilterError
+	if !errors.As(err, &cfe) {
+		t.Fatalf("Filter error = %v, want ContentFilterError", err)
+	}
+	if cfe.Path != "logo.png" || cfe.Stderr != "broken" {
+		t.Errorf("error = %+v", cfe)
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", ContentFilters: []ContentFilter{{Command: "cat"}}}); err == nil {
+		t.Errorf("expected error for a content filter without paths")
+	}
+}
+
+func TestExportFilter_ContentFiltersLargeBlobs(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	content := "cell\noutput: secret-output\n" + strings.Repeat("padding line\n", 20)
+	commitFiles(t, repo, map[string]string{"nb/analysis.ipynb": content}, "Add notebook")
+
+	rules, err := Compile(Rules{
+		PrivateUsername:     "obinnaokechukwu",
+		Replacement:         "johndoe",
+		MaxRewriteBlobBytes: 64,
+		ContentFilters:      []ContentFilter{{Patterns: []string{"**/*.ipynb"}, Command: "grep -v '^output:'"}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	show, err := gitx.Run(nil, bare, "show", "refs/heads/main:nb/analysis.ipynb")
+	if err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	if want := strings.Replace(content, "output: secret-output\n", "", 1); show.Stdout != want {
+		t.Errorf("large notebook = %q, want it filtered: %q", show.Stdout, want)
+	}
+}
@@ -0,0 +1,250 @@
+package scrub
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultEntropyThreshold is the Shannon entropy (bits per character) at
+	// or above which a token is reported as a likely credential. A random
+	// base64 token of DefaultEntropyMinLength characters averages about 4.0
+	// bits per character, and can't exceed log2(20) = 4.32.
+	DefaultEntropyThreshold = 4.0
+	// DefaultHexEntropyThreshold is the threshold for tokens made only of
+	// hex digits, which carry at most 4 bits per character.
+	DefaultHexEntropyThreshold = 3.0
+	// DefaultEntropyMinLength is the shortest token that is considered.
+	DefaultEntropyMinLength = 20
+)
+
+// DefaultEntropyAllow are tokens that are never reported: git object ids.
+var DefaultEntropyAllow = []string{`^[0-9a-f]{40}$`, `^[0-9a-f]{64}$`}
+
+// DefaultEntropyAllowPaths are files full of legitimate high-entropy hashes
+// that are never checked.
+var DefaultEntropyAllowPaths = []string{
+	"**/go.sum", "**/package-lock.json", "**/yarn.lock", "**/pnpm-lock.yaml",
+	"**/Cargo.lock", "**/poetry.lock", "**/composer.lock", "**/Gemfile.lock",
+}
+
+// EntropyRules configures the high-entropy secret check. Any file content or
+// commit or tag message destined for the public repo that contains a token
+// at or above Threshold (HexThreshold for hex tokens) fails the export.
+// Tokens without a digit, such as long identifiers, are not considered.
+type EntropyRules struct {
+	Threshold    float64 // default DefaultEntropyThreshold
+	HexThreshold float64 // default DefaultHexEntropyThreshold
+	MinLength    int     // default DefaultEntropyMinLength
+	// Allow are regular expressions; matching tokens are not reported.
+	Allow []string
+	// AllowPaths are globs of files that are not checked.
+	AllowPaths []string
+}
+
+// EntropyError reports a likely credential found in published content, or
+// in a commit message (Path empty) or tag message (Tag set).
+type EntropyError struct {
+	Path    string
+	Commit  string
+	Tag     string
+	Token   string
+	Entropy float64
+}
+
+func (e EntropyError) Error() string {
+	switch {
+	case e.Tag != "":
+		return fmt.Sprintf("possible secret in the message of tag %s: high-entropy string %q (%.2f bits/char); allowlist it", e.Tag, redactToken(e.Token), e.Entropy)
+	case e.Path == "":
+		return fmt.Sprintf("possible secret in the message of commit %s: high-entropy string %q (%.2f bits/char); allowlist it", e.Commit, redactToken(e.Token), e.Entropy)
+	}
+	return fmt.Sprintf("possible secret in %s at commit %s: high-entropy string %q (%.2f bits/char); allowlist it or exclude the file", e.Path, e.Commit, redactToken(e.Token), e.Entropy)
+}
+
+type compiledEntropy struct {
+	threshold    float64
+	hexThreshold float64
+	minLength    int
+	allow        []*regexp.Regexp
+	allowPaths   []string
+}
+
+var entropyTokenRe = regexp.MustCompile(`[A-Za-z0-9+/=_\-]+`)
+
+func compileEntropy(r *EntropyRules) (*compiledEntropy, error) {
+	if r == nil {
+		return nil, nil
+	}
+	ce := &compiledEntropy{
+		threshold:    r.Threshold,
+		hexThreshold: r.HexThreshold,
+		minLength:    r.MinLength,
+		allowPaths:   append(append([]string{}, DefaultEntropyAllowPaths...), r.AllowPaths...),
+	}
+	if ce.threshold <= 0 {
+		ce.threshold = DefaultEntropyThreshold
+	}
+	if ce.hexThreshold <= 0 {
+		ce.hexThreshold = DefaultHexEntropyThreshold
+	}
+	if ce.minLength <= 0 {
+		ce.minLength = DefaultEntropyMinLength
+	}
+	for _, a := range append(append([]string{}, DefaultEntropyAllow...), r.Allow...) {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entropy allow pattern %q: %w", a, err)
+		}
+		ce.allow = append(ce.allow, re)
+	}
+	return ce, nil
+}
+
+// HasEntropyCheck reports whether the high-entropy secret check is enabled.
+func (c CompiledRules) HasEntropyCheck() bool { return c.entropy != nil }
+
+// FindHighEntropy returns the first token in content (destined for path p)
+// whose entropy reaches the threshold. Binary content and allowlisted paths
+// are skipped.
+func (c CompiledRules) FindHighEntropy(content []byte, p string) (token string, entropy float64, found bool) {
+	ce := c.entropy
+	if ce == nil || c.EntropyExempt(p) || IsBinaryContent(content) {
+		return "", 0, false
+	}
+	return ce.find(content)
+}
+
+// findHighEntropyMessage is FindHighEntropy for a commit or tag message.
+func (c CompiledRules) findHighEntropyMessage(msg []byte) (string, float64, bool) {
+	if c.entropy == nil {
+		return "", 0, false
+	}
+	return c.entropy.find(msg)
+}
+
+func (ce *compiledEntropy) find(content []byte) (string, float64, bool) {
+	for _, loc := range entropyTokenRe.FindAllIndex(content, -1) {
+		if loc[1]-loc[0] < ce.minLength {
+			continue
+		}
+		tok := content[loc[0]:loc[1]]
+		if bytes.IndexAny(tok, "0123456789") < 0 {
+			continue
+		}
+		threshold := ce.threshold
+		if isHexToken(tok) {
+			threshold = ce.hexThreshold
+		}
+		s := string(tok)
+		e := shannonEntropy(s)
+		if e < threshold || ce.allowed(s) {
+			continue
+		}
+		return s, e, true
+	}
+	return "", 0, false
+}
+
+func isHexToken(tok []byte) bool {
+	for _, b := range tok {
+		if !('0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// entropyScanner runs the entropy check over content written to it in
+// pieces, holding back a trailing partial token until the next write.
+type entropyScanner struct {
+	ce    *compiledEntropy
+	buf   []byte
+	token string
+	e     float64
+	found bool
+}
+
+func (s *entropyScanner) Write(b []byte) (int, error) {
+	if s.found {
+		return len(b), nil
+	}
+	s.buf = append(s.buf, b...)
+	cut := len(s.buf)
+	for cut > 0 && isEntropyTokenByte(s.buf[cut-1]) {
+		cut--
+	}
+	if cut == 0 && len(s.buf) < streamChunkSize {
+		return len(b), nil
+	}
+	if cut == 0 {
+		// One very long token; check it as is.
+		cut = len(s.buf)
+	}
+	s.token, s.e, s.found = s.ce.find(s.buf[:cut])
+	s.buf = append(s.buf[:0], s.buf[cut:]...)
+	return len(b), nil
+}
+
+// finish checks what is left and reports the first token found.
+func (s *entropyScanner) finish() (string, float64, bool) {
+	if !s.found {
+		s.token, s.e, s.found = s.ce.find(s.buf)
+	}
+	return s.token, s.e, s.found
+}
+
+func isEntropyTokenByte(b byte) bool {
+	return 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("+/=_-", b) >= 0
+}
+
+// EntropyExempt reports whether path p is on the entropy path allowlist.
+func (c CompiledRules) EntropyExempt(p string) bool {
+	if c.entropy == nil {
+		return false
+	}
+	for _, pat := range c.entropy.allowPaths {
+		if matchGlob(pat, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ce *compiledEntropy) allowed(tok string) bool {
+	for _, re := range ce.allow {
+		if re.MatchString(tok) {
+			return true
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	n := float64(len(s))
+	var e float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// redactToken keeps only the ends of a token for error messages.
+func redactToken(tok string) string {
+	if len(tok) <= 8 {
+		return strings.Repeat("*", len(tok))
+	}
+	return tok[:4] + strings.Repeat("*", len(tok)-8) + tok[len(tok)-4:]
+}
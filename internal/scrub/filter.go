@@ -2,8 +2,10 @@ package scrub
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -24,11 +26,103 @@ type ExportFilter struct {
 	// replaceHistoryMarks maps normalized file paths to the synthetic blob marks
 	// for replace_history_with_current files.
 	replaceHistoryMarks map[string]string
-	// nextSyntheticMark is the counter for generating synthetic marks for
-	// replace_history_with_current blobs.
+	// nextSyntheticMark is the counter for generating synthetic marks, from
+	// syntheticMarkBase on.
 	nextSyntheticMark int
+	// inputMarks maps export marks in the synthetic range to the synthetic
+	// marks they were given.
+	inputMarks map[string]string
 	// syntheticBlobsEmitted tracks whether we've emitted synthetic blobs yet.
 	syntheticBlobsEmitted bool
+	// redactMark is the synthetic blob mark of the redact placeholder.
+	redactMark string
+	// injectOps add the injected files to root commits.
+	injectOps []string
+
+	// pathBlobs holds raw blob payloads by mark when blob rewriting depends on
+	// the destination path (path-scoped replacements, binary detection). Such
+	// blobs are emitted lazily, once the commit that uses them reveals the path.
+	// pathBlobBytes is their total size, which streamThreshold caps too.
+	pathBlobs     map[string][]byte
+	pathBlobBytes int
+	// pathBlobVariants maps a blob mark to the emitted mark per path rule key.
+	pathBlobVariants map[string]map[string]string
+	// pendingBlobs are blob records to write before the current commit.
+	pendingBlobs []pendingBlob
+	// pathBlobFiles holds deferred payloads too large for pathBlobs, or that
+	// would take it over its total, spooled to temp files. rawBlobs are
+	// those over Rules.MaxRewriteBlobBytes, deferred only for content filters
+	// and the path-dependent checks and never rewritten.
+	pathBlobFiles map[string]*spoolFile
+	rawBlobs      map[string]bool
+
+	// oversized holds the marks of blobs larger than Rules.ExcludeLargerThan.
+	oversized map[string]bool
+
+	// lfsPointers maps blob marks to the LFS object id they point to.
+	lfsPointers map[string]string
+	// lfsObjects are the LFS object ids referenced by published paths.
+	lfsObjects map[string]bool
+
+	// symlinkTargets holds the published content of blobs matching the
+	// symlink patterns, by mark; symlinkMarks are their rewritten variants.
+	symlinkTargets map[string][]byte
+	symlinkMarks   map[string]string
+
+	// carriedOps are the file operations of commits left out by
+	// SkipCommitPatterns (and of empty commits built on them), by mark; they
+	// are prepended to the operations of the next published child.
+	carriedOps map[string][]string
+
+	// commitMarks maps original commit ids (from original-oid lines) to marks.
+	commitMarks map[string]string
+	// curCommit identifies the commit being filtered (original id or mark).
+	curCommit string
+	// deferredNotes are notes-ref records held until the end of the stream.
+	deferredNotes []deferredRecord
+
+	// baselineMark is the mark of the squash base commit, once seen.
+	baselineMark string
+	baselineSeen bool
+	// deferredSquash are records held back until the baseline commit is seen;
+	// squashPending holds their marks.
+	deferredSquash []deferredRecord
+	squashPending  map[string]bool
+	// squashHidden holds the marks of commits dropped by Rules.SquashHide.
+	squashHidden map[string]bool
+
+	// unpublishedRefs maps refs left out by RefInclude/RefExclude to the
+	// scratch refs their commits are imported under.
+	unpublishedRefs map[string]string
+	// writtenRefs are the public refs written to the output.
+	writtenRefs map[string]bool
+
+	// clock is the last commit time assigned in TimestampRespace mode.
+	clock    int64
+	clockSet bool
+
+	// publicPathOrigins detects path mapping collisions: public path -> private path.
+	publicPathOrigins map[string]string
+
+	// droppedGitlinks are the private paths of gitlinks left out by
+	// SubmoduleDrop, whose later deletions and renames are left out too.
+	droppedGitlinks map[string]bool
+
+	progress   Progress
+	onProgress func(Progress)
+}
+
+type pendingBlob struct {
+	mark    string
+	content []byte
+
+	// spooled is set instead of content for large payloads; they are
+	// rewritten for path when written, unless raw. release closes spooled
+	// afterwards.
+	spooled *spoolFile
+	path    string
+	raw     bool
+	release bool
 }
 
 func NewExportFilter(r CompiledRules) *ExportFilter {
@@ -39,14 +133,33 @@ func NewExportFilter(r CompiledRules) *ExportFilter {
 		refReverseMap:           map[string]string{},
 		replaceHistorySeenFiles: map[string]bool{},
 		replaceHistoryMarks:     map[string]string{},
-		nextSyntheticMark:       900000000, // Start high to avoid collisions with normal marks
+		nextSyntheticMark:       syntheticMarkBase,
+		inputMarks:              map[string]string{},
+		pathBlobs:               map[string][]byte{},
+		pathBlobVariants:        map[string]map[string]string{},
+		pathBlobFiles:           map[string]*spoolFile{},
+		rawBlobs:                map[string]bool{},
+		lfsPointers:             map[string]string{},
+		lfsObjects:              map[string]bool{},
+		oversized:               map[string]bool{},
+		symlinkTargets:          map[string][]byte{},
+		symlinkMarks:            map[string]string{},
+		commitMarks:             map[string]string{},
+		carriedOps:              map[string][]string{},
+		publicPathOrigins:       map[string]string{},
+		droppedGitlinks:         map[string]bool{},
+		squashPending:           map[string]bool{},
+		squashHidden:            map[string]bool{},
+		unpublishedRefs:         map[string]string{},
+		writtenRefs:             map[string]bool{},
 	}
 }
 
 func (f *ExportFilter) Filter(r io.Reader, w io.Writer) error {
-	br := bufio.NewReader(r)
-	bw := bufio.NewWriter(w)
+	br := bufio.NewReader(countingReader{r, &f.progress.BytesIn})
+	bw := bufio.NewWriter(countingWriter{w, &f.progress.BytesOut})
 	defer bw.Flush()
+	defer f.closeSpools()
 
 	for {
 		line, err := br.ReadString('\n')
@@ -59,9 +172,14 @@ func (f *ExportFilter) Filter(r io.Reader, w io.Writer) error {
 
 		switch {
 		case line == "blob\n":
+			f.progress.Blobs++
 			if err := f.handleBlob(br, bw); err != nil {
 				return err
 			}
+		case (strings.HasPrefix(line, "commit ") || strings.HasPrefix(line, "reset ")) && isNotesRef(recordRef(line)):
+			if err := f.deferNotesRecord(line, br); err != nil {
+				return err
+			}
 		case strings.HasPrefix(line, "commit "):
 			// Emit synthetic blobs before the first commit
 			if !f.syntheticBlobsEmitted {
@@ -70,15 +188,17 @@ func (f *ExportFilter) Filter(r io.Reader, w io.Writer) error {
 				}
 				f.syntheticBlobsEmitted = true
 			}
-			if err := f.handleCommit(line, br, bw); err != nil {
+			if err := f.handleSquashable(line, br, bw, f.handleCommit); err != nil {
 				return err
 			}
+			f.progress.Commits++
+			f.reportProgress()
 		case strings.HasPrefix(line, "tag "):
 			if err := f.handleTag(line, br, bw); err != nil {
 				return err
 			}
 		case strings.HasPrefix(line, "reset "):
-			if err := f.handleReset(line, br, bw); err != nil {
+			if err := f.handleSquashable(line, br, bw, f.handleReset); err != nil {
 				return err
 			}
 		default:
@@ -90,9 +210,46 @@ func (f *ExportFilter) Filter(r io.Reader, w io.Writer) error {
 			break
 		}
 	}
+	if err := f.replaySquashDeferred(bw); err != nil {
+		return err
+	}
+	if err := f.replayNotes(bw); err != nil {
+		return err
+	}
+	f.deleteUnpublishedRefs(bw)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	f.progress.Done = true
+	f.reportProgress()
+	return nil
+}
+
+// handleSquashable passes a commit or reset record to handle, first holding
+// it back if it builds on squashed history that precedes the baseline commit.
+func (f *ExportFilter) handleSquashable(line string, br *bufio.Reader, bw *bufio.Writer, handle func(string, *bufio.Reader, *bufio.Writer) error) error {
+	if f.rules.squashBase == "" || f.baselineSeen {
+		return handle(line, br, bw)
+	}
+	deferred, rd, err := f.deferSquashRecord(line, br)
+	if err != nil || deferred {
+		return err
+	}
+	if err := handle(line, rd, bw); err != nil {
+		return err
+	}
+	if f.baselineSeen {
+		return f.replaySquashDeferred(bw)
+	}
 	return nil
 }
 
+// recordRef returns the ref named on a "commit <ref>" or "reset <ref>" line.
+func recordRef(line string) string {
+	_, ref, _ := strings.Cut(strings.TrimSpace(line), " ")
+	return ref
+}
+
 // emitSyntheticBlobs emits blob records for all replace_history_with_current files
 // at the beginning of the stream, before any commits.
 func (f *ExportFilter) emitSyntheticBlobs(bw *bufio.Writer) error {
@@ -105,8 +262,7 @@ func (f *ExportFilter) emitSyntheticBlobs(bw *bufio.Writer) error {
 		}
 
 		// Generate a synthetic mark for this file
-		mark := fmt.Sprintf(":%d", f.nextSyntheticMark)
-		f.nextSyntheticMark++
+		mark := f.newMark()
 		f.replaceHistoryMarks[filePath] = mark
 
 		// Emit the blob
@@ -118,46 +274,467 @@ func (f *ExportFilter) emitSyntheticBlobs(bw *bufio.Writer) error {
 		}
 		_, _ = bw.WriteString("\n")
 	}
-	return nil
+	if err := f.emitRedactBlob(bw); err != nil {
+		return err
+	}
+	return f.emitInjectedBlobs(bw)
 }
 
 func (f *ExportFilter) handleBlob(br *bufio.Reader, bw *bufio.Writer) error {
+	if f.rules.DeferBlobs() {
+		return f.bufferPathBlob(br, bw)
+	}
 	_, _ = bw.WriteString("blob\n")
+	var mark string
 	for {
 		line, err := br.ReadString('\n')
 		if err != nil {
 			return err
 		}
+		if strings.HasPrefix(line, "mark ") {
+			line = f.inputMarkLine(line)
+			mark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+		}
 		if strings.HasPrefix(line, "data ") {
 			n, err := parseDataLen(line)
 			if err != nil {
 				return err
 			}
-			b := make([]byte, n)
-			if _, err := io.ReadFull(br, b); err != nil {
+			if f.noteBlobSize(mark, n) {
+				// No published commit references it; keep the mark defined
+				// but don't carry the payload.
+				if err := skipBlobData(br, n); err != nil {
+					return err
+				}
+				return writeBlobData(bw, nil)
+			}
+			if f.rules.StreamBlob(n) {
+				return copyBlobData(br, bw, n)
+			}
+			if n <= streamThreshold {
+				b := make([]byte, n)
+				if _, err := io.ReadFull(br, b); err != nil {
+					return err
+				}
+				if _, err := br.ReadByte(); err != nil {
+					return err
+				}
+				if oid, ok := LFSPointerOID(b); ok {
+					f.noteLFSPointer(mark, oid)
+					return writeBlobData(bw, b)
+				}
+				out := f.rules.RewriteBytes(b)
+				f.noteBlobRewrite(b, out)
+				f.noteSymlinkCandidate(mark, out)
+				return writeBlobData(bw, out)
+			}
+			_, spooled, err := f.readRewrittenData(br, n, "")
+			if err != nil {
 				return err
 			}
+			defer spooled.Close()
 			// Consume trailing newline after data payload
 			if _, err := br.ReadByte(); err != nil {
 				return err
 			}
-			nb := f.rewriteBytes(b)
-			_, _ = bw.WriteString(fmt.Sprintf("data %d\n", len(nb)))
-			if _, err := bw.Write(nb); err != nil {
+			if err := spooled.writeData(bw); err != nil {
 				return err
 			}
 			_, _ = bw.WriteString("\n")
 			return nil
 		}
 		// pass-through metadata (mark, original-oid)
-		_, _ = bw.WriteString(line)
+		if f.keepHeader(line) {
+			_, _ = bw.WriteString(line)
+		}
+	}
+}
+
+// keepHeader reports whether a pass-through blob header line is written to
+// the output. original-oid lines are dropped unless Rules.KeepOriginalOIDs.
+func (f *ExportFilter) keepHeader(line string) bool {
+	return f.rules.keepOrigOIDs || !strings.HasPrefix(line, "original-oid ")
+}
+
+// bufferPathBlob reads a blob record and holds its raw payload until a commit
+// references it, so path-dependent rewriting can be applied per destination.
+// Blobs without a mark cannot be referenced later and are rewritten directly.
+func (f *ExportFilter) bufferPathBlob(br *bufio.Reader, bw *bufio.Writer) error {
+	var mark string
+	var header []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "data ") {
+			if strings.HasPrefix(line, "mark ") {
+				line = f.inputMarkLine(line)
+				mark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+			}
+			if f.keepHeader(line) {
+				header = append(header, line)
+			}
+			continue
+		}
+		n, err := parseDataLen(line)
+		if err != nil {
+			return err
+		}
+		if f.noteBlobSize(mark, n) {
+			return skipBlobData(br, n)
+		}
+		if f.rules.StreamBlob(n) && (mark == "" || (f.rules.entropy == nil && len(f.rules.contentFilters) == 0)) {
+			// Too large to hold: emit as-is now. Commits referencing the
+			// mark find no buffered payload and use it unchanged.
+			// With the entropy check or content filters the blob is
+			// spooled below instead, as they need its path.
+			_, _ = bw.WriteString("blob\n")
+			for _, h := range header {
+				_, _ = bw.WriteString(h)
+			}
+			return copyBlobData(br, bw, n)
+		}
+		if (n > streamThreshold || f.rules.StreamBlob(n)) && mark != "" {
+			lr := &io.LimitedReader{R: br, N: int64(n)}
+			s, err := spool(func(w io.Writer) error {
+				_, err := io.Copy(w, lr)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			f.pathBlobFiles[mark] = s
+			f.rawBlobs[mark] = f.rules.StreamBlob(n)
+			if lr.N != 0 {
+				return io.ErrUnexpectedEOF
+			}
+			_, err = br.ReadByte()
+			return err
+		}
+		if n > streamThreshold {
+			_, _ = bw.WriteString("blob\n")
+			for _, h := range header {
+				_, _ = bw.WriteString(h)
+			}
+			_, s, err := f.readRewrittenData(br, n, "")
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			if _, err := br.ReadByte(); err != nil {
+				return err
+			}
+			if err := s.writeData(bw); err != nil {
+				return err
+			}
+			_, _ = bw.WriteString("\n")
+			return nil
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return err
+		}
+		if _, err := br.ReadByte(); err != nil {
+			return err
+		}
+		if oid, ok := LFSPointerOID(b); ok {
+			f.noteLFSPointer(mark, oid)
+		}
+		if mark != "" {
+			if f.rules.symlinks != nil && len(b) <= symlinkMaxTarget {
+				// Symlink targets get the replacement rules that apply
+				// everywhere, not path-scoped ones.
+				f.noteSymlinkCandidate(mark, f.rules.RewriteBytes(b))
+			}
+			if f.pathBlobBytes+n > streamThreshold {
+				// Payloads wait for their first commit, which may be far
+				// off; the rest wait on disk.
+				s, err := spool(func(w io.Writer) error {
+					_, err := w.Write(b)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				f.pathBlobFiles[mark] = s
+				return nil
+			}
+			f.pathBlobs[mark] = b
+			f.pathBlobBytes += n
+			return nil
+		}
+		_, _ = bw.WriteString("blob\n")
+		for _, h := range header {
+			_, _ = bw.WriteString(h)
+		}
+		out := f.rewriteBlob(b, "")
+		f.noteBlobRewrite(b, out)
+		return writeBlobData(bw, out)
+	}
+}
+
+// pathBlobRef returns the mark to use for blob dataref at path p, queueing a
+// rewritten blob variant if this (blob, path rules) combination is new.
+func (f *ExportFilter) pathBlobRef(dataref, p string) (string, error) {
+	key := f.rules.PathRuleKey(p)
+	variants := f.pathBlobVariants[dataref]
+	if m, ok := variants[key]; ok {
+		return m, nil
+	}
+	raw, ok := f.pathBlobs[dataref]
+	spooled := f.pathBlobFiles[dataref]
+	if !ok && spooled == nil {
+		return dataref, nil
+	}
+	if variants == nil {
+		variants = map[string]string{}
+		f.pathBlobVariants[dataref] = variants
+	}
+	mark := dataref
+	if len(variants) > 0 {
+		mark = f.newMark()
+	}
+	variants[key] = mark
+	pb := pendingBlob{mark: mark}
+	if spooled != nil {
+		pb.spooled, pb.path, pb.raw = spooled, p, f.rawBlobs[dataref]
+	} else {
+		filtered, err := f.filterContent(raw, p)
+		if err != nil {
+			return "", err
+		}
+		pb.content = f.rewriteBlob(filtered, p)
+		f.noteBlobRewrite(raw, pb.content)
+		if err := f.rules.checkGoFile(pb.content, p); err != nil {
+			return "", err
+		}
+		if p != "" {
+			if tok, e, found := f.rules.FindHighEntropy(pb.content, p); found {
+				return "", EntropyError{Path: p, Commit: f.curCommit, Token: tok, Entropy: e}
+			}
+		}
+	}
+	if f.rules.pathRuleKeyConstant() {
+		// Every path shares the first variant, so the raw payload is no
+		// longer needed. Otherwise a later path with a different key (such
+		// as a text path after a binary-extension one) must still be able
+		// to rewrite it.
+		f.pathBlobBytes -= len(raw)
+		delete(f.pathBlobs, dataref)
+		delete(f.pathBlobFiles, dataref)
+		pb.release = spooled != nil
+	}
+	f.pendingBlobs = append(f.pendingBlobs, pb)
+	return mark, nil
+}
+
+// noteBlobSize records whether the blob at mark, of n bytes, is too large to
+// publish, and reports whether it is.
+func (f *ExportFilter) noteBlobSize(mark string, n int) bool {
+	if mark == "" || !f.rules.ExcludeBlob(n) {
+		return false
+	}
+	f.oversized[mark] = true
+	return true
+}
+
+// noteLFSPointer records that the blob at mark is an LFS pointer to oid.
+func (f *ExportFilter) noteLFSPointer(mark, oid string) {
+	if mark != "" {
+		f.lfsPointers[mark] = oid
+	}
+}
+
+// LFSObjects returns the sorted LFS object ids referenced by published paths
+// when the LFS mode is LFSPush.
+func (f *ExportFilter) LFSObjects() []string {
+	out := make([]string, 0, len(f.lfsObjects))
+	for oid := range f.lfsObjects {
+		out = append(out, oid)
 	}
+	sort.Strings(out)
+	return out
+}
+
+// rewriteBlob returns the public content of a blob destined for path p
+// (p may be empty when unknown). Binary blobs pass through untouched when
+// configured to.
+func (f *ExportFilter) rewriteBlob(raw []byte, p string) []byte {
+	if _, ok := LFSPointerOID(raw); ok || f.rules.Verbatim(p) {
+		return raw
+	}
+	if f.rules.SkipBinaryBlobs() && f.rules.IsBinary(raw, p) {
+		return raw
+	}
+	return f.rules.RewriteBytesForPath(raw, p)
+}
+
+// flushPendingBlobs writes queued path-scoped blob variants.
+func (f *ExportFilter) flushPendingBlobs(bw *bufio.Writer) error {
+	for _, pb := range f.pendingBlobs {
+		_, _ = bw.WriteString("blob\n")
+		_, _ = bw.WriteString("mark " + pb.mark + "\n")
+		var err error
+		if pb.spooled != nil {
+			err = f.writeSpooledBlob(bw, pb.spooled, pb.path, pb.raw)
+			if pb.release {
+				pb.spooled.Close()
+			}
+		} else {
+			err = writeBlobData(bw, pb.content)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	f.pendingBlobs = f.pendingBlobs[:0]
+	return nil
+}
+
+// writeSpooledBlob writes a blob payload held in a temp file, rewriting it
+// for path p in chunks unless it is raw, or binary and binary blobs are
+// skipped.
+func (f *ExportFilter) writeSpooledBlob(bw *bufio.Writer, s *spoolFile, p string, raw bool) error {
+	filtered, err := f.filterSpooledContent(s, p)
+	if err != nil {
+		return err
+	}
+	if filtered != s {
+		defer filtered.Close()
+		s = filtered
+	}
+	out := s
+	verbatim := raw || f.rules.Verbatim(p) || (f.rules.SkipBinaryBlobs() && f.isSpooledBinary(s, p))
+	if !verbatim {
+		var err error
+		out, err = spool(func(w io.Writer) error { return f.rules.RewriteStream(w, s.reader(), p) })
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := f.checkSpooledEntropy(out, p); err != nil {
+		return err
+	}
+	if err := out.writeData(bw); err != nil {
+		return err
+	}
+	_, _ = bw.WriteString("\n")
+	return nil
+}
+
+// checkSpooledEntropy runs the entropy check over a spooled payload for
+// path p.
+func (f *ExportFilter) checkSpooledEntropy(s *spoolFile, p string) error {
+	ce := f.rules.entropy
+	if ce == nil || p == "" || f.rules.EntropyExempt(p) {
+		return nil
+	}
+	if head, err := s.head(binarySniffLen); err != nil || IsBinaryContent(head) {
+		return err
+	}
+	sc := &entropyScanner{ce: ce}
+	if _, err := io.Copy(sc, s.reader()); err != nil {
+		return err
+	}
+	if tok, e, found := sc.finish(); found {
+		return EntropyError{Path: p, Commit: f.curCommit, Token: tok, Entropy: e}
+	}
+	return nil
+}
+
+// messageEntropy runs the entropy check over a message held in memory or,
+// if s is set, in a temp file.
+func (f *ExportFilter) messageEntropy(msg []byte, s *spoolFile) (string, float64, bool, error) {
+	if f.rules.entropy == nil {
+		return "", 0, false, nil
+	}
+	if s == nil {
+		tok, e, found := f.rules.findHighEntropyMessage(msg)
+		return tok, e, found, nil
+	}
+	sc := &entropyScanner{ce: f.rules.entropy}
+	if _, err := io.Copy(sc, s.reader()); err != nil {
+		return "", 0, false, err
+	}
+	tok, e, found := sc.finish()
+	return tok, e, found, nil
+}
+
+func (f *ExportFilter) isSpooledBinary(s *spoolFile, p string) bool {
+	head, err := s.head(binarySniffLen)
+	return err == nil && f.rules.IsBinary(head, p)
+}
+
+// readRewrittenData reads an n-byte data payload from br and rewrites it for
+// path p (empty for messages). Payloads larger than streamThreshold are
+// rewritten in chunks into a temp file, returned as spooled; the caller must
+// close it.
+func (f *ExportFilter) readRewrittenData(br *bufio.Reader, n int, p string) (b []byte, spooled *spoolFile, err error) {
+	if n <= streamThreshold {
+		b = make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, nil, err
+		}
+		return f.rules.RewriteBytesForPath(b, p), nil, nil
+	}
+	lr := &io.LimitedReader{R: br, N: int64(n)}
+	spooled, err = spool(func(w io.Writer) error { return f.rules.RewriteStream(w, lr, p) })
+	if err != nil {
+		return nil, nil, err
+	}
+	if lr.N != 0 {
+		spooled.Close()
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return nil, spooled, nil
+}
+
+// closeSpools removes temp files for deferred blobs never referenced.
+func (f *ExportFilter) closeSpools() {
+	for mark, s := range f.pathBlobFiles {
+		s.Close()
+		delete(f.pathBlobFiles, mark)
+	}
+}
+
+// copyBlobData streams an n-byte data payload (and its trailing newline) from
+// br to bw without rewriting or buffering it.
+func copyBlobData(br *bufio.Reader, bw *bufio.Writer, n int) error {
+	_, _ = bw.WriteString(fmt.Sprintf("data %d\n", n))
+	if _, err := io.CopyN(bw, br, int64(n)); err != nil {
+		return err
+	}
+	if _, err := br.ReadByte(); err != nil {
+		return err
+	}
+	_, _ = bw.WriteString("\n")
+	return nil
+}
+
+// skipBlobData discards a blob payload of n bytes and its trailing newline.
+func skipBlobData(br *bufio.Reader, n int) error {
+	if _, err := br.Discard(n); err != nil {
+		return err
+	}
+	_, err := br.ReadByte()
+	return err
+}
+
+func writeBlobData(bw *bufio.Writer, content []byte) error {
+	_, _ = bw.WriteString(fmt.Sprintf("data %d\n", len(content)))
+	if _, err := bw.Write(content); err != nil {
+		return err
+	}
+	_, _ = bw.WriteString("\n")
+	return nil
 }
 
 func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufio.Writer) error {
 	origRef := strings.TrimSpace(strings.TrimPrefix(firstLine, "commit "))
-	newRef := f.rewriteRef(origRef)
-	if err := f.checkRefCollision(origRef, newRef); err != nil {
+	newRef, err := f.importRef(origRef)
+	if err != nil {
 		return err
 	}
 
@@ -170,10 +747,11 @@ func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufi
 
 		otherHeader []string
 
-		message []byte
-		parent  string
-		merges  []string
-		ops     []string
+		message     []byte
+		messageFile *spoolFile // set instead of message for very large messages
+		parent      string
+		merges      []string
+		ops         []string
 	)
 
 	// For commits, git fast-export emits:
@@ -195,7 +773,7 @@ func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufi
 
 		switch {
 		case strings.HasPrefix(line, "mark "):
-			oldMark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+			oldMark = f.inputMark(strings.TrimSpace(strings.TrimPrefix(line, "mark ")))
 			f.markMap[oldMark] = oldMark // default unless remapped by skipped commit
 		case strings.HasPrefix(line, "original-oid "):
 			origOidLine = line
@@ -207,19 +785,21 @@ func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufi
 			encodingLine = line
 		case strings.HasPrefix(line, "from "):
 			// Some exporters might place from before data; accept it either way.
-			parent = strings.TrimSpace(strings.TrimPrefix(line, "from "))
+			parent = f.inputMark(strings.TrimSpace(strings.TrimPrefix(line, "from ")))
 		case strings.HasPrefix(line, "merge "):
-			merges = append(merges, strings.TrimSpace(strings.TrimPrefix(line, "merge ")))
+			merges = append(merges, f.inputMark(strings.TrimSpace(strings.TrimPrefix(line, "merge "))))
 		case strings.HasPrefix(line, "data "):
 			n, err := parseDataLen(line)
 			if err != nil {
 				return err
 			}
-			b := make([]byte, n)
-			if _, err := io.ReadFull(br, b); err != nil {
+			message, messageFile, err = f.readRewrittenData(br, n, "")
+			if err != nil {
 				return err
 			}
-			message = f.rewriteBytes(b)
+			if messageFile != nil {
+				defer messageFile.Close()
+			}
 
 			// Parse trailing lines until the blank line ends the commit record.
 			for {
@@ -231,14 +811,14 @@ func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufi
 					break
 				}
 				if strings.HasPrefix(l2, "from ") {
-					parent = strings.TrimSpace(strings.TrimPrefix(l2, "from "))
+					parent = f.inputMark(strings.TrimSpace(strings.TrimPrefix(l2, "from ")))
 					continue
 				}
 				if strings.HasPrefix(l2, "merge ") {
-					merges = append(merges, strings.TrimSpace(strings.TrimPrefix(l2, "merge ")))
+					merges = append(merges, f.inputMark(strings.TrimSpace(strings.TrimPrefix(l2, "merge "))))
 					continue
 				}
-				ops = append(ops, l2)
+				ops = append(ops, f.inputOp(l2))
 			}
 			goto EMIT
 		default:
@@ -247,21 +827,72 @@ func (f *ExportFilter) handleCommit(firstLine string, br *bufio.Reader, bw *bufi
 	}
 
 EMIT:
+	if f.rules.squashBase != "" && !isNotesRef(origRef) {
+		oid := strings.TrimSpace(strings.TrimPrefix(origOidLine, "original-oid "))
+		if f.hideSquashed(oid, oldMark) {
+			return nil
+		}
+		parent, merges = f.squashParents(oid, oldMark, parent, merges)
+		if oid == f.rules.squashBase {
+			message, messageFile = []byte(SquashMessage), nil
+		}
+	}
+
+	if parent == "" && len(merges) == 0 && f.rules.graft != "" && !isNotesRef(origRef) {
+		parent = f.rules.graft
+	}
 	parentResolved := ""
 	if parent != "" {
 		parentResolved = f.resolveCommitRef(parent)
 	}
 
-	filteredOps, keptOps, err := f.filterOps(ops)
+	f.curCommit = oldMark
+	if origOidLine != "" {
+		f.curCommit = strings.TrimSpace(strings.TrimPrefix(origOidLine, "original-oid "))
+		if oldMark != "" {
+			f.commitMarks[f.curCommit] = oldMark
+		}
+	}
+
+	opsFilter := f.filterOps
+	skipByMessage := false
+	if isNotesRef(origRef) {
+		opsFilter = f.filterNoteOps
+	} else if f.rules.squashBase == "" || f.curCommit != f.rules.squashBase {
+		if skipByMessage, err = f.skipCommitMessage(message, messageFile); err != nil {
+			return err
+		}
+	}
+	if !isNotesRef(origRef) && f.rules.HasMessageTransforms() {
+		transformed, err := f.transformMessage(message, messageFile)
+		if err != nil {
+			return err
+		}
+		message, messageFile = transformed, nil
+	}
+	filteredOps, keptOps, err := opsFilter(ops)
 	if err != nil {
 		return err
 	}
+	if err := f.flushPendingBlobs(bw); err != nil {
+		return err
+	}
+
+	// A first parent that was left out passes its changes on.
+	carried := f.carriedOps[parent]
+
+	if f.rules.pruneMerges {
+		merges = f.pruneMergeParents(parentResolved, merges)
+	}
 
 	// Skip commit if exclusions remove all file operations and it's not a merge commit.
-	if keptOps == 0 && len(merges) == 0 {
+	if skipByMessage || (keptOps == 0 && len(merges) == 0 && len(carried) == 0) {
 		if oldMark != "" {
 			// Any later reference to this mark should resolve to the parent.
 			f.markMap[oldMark] = parentResolved
+			if pending := append(append([]string{}, carried...), filteredOps...); len(pending) > 0 {
+				f.carriedOps[oldMark] = pending
+			}
 		}
 		// Ensure branch tip doesn't advance to a skipped commit.
 		_, _ = bw.WriteString("reset " + newRef + "\n")
@@ -272,19 +903,34 @@ EMIT:
 		return nil
 	}
 
+	if !isNotesRef(origRef) {
+		tok, e, found, err := f.messageEntropy(message, messageFile)
+		if err != nil {
+			return err
+		}
+		if found {
+			return EntropyError{Commit: f.curCommit, Token: tok, Entropy: e}
+		}
+	}
+
 	// Emit commit record (ordering compatible with git fast-import).
 	_, _ = bw.WriteString("commit " + newRef + "\n")
 	if oldMark != "" {
 		_, _ = bw.WriteString("mark " + oldMark + "\n")
 	}
-	if origOidLine != "" {
+	if origOidLine != "" && f.rules.keepOrigOIDs {
 		_, _ = bw.WriteString(origOidLine)
 	}
+	if committerLine != "" {
+		f.tickCommitClock(committerLine)
+	} else {
+		f.tickCommitClock(authorLine)
+	}
 	if authorLine != "" {
-		_, _ = bw.WriteString(rewriteIdentityLine("author", authorLine, f.rules))
+		_, _ = bw.WriteString(f.retime(rewriteIdentityLine("author", authorLine, f.rules)))
 	}
 	if committerLine != "" {
-		_, _ = bw.WriteString(rewriteIdentityLine("committer", committerLine, f.rules))
+		_, _ = bw.WriteString(f.retime(rewriteIdentityLine("committer", committerLine, f.rules)))
 	}
 	if encodingLine != "" {
 		_, _ = bw.WriteString(encodingLine)
@@ -294,9 +940,15 @@ EMIT:
 	}
 
 	// Commit message: do NOT add any delimiter newline after the payload.
-	_, _ = bw.WriteString(fmt.Sprintf("data %d\n", len(message)))
-	if _, err := bw.Write(message); err != nil {
-		return err
+	if messageFile != nil {
+		if err := messageFile.writeData(bw); err != nil {
+			return err
+		}
+	} else {
+		_, _ = bw.WriteString(fmt.Sprintf("data %d\n", len(message)))
+		if _, err := bw.Write(message); err != nil {
+			return err
+		}
 	}
 
 	// Parents and merges appear after the message data.
@@ -311,6 +963,14 @@ EMIT:
 		_, _ = bw.WriteString("merge " + m + "\n")
 	}
 
+	if parentResolved == "" && !isNotesRef(origRef) {
+		for _, op := range f.injectOps {
+			_, _ = bw.WriteString(op)
+		}
+	}
+	for _, op := range carried {
+		_, _ = bw.WriteString(op)
+	}
 	for _, op := range filteredOps {
 		_, _ = bw.WriteString(op)
 	}
@@ -320,14 +980,17 @@ EMIT:
 
 func (f *ExportFilter) handleTag(firstLine string, br *bufio.Reader, bw *bufio.Writer) error {
 	origRef := strings.TrimSpace(strings.TrimPrefix(firstLine, "tag "))
-	newRef := f.rewriteRef(origRef)
-	if err := f.checkRefCollision(origRef, newRef); err != nil {
-		return err
+	publish := f.rules.PublishRef("refs/tags/" + origRef)
+	newRef := f.rewriteTagName(origRef)
+	if publish {
+		if err := f.checkRefCollision(origRef, newRef); err != nil {
+			return err
+		}
 	}
-	_, _ = bw.WriteString("tag " + newRef + "\n")
 
 	var taggerLine, fromLine, markLine, origOidLine string
 	var message []byte
+	var messageFile *spoolFile
 	var extra []string
 
 	for {
@@ -341,9 +1004,9 @@ func (f *ExportFilter) handleTag(firstLine string, br *bufio.Reader, bw *bufio.W
 		}
 		switch {
 		case strings.HasPrefix(line, "from "):
-			fromLine = line
+			fromLine = f.inputMarkLine(line)
 		case strings.HasPrefix(line, "mark "):
-			markLine = line
+			markLine = f.inputMarkLine(line)
 		case strings.HasPrefix(line, "original-oid "):
 			origOidLine = line
 		case strings.HasPrefix(line, "tagger "):
@@ -353,15 +1016,17 @@ func (f *ExportFilter) handleTag(firstLine string, br *bufio.Reader, bw *bufio.W
 			if err != nil {
 				return err
 			}
-			b := make([]byte, n)
-			if _, err := io.ReadFull(br, b); err != nil {
+			message, messageFile, err = f.readRewrittenData(br, n, "")
+			if err != nil {
 				return err
 			}
+			if messageFile != nil {
+				defer messageFile.Close()
+			}
 			// Consume the optional trailing LF after data payload.
 			if _, err := br.ReadByte(); err != nil && err != io.EOF {
 				return err
 			}
-			message = f.rewriteBytes(b)
 			// Tag data is always the last field — nothing follows it.
 			// Continuing the loop would consume the next record.
 			goto EMIT
@@ -377,8 +1042,33 @@ func (f *ExportFilter) handleTag(firstLine string, br *bufio.Reader, bw *bufio.W
 	}
 
 EMIT:
-	if origOidLine != "" {
-		_, _ = bw.WriteString(origOidLine)
+	if !publish {
+		if markLine != "" {
+			// Tags of this tag point at its target instead.
+			p := strings.TrimSpace(strings.TrimPrefix(fromLine, "from "))
+			f.markMap[strings.TrimSpace(strings.TrimPrefix(markLine, "mark "))] = f.resolveCommitRef(p)
+		}
+		return nil
+	}
+	if f.rules.stripTagMessages() {
+		message, messageFile = []byte{}, nil
+	}
+	if f.rules.squashBase != "" && f.squashed(strings.TrimSpace(strings.TrimPrefix(fromLine, "from "))) {
+		// Tags of squashed commits are not published.
+		return nil
+	}
+	tok, e, found, err := f.messageEntropy(message, messageFile)
+	if err != nil {
+		return err
+	}
+	if found {
+		return EntropyError{Tag: newRef, Token: tok, Entropy: e}
+	}
+	// fast-import expects mark, from, original-oid, tagger in this order.
+	f.writtenRefs["refs/tags/"+newRef] = true
+	_, _ = bw.WriteString("tag " + newRef + "\n")
+	if markLine != "" {
+		_, _ = bw.WriteString(markLine)
 	}
 	if fromLine != "" {
 		// rewrite from ref if it uses marks
@@ -388,17 +1078,22 @@ EMIT:
 			_, _ = bw.WriteString("from " + p2 + "\n")
 		}
 	}
+	if origOidLine != "" && f.rules.keepOrigOIDs {
+		_, _ = bw.WriteString(origOidLine)
+	}
 	if taggerLine != "" {
 		// overwrite identity
-		_, _ = bw.WriteString(rewriteIdentityLine("tagger", taggerLine, f.rules))
-	}
-	if markLine != "" {
-		_, _ = bw.WriteString(markLine)
+		_, _ = bw.WriteString(f.retime(rewriteIdentityLine("tagger", taggerLine, f.rules)))
 	}
 	for _, l := range extra {
 		_, _ = bw.WriteString(l)
 	}
-	if message != nil {
+	if messageFile != nil {
+		if err := messageFile.writeData(bw); err != nil {
+			return err
+		}
+		_, _ = bw.WriteString("\n")
+	} else if message != nil {
 		// Tag message: write data payload followed by optional trailing LF.
 		// Do NOT add a blank terminator line — unlike commits, tags have no
 		// sub-commands after the data section, and an extra blank line causes
@@ -414,10 +1109,34 @@ EMIT:
 
 func (f *ExportFilter) handleReset(firstLine string, br *bufio.Reader, bw *bufio.Writer) error {
 	origRef := strings.TrimSpace(strings.TrimPrefix(firstLine, "reset "))
-	newRef := f.rewriteRef(origRef)
-	if err := f.checkRefCollision(origRef, newRef); err != nil {
+	newRef, err := f.importRef(origRef)
+	if err != nil {
 		return err
 	}
+	if f.rules.squashBase != "" {
+		// Refs to squashed commits are not published.
+		if peek, err := br.Peek(5); err == nil && string(peek) == "from " {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			p0 := f.inputMark(strings.TrimSpace(strings.TrimPrefix(line, "from ")))
+			if f.squashed(p0) {
+				// Swallow the optional blank line ending the record.
+				if peek, err := br.Peek(1); err == nil && peek[0] == '\n' {
+					_, _ = br.ReadByte()
+				}
+				return nil
+			}
+			_, _ = bw.WriteString("reset " + newRef + "\n")
+			if p1 := f.resolveCommitRef(p0); p1 != "" {
+				_, _ = bw.WriteString("from " + p1 + "\n")
+			} else {
+				_, _ = bw.WriteString(line)
+			}
+			return nil
+		}
+	}
 	_, _ = bw.WriteString("reset " + newRef + "\n")
 
 	// In git fast-export, a reset may be followed by:
@@ -440,7 +1159,7 @@ func (f *ExportFilter) handleReset(firstLine string, br *bufio.Reader, bw *bufio
 		if err != nil {
 			return err
 		}
-		p0 := strings.TrimSpace(strings.TrimPrefix(line, "from "))
+		p0 := f.inputMark(strings.TrimSpace(strings.TrimPrefix(line, "from ")))
 		p1 := f.resolveCommitRef(p0)
 		if p1 != "" {
 			_, _ = bw.WriteString("from " + p1 + "\n")
@@ -462,25 +1181,42 @@ func (f *ExportFilter) filterOps(ops []string) ([]string, int, error) {
 		}
 		if opTrim == "deleteall" {
 			out = append(out, "deleteall\n")
+			out = append(out, f.injectOps...)
 			kept++
 			continue
 		}
 		switch {
 		case strings.HasPrefix(opTrim, "M "):
-			mode, dataref, path, ok := parseM(opTrim)
-			if !ok {
-				out = append(out, op)
-				kept++
+			mode, dataref, path, err := parseM(opTrim)
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed file operation %q: %w", opTrim, err)
+			}
+			if f.excludedSource(path) {
 				continue
 			}
-			if f.rules.ShouldExclude(path) {
+			newPath, err := f.publicPath(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			if f.rules.ShouldExclude(newPath) || f.oversized[dataref] || f.rules.Injected(newPath) {
 				continue
 			}
-			newPath := f.rules.RewriteString(path)
-			newPath = strings.TrimPrefix(newPath, "./")
-			if f.rules.ShouldExclude(newPath) {
+			if f.rules.ShouldRedact(path) && mode != "160000" {
+				out = append(out, fmt.Sprintf("M %s %s %s\n", mode, f.redactMark, quotePath(newPath)))
+				kept++
 				continue
 			}
+			if f.dropSubmodules() {
+				if mode == "160000" {
+					f.droppedGitlinks[normPath(path)] = true
+					continue
+				}
+				// A file may take the place of a dropped gitlink.
+				delete(f.droppedGitlinks, normPath(path))
+				if normPath(newPath) == ".gitmodules" {
+					continue
+				}
+			}
 
 			// Check if this is a replace_history_with_current file
 			normalizedPath := normPath(newPath)
@@ -499,22 +1235,66 @@ func (f *ExportFilter) filterOps(ops []string) ([]string, int, error) {
 				}
 				// First occurrence: emit M with synthetic blob mark
 				f.replaceHistorySeenFiles[normalizedPath] = true
-				out = append(out, fmt.Sprintf("M %s %s %s\n", mode, syntheticMark, newPath))
+				out = append(out, fmt.Sprintf("M %s %s %s\n", mode, syntheticMark, quotePath(newPath)))
 				kept++
 				continue
 			}
 
-			out = append(out, fmt.Sprintf("M %s %s %s\n", mode, dataref, newPath))
+			if mode == "120000" && f.rules.symlinks != nil {
+				op, handled, err := f.filterSymlink(dataref, newPath)
+				if err != nil {
+					return nil, 0, err
+				}
+				if handled {
+					if op != "" {
+						out = append(out, op)
+						kept++
+					}
+					continue
+				}
+			}
+
+			if oid, ok := f.lfsPointers[dataref]; ok {
+				switch f.rules.LFSMode() {
+				case LFSExclude:
+					continue
+				case LFSError:
+					return nil, 0, fmt.Errorf("%s is a Git LFS pointer; set the target's lfs mode to %q or %q", path, LFSPush, LFSExclude)
+				case LFSPush:
+					f.lfsObjects[oid] = true
+				}
+			}
+			if f.rules.DeferBlobs() {
+				if dataref, err = f.pathBlobRef(dataref, newPath); err != nil {
+					return nil, 0, err
+				}
+			}
+			out = append(out, fmt.Sprintf("M %s %s %s\n", mode, dataref, quotePath(newPath)))
 			kept++
 		case strings.HasPrefix(opTrim, "D "):
-			path := strings.TrimSpace(strings.TrimPrefix(opTrim, "D "))
-			if f.rules.ShouldExclude(path) {
+			path, err := parseLastPath(strings.TrimPrefix(opTrim, "D "))
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed file operation %q: %w", opTrim, err)
+			}
+			if f.excludedSource(path) {
 				continue
 			}
-			newPath := f.rules.RewriteString(path)
-			if f.rules.ShouldExclude(newPath) {
+			newPath, err := f.publicPath(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			if f.rules.ShouldExclude(newPath) || f.rules.Injected(newPath) {
 				continue
 			}
+			if f.dropSubmodules() {
+				if f.droppedGitlinks[normPath(path)] {
+					delete(f.droppedGitlinks, normPath(path))
+					continue
+				}
+				if normPath(newPath) == ".gitmodules" {
+					continue
+				}
+			}
 
 			// Check if this is a replace_history_with_current file
 			normalizedPath := normPath(newPath)
@@ -524,42 +1304,98 @@ func (f *ExportFilter) filterOps(ops []string) ([]string, int, error) {
 				continue
 			}
 
-			out = append(out, "D "+newPath+"\n")
+			out = append(out, "D "+quotePath(newPath)+"\n")
 			kept++
 		case strings.HasPrefix(opTrim, "R "):
-			oldP, newP, ok := parseTwoPaths(opTrim, "R")
-			if !ok {
-				out = append(out, op)
-				kept++
-				continue
+			oldP, newP, err := parseTwoPaths(opTrim, "R")
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed file operation %q: %w", opTrim, err)
 			}
 			// If source excluded but dest included, rename cannot be represented safely (fast-import needs source present).
-			if f.rules.ShouldExclude(oldP) && !f.rules.ShouldExclude(newP) {
+			if f.excludedSource(oldP) && !f.excludedSource(newP) {
 				return nil, 0, fmt.Errorf("unsafe rename from excluded path %q to included path %q; add an exclusion for the destination or avoid renaming excluded files", oldP, newP)
 			}
-			if f.rules.ShouldExclude(newP) {
+			if f.excludedSource(newP) {
 				continue
 			}
-			old2 := f.rules.RewriteString(oldP)
-			new2 := f.rules.RewriteString(newP)
-			out = append(out, fmt.Sprintf("R %s %s\n", old2, new2))
-			kept++
-		case strings.HasPrefix(opTrim, "C "):
-			oldP, newP, ok := parseTwoPaths(opTrim, "C")
-			if !ok {
-				out = append(out, op)
+			if f.rules.ShouldRedact(newP) && !f.rules.ShouldRedact(oldP) {
+				return nil, 0, fmt.Errorf("unsafe rename from %q to redacted path %q; redact the source as well", oldP, newP)
+			}
+			old2, err := f.publicPath(oldP)
+			if err != nil {
+				return nil, 0, err
+			}
+			new2, err := f.publicPath(newP)
+			if err != nil {
+				return nil, 0, err
+			}
+			if f.rules.Injected(old2) {
+				return nil, 0, fmt.Errorf("unsafe rename from injected path %q to %q; add an exclusion for the destination", old2, new2)
+			}
+			if f.dropSubmodules() {
+				if f.droppedGitlinks[normPath(oldP)] {
+					delete(f.droppedGitlinks, normPath(oldP))
+					f.droppedGitlinks[normPath(newP)] = true
+					continue
+				}
+				if normPath(old2) == ".gitmodules" {
+					return nil, 0, fmt.Errorf("unsafe rename from dropped .gitmodules to %q; add an exclusion for the destination", new2)
+				}
+				if normPath(new2) == ".gitmodules" {
+					out = append(out, "D "+quotePath(old2)+"\n")
+					kept++
+					continue
+				}
+			}
+			if f.rules.Injected(new2) {
+				// The injected file wins; the renamed file is gone.
+				out = append(out, "D "+quotePath(old2)+"\n")
 				kept++
 				continue
 			}
-			if f.rules.ShouldExclude(oldP) && !f.rules.ShouldExclude(newP) {
+			out = append(out, fmt.Sprintf("R %s %s\n", quotePath(old2), quotePath(new2)))
+			kept++
+		case strings.HasPrefix(opTrim, "C "):
+			oldP, newP, err := parseTwoPaths(opTrim, "C")
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed file operation %q: %w", opTrim, err)
+			}
+			if f.excludedSource(oldP) && !f.excludedSource(newP) {
 				return nil, 0, fmt.Errorf("unsafe copy from excluded path %q to included path %q; add an exclusion for the destination or avoid copying excluded files", oldP, newP)
 			}
-			if f.rules.ShouldExclude(newP) {
+			if f.excludedSource(newP) {
 				continue
 			}
-			old2 := f.rules.RewriteString(oldP)
-			new2 := f.rules.RewriteString(newP)
-			out = append(out, fmt.Sprintf("C %s %s\n", old2, new2))
+			if f.rules.ShouldRedact(newP) && !f.rules.ShouldRedact(oldP) {
+				return nil, 0, fmt.Errorf("unsafe copy from %q to redacted path %q; redact the source as well", oldP, newP)
+			}
+			old2, err := f.publicPath(oldP)
+			if err != nil {
+				return nil, 0, err
+			}
+			new2, err := f.publicPath(newP)
+			if err != nil {
+				return nil, 0, err
+			}
+			if f.rules.Injected(old2) {
+				return nil, 0, fmt.Errorf("unsafe copy from injected path %q to %q; add an exclusion for the destination", old2, new2)
+			}
+			if f.dropSubmodules() {
+				if f.droppedGitlinks[normPath(oldP)] {
+					f.droppedGitlinks[normPath(newP)] = true
+					continue
+				}
+				if normPath(old2) == ".gitmodules" {
+					return nil, 0, fmt.Errorf("unsafe copy from dropped .gitmodules to %q; add an exclusion for the destination", new2)
+				}
+				if normPath(new2) == ".gitmodules" {
+					continue
+				}
+			}
+			if f.rules.Injected(new2) {
+				continue
+			}
+			out = append(out, fmt.Sprintf("C %s %s\n", quotePath(old2), quotePath(new2)))
 			kept++
 		default:
 			// Unknown operation; keep but scrub obvious usernames in the line
@@ -570,6 +1406,57 @@ func (f *ExportFilter) filterOps(ops []string) ([]string, int, error) {
 	return out, kept, nil
 }
 
+// dropSubmodules reports whether gitlinks and .gitmodules are left out of
+// the published history.
+func (f *ExportFilter) dropSubmodules() bool {
+	return f.rules.SubmodulePolicy() == SubmoduleDrop
+}
+
+// excludedSource reports whether a private (pre-rewrite) path is dropped,
+// either by exclusion rules or by falling outside the target's include set
+// or subtree.
+func (f *ExportFilter) excludedSource(p string) bool {
+	return f.rules.ShouldExclude(p) || !f.rules.ShouldInclude(p) || !f.rules.InSubtree(p)
+}
+
+// publicPath maps a private path to its public location: path mappings are
+// applied first, then username/extra replacements. It fails if two distinct
+// private paths end up at the same public path.
+func (f *ExportFilter) publicPath(p string) (string, error) {
+	mapped := f.rules.MapPath(p)
+	if mapped == "" {
+		return "", fmt.Errorf("path mapping rewrites %q to an empty path", p)
+	}
+	pub := strings.TrimPrefix(f.rules.RewriteString(mapped), "./")
+	if !f.rules.HasPathMappings() {
+		return pub, nil
+	}
+	if prev, ok := f.publicPathOrigins[pub]; ok && prev != p {
+		return "", fmt.Errorf("path mapping collision: %q and %q both map to %q", prev, p, pub)
+	}
+	f.publicPathOrigins[pub] = p
+	return pub, nil
+}
+
+// pruneMergeParents resolves merge parents and drops those that are empty
+// or duplicate the first parent or an earlier merge parent.
+func (f *ExportFilter) pruneMergeParents(parent string, merges []string) []string {
+	seen := map[string]bool{}
+	if parent != "" {
+		seen[parent] = true
+	}
+	var kept []string
+	for _, m0 := range merges {
+		m := f.resolveCommitRef(m0)
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		kept = append(kept, m)
+	}
+	return kept
+}
+
 func (f *ExportFilter) resolveCommitRef(ref string) string {
 	ref = strings.TrimSpace(ref)
 	if strings.HasPrefix(ref, ":") {
@@ -582,10 +1469,6 @@ func (f *ExportFilter) resolveCommitRef(ref string) string {
 	return ref
 }
 
-func (f *ExportFilter) rewriteBytes(b []byte) []byte {
-	return f.rules.RewriteBytes(b)
-}
-
 func rewriteIdentityLine(kind, line string, rules CompiledRules) string {
 	// line format: "<kind> Name <email> timestamp tz\n"
 	// We keep timestamp+tz from original, but overwrite name/email.
@@ -597,8 +1480,11 @@ func rewriteIdentityLine(kind, line string, rules CompiledRules) string {
 		return kind + " " + rules.RewriteString(rest) + "\n"
 	}
 	after := strings.TrimSpace(rest[i+1:]) // timestamp tz
-	name := rules.PublicAuthorName()
-	email := rules.PublicAuthorEmail()
+	var privName, privEmail string
+	if j := strings.LastIndex(rest[:i], "<"); j >= 0 {
+		privName, privEmail = strings.TrimSpace(rest[:j]), rest[j+1:i]
+	}
+	name, email := rules.PublicIdentity(privName, privEmail)
 	return fmt.Sprintf("%s %s <%s> %s\n", kind, name, email, after)
 }
 
@@ -611,37 +1497,157 @@ func parseDataLen(line string) (int, error) {
 	return n, nil
 }
 
-func parseM(line string) (mode, dataref, path string, ok bool) {
+func parseM(line string) (mode, dataref, path string, err error) {
 	// line: "M <mode> <dataref> <path>"
-	// Find first space after "M "
 	rest := strings.TrimPrefix(line, "M ")
 	i1 := strings.IndexByte(rest, ' ')
 	if i1 < 0 {
-		return "", "", "", false
+		return "", "", "", errors.New("missing dataref")
 	}
 	mode = rest[:i1]
 	rest2 := rest[i1+1:]
 	i2 := strings.IndexByte(rest2, ' ')
 	if i2 < 0 {
-		return "", "", "", false
+		return "", "", "", errors.New("missing path")
 	}
 	dataref = rest2[:i2]
-	path = strings.TrimSpace(rest2[i2+1:])
-	return mode, dataref, path, true
+	path, err = parseLastPath(rest2[i2+1:])
+	return mode, dataref, path, err
 }
 
-func parseTwoPaths(line, prefix string) (a, b string, ok bool) {
-	// Very common case: paths without spaces. If paths contain spaces, fast-import format becomes ambiguous.
-	parts := strings.Fields(line)
-	if len(parts) != 3 || parts[0] != prefix {
-		return "", "", false
+// parseTwoPaths parses "<prefix> <src> <dst>" as emitted for R and C
+// operations. A source path containing spaces is always quoted.
+func parseTwoPaths(line, prefix string) (a, b string, err error) {
+	rest := strings.TrimPrefix(line, prefix+" ")
+	if strings.HasPrefix(rest, `"`) {
+		var n int
+		a, n, err = unquotePath(rest)
+		if err != nil {
+			return "", "", err
+		}
+		rest = rest[n:]
+		if !strings.HasPrefix(rest, " ") {
+			return "", "", errors.New("missing destination path")
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			return "", "", errors.New("missing destination path")
+		}
+		a, rest = rest[:i], rest[i+1:]
 	}
-	return parts[1], parts[2], true
+	b, err = parseLastPath(rest)
+	return a, b, err
+}
+
+// parseLastPath parses a path that runs to the end of the line, which may be
+// C-style quoted.
+func parseLastPath(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	p, n, err := unquotePath(s)
+	if err != nil {
+		return "", err
+	}
+	if n != len(s) {
+		return "", fmt.Errorf("unexpected text after quoted path: %q", s[n:])
+	}
+	return p, nil
+}
+
+// unquotePath decodes the C-style quoted path at the start of s, returning
+// the path and the number of bytes consumed (including both quotes).
+func unquotePath(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, nil
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		switch e := s[i]; e {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"':
+			b.WriteByte(e)
+		default:
+			if i+3 > len(s) {
+				return "", 0, fmt.Errorf("invalid escape in quoted path %q", s)
+			}
+			v, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid escape in quoted path %q", s)
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted path %q", s)
+}
+
+// quotePath returns p as a fast-import path argument, C-style quoting it when
+// it contains spaces, quotes, backslashes or control characters.
+func quotePath(p string) string {
+	needs := strings.HasPrefix(p, `"`)
+	for i := 0; i < len(p) && !needs; i++ {
+		c := p[i]
+		needs = c == ' ' || c == '\\' || c == '"' || c < 0x20 || c == 0x7f
+	}
+	if !needs {
+		return p
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
 }
 
 func (f *ExportFilter) rewriteRef(ref string) string {
 	// ref is a token like "refs/heads/main" or "refs/tags/v1.0"
-	return f.rules.RewriteString(ref)
+	return f.rules.PublicRef(ref)
+}
+
+// rewriteTagName returns the public name of a tag record's tag.
+func (f *ExportFilter) rewriteTagName(name string) string {
+	return strings.TrimPrefix(f.rewriteRef("refs/tags/"+name), "refs/tags/")
 }
 
 func (f *ExportFilter) checkRefCollision(orig, rewritten string) error {
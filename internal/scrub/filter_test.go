@@ -3,6 +3,8 @@ package scrub
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -557,6 +559,41 @@ func TestReplaceHistoryWithCurrent_MultipleFiles(t *testing.T) {
 	}
 }
 
+func TestReplaceHistoryWithCurrent_Glob(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"docs/legal/LICENSE": "License v1\n", "docs/legal/sub/NOTICE": "Notice v1\n", "docs/guide.md": "guide v1\n"}, "Initial files")
+	commitFiles(t, repo, map[string]string{"docs/legal/LICENSE": "License v2\n", "docs/legal/sub/NOTICE": "Notice v2\n", "docs/guide.md": "guide v2\n"}, "Update docs")
+
+	files := ExpandReplaceHistory([]string{"docs/legal/**"}, []string{"docs/legal/LICENSE", "docs/legal/sub/NOTICE", "docs/guide.md"})
+	if strings.Join(files, ",") != "docs/legal/LICENSE,docs/legal/sub/NOTICE" {
+		t.Fatalf("ExpandReplaceHistory = %v", files)
+	}
+	rules, err := Compile(Rules{
+		PrivateUsername:           "obinnaokechukwu",
+		Replacement:               "johndoe",
+		ReplaceHistoryWithCurrent: []string{"docs/legal/**"},
+		ReplaceHistoryContent: map[string][]byte{
+			"docs/legal/LICENSE":    []byte("License v2\n"),
+			"docs/legal/sub/NOTICE": []byte("Notice v2\n"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	for p, want := range map[string]string{"docs/legal/LICENSE": "License v2\n", "docs/legal/sub/NOTICE": "Notice v2\n", "docs/guide.md": "guide v1\n"} {
+		show, err := gitx.Run(nil, bare, "show", "refs/heads/main~1:"+p)
+		if err != nil {
+			t.Fatalf("show %s: %v", p, err)
+		}
+		if show.Stdout != want {
+			t.Errorf("first commit %s = %q, want %q", p, show.Stdout, want)
+		}
+	}
+}
+
 func TestReplaceHistoryWithCurrent_FileNotInHistory(t *testing.T) {
 	// Test handling files that don't exist in history but do exist in HEAD
 	dir := t.TempDir()
@@ -846,3 +883,819 @@ func TestExportFilter_MultipleTagsBackToBack(t *testing.T) {
 		}
 	}
 }
+
+// newFilterTestRepo creates an empty repo on branch main with a private identity.
+func newFilterTestRepo(t *testing.T) string {
+	t.Helper()
+	repo := filepath.Join(t.TempDir(), "repo")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "init", "-b", "main"); err != nil {
+		if _, err2 := gitx.Run(nil, repo, "init"); err2 != nil {
+			t.Fatalf("git init: %v", err2)
+		}
+		_, _ = gitx.Run(nil, repo, "checkout", "-b", "main")
+	}
+	_, _ = gitx.Run(nil, repo, "config", "user.name", "obinnaokechukwu")
+	_, _ = gitx.Run(nil, repo, "config", "user.email", "obinnaokechukwu@private.invalid")
+	return repo
+}
+
+// commitFiles writes files (path -> content) into a filter test repo and
+// commits everything as msg.
+func commitFiles(t *testing.T, repo string, files map[string]string, msg string) {
+	t.Helper()
+	for p, content := range files {
+		full := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+	if _, err := gitx.Run(nil, repo, "add", "-A"); err != nil {
+		t.Fatalf("git add: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-m", msg); err != nil {
+		t.Fatalf("git commit: %v", err)
+	}
+}
+
+func TestExportFilter_PathReplacementsOnlyApplyToMatchingPaths(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	// Identical content => fast-export emits one blob referenced by both paths.
+	content := "host: internal.example.com\n"
+	commitFiles(t, repo, map[string]string{
+		"deploy/prod.yaml": content,
+		"app/config.yaml":  content,
+	}, "add configs")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		PathReplacements: []PathReplacements{{
+			Patterns:     []string{"deploy/**"},
+			Replacements: map[string]string{"internal.example.com": "public.example.com"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	deploy, err := gitx.Run(nil, bare, "show", "refs/heads/main:deploy/prod.yaml")
+	if err != nil {
+		t.Fatalf("show deploy: %v", err)
+	}
+	if deploy.Stdout != "host: public.example.com\n" {
+		t.Fatalf("expected deploy file rewritten, got %q", deploy.Stdout)
+	}
+	app, err := gitx.Run(nil, bare, "show", "refs/heads/main:app/config.yaml")
+	if err != nil {
+		t.Fatalf("show app: %v", err)
+	}
+	if app.Stdout != content {
+		t.Fatalf("expected app file untouched, got %q", app.Stdout)
+	}
+}
+
+func TestExportFilter_PathMappings(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"services/api/main.go":   "package main\n",
+		"docs/internal/guide.md": "guide\n",
+	}, "initial")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		PathMappings: []PathMapping{
+			{From: "services/api", To: ""},
+			{From: "docs/internal", To: "docs"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	ls, err := gitx.Run(nil, bare, "ls-tree", "-r", "--name-only", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("ls-tree: %v", err)
+	}
+	got := strings.Fields(ls.Stdout)
+	if len(got) != 2 || got[0] != "docs/guide.md" || got[1] != "main.go" {
+		t.Fatalf("unexpected mapped tree: %v", got)
+	}
+}
+
+func TestFilterOps_PathMappingCollision(t *testing.T) {
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		PathMappings:    []PathMapping{{From: "services/api", To: ""}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	f := NewExportFilter(rules)
+	_, _, err = f.filterOps([]string{
+		"M 100644 :1 README.md\n",
+		"M 100644 :2 services/api/README.md\n",
+	})
+	if err == nil || !strings.Contains(err.Error(), "collision") {
+		t.Fatalf("expected path mapping collision error, got: %v", err)
+	}
+}
+
+func TestExportFilter_SubtreePrunesUnrelatedCommits(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"services/api/main.go": "package main\n",
+		"web/index.html":       "<html></html>\n",
+	}, "initial")
+	commitFiles(t, repo, map[string]string{"web/index.html": "<html>v2</html>\n"}, "web only")
+	commitFiles(t, repo, map[string]string{"services/api/main.go": "package main // v2\n"}, "api change")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		Subtree:         "services/api/",
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	log, err := gitx.Run(nil, bare, "log", "--format=%s", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	subjects := strings.Split(strings.TrimSpace(log.Stdout), "\n")
+	if len(subjects) != 2 || subjects[0] != "api change" || subjects[1] != "initial" {
+		t.Fatalf("expected web-only commit pruned, got: %q", subjects)
+	}
+	ls, err := gitx.Run(nil, bare, "ls-tree", "-r", "--name-only", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("ls-tree: %v", err)
+	}
+	if strings.TrimSpace(ls.Stdout) != "main.go" {
+		t.Fatalf("expected subtree at root, got:\n%s", ls.Stdout)
+	}
+}
+
+func TestExportFilter_SkipBinaryBlobs(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	binary := "\x89PNG\x00\x00obinnaokechukwu\x00"
+	commitFiles(t, repo, map[string]string{
+		"logo.png":   binary,
+		"font.woff2": "obinnaokechukwu",
+		"README.md":  "by obinnaokechukwu\n",
+		// Same blob as font.woff2, referenced later at a text path.
+		"notes.txt": "obinnaokechukwu",
+	}, "add assets")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		SkipBinaryBlobs: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	cases := []struct {
+		path, expected string
+	}{
+		{"logo.png", binary},
+		{"font.woff2", "obinnaokechukwu"},
+		{"README.md", "by johndoe\n"},
+		{"notes.txt", "johndoe"},
+	}
+	for _, tc := range cases {
+		res, err := gitx.Run(nil, bare, "show", "refs/heads/main:"+tc.path)
+		if err != nil {
+			t.Fatalf("show %s: %v", tc.path, err)
+		}
+		if res.Stdout != tc.expected {
+			t.Errorf("%s = %q, want %q", tc.path, res.Stdout, tc.expected)
+		}
+	}
+}
+
+func TestExportFilter_MaxRewriteBlobBytes(t *testing.T) {
+	big := strings.Repeat("obinnaokechukwu ", 64)
+	for _, deferred := range []bool{false, true} {
+		repo := newFilterTestRepo(t)
+		commitFiles(t, repo, map[string]string{
+			"big.txt":   big,
+			"small.txt": "by obinnaokechukwu\n",
+		}, "add files")
+
+		rules, err := Compile(Rules{
+			PrivateUsername:     "obinnaokechukwu",
+			Replacement:         "johndoe",
+			MaxRewriteBlobBytes: 512,
+			SkipBinaryBlobs:     deferred,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+
+		bare := filepath.Join(t.TempDir(), "out.git")
+		runExportFilterImport(t, repo, bare, rules)
+
+		res, err := gitx.Run(nil, bare, "show", "refs/heads/main:big.txt")
+		if err != nil {
+			t.Fatalf("show big.txt: %v", err)
+		}
+		if res.Stdout != big {
+			t.Errorf("deferred=%v: expected big.txt passed through unchanged", deferred)
+		}
+		res, err = gitx.Run(nil, bare, "show", "refs/heads/main:small.txt")
+		if err != nil {
+			t.Fatalf("show small.txt: %v", err)
+		}
+		if res.Stdout != "by johndoe\n" {
+			t.Errorf("deferred=%v: small.txt = %q", deferred, res.Stdout)
+		}
+	}
+
+	// replace_history_with_current content honors the limit too.
+	rules, err := Compile(Rules{
+		PrivateUsername:           "obinnaokechukwu",
+		Replacement:               "johndoe",
+		MaxRewriteBlobBytes:       512,
+		ReplaceHistoryWithCurrent: []string{"big.txt", "small.txt"},
+		ReplaceHistoryContent:     map[string][]byte{"big.txt": []byte(big), "small.txt": []byte("by obinnaokechukwu\n")},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := string(rules.GetReplaceHistoryContent("big.txt")); got != big {
+		t.Errorf("expected big.txt replace history content unchanged")
+	}
+	if got := string(rules.GetReplaceHistoryContent("small.txt")); got != "by johndoe\n" {
+		t.Errorf("small.txt replace history content = %q", got)
+	}
+}
+
+func TestExportFilter_LFSModes(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12345\n"
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"assets/video.mp4": pointer,
+		"README.md":        "by obinnaokechukwu\n",
+	}, "add assets")
+
+	for _, mode := range []string{LFSKeep, LFSPush, LFSExclude, LFSError} {
+		rules, err := Compile(Rules{
+			PrivateUsername:   "obinnaokechukwu",
+			Replacement:       "johndoe",
+			ExtraReplacements: map[string]string{"12345": "99999"},
+			LFSMode:           mode,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+
+		exp := gitx.FastExportCmd(repo, "--all")
+		out, err := exp.Output()
+		if err != nil {
+			t.Fatalf("fast-export: %v", err)
+		}
+		f := NewExportFilter(rules)
+		var buf bytes.Buffer
+		ferr := f.Filter(bytes.NewReader(out), &buf)
+		if mode == LFSError {
+			if ferr == nil || !strings.Contains(ferr.Error(), "assets/video.mp4") {
+				t.Fatalf("expected LFS pointer error naming the path, got %v", ferr)
+			}
+			continue
+		}
+		if ferr != nil {
+			t.Fatalf("%s: Filter: %v", mode, ferr)
+		}
+		s := buf.String()
+		if mode == LFSExclude {
+			if strings.Contains(s, "assets/video.mp4") {
+				t.Errorf("exclude: expected pointer path dropped")
+			}
+			continue
+		}
+		if !strings.Contains(s, pointer) {
+			t.Errorf("%s: expected pointer published unmodified", mode)
+		}
+		objs := f.LFSObjects()
+		if mode == LFSPush && (len(objs) != 1 || objs[0] != oid) {
+			t.Errorf("push: LFSObjects = %v", objs)
+		}
+		if mode == LFSKeep && len(objs) != 0 {
+			t.Errorf("keep: expected no LFS objects, got %v", objs)
+		}
+	}
+}
+
+func TestExportFilter_SubmodulePolicy(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	gitmodules := "[submodule \"lib\"]\n\tpath = lib\n\turl = https://github.com/obinnaokechukwu/lib.git\n"
+	commitFiles(t, repo, map[string]string{".gitmodules": gitmodules}, "add .gitmodules")
+	subSha := strings.Repeat("1", 40)
+	if _, err := gitx.Run(nil, repo, "update-index", "--add", "--cacheinfo", "160000,"+subSha+",lib"); err != nil {
+		t.Fatalf("update-index: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-m", "add submodule"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	out, err := gitx.FastExportCmd(repo, "--all").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+
+	cases := []struct {
+		policy      string
+		wantGitlink bool
+		wantURL     string
+	}{
+		{SubmoduleRewrite, true, "https://github.com/johndoe/lib.git"},
+		{SubmoduleKeep, true, "https://github.com/obinnaokechukwu/lib.git"},
+		{SubmoduleDrop, false, ""},
+	}
+	for _, tc := range cases {
+		rules, err := Compile(Rules{
+			PrivateUsername: "obinnaokechukwu",
+			Replacement:     "johndoe",
+			SubmodulePolicy: tc.policy,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := NewExportFilter(rules).Filter(bytes.NewReader(out), &buf); err != nil {
+			t.Fatalf("%s: Filter: %v", tc.policy, err)
+		}
+		s := buf.String()
+		if got := strings.Contains(s, "M 160000 "+subSha+" lib\n"); got != tc.wantGitlink {
+			t.Errorf("%s: gitlink present = %v, want %v", tc.policy, got, tc.wantGitlink)
+		}
+		if tc.wantURL == "" {
+			if strings.Contains(s, ".gitmodules") {
+				t.Errorf("%s: expected .gitmodules dropped", tc.policy)
+			}
+		} else if !strings.Contains(s, tc.wantURL) {
+			t.Errorf("%s: expected .gitmodules url %q in output", tc.policy, tc.wantURL)
+		}
+	}
+}
+
+func TestParsePaths_Quoted(t *testing.T) {
+	_, _, p, err := parseM(`M 100644 :1 "My Documents/caf\303\251 \"x\".txt"`)
+	if err != nil || p != `My Documents/café "x".txt` {
+		t.Fatalf("parseM = %q, %v", p, err)
+	}
+	a, b, err := parseTwoPaths(`R "old name.txt" new name.txt`, "R")
+	if err != nil || a != "old name.txt" || b != "new name.txt" {
+		t.Fatalf("parseTwoPaths = %q, %q, %v", a, b, err)
+	}
+	a, b, err = parseTwoPaths(`C a.txt "b\tc.txt"`, "C")
+	if err != nil || a != "a.txt" || b != "b\tc.txt" {
+		t.Fatalf("parseTwoPaths = %q, %q, %v", a, b, err)
+	}
+	if _, _, _, err := parseM(`M 100644 :1 "unterminated`); err == nil {
+		t.Fatalf("expected error for unterminated quoted path")
+	}
+
+	for _, p := range []string{"plain.txt", "My Documents/a b.txt", `q"uote`, "tab\there", `back\slash`, "café.txt"} {
+		got, err := parseLastPath(quotePath(p))
+		if err != nil || got != p {
+			t.Errorf("round trip %q = %q, %v", p, got, err)
+		}
+	}
+}
+
+func TestExportFilter_PathsWithSpaces(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"My Documents/obinnaokechukwu notes.txt": "by obinnaokechukwu\n",
+		"café/menu.txt":                          "menu\n",
+	}, "add files")
+	if _, err := gitx.Run(nil, repo, "rm", "-q", "café/menu.txt"); err != nil {
+		t.Fatalf("git rm: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-q", "-m", "remove menu"); err != nil {
+		t.Fatalf("git commit: %v", err)
+	}
+
+	rules, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	res, err := gitx.Run(nil, bare, "-c", "core.quotepath=false", "ls-tree", "-r", "--name-only", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("ls-tree: %v", err)
+	}
+	if strings.TrimSpace(res.Stdout) != "My Documents/johndoe notes.txt" {
+		t.Fatalf("unexpected tree: %q", res.Stdout)
+	}
+	res, err = gitx.Run(nil, bare, "-c", "core.quotepath=false", "ls-tree", "-r", "--name-only", "refs/heads/main~1")
+	if err != nil {
+		t.Fatalf("ls-tree: %v", err)
+	}
+	if !strings.Contains(res.Stdout, "café/menu.txt") {
+		t.Fatalf("expected non-ASCII path in first commit, got %q", res.Stdout)
+	}
+}
+
+func TestExportFilter_Notes(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+	commitFiles(t, repo, map[string]string{".env": "SECRET=1\n"}, "excluded only")
+	commitFiles(t, repo, map[string]string{"b.txt": "b\n"}, "third")
+	for rev, note := range map[string]string{"HEAD~2": "reviewed by obinnaokechukwu", "HEAD~1": "private note", "HEAD": "ok"} {
+		if _, err := gitx.Run(nil, repo, "notes", "add", "-m", note, rev); err != nil {
+			t.Fatalf("notes add: %v", err)
+		}
+	}
+	stream, err := gitx.FastExportCmd(repo, "--all", "--show-original-ids").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+
+	for _, drop := range []bool{false, true} {
+		rules, err := Compile(Rules{
+			PrivateUsername: "obinnaokechukwu",
+			Replacement:     "johndoe",
+			ExcludePatterns: []string{".env"},
+			DropNotes:       drop,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		var out bytes.Buffer
+		if err := NewExportFilter(rules).Filter(bytes.NewReader(stream), &out); err != nil {
+			t.Fatalf("Filter: %v", err)
+		}
+		if bytes.Contains(out.Bytes(), []byte("original-oid ")) {
+			t.Errorf("private original-oid leaked into output")
+		}
+		bare := filepath.Join(t.TempDir(), "out.git")
+		if _, err := gitx.Run(nil, "", "init", "--bare", bare); err != nil {
+			t.Fatalf("init bare: %v", err)
+		}
+		imp := gitx.FastImportCmd(bare)
+		imp.Stdin = &out
+		if b, err := imp.CombinedOutput(); err != nil {
+			t.Fatalf("fast-import: %v (%s)", err, b)
+		}
+
+		res, err := gitx.Run(nil, bare, "log", "--format=%s|%N", "refs/heads/main")
+		if err != nil {
+			t.Fatalf("log: %v", err)
+		}
+		got := strings.Fields(strings.ReplaceAll(res.Stdout, "\n", " "))
+		want := []string{"third|ok", "first|reviewed", "by", "johndoe"}
+		if drop {
+			want = []string{"third|", "first|"}
+			refs, _ := gitx.ListRefs(bare)
+			if _, ok := refs["refs/notes/commits"]; ok {
+				t.Errorf("expected notes ref dropped")
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("drop=%v: log = %q", drop, res.Stdout)
+		}
+		if drop {
+			continue
+		}
+		// The note on the skipped commit must not survive anywhere.
+		all, _ := gitx.Run(nil, bare, "log", "-p", "refs/notes/commits")
+		if strings.Contains(all.Stdout, "private note") {
+			t.Errorf("note on unpublished commit leaked: %q", all.Stdout)
+		}
+	}
+}
+
+func TestExportFilter_EntropyCheckBlocksExport(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"README.md": "hello\n"}, "first")
+	commitFiles(t, repo, map[string]string{"deploy/env.sh": "export TOKEN=q8Zr2VxN7bLk4TgW1mYc9HdP3sJf6Ua0\n"}, "add deploy")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		Entropy:         &EntropyRules{},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	stream, err := gitx.FastExportCmd(repo, "--all", "--show-original-ids").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+	head, err := gitx.Run(nil, repo, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+
+	err = NewExportFilter(rules).Filter(bytes.NewReader(stream), io.Discard)
+	var ee EntropyError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected EntropyError, got %v", err)
+	}
+	if ee.Path != "deploy/env.sh" || ee.Commit != strings.TrimSpace(head.Stdout) {
+		t.Fatalf("unexpected error location: %+v", ee)
+	}
+	if strings.Contains(err.Error(), "q8Zr2VxN7bLk4TgW1mYc9HdP3sJf6Ua0") {
+		t.Fatalf("error message must not contain the full token: %v", err)
+	}
+}
+
+func TestExportFilter_EntropyCheckEveryPayload(t *testing.T) {
+	old := streamThreshold
+	streamThreshold = 256
+	defer func() { streamThreshold = old }()
+
+	const token = "q8Zr2VxN7bLk4TgW1mYc9HdP3sJf6Ua0"
+	filler := strings.Repeat("plain text\n", 40)
+	cases := []struct {
+		name  string
+		setup func(t *testing.T, repo string)
+		check func(ee EntropyError) bool
+	}{
+		{"spooled blob", func(t *testing.T, repo string) {
+			commitFiles(t, repo, map[string]string{"big.txt": filler + token + "\n"}, "add big")
+		}, func(ee EntropyError) bool { return ee.Path == "big.txt" }},
+		{"blob over max_rewrite_blob_bytes", func(t *testing.T, repo string) {
+			commitFiles(t, repo, map[string]string{"huge.txt": strings.Repeat(filler, 4) + token + "\n"}, "add huge")
+		}, func(ee EntropyError) bool { return ee.Path == "huge.txt" }},
+		{"commit message", func(t *testing.T, repo string) {
+			commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "rotate key "+token)
+		}, func(ee EntropyError) bool { return ee.Path == "" && ee.Tag == "" && ee.Commit != "" }},
+		{"tag message", func(t *testing.T, repo string) {
+			commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+			if _, err := gitx.Run(nil, repo, "tag", "-a", "v1", "-m", "key "+token); err != nil {
+				t.Fatalf("tag: %v", err)
+			}
+		}, func(ee EntropyError) bool { return ee.Tag == "v1" }},
+	}
+	for _, tc := range cases {
+		repo := newFilterTestRepo(t)
+		tc.setup(t, repo)
+		rules, err := Compile(Rules{
+			PrivateUsername:     "obinnaokechukwu",
+			Replacement:         "johndoe",
+			MaxRewriteBlobBytes: 1024,
+			Entropy:             &EntropyRules{},
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		stream, err := gitx.FastExportCmd(repo, "--all", "--show-original-ids").Output()
+		if err != nil {
+			t.Fatalf("fast-export: %v", err)
+		}
+		err = NewExportFilter(rules).Filter(bytes.NewReader(stream), io.Discard)
+		var ee EntropyError
+		if !errors.As(err, &ee) || !tc.check(ee) {
+			t.Errorf("%s: expected an EntropyError, got %v (%+v)", tc.name, err, ee)
+		}
+	}
+}
+
+func TestRewriteIdentityLine_AuthorMap(t *testing.T) {
+	rules, err := Compile(Rules{
+		PrivateUsername:   "obinnaokechukwu",
+		Replacement:       "johndoe",
+		PublicAuthorName:  "John Doe",
+		PublicAuthorEmail: "john@example.com",
+		AuthorMap: []AuthorMapping{
+			{PrivateEmail: "Alice@Corp.example", PublicName: "Alice", PublicEmail: "alice@example.com"},
+			{PrivateName: "bob", PublicName: "Bob B", PublicEmail: "bob@example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	cases := []struct {
+		input, expected string
+	}{
+		{"author Alice Smith <alice@corp.example> 1700000000 +0100\n", "author Alice <alice@example.com> 1700000000 +0100\n"},
+		{"committer Bob <bob@home.example> 1700000000 +0000\n", "committer Bob B <bob@example.com> 1700000000 +0000\n"},
+		{"author obinnaokechukwu <obinnaokechukwu@private.invalid> 1700000000 +0000\n", "author John Doe <john@example.com> 1700000000 +0000\n"},
+	}
+	for _, tc := range cases {
+		kind := strings.Fields(tc.input)[0]
+		if out := rewriteIdentityLine(kind, tc.input, rules); out != tc.expected {
+			t.Errorf("rewriteIdentityLine(%q) = %q, want %q", tc.input, out, tc.expected)
+		}
+	}
+
+	_, err = Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		AuthorMap:       []AuthorMapping{{PrivateName: "x", PublicName: "obinnaokechukwu", PublicEmail: "x@example.com"}},
+	})
+	if err == nil {
+		t.Fatalf("expected error for public identity containing the private username")
+	}
+}
+
+func TestExportFilter_ExcludeLargerThanAndExtensions(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"assets/video.bin": strings.Repeat("x", 2048),
+		"art/Logo.PSD":     "layers\n",
+		"main.go":          "package main // obinnaokechukwu\n",
+	}, "Add assets")
+	commitFiles(t, repo, map[string]string{"assets/small.bin": "tiny\n"}, "Add small asset")
+
+	for _, tc := range []struct {
+		name  string
+		rules Rules
+	}{
+		{"streamed", Rules{}},
+		{"deferred", Rules{PathReplacements: []PathReplacements{{Patterns: []string{"*.md"}, Replacements: map[string]string{"a": "b"}}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.rules
+			r.PrivateUsername, r.Replacement = "obinnaokechukwu", "johndoe"
+			r.ExcludeLargerThan = 1024
+			r.ExcludeExtensions = []string{"psd", ".mp4"}
+			rules, err := Compile(r)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			bare := filepath.Join(t.TempDir(), "out.git")
+			runExportFilterImport(t, repo, bare, rules)
+
+			ls, err := gitx.Run(nil, bare, "ls-tree", "-r", "--name-only", "refs/heads/main")
+			if err != nil {
+				t.Fatalf("ls-tree: %v", err)
+			}
+			if got := strings.Join(nonEmpty(strings.Split(ls.Stdout, "\n")), ","); got != "assets/small.bin,main.go" {
+				t.Errorf("published files = %q, want the large blob and .psd left out", got)
+			}
+			// The payload of the excluded blob isn't imported either.
+			objects, err := gitx.Run(nil, bare, "cat-file", "--batch-all-objects", "--batch-check=%(objectsize)")
+			if err != nil {
+				t.Fatalf("cat-file: %v", err)
+			}
+			for _, size := range nonEmpty(strings.Split(objects.Stdout, "\n")) {
+				if size == "2048" {
+					t.Errorf("excluded blob was imported")
+				}
+			}
+		})
+	}
+}
+
+func nonEmpty(lines []string) []string {
+	var out []string
+	for _, l := range lines {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func TestExportFilter_PruneEmptyMerges(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"README.md": "readme\n"}, "Initial")
+	if _, err := gitx.Run(nil, repo, "checkout", "-b", "notes"); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	commitFiles(t, repo, map[string]string{"private/notes.txt": "todo\n"}, "Private notes")
+	if _, err := gitx.Run(nil, repo, "checkout", "main"); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	// Both parents of the merge resolve to "Initial" once "Private notes" is
+	// left out.
+	if _, err := gitx.Run(nil, repo, "merge", "--no-ff", "-m", "Merge notes", "notes"); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	for _, tc := range []struct {
+		prune bool
+		want  string
+	}{
+		{false, "Merge notes,Initial"},
+		{true, "Initial"},
+	} {
+		rules, err := Compile(Rules{
+			PrivateUsername:  "obinnaokechukwu",
+			Replacement:      "johndoe",
+			ExcludePatterns:  []string{"private/**"},
+			PruneEmptyMerges: tc.prune,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		bare := filepath.Join(t.TempDir(), "out.git")
+		runExportFilterImport(t, repo, bare, rules)
+
+		log, err := gitx.Run(nil, bare, "log", "--format=%s", "refs/heads/main")
+		if err != nil {
+			t.Fatalf("log: %v", err)
+		}
+		if got := strings.Join(nonEmpty(strings.Split(log.Stdout, "\n")), ","); got != tc.want {
+			t.Errorf("prune=%v: history = %q, want %q", tc.prune, got, tc.want)
+		}
+	}
+}
+
+func TestExportFilter_StripsOriginalOIDs(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+	commitFiles(t, repo, map[string]string{"b.txt": "b\n"}, "second")
+	if _, err := gitx.Run(nil, repo, "tag", "-a", "-m", "release", "v1"); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	stream, err := gitx.FastExportCmd(repo, "--all", "--show-original-ids").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+	if !bytes.Contains(stream, []byte("original-oid ")) {
+		t.Fatalf("fast-export did not show original ids")
+	}
+
+	for _, keep := range []bool{false, true} {
+		rules, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", KeepOriginalOIDs: keep})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		var out bytes.Buffer
+		if err := NewExportFilter(rules).Filter(bytes.NewReader(stream), &out); err != nil {
+			t.Fatalf("Filter: %v", err)
+		}
+		if got := strings.Count(out.String(), "original-oid "); keep != (got > 0) {
+			t.Errorf("keep=%v: %d original-oid lines in output", keep, got)
+		}
+	}
+}
+
+func TestExportFilter_SubmoduleDropDeletions(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	gitmodules := "[submodule \"obinnaokechukwu-lib\"]\n\tpath = obinnaokechukwu-lib\n\turl = https://github.com/obinnaokechukwu/lib.git\n"
+	commitFiles(t, repo, map[string]string{".gitmodules": gitmodules, "a.txt": "a\n"}, "add .gitmodules")
+	if _, err := gitx.Run(nil, repo, "update-index", "--add", "--cacheinfo", "160000,"+strings.Repeat("1", 40)+",obinnaokechukwu-lib"); err != nil {
+		t.Fatalf("update-index: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-m", "add submodule"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		SubmodulePolicy: SubmoduleDrop,
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	marks := filepath.Join(t.TempDir(), "marks")
+	out, err := gitx.FastExportCmd(repo, "--export-marks="+marks, "--all").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+	f := NewExportFilter(rules)
+	if err := f.Filter(bytes.NewReader(out), io.Discard); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+
+	// The deletion comes in a later, incremental export.
+	if _, err := gitx.Run(nil, repo, "rm", "-q", "--cached", "obinnaokechukwu-lib", ".gitmodules"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-m", "remove submodule"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	out, err = gitx.FastExportCmd(repo, "--import-marks="+marks, "--all").Output()
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+	next := NewExportFilter(rules)
+	next.Resume(f.State())
+	var buf bytes.Buffer
+	if err := next.Filter(bytes.NewReader(out), &buf); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if s := buf.String(); strings.Contains(s, "lib") || strings.Contains(s, ".gitmodules") {
+		t.Errorf("expected the dropped submodule's deletion left out, got:\n%s", s)
+	}
+}
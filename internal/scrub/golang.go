@@ -0,0 +1,143 @@
+package scrub
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// GoModuleRewrite publishes the Go module From (e.g.
+// "github.com/obinnaokechukwu/tool") as To (e.g. "github.com/johndoe/tool").
+// Module paths under From (nested modules, packages) move with it.
+type GoModuleRewrite struct {
+	From string
+	To   string
+}
+
+type compiledGoModule struct {
+	re *regexp.Regexp
+	to string
+}
+
+func compileGoModules(mods []GoModuleRewrite, usernames []compiledUsername) ([]compiledGoModule, error) {
+	var out []compiledGoModule
+	for _, m := range mods {
+		from, to := strings.TrimSpace(m.From), strings.TrimSpace(m.To)
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("go module rewrite needs both paths (%q -> %q)", m.From, m.To)
+		}
+		for _, u := range usernames {
+			if strings.Contains(strings.ToLower(to), strings.ToLower(u.name)) {
+				return nil, fmt.Errorf("public go module %q must not contain private username %q", to, u.name)
+			}
+		}
+		// A module path is delimited by quotes, whitespace, "@" (versions)
+		// or "/" (packages and nested modules).
+		re, err := regexp.Compile(`(?m)(^|[\s"'` + "`" + `(])` + regexp.QuoteMeta(from) + `([/"'` + "`" + `\s@)]|$)`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid go module path %q: %w", from, err)
+		}
+		out = append(out, compiledGoModule{re: re, to: to})
+	}
+	return out, nil
+}
+
+// isGoFile reports whether p is Go source or a module file (go.mod, go.sum,
+// go.work), whose module paths are rewritten by Rules.GoModules.
+func isGoFile(p string) bool {
+	switch path.Base(p) {
+	case "go.mod", "go.sum", "go.work", "go.work.sum":
+		return true
+	}
+	return path.Ext(p) == ".go"
+}
+
+// rewriteGoModules applies the module path rewrites to Go file content.
+func (c CompiledRules) rewriteGoModules(b []byte, p string) []byte {
+	if len(c.goModules) == 0 || !isGoFile(p) {
+		return b
+	}
+	for _, m := range c.goModules {
+		b = m.re.ReplaceAll(b, []byte("${1}"+m.to+"${2}"))
+	}
+	return b
+}
+
+// checkGoMod reports whether rewritten go.mod (or go.work) content still
+// parses: directives are well formed, blocks are balanced and a go.mod
+// declares exactly one module.
+func checkGoMod(content []byte, p string) error {
+	modules := 0
+	inBlock := ""
+	for i, line := range strings.Split(string(content), "\n") {
+		if j := strings.Index(line, "//"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		bad := func(why string) error {
+			return fmt.Errorf("%s:%d: %s after go module rewrite", p, i+1, why)
+		}
+		if inBlock != "" {
+			if fields[0] == ")" {
+				inBlock = ""
+				continue
+			}
+			if inBlock == "module" {
+				modules++
+			}
+			continue
+		}
+		if strings.HasSuffix(fields[len(fields)-1], "(") {
+			if len(fields) != 2 || fields[1] != "(" {
+				return bad("malformed block")
+			}
+			inBlock = fields[0]
+			continue
+		}
+		if fields[0] == ")" {
+			return bad("unbalanced )")
+		}
+		if fields[0] == "module" {
+			if len(fields) != 2 || strings.Trim(fields[1], `"`) == "" {
+				return bad("malformed module directive")
+			}
+			modules++
+		} else if len(fields) < 2 {
+			return bad(fmt.Sprintf("directive %q has no arguments", fields[0]))
+		}
+	}
+	if inBlock != "" {
+		return fmt.Errorf("%s: unterminated %s block after go module rewrite", p, inBlock)
+	}
+	if path.Base(p) == "go.mod" && modules != 1 {
+		return fmt.Errorf("%s: want one module directive after go module rewrite, found %d", p, modules)
+	}
+	return nil
+}
+
+// checkGoFile validates a rewritten module file at p.
+func (c CompiledRules) checkGoFile(content []byte, p string) error {
+	if len(c.goModules) == 0 {
+		return nil
+	}
+	switch path.Base(p) {
+	case "go.mod", "go.work":
+		return checkGoMod(content, p)
+	}
+	return nil
+}
+
+// GoModulePath returns the module path declared by go.mod content, or "".
+func GoModulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package scrub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_GoModules(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{
+		"go.mod":    "module github.com/obinnaokechukwu/secret-tool\n\ngo 1.22\n\nrequire github.com/obinnaokechukwu/secret-tool-lib v1.0.0\n",
+		"go.sum":    "github.com/obinnaokechukwu/secret-tool-lib v1.0.0 h1:abc=\n",
+		"main.go":   "package main\n\nimport \"github.com/obinnaokechukwu/secret-tool/internal/x\"\n",
+		"README.md": "See github.com/obinnaokechukwu/secret-tool\n",
+	}, "Initial")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		GoModules:       []GoModuleRewrite{{From: "github.com/obinnaokechukwu/secret-tool", To: "github.com/johndoe/tool"}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	for p, want := range map[string]string{
+		"go.mod":  "module github.com/johndoe/tool\n",
+		"main.go": "\"github.com/johndoe/tool/internal/x\"",
+		// A different module sharing the prefix is only renamed by the username rule.
+		"go.sum": "github.com/johndoe/secret-tool-lib v1.0.0",
+		// Non-Go files only get the plain username replacement.
+		"README.md": "github.com/johndoe/secret-tool\n",
+	} {
+		show, err := gitx.Run(nil, bare, "show", "refs/heads/main:"+p)
+		if err != nil {
+			t.Fatalf("show %s: %v", p, err)
+		}
+		if !strings.Contains(show.Stdout, want) {
+			t.Errorf("%s = %q, want it to contain %q", p, show.Stdout, want)
+		}
+	}
+}
+
+func TestCheckGoMod(t *testing.T) {
+	ok := "module example.com/a\n\ngo 1.22\n\nrequire (\n\texample.com/b v1.0.0 // indirect\n)\n"
+	if err := checkGoMod([]byte(ok), "go.mod"); err != nil {
+		t.Errorf("valid go.mod rejected: %v", err)
+	}
+	for _, bad := range []string{
+		"go 1.22\n",
+		"module example.com/a\nrequire (\n\texample.com/b v1.0.0\n",
+		"module\n",
+	} {
+		if err := checkGoMod([]byte(bad), "go.mod"); err == nil {
+			t.Errorf("invalid go.mod accepted: %q", bad)
+		}
+	}
+	if _, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", GoModules: []GoModuleRewrite{{From: "x", To: "github.com/obinnaokechukwu/x"}}}); err == nil {
+		t.Errorf("expected error for public module containing the private username")
+	}
+}
@@ -0,0 +1,99 @@
+package scrub
+
+import "sort"
+
+// FilterState is the part of an ExportFilter's state that a later run needs
+// to filter an incremental export, one made with fast-export --import-marks
+// that only contains commits the earlier runs didn't see.
+type FilterState struct {
+	// MarkMap holds the commit marks that were dropped or remapped to
+	// another commit; marks not listed are published unchanged.
+	MarkMap map[string]string `json:"mark_map,omitempty"`
+	// CommitMarks maps original commit ids to marks, for notes.
+	CommitMarks map[string]string `json:"commit_marks,omitempty"`
+	// PublicPaths maps public paths to the private paths they came from, to
+	// detect path mapping collisions with files of earlier runs.
+	PublicPaths map[string]string `json:"public_paths,omitempty"`
+	// Clock is the last assigned commit time in TimestampRespace mode.
+	Clock int64 `json:"clock,omitempty"`
+	// CarriedOps are the changes of skipped commits not yet published.
+	CarriedOps map[string][]string `json:"carried_ops,omitempty"`
+	// NextMark is the next free synthetic mark. Carried operations and
+	// remapped marks may name synthetic marks of earlier runs, so they are
+	// not reused.
+	NextMark int `json:"next_mark,omitempty"`
+	// InputMarks are the export marks remapped out of the synthetic range.
+	InputMarks map[string]string `json:"input_marks,omitempty"`
+	// DroppedGitlinks are the private paths of gitlinks left out by
+	// SubmoduleDrop, so that their later deletions are left out too.
+	DroppedGitlinks []string `json:"dropped_gitlinks,omitempty"`
+}
+
+// State returns the filter's state after Filter, to be passed to Resume in
+// the next incremental run.
+func (f *ExportFilter) State() FilterState {
+	s := FilterState{
+		MarkMap:     map[string]string{},
+		CommitMarks: f.commitMarks,
+		PublicPaths: f.publicPathOrigins,
+		CarriedOps:  f.carriedOps,
+		InputMarks:  f.inputMarks,
+	}
+	if f.nextSyntheticMark > syntheticMarkBase {
+		s.NextMark = f.nextSyntheticMark
+	}
+	for k, v := range f.markMap {
+		if k != v {
+			s.MarkMap[k] = v
+		}
+	}
+	if f.clockSet {
+		s.Clock = f.clock
+	}
+	for p := range f.droppedGitlinks {
+		s.DroppedGitlinks = append(s.DroppedGitlinks, p)
+	}
+	sort.Strings(s.DroppedGitlinks)
+	return s
+}
+
+// Resume restores state saved by an earlier run. It must be called before
+// Filter.
+func (f *ExportFilter) Resume(s FilterState) {
+	for k, v := range s.MarkMap {
+		f.markMap[k] = v
+	}
+	for k, v := range s.CommitMarks {
+		f.commitMarks[k] = v
+	}
+	for k, v := range s.PublicPaths {
+		f.publicPathOrigins[k] = v
+	}
+	for k, v := range s.CarriedOps {
+		f.carriedOps[k] = v
+	}
+	if s.Clock != 0 {
+		f.clock, f.clockSet = s.Clock, true
+	}
+	if s.NextMark > f.nextSyntheticMark {
+		f.nextSyntheticMark = s.NextMark
+	}
+	for k, v := range s.InputMarks {
+		f.inputMarks[k] = v
+	}
+	for _, p := range s.DroppedGitlinks {
+		f.droppedGitlinks[p] = true
+	}
+}
+
+// WrittenRefs returns the public refs written by Filter, sorted. An
+// incremental export names every ref, so refs missing from this list were
+// deleted (or stopped being published) since the last run.
+func (f *ExportFilter) WrittenRefs() []string {
+	refs := make([]string, 0, len(f.writtenRefs))
+	for r := range f.writtenRefs {
+		refs = append(refs, r)
+	}
+	sort.Strings(refs)
+	return refs
+}
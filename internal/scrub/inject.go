@@ -0,0 +1,63 @@
+package scrub
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+)
+
+// InjectedFile is a file that exists only in the public repo. It is added to
+// every root commit, and private changes to its path are dropped, so it is
+// unchanged throughout the published history. Its content is published as
+// is, without replacement rules.
+type InjectedFile struct {
+	Path    string
+	Content []byte
+}
+
+func compileInjectedFiles(files []InjectedFile) ([]InjectedFile, error) {
+	var out []InjectedFile
+	seen := map[string]bool{}
+	for _, inj := range files {
+		p := normPath(inj.Path)
+		if p == "" {
+			return nil, fmt.Errorf("injected file path is required")
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("duplicate injected file %q", p)
+		}
+		seen[p] = true
+		out = append(out, InjectedFile{Path: p, Content: inj.Content})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// Injected reports whether public path p holds an injected file.
+func (c CompiledRules) Injected(p string) bool {
+	if len(c.inject) == 0 {
+		return false
+	}
+	p = normPath(p)
+	for _, inj := range c.inject {
+		if inj.Path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// emitInjectedBlobs emits the blobs of the injected files alongside the
+// replace_history_with_current synthetic blobs, and prepares the file
+// operations that add them to root commits.
+func (f *ExportFilter) emitInjectedBlobs(bw *bufio.Writer) error {
+	for _, inj := range f.rules.inject {
+		mark := f.newMark()
+		_, _ = bw.WriteString("blob\nmark " + mark + "\n")
+		if err := writeBlobData(bw, inj.Content); err != nil {
+			return err
+		}
+		f.injectOps = append(f.injectOps, fmt.Sprintf("M 100644 %s %s\n", mark, quotePath(inj.Path)))
+	}
+	return nil
+}
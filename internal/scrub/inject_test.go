@@ -0,0 +1,62 @@
+package scrub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_InjectFiles(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"README.md": "readme\n"}, "Initial")
+	commitFiles(t, repo, map[string]string{"NOTICE.md": "private notice\n", "main.go": "package main\n"}, "Add notice")
+	if _, err := gitx.Run(nil, repo, "rm", "-q", "NOTICE.md"); err != nil {
+		t.Fatalf("git rm: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-q", "-m", "Remove notice"); err != nil {
+		t.Fatalf("git commit: %v", err)
+	}
+
+	notice := "This repository is a mirror.\n"
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		InjectFiles:     []InjectedFile{{Path: "NOTICE.md", Content: []byte(notice)}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	commits, err := gitx.RevList(bare, "refs/heads/main")
+	if err != nil {
+		t.Fatalf("rev-list: %v", err)
+	}
+	// "Remove notice" only touched the injected path, so it is left out.
+	if len(commits) != 2 {
+		t.Fatalf("published %d commits, want 2", len(commits))
+	}
+	for _, c := range commits {
+		show, err := gitx.Run(nil, bare, "show", c+":NOTICE.md")
+		if err != nil {
+			t.Fatalf("show NOTICE.md at %s: %v", c, err)
+		}
+		if show.Stdout != notice {
+			t.Errorf("NOTICE.md at %s = %q, want %q", c, show.Stdout, notice)
+		}
+	}
+	log, err := gitx.Run(nil, bare, "log", "--format=%s", "refs/heads/main", "--", "NOTICE.md")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if got := strings.TrimSpace(log.Stdout); got != "Initial" {
+		t.Errorf("commits touching NOTICE.md = %q, want only the root commit", got)
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", InjectFiles: []InjectedFile{{Path: "a"}, {Path: "./a"}}}); err == nil {
+		t.Errorf("expected error for duplicate injected files")
+	}
+}
@@ -0,0 +1,46 @@
+package scrub
+
+import (
+	"bytes"
+	"strings"
+)
+
+// LFS modes for Rules.LFSMode.
+const (
+	// LFSKeep publishes pointer files unchanged without uploading objects.
+	LFSKeep = "keep"
+	// LFSPush publishes pointer files and uploads the referenced objects to
+	// the target after the mirror push.
+	LFSPush = "push"
+	// LFSExclude drops paths whose content is an LFS pointer.
+	LFSExclude = "exclude"
+	// LFSError fails the export when an LFS pointer is encountered.
+	LFSError = "error"
+)
+
+const (
+	lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1\n"
+	// lfsPointerMaxSize is the largest size git-lfs itself accepts for a pointer.
+	lfsPointerMaxSize = 1024
+)
+
+// LFSPointerOID returns the sha256 object id if b is a Git LFS pointer file.
+func LFSPointerOID(b []byte) (string, bool) {
+	if len(b) > lfsPointerMaxSize || !bytes.HasPrefix(b, []byte(lfsPointerPrefix)) {
+		return "", false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if oid, ok := strings.CutPrefix(line, "oid sha256:"); ok && len(oid) == 64 {
+			return oid, true
+		}
+	}
+	return "", false
+}
+
+func validLFSMode(m string) bool {
+	switch m {
+	case "", LFSKeep, LFSPush, LFSExclude, LFSError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package scrub
+
+import (
+	"strconv"
+	"strings"
+)
+
+// syntheticMarkBase is the first mark allocated for objects the filter
+// creates itself (replacement, redacted, injected and per-path blobs). Marks
+// from this number on belong to the filter: export marks that reach it, in
+// a huge repository or after many incremental runs, are remapped to fresh
+// synthetic marks instead of redefining the filter's objects in fast-import.
+const syntheticMarkBase = 900000000
+
+// newMark allocates a synthetic mark.
+func (f *ExportFilter) newMark() string {
+	m := ":" + strconv.Itoa(f.nextSyntheticMark)
+	f.nextSyntheticMark++
+	return m
+}
+
+// inputMark translates a mark read from the export to the mark it has in the
+// output. Other references are returned unchanged.
+func (f *ExportFilter) inputMark(ref string) string {
+	if !strings.HasPrefix(ref, ":") {
+		return ref
+	}
+	if n, err := strconv.Atoi(ref[1:]); err != nil || n < syntheticMarkBase {
+		return ref
+	}
+	if m, ok := f.inputMarks[ref]; ok {
+		return m
+	}
+	m := f.newMark()
+	f.inputMarks[ref] = m
+	return m
+}
+
+// inputMarkLine translates the mark of a "mark", "from" or "merge" line.
+func (f *ExportFilter) inputMarkLine(line string) string {
+	kind, ref, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	if !ok || !strings.HasPrefix(ref, ":") {
+		return line
+	}
+	return kind + " " + f.inputMark(ref) + "\n"
+}
+
+// inputOp translates the marks of a filemodify or notemodify operation.
+func (f *ExportFilter) inputOp(op string) string {
+	if len(f.inputMarks) == 0 {
+		// No export mark has reached the synthetic range.
+		return op
+	}
+	switch {
+	case strings.HasPrefix(op, "M "):
+		// "M <mode> <dataref> <path>"
+		fields := strings.SplitN(op, " ", 4)
+		if len(fields) == 4 {
+			fields[2] = f.inputMark(fields[2])
+			return strings.Join(fields, " ")
+		}
+	case strings.HasPrefix(op, "N "):
+		// "N <dataref> <commit-ish>"
+		fields := strings.SplitN(strings.TrimSuffix(op, "\n"), " ", 3)
+		if len(fields) == 3 {
+			return "N " + f.inputMark(fields[1]) + " " + f.inputMark(fields[2]) + "\n"
+		}
+	}
+	return op
+}
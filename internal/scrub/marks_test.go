@@ -0,0 +1,86 @@
+package scrub
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_RemapsMarksInSyntheticRange(t *testing.T) {
+	// The export's marks run into the range the synthetic NOTICE blob uses.
+	stream := `blob
+mark :900000000
+data 24
+hello obinnaokechukwu 1
+
+commit refs/heads/main
+mark :900000001
+author A <a@example.com> 1700000000 +0000
+committer A <a@example.com> 1700000000 +0000
+data 4
+one
+M 100644 :900000000 a.txt
+
+commit refs/heads/main
+mark :900000002
+author A <a@example.com> 1700000100 +0000
+committer A <a@example.com> 1700000100 +0000
+data 4
+two
+from :900000001
+M 100644 inline b.txt
+data 2
+b
+
+`
+	notice := "This repository is a mirror.\n"
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		InjectFiles:     []InjectedFile{{Path: "NOTICE.md", Content: []byte(notice)}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	f := NewExportFilter(rules)
+	var out bytes.Buffer
+	if err := f.Filter(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	if _, err := gitx.Run(nil, "", "init", "--bare", bare); err != nil {
+		t.Fatalf("init bare: %v", err)
+	}
+	imp := gitx.FastImportCmd(bare)
+	imp.Stdin = &out
+	if b, err := imp.CombinedOutput(); err != nil {
+		t.Fatalf("fast-import: %v (%s)", err, b)
+	}
+	for path, want := range map[string]string{"a.txt": "hello johndoe 1\n", "b.txt": "b\n", "NOTICE.md": notice} {
+		show, err := gitx.Run(nil, bare, "show", "refs/heads/main:"+path)
+		if err != nil {
+			t.Fatalf("show %s: %v", path, err)
+		}
+		if show.Stdout != want {
+			t.Errorf("%s = %q, want %q", path, show.Stdout, want)
+		}
+	}
+	if commits, _ := gitx.RevList(bare, "refs/heads/main"); len(commits) != 2 {
+		t.Errorf("published %d commits, want 2", len(commits))
+	}
+
+	// A resumed run maps the earlier export marks the same way and doesn't
+	// reuse the synthetic marks already handed out.
+	state := f.State()
+	next := NewExportFilter(rules)
+	next.Resume(state)
+	if got, want := next.inputMark(":900000002"), f.inputMark(":900000002"); got != want {
+		t.Errorf("resumed mark = %s, want %s", got, want)
+	}
+	if m := next.newMark(); m == ":900000000" || f.inputMarks[":900000000"] == m {
+		t.Errorf("resumed filter reused synthetic mark %s", m)
+	}
+}
@@ -0,0 +1,267 @@
+package scrub
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MessageRules transform published commit messages after replacement rules
+// have been applied.
+type MessageRules struct {
+	// StripPatterns are regular expressions removed from the message, e.g.
+	// `^\[?[A-Z]+-[0-9]+\]?:?\s*` for Jira-style ticket prefixes.
+	StripPatterns []string
+	// MaxBodyLines truncates the body (everything after the subject line) to
+	// this many lines (0 = no limit).
+	MaxBodyLines int
+	// Template, if set, formats the message; {subject} and {body} are
+	// substituted, so "{subject}" publishes the subject line only.
+	Template string
+	// IssueRefs, if set, remaps or removes references to private issues and
+	// pull requests. They are rewritten before StripPatterns apply.
+	IssueRefs *IssueRefRules
+}
+
+// DefaultIssueRefPattern matches GitHub-style references such as #123.
+const DefaultIssueRefPattern = `\B#([0-9]+)\b`
+
+// IssueRefRules rewrite issue references in commit messages.
+type IssueRefRules struct {
+	// Pattern matches a reference, with the issue number as its first group
+	// (DefaultIssueRefPattern if empty).
+	Pattern string
+	// Map replaces the number of a matching reference: {"123": "45"} turns
+	// #123 into #45.
+	Map map[string]string
+	// Replacement is the expansion of references not in Map ($1 refers to
+	// a capture group); the empty default removes them and "$0" keeps them.
+	Replacement string
+}
+
+type compiledMessages struct {
+	strip        []*regexp.Regexp
+	maxBodyLines int
+	template     string
+	issueRefs    *compiledIssueRefs
+}
+
+type compiledIssueRefs struct {
+	re          *regexp.Regexp
+	mapping     map[string]string
+	replacement []byte
+}
+
+func compileMessages(m *MessageRules) (*compiledMessages, error) {
+	if m == nil {
+		return nil, nil
+	}
+	if m.MaxBodyLines < 0 {
+		return nil, fmt.Errorf("message max body lines must not be negative")
+	}
+	cm := &compiledMessages{maxBodyLines: m.MaxBodyLines, template: m.Template}
+	if ir := m.IssueRefs; ir != nil {
+		p := ir.Pattern
+		if p == "" {
+			p = DefaultIssueRefPattern
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid issue reference pattern %q: %w", p, err)
+		}
+		if len(ir.Map) > 0 && re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("issue reference pattern %q needs a group for the issue number", p)
+		}
+		cm.issueRefs = &compiledIssueRefs{re: re, mapping: ir.Map, replacement: []byte(ir.Replacement)}
+	}
+	for _, p := range m.StripPatterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message strip pattern %q: %w", p, err)
+		}
+		cm.strip = append(cm.strip, re)
+	}
+	if len(cm.strip) == 0 && cm.maxBodyLines == 0 && cm.template == "" && cm.issueRefs == nil {
+		return nil, nil
+	}
+	return cm, nil
+}
+
+// HasMessageTransforms reports whether commit messages are transformed.
+func (c CompiledRules) HasMessageTransforms() bool { return c.messages != nil || c.trailers != nil }
+
+// TransformMessage applies the configured trailer rules and message
+// transforms to an already rewritten commit message.
+func (c CompiledRules) TransformMessage(msg []byte) []byte {
+	if c.trailers != nil {
+		msg = []byte(c.applyTrailers(string(msg)))
+	}
+	m := c.messages
+	if m == nil {
+		return msg
+	}
+	if m.issueRefs != nil {
+		msg = m.issueRefs.rewrite(msg)
+	}
+	for _, re := range m.strip {
+		msg = re.ReplaceAll(msg, nil)
+	}
+	subject, body := splitMessage(string(msg))
+	if m.maxBodyLines > 0 {
+		if lines := strings.Split(body, "\n"); len(lines) > m.maxBodyLines {
+			body = strings.Join(lines[:m.maxBodyLines], "\n")
+		}
+	}
+
+	var out string
+	if m.template != "" {
+		out = strings.NewReplacer("{subject}", subject, "{body}", body).Replace(m.template)
+	} else if body != "" {
+		out = subject + "\n\n" + body
+	} else {
+		out = subject
+	}
+	out = strings.TrimRight(out, " \t\n")
+	if out == "" {
+		return nil
+	}
+	return []byte(out + "\n")
+}
+
+// rewrite remaps the issue number of mapped references and expands the
+// replacement for the others.
+func (ir *compiledIssueRefs) rewrite(msg []byte) []byte {
+	var out []byte
+	last := 0
+	for _, loc := range ir.re.FindAllSubmatchIndex(msg, -1) {
+		out = append(out, msg[last:loc[0]]...)
+		last = loc[1]
+		if len(loc) > 3 && loc[2] >= 0 {
+			if pub, ok := ir.mapping[string(msg[loc[2]:loc[3]])]; ok {
+				out = append(out, msg[loc[0]:loc[2]]...)
+				out = append(out, pub...)
+				out = append(out, msg[loc[3]:loc[1]]...)
+				continue
+			}
+		}
+		out = ir.re.Expand(out, ir.replacement, msg, loc)
+	}
+	return append(out, msg[last:]...)
+}
+
+// splitMessage splits a commit message into its subject line and body, with
+// surrounding blank lines trimmed.
+func splitMessage(msg string) (subject, body string) {
+	msg = strings.TrimLeft(msg, " \t\n")
+	subject, body, _ = strings.Cut(msg, "\n")
+	return strings.TrimSpace(subject), strings.Trim(body, " \t\n")
+}
+
+// transformMessage applies message transforms to a commit message held in
+// memory or spooled to a file.
+func (f *ExportFilter) transformMessage(message []byte, messageFile *spoolFile) ([]byte, error) {
+	if messageFile != nil {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(messageFile.reader()); err != nil {
+			return nil, err
+		}
+		message = buf.Bytes()
+	}
+	return f.rules.TransformMessage(message), nil
+}
+
+// Trailer modes for TrailerRules.Mode.
+const (
+	// TrailerDrop removes matching trailers from commit messages.
+	TrailerDrop = "drop"
+	// TrailerRewrite replaces the identity in matching trailers with its public
+	// identity (see Rules.AuthorMap).
+	TrailerRewrite = "rewrite"
+)
+
+// DefaultTrailerKeys are the trailers handled when TrailerRules.Keys is empty.
+// They commonly carry collaborators' private emails.
+var DefaultTrailerKeys = []string{"Co-authored-by", "Signed-off-by", "Reviewed-by", "Acked-by", "Tested-by", "Reported-by"}
+
+// TrailerRules drop or rewrite identity trailers in commit messages.
+type TrailerRules struct {
+	Mode string
+	// Keys are the trailer keys to handle, matched case-insensitively
+	// (DefaultTrailerKeys if empty).
+	Keys []string
+}
+
+type compiledTrailers struct {
+	mode string
+	keys map[string]bool
+}
+
+func compileTrailers(t *TrailerRules) (*compiledTrailers, error) {
+	if t == nil {
+		return nil, nil
+	}
+	switch t.Mode {
+	case TrailerDrop, TrailerRewrite:
+	default:
+		return nil, fmt.Errorf("invalid trailer mode %q (want drop or rewrite)", t.Mode)
+	}
+	keys := t.Keys
+	if len(keys) == 0 {
+		keys = DefaultTrailerKeys
+	}
+	ct := &compiledTrailers{mode: t.Mode, keys: map[string]bool{}}
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			ct.keys[strings.ToLower(k)] = true
+		}
+	}
+	return ct, nil
+}
+
+var trailerRe = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*):\s*(.*)$`)
+
+// applyTrailers drops or rewrites configured trailers in the last paragraph
+// of msg.
+func (c CompiledRules) applyTrailers(msg string) string {
+	t := c.trailers
+	trimmed := strings.TrimRight(msg, "\n")
+	start := strings.LastIndex(trimmed, "\n\n") + 2
+	if start < 2 {
+		// A message without a blank line has no trailer block.
+		return msg
+	}
+	lines := strings.Split(trimmed[start:], "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		m := trailerRe.FindStringSubmatch(l)
+		if m == nil || !t.keys[strings.ToLower(m[1])] {
+			kept = append(kept, l)
+			continue
+		}
+		if t.mode == TrailerDrop {
+			continue
+		}
+		kept = append(kept, m[1]+": "+c.publicTrailerIdentity(m[2]))
+	}
+	out := strings.TrimRight(trimmed[:start], "\n")
+	if len(kept) > 0 {
+		out += "\n\n" + strings.Join(kept, "\n")
+	}
+	return out + "\n"
+}
+
+// publicTrailerIdentity maps a "Name <email>" trailer value to the public identity.
+func (c CompiledRules) publicTrailerIdentity(v string) string {
+	var name, email string
+	if i, j := strings.LastIndex(v, "<"), strings.LastIndex(v, ">"); i >= 0 && j > i {
+		name, email = strings.TrimSpace(v[:i]), v[i+1:j]
+	} else {
+		name = strings.TrimSpace(v)
+	}
+	pubName, pubEmail := c.PublicIdentity(name, email)
+	return fmt.Sprintf("%s <%s>", pubName, pubEmail)
+}
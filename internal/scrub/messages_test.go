@@ -0,0 +1,97 @@
+package scrub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestTransformMessage(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules MessageRules
+		in    string
+		want  string
+	}{
+		{"strip", MessageRules{StripPatterns: []string{`^\[?[A-Z]+-[0-9]+\]?:?\s*`}}, "[PROJ-123] Fix login\n\nDetails\n", "Fix login\n\nDetails\n"},
+		{"truncate", MessageRules{MaxBodyLines: 1}, "Subject\n\nline one\nline two\n", "Subject\n\nline one\n"},
+		{"subject only", MessageRules{Template: "{subject}"}, "Subject\n\nSecret internal context\n", "Subject\n"},
+		{"template", MessageRules{Template: "{subject} (synced)\n\n{body}"}, "Subject\n", "Subject (synced)\n"},
+		{"issue refs", MessageRules{IssueRefs: &IssueRefRules{Map: map[string]string{"123": "7"}}}, "Fix #123 and #124\n\nSee a#1\n", "Fix #7 and\n\nSee a#1\n"},
+		{"issue refs kept", MessageRules{IssueRefs: &IssueRefRules{Pattern: `\s*\(?PROJ-([0-9]+)\)?`, Map: map[string]string{"5": "9"}, Replacement: "$0"}}, "Fix (PROJ-5) and PROJ-6\n", "Fix (PROJ-9) and PROJ-6\n"},
+		{"issue refs removed", MessageRules{IssueRefs: &IssueRefRules{Pattern: `\s*\(#[0-9]+\)`}}, "Fix login (#12)\n", "Fix login\n"},
+	}
+	for _, tc := range cases {
+		r, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", Messages: &tc.rules})
+		if err != nil {
+			t.Fatalf("%s: Compile: %v", tc.name, err)
+		}
+		if got := string(r.TransformMessage([]byte(tc.in))); got != tc.want {
+			t.Errorf("%s: TransformMessage = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", Messages: &MessageRules{StripPatterns: []string{"("}}}); err == nil {
+		t.Errorf("expected error for invalid strip pattern")
+	}
+}
+
+func TestExportFilter_MessageTransforms(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "ABC-42: work by obinnaokechukwu\n\nInternal notes here")
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		Messages:        &MessageRules{StripPatterns: []string{`^[A-Z]+-[0-9]+:\s*`}, Template: "{subject}"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	res, err := gitx.Run(nil, bare, "log", "-1", "--format=%B", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if got := strings.TrimSpace(res.Stdout); got != "work by johndoe" {
+		t.Errorf("message = %q, want %q", got, "work by johndoe")
+	}
+}
+
+func TestTransformMessage_Trailers(t *testing.T) {
+	msg := "Fix bug\n\nLonger description.\n\nCo-authored-by: Alice <alice@corp.example>\nSigned-off-by: Bob <bob@corp.example>\nChange-Id: I1234\n"
+	authors := []AuthorMapping{{PrivateEmail: "alice@corp.example", PublicName: "Alice", PublicEmail: "alice@example.com"}}
+
+	r, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", Trailers: &TrailerRules{Mode: TrailerDrop}})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got, want := string(r.TransformMessage([]byte(msg))), "Fix bug\n\nLonger description.\n\nChange-Id: I1234\n"; got != want {
+		t.Errorf("drop: got %q, want %q", got, want)
+	}
+
+	r, err = Compile(Rules{
+		PrivateUsername:   "obinnaokechukwu",
+		Replacement:       "johndoe",
+		PublicAuthorName:  "John Doe",
+		PublicAuthorEmail: "john@example.com",
+		AuthorMap:         authors,
+		Trailers:          &TrailerRules{Mode: TrailerRewrite},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	want := "Fix bug\n\nLonger description.\n\nCo-authored-by: Alice <alice@example.com>\nSigned-off-by: John Doe <john@example.com>\nChange-Id: I1234\n"
+	if got := string(r.TransformMessage([]byte(msg))); got != want {
+		t.Errorf("rewrite: got %q, want %q", got, want)
+	}
+
+	// Without a trailer block the message is untouched.
+	if got := string(r.TransformMessage([]byte("Signed-off-by: x\n"))); got != "Signed-off-by: x\n" {
+		t.Errorf("single paragraph changed: %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// zeroOID as a notemodify dataref removes the note.
+const zeroOID = "0000000000000000000000000000000000000000"
+
+// deferredRecord is a raw notes-ref record replayed after the rest of the
+// stream, once the commits its notes annotate have been assigned marks.
+type deferredRecord struct {
+	firstLine string
+	raw       []byte
+}
+
+func isNotesRef(ref string) bool { return strings.HasPrefix(ref, "refs/notes/") }
+
+// readRawCommit reads the remainder of a commit record without interpreting it.
+func readRawCommit(br *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(line)
+		if !strings.HasPrefix(line, "data ") {
+			continue
+		}
+		n, err := parseDataLen(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(&buf, br, int64(n)); err != nil {
+			return nil, err
+		}
+		for {
+			l2, err := br.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(l2)
+			if l2 == "\n" {
+				return buf.Bytes(), nil
+			}
+		}
+	}
+}
+
+// readRawReset reads the optional "from" line (or blank line) of a reset record.
+func readRawReset(br *bufio.Reader) ([]byte, error) {
+	peek1, err := br.Peek(1)
+	if err != nil {
+		return nil, nil // EOF
+	}
+	if peek1[0] == '\n' {
+		_, _ = br.ReadByte()
+		return []byte("\n"), nil
+	}
+	if peek5, err := br.Peek(5); err == nil && string(peek5) == "from " {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		return []byte(line), nil
+	}
+	return nil, nil
+}
+
+// deferNotesRecord captures a notes-ref commit or reset for replay at the end
+// of the stream, or discards it when notes are dropped.
+func (f *ExportFilter) deferNotesRecord(line string, br *bufio.Reader) error {
+	var raw []byte
+	var err error
+	if strings.HasPrefix(line, "commit ") {
+		raw, err = readRawCommit(br)
+	} else {
+		raw, err = readRawReset(br)
+	}
+	if err != nil {
+		return err
+	}
+	if !f.rules.DropNotes() {
+		f.deferredNotes = append(f.deferredNotes, deferredRecord{firstLine: line, raw: raw})
+	}
+	return nil
+}
+
+// replayNotes emits the deferred notes-ref records.
+func (f *ExportFilter) replayNotes(bw *bufio.Writer) error {
+	for _, rec := range f.deferredNotes {
+		br := bufio.NewReader(bytes.NewReader(rec.raw))
+		var err error
+		if strings.HasPrefix(rec.firstLine, "commit ") {
+			err = f.handleCommit(rec.firstLine, br, bw)
+		} else {
+			err = f.handleReset(rec.firstLine, br, bw)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	f.deferredNotes = nil
+	return nil
+}
+
+// filterNoteOps converts the file operations of a notes-ref commit, whose
+// paths name annotated commits by their private object id, into notemodify
+// commands addressed by mark. Notes on commits that were not published, or
+// whose object id is unknown (fast-export ran without --show-original-ids),
+// are dropped so private object ids never reach the target.
+func (f *ExportFilter) filterNoteOps(ops []string) ([]string, int, error) {
+	out := make([]string, 0, len(ops))
+	for _, op := range ops {
+		opTrim := strings.TrimRight(op, "\n")
+		switch {
+		case opTrim == "":
+			continue
+		case opTrim == "deleteall":
+			out = append(out, "deleteall\n")
+		case strings.HasPrefix(opTrim, "M "):
+			_, dataref, p, err := parseM(opTrim)
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed note operation %q: %w", opTrim, err)
+			}
+			target, ok := f.noteTarget(p)
+			if !ok {
+				continue
+			}
+			if f.rules.DeferBlobs() {
+				if dataref, err = f.pathBlobRef(dataref, ""); err != nil {
+					return nil, 0, err
+				}
+			}
+			out = append(out, "N "+dataref+" "+target+"\n")
+		case strings.HasPrefix(opTrim, "D "):
+			p, err := parseLastPath(strings.TrimPrefix(opTrim, "D "))
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed note operation %q: %w", opTrim, err)
+			}
+			if target, ok := f.noteTarget(p); ok {
+				out = append(out, "N "+zeroOID+" "+target+"\n")
+			}
+		case strings.HasPrefix(opTrim, "N "):
+			// notemodify: "N <dataref> <commit-ish>"
+			parts := strings.Fields(opTrim)
+			if len(parts) != 3 {
+				return nil, 0, fmt.Errorf("malformed note operation %q", opTrim)
+			}
+			target, ok := f.noteTargetRef(parts[2])
+			if !ok {
+				continue
+			}
+			dataref := parts[1]
+			if f.rules.DeferBlobs() && dataref != zeroOID {
+				var err error
+				if dataref, err = f.pathBlobRef(dataref, ""); err != nil {
+					return nil, 0, err
+				}
+			}
+			out = append(out, "N "+dataref+" "+target+"\n")
+		}
+	}
+	return out, len(out), nil
+}
+
+// noteTarget maps a notes tree path (a commit id, possibly split into fanout
+// directories) to the mark of the published commit it annotates.
+func (f *ExportFilter) noteTarget(p string) (string, bool) {
+	oid := strings.ReplaceAll(p, "/", "")
+	mark, ok := f.commitMarks[oid]
+	if !ok {
+		return "", false
+	}
+	return f.noteTargetRef(mark)
+}
+
+// noteTargetRef resolves a commit mark to itself if that commit was published.
+func (f *ExportFilter) noteTargetRef(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, ":") {
+		mark, ok := f.commitMarks[ref]
+		if !ok {
+			return "", false
+		}
+		ref = mark
+	}
+	if f.markMap[ref] != ref {
+		return "", false
+	}
+	return ref, true
+}
@@ -0,0 +1,125 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrivateFile is a file from the private tree to preview.
+type PrivateFile struct {
+	Path    string
+	Mode    string
+	Content []byte
+}
+
+// FilePreview pairs a private file with how it appears in the public tree.
+type FilePreview struct {
+	PrivateFile
+	// PublicPath is the path after path renames, or "" when the file is
+	// not published.
+	PublicPath    string
+	PublicMode    string
+	PublicContent []byte
+}
+
+// Preview runs files through the export filter as if each were added by its
+// own commit, and reports what the filter publishes for each one. It uses the
+// same code path as a sync, so path renames, exclusions, redaction and
+// content replacements match what the public repo would contain.
+func Preview(r CompiledRules, files []PrivateFile) ([]FilePreview, error) {
+	var in bytes.Buffer
+	n := len(files)
+	for i, pf := range files {
+		fmt.Fprintf(&in, "blob\nmark :%d\ndata %d\n", i+1, len(pf.Content))
+		in.Write(pf.Content)
+		in.WriteString("\n")
+	}
+	for i, pf := range files {
+		fmt.Fprintf(&in, "commit refs/heads/preview\nmark :%d\n", n+i+1)
+		in.WriteString("author preview <preview@invalid> 0 +0000\n")
+		in.WriteString("committer preview <preview@invalid> 0 +0000\n")
+		in.WriteString("data 0\n")
+		fmt.Fprintf(&in, "M %s :%d %s\n\n", pf.Mode, i+1, quotePath(pf.Path))
+	}
+
+	var out bytes.Buffer
+	f := NewExportFilter(r)
+	if err := f.Filter(&in, &out); err != nil {
+		return nil, err
+	}
+	// Every preview commit is a root commit, so each one also carries the
+	// injected files.
+	injected := map[string]bool{}
+	for _, op := range f.injectOps {
+		injected[strings.TrimSuffix(op, "\n")] = true
+	}
+	blobs, ops, err := parsePreviewStream(&out, injected)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([]FilePreview, n)
+	for i, pf := range files {
+		previews[i] = FilePreview{PrivateFile: pf}
+		op, ok := ops[fmt.Sprintf(":%d", n+i+1)]
+		if !ok {
+			continue
+		}
+		mode, dataref, p, err := parseM(op)
+		if err != nil {
+			return nil, fmt.Errorf("preview %s: %w", pf.Path, err)
+		}
+		previews[i].PublicPath = p
+		previews[i].PublicMode = mode
+		previews[i].PublicContent = blobs[dataref]
+	}
+	return previews, nil
+}
+
+// parsePreviewStream collects blob payloads by mark and the first file
+// modification of each commit, other than those in skip, by commit mark from
+// a filtered stream.
+func parsePreviewStream(r io.Reader, skip map[string]bool) (blobs map[string][]byte, ops map[string]string, err error) {
+	blobs = map[string][]byte{}
+	ops = map[string]string{}
+	br := bufio.NewReader(r)
+	var record, mark string
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return blobs, ops, nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "blob" || strings.HasPrefix(line, "commit ") || strings.HasPrefix(line, "tag ") || strings.HasPrefix(line, "reset "):
+			record, mark = line, ""
+		case strings.HasPrefix(line, "mark "):
+			mark = strings.TrimPrefix(line, "mark ")
+		case strings.HasPrefix(line, "data "):
+			size, derr := parseDataLen(line + "\n")
+			if derr != nil {
+				return nil, nil, derr
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return nil, nil, err
+			}
+			if record == "blob" {
+				blobs[mark] = data
+			}
+		case strings.HasPrefix(line, "M ") && strings.HasPrefix(record, "commit ") && !skip[line]:
+			if _, ok := ops[mark]; !ok {
+				ops[mark] = line
+			}
+		}
+		if err == io.EOF {
+			return blobs, ops, nil
+		}
+	}
+}
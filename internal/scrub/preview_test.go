@@ -0,0 +1,44 @@
+package scrub
+
+import "testing"
+
+func TestPreview(t *testing.T) {
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		ExcludePatterns: []string{"secrets/**"},
+		RedactPatterns:  []string{"notes.md"},
+		PathMappings:    []PathMapping{{From: "services/api", To: "api"}},
+		// Injected into every root commit, so into each preview commit.
+		InjectFiles: []InjectedFile{{Path: "LICENSE", Content: []byte("MIT\n")}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	files := []PrivateFile{
+		{Path: "services/api/main.go", Mode: "100644", Content: []byte("// by obinnaokechukwu\n")},
+		{Path: "secrets/key.txt", Mode: "100644", Content: []byte("hunter2\n")},
+		{Path: "notes.md", Mode: "100644", Content: []byte("private plans\n")},
+		{Path: "run me.sh", Mode: "100755", Content: []byte("echo obinnaokechukwu\n")},
+	}
+	got, err := Preview(rules, files)
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	want := []FilePreview{
+		{PublicPath: "api/main.go", PublicMode: "100644", PublicContent: []byte("// by johndoe\n")},
+		{},
+		{PublicPath: "notes.md", PublicMode: "100644", PublicContent: []byte(DefaultRedactPlaceholder)},
+		{PublicPath: "run me.sh", PublicMode: "100755", PublicContent: []byte("echo johndoe\n")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Preview returned %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Path != files[i].Path || g.PublicPath != w.PublicPath || g.PublicMode != w.PublicMode || string(g.PublicContent) != string(w.PublicContent) {
+			t.Errorf("%s: published as %q mode %q content %q, want %q mode %q content %q",
+				files[i].Path, g.PublicPath, g.PublicMode, g.PublicContent, w.PublicPath, w.PublicMode, w.PublicContent)
+		}
+	}
+}
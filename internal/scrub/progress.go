@@ -0,0 +1,81 @@
+package scrub
+
+import (
+	"fmt"
+	"io"
+)
+
+// Progress counts what a Filter run has processed so far.
+type Progress struct {
+	Commits int
+	Blobs   int
+	// BlobsRewritten are blobs whose content changed. Payloads rewritten in
+	// chunks (larger than the stream threshold) are not counted.
+	BlobsRewritten int
+	BytesIn        int64
+	BytesOut       int64
+	// Done is set on the last report, once the stream has been filtered.
+	Done bool
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d commits, %d blobs (%d rewritten), %s in, %s out",
+		p.Commits, p.Blobs, p.BlobsRewritten, FormatBytes(p.BytesIn), FormatBytes(p.BytesOut))
+}
+
+// FormatBytes formats n bytes with a binary unit, e.g. "1.5 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// OnProgress sets fn to be called after every commit and once more when
+// Filter finishes. fn runs on the goroutine calling Filter and should be
+// cheap; throttle it if reporting is expensive.
+func (f *ExportFilter) OnProgress(fn func(Progress)) { f.onProgress = fn }
+
+func (f *ExportFilter) reportProgress() {
+	if f.onProgress != nil {
+		f.onProgress(f.progress)
+	}
+}
+
+// noteBlobRewrite counts the blob if rewriting changed its content.
+func (f *ExportFilter) noteBlobRewrite(raw, out []byte) {
+	if len(raw) != len(out) || string(raw) != string(out) {
+		f.progress.BlobsRewritten++
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// Progress returns the counters so far; after Filter returns, the totals.
+func (f *ExportFilter) Progress() Progress { return f.progress }
@@ -0,0 +1,47 @@
+package scrub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportFilter_Progress(t *testing.T) {
+	rules, err := Compile(Rules{PrivateUsername: "alice", Replacement: "bob"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	stream := strings.Join([]string{
+		"blob", "mark :1", "data 6", "alice", "",
+		"blob", "mark :2", "data 6", "hello", "",
+		"commit refs/heads/main", "mark :3",
+		"author alice <alice@example.com> 1700000000 +0000",
+		"committer alice <alice@example.com> 1700000000 +0000",
+		"data 4", "one", "M 100644 :1 a.txt", "M 100644 :2 b.txt", "",
+		"commit refs/heads/main", "mark :4",
+		"author alice <alice@example.com> 1700000001 +0000",
+		"committer alice <alice@example.com> 1700000001 +0000",
+		"data 4", "two", "from :3", "D b.txt", "", "",
+	}, "\n")
+
+	var reports []Progress
+	f := NewExportFilter(rules)
+	f.OnProgress(func(p Progress) { reports = append(reports, p) })
+	var out bytes.Buffer
+	if err := f.Filter(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want one per commit plus the final one", len(reports))
+	}
+	last := reports[2]
+	if !last.Done || last.Commits != 2 || last.Blobs != 2 || last.BlobsRewritten != 1 {
+		t.Errorf("final report = %+v, want 2 commits, 2 blobs, 1 rewritten", last)
+	}
+	if last.BytesIn != int64(len(stream)) || last.BytesOut != int64(out.Len()) {
+		t.Errorf("bytes = %d in, %d out; want %d, %d", last.BytesIn, last.BytesOut, len(stream), out.Len())
+	}
+	if got := last.String(); !strings.HasPrefix(got, "2 commits, 2 blobs (1 rewritten), ") {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package scrub
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// DefaultRedactPlaceholder is the content published for redacted files.
+const DefaultRedactPlaceholder = "This file has been removed for privacy.\n"
+
+// ShouldRedact reports whether private path p matches a redact pattern. Its
+// content is published as the placeholder, under the file's own mode.
+func (c CompiledRules) ShouldRedact(p string) bool {
+	p = normPath(p)
+	for _, pat := range c.redact {
+		if matchGlob(pat, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// emitRedactBlob emits the placeholder blob shared by all redacted files,
+// alongside the replace_history_with_current synthetic blobs.
+func (f *ExportFilter) emitRedactBlob(bw *bufio.Writer) error {
+	if len(f.rules.redact) == 0 {
+		return nil
+	}
+	f.redactMark = f.newMark()
+	content := f.rules.redactPlaceholder
+	_, _ = bw.WriteString("blob\nmark " + f.redactMark + "\n")
+	_, _ = bw.WriteString(fmt.Sprintf("data %d\n", len(content)))
+	if _, err := bw.Write(content); err != nil {
+		return err
+	}
+	_, _ = bw.WriteString("\n")
+	return nil
+}
@@ -0,0 +1,54 @@
+package scrub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_Redact(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"internal/notes.md": "private plans\n", "main.go": "package main\n"}, "Initial files")
+	commitFiles(t, repo, map[string]string{"internal/notes.md": "more private plans\n"}, "Update notes")
+	commitFiles(t, repo, map[string]string{"internal/deploy.sh": "#!/bin/sh\n"}, "Add deploy script")
+	if _, err := gitx.Run(nil, repo, "update-index", "--chmod=+x", "internal/deploy.sh"); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if _, err := gitx.Run(nil, repo, "commit", "-m", "Make deploy script executable"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		RedactPatterns:  []string{"internal/**"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	for _, rev := range []string{"refs/heads/main", "refs/heads/main~3"} {
+		show, err := gitx.Run(nil, bare, "show", rev+":internal/notes.md")
+		if err != nil {
+			t.Fatalf("show %s: %v", rev, err)
+		}
+		if show.Stdout != DefaultRedactPlaceholder {
+			t.Errorf("%s:internal/notes.md = %q, want placeholder", rev, show.Stdout)
+		}
+	}
+	// Redacted files keep their mode.
+	ls, err := gitx.Run(nil, bare, "ls-tree", "refs/heads/main", "internal/deploy.sh")
+	if err != nil {
+		t.Fatalf("ls-tree: %v", err)
+	}
+	if !strings.HasPrefix(ls.Stdout, "100755 ") {
+		t.Errorf("internal/deploy.sh entry = %q, want mode 100755", ls.Stdout)
+	}
+	if show, err := gitx.Run(nil, bare, "show", "refs/heads/main:main.go"); err != nil || show.Stdout != "package main\n" {
+		t.Errorf("main.go = %q, %v", show.Stdout, err)
+	}
+}
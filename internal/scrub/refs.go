@@ -0,0 +1,205 @@
+package scrub
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unpublishedRefPrefix names the scratch refs that commits on unpublished
+// refs are imported under. They are deleted at the end of the stream.
+const unpublishedRefPrefix = "refs/git-copy/unpublished/"
+
+// nullOid deletes a ref when used as the "from" of a fast-import reset.
+const nullOid = "0000000000000000000000000000000000000000"
+
+// BranchRename publishes a private branch under another name. From and To
+// are branch names without refs/heads/; "prefix/*" on both sides renames
+// every branch under the prefix (e.g. "obinna/*" to "dev/*").
+type BranchRename struct {
+	From string
+	To   string
+}
+
+type compiledRename struct {
+	from, to string
+	prefix   bool
+}
+
+// compileBranchRenames validates renames and orders them exact names first,
+// then by longest prefix.
+func compileBranchRenames(renames []BranchRename) ([]compiledRename, error) {
+	out := make([]compiledRename, 0, len(renames))
+	for _, r := range renames {
+		from, to := strings.TrimSpace(r.From), strings.TrimSpace(r.To)
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("branch rename needs both names (%q -> %q)", r.From, r.To)
+		}
+		fromPrefix, toPrefix := strings.HasSuffix(from, "/*"), strings.HasSuffix(to, "/*")
+		if fromPrefix != toPrefix {
+			return nil, fmt.Errorf("branch rename %q -> %q: both names or neither must end in /*", from, to)
+		}
+		if fromPrefix {
+			from, to = strings.TrimSuffix(from, "*"), strings.TrimSuffix(to, "*")
+		}
+		out = append(out, compiledRename{from: from, to: to, prefix: fromPrefix})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].prefix != out[j].prefix {
+			return !out[i].prefix
+		}
+		return len(out[i].from) > len(out[j].from)
+	})
+	return out, nil
+}
+
+// renameBranch applies the branch renames to a full private ref name.
+func (c CompiledRules) renameBranch(ref string) string {
+	name, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		return ref
+	}
+	for _, r := range c.branchRenames {
+		if !r.prefix && name == r.from {
+			return "refs/heads/" + r.to
+		}
+		if rest, ok := strings.CutPrefix(name, r.from); r.prefix && ok {
+			return "refs/heads/" + r.to + rest
+		}
+	}
+	return ref
+}
+
+// RefNamespace publishes the refs under From under To instead. Both are full
+// ref prefixes ending in "/*", e.g. "refs/heads/*" to "refs/heads/mirror/*".
+// Annotated tags can only be moved within refs/tags/.
+type RefNamespace struct {
+	From string
+	To   string
+}
+
+type compiledNamespace struct {
+	from, to string
+}
+
+// compileRefNamespaces validates namespaces and orders them by longest
+// prefix.
+func compileRefNamespaces(namespaces []RefNamespace) ([]compiledNamespace, error) {
+	out := make([]compiledNamespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		from, to := strings.TrimSpace(ns.From), strings.TrimSpace(ns.To)
+		for _, p := range []string{from, to} {
+			if !strings.HasPrefix(p, "refs/") || !strings.HasSuffix(p, "/*") {
+				return nil, fmt.Errorf("ref namespace %q -> %q: both must be ref prefixes such as refs/heads/*", ns.From, ns.To)
+			}
+		}
+		from, to = strings.TrimSuffix(from, "*"), strings.TrimSuffix(to, "*")
+		if strings.HasPrefix(from, "refs/tags/") != strings.HasPrefix(to, "refs/tags/") {
+			return nil, fmt.Errorf("ref namespace %q -> %q: tags can only be moved within refs/tags/", ns.From, ns.To)
+		}
+		out = append(out, compiledNamespace{from: from, to: to})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].from) > len(out[j].from) })
+	return out, nil
+}
+
+// PublicRef returns the name a full private ref is published under, after
+// branch renames, replacements and ref namespaces.
+func (c CompiledRules) PublicRef(ref string) string {
+	return c.namespaceRef(c.RewriteString(c.renameBranch(ref)))
+}
+
+// namespaceRef moves a scrubbed full ref name into its namespace.
+func (c CompiledRules) namespaceRef(ref string) string {
+	for _, ns := range c.refNamespaces {
+		if rest, ok := strings.CutPrefix(ref, ns.from); ok {
+			return ns.to + rest
+		}
+	}
+	return ref
+}
+
+func compileRefPatterns(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// HasRefFilters reports whether RefInclude or RefExclude patterns are configured.
+func (c CompiledRules) HasRefFilters() bool {
+	return len(c.refInclude) > 0 || len(c.refExclude) > 0
+}
+
+// PublishRef reports whether a full private ref name (e.g. "refs/tags/v1.0")
+// is published: it matches RefInclude (if set) and no RefExclude pattern,
+// and tags are kept by the tag policy.
+func (c CompiledRules) PublishRef(ref string) bool {
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok && !c.publishTag(name) {
+		return false
+	}
+	for _, pat := range c.refExclude {
+		if matchGlob(pat, ref) {
+			return false
+		}
+	}
+	if len(c.refInclude) == 0 {
+		return true
+	}
+	for _, pat := range c.refInclude {
+		if matchGlob(pat, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishedRefs returns the refs in refs that are published, sorted.
+func (c CompiledRules) PublishedRefs(refs []string) []string {
+	var out []string
+	for _, r := range refs {
+		if c.PublishRef(r) {
+			out = append(out, r)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// importRef returns the ref a commit or reset record is imported under: the
+// scrubbed ref, or for an unpublished ref (including lightweight tags when
+// only annotated tags are published) a scratch ref. Commits on unpublished
+// refs may still be ancestors of published ones, so they are imported
+// rather than dropped.
+func (f *ExportFilter) importRef(origRef string) (string, error) {
+	if f.rules.PublishRef(origRef) && !f.rules.lightweightTagRef(origRef) {
+		newRef := f.rewriteRef(origRef)
+		if err := f.checkRefCollision(origRef, newRef); err != nil {
+			return "", err
+		}
+		f.writtenRefs[newRef] = true
+		return newRef, nil
+	}
+	scratch, ok := f.unpublishedRefs[origRef]
+	if !ok {
+		scratch = fmt.Sprintf("%s%d", unpublishedRefPrefix, len(f.unpublishedRefs))
+		f.unpublishedRefs[origRef] = scratch
+	}
+	return scratch, nil
+}
+
+// deleteUnpublishedRefs removes the scratch refs created by importRef.
+func (f *ExportFilter) deleteUnpublishedRefs(bw *bufio.Writer) {
+	scratch := make([]string, 0, len(f.unpublishedRefs))
+	for _, r := range f.unpublishedRefs {
+		scratch = append(scratch, r)
+	}
+	sort.Strings(scratch)
+	for _, r := range scratch {
+		_, _ = bw.WriteString("reset " + r + "\nfrom " + nullOid + "\n\n")
+	}
+}
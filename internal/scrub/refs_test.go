@@ -0,0 +1,186 @@
+package scrub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportFilter_RefFilters(t *testing.T) {
+	// The excluded wip branch holds main's parent commit, as in an --all export.
+	stream := `blob
+mark :1
+data 2
+a
+
+commit refs/heads/wip
+mark :2
+author A <a@example.com> 1700000000 +0000
+committer A <a@example.com> 1700000000 +0000
+data 4
+wip
+M 100644 :1 a.txt
+
+commit refs/heads/main
+mark :3
+author A <a@example.com> 1700000100 +0000
+committer A <a@example.com> 1700000100 +0000
+data 5
+main
+from :2
+M 100644 :1 b.txt
+
+reset refs/heads/wip
+from :2
+
+tag v1
+from :3
+tagger A <a@example.com> 1700000200 +0000
+data 2
+v1
+tag rc1
+from :3
+tagger A <a@example.com> 1700000200 +0000
+data 3
+rc1
+`
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		RefInclude:      []string{"refs/heads/main", "refs/tags/v*"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewExportFilter(rules).Filter(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"commit refs/git-copy/unpublished/0\n",
+		"commit refs/heads/main\n",
+		"tag v1\n",
+		"reset refs/git-copy/unpublished/0\nfrom " + nullOid + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	for _, bad := range []string{"refs/heads/wip", "rc1"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("output contains unpublished %q:\n%s", bad, got)
+		}
+	}
+
+	rules, _ = Compile(Rules{PrivateUsername: "a", Replacement: "b", RefExclude: []string{"refs/heads/wip/**"}})
+	if !rules.PublishRef("refs/heads/main") || rules.PublishRef("refs/heads/wip/x") {
+		t.Errorf("RefExclude not applied")
+	}
+}
+
+func TestExportFilter_BranchRenames(t *testing.T) {
+	stream := `commit refs/heads/main
+mark :1
+author A <a@example.com> 1700000000 +0000
+committer A <a@example.com> 1700000000 +0000
+data 4
+one
+M 100644 inline a.txt
+data 2
+a
+
+reset refs/heads/obinnaokechukwu/topic
+from :1
+
+reset refs/heads/obinnaokechukwu/fixes/x
+from :1
+
+`
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		BranchRenames: []BranchRename{
+			{From: "main", To: "master"},
+			{From: "obinnaokechukwu/*", To: "dev/*"},
+			{From: "obinnaokechukwu/fixes/*", To: "fix/*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewExportFilter(rules).Filter(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	for _, want := range []string{"commit refs/heads/master\n", "reset refs/heads/dev/topic\n", "reset refs/heads/fix/x\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// Renaming onto an existing branch is a collision.
+	rules, _ = Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", BranchRenames: []BranchRename{{From: "obinnaokechukwu/topic", To: "main"}}})
+	stream = strings.Replace(stream, "obinnaokechukwu/fixes/x", "main", 1)
+	if err := NewExportFilter(rules).Filter(strings.NewReader(stream), &out); err == nil || !strings.Contains(err.Error(), "collision") {
+		t.Errorf("expected ref collision error, got %v", err)
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", BranchRenames: []BranchRename{{From: "x/*", To: "y"}}}); err == nil {
+		t.Errorf("expected error for mismatched prefix rename")
+	}
+}
+
+func TestExportFilter_RefNamespaces(t *testing.T) {
+	stream := `commit refs/heads/main
+mark :1
+author A <a@example.com> 1700000000 +0000
+committer A <a@example.com> 1700000000 +0000
+data 4
+one
+M 100644 inline a.txt
+data 2
+a
+
+reset refs/heads/obinnaokechukwu/topic
+from :1
+
+tag v1
+from :1
+tagger A <a@example.com> 1700000100 +0000
+data 3
+v1
+
+`
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		BranchRenames:   []BranchRename{{From: "main", To: "master"}},
+		RefNamespaces: []RefNamespace{
+			{From: "refs/heads/*", To: "refs/heads/mirror/*"},
+			{From: "refs/tags/*", To: "refs/tags/mirror/*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewExportFilter(rules).Filter(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	for _, want := range []string{"commit refs/heads/mirror/master\n", "reset refs/heads/mirror/johndoe/topic\n", "tag mirror/v1\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	for _, ns := range []RefNamespace{
+		{From: "refs/heads/*", To: "mirror/*"},
+		{From: "refs/heads", To: "refs/heads/mirror"},
+		{From: "refs/tags/*", To: "refs/heads/tags/*"},
+	} {
+		if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", RefNamespaces: []RefNamespace{ns}}); err == nil {
+			t.Errorf("expected error for namespace %+v", ns)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package scrub
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -42,44 +44,313 @@ func isNonNegotiablePattern(p string) bool {
 	return false
 }
 
+// RegexReplacementPrefix marks an ExtraReplacements key as a full regular
+// expression instead of a literal substring. The replacement value may use
+// $1/${name} capture-group references.
+const RegexReplacementPrefix = "regex:"
+
 type Rules struct {
-	PrivateUsername   string
-	Replacement       string
+	PrivateUsername string
+	Replacement     string
+	// AdditionalUsernames are further private usernames (e.g. a second
+	// personal account), each rewritten to its own replacement. An empty
+	// Replacement falls back to Replacement.
+	AdditionalUsernames []UsernameReplacement
+	// MatchWholeWord restricts private username matches to standalone words,
+	// so identifiers that merely contain the username are left untouched.
+	MatchWholeWord bool
+	// ExtraReplacements maps literal, case-insensitive substrings to their
+	// replacement. Keys prefixed with RegexReplacementPrefix are compiled as
+	// regular expressions and replaced with capture-group expansion.
 	ExtraReplacements map[string]string
+	// PathReplacements apply additional replacements only to the contents of
+	// files whose (rewritten) path matches one of the rule's globs.
+	PathReplacements []PathReplacements
+
+	// PathMappings rewrite path prefixes (e.g. "services/api" -> "" publishes
+	// that directory as the repository root). The longest matching prefix wins.
+	PathMappings []PathMapping
+
+	// Subtree, if set, publishes only this directory, as the repository root.
+	// Paths outside it are dropped and commits that no longer touch anything
+	// are pruned (like `git filter-repo --subdirectory-filter`).
+	Subtree string
+
+	// SkipBinaryBlobs passes binary blobs (NUL bytes in the first 8000 bytes,
+	// or a binary file extension) through without content rewriting.
+	SkipBinaryBlobs bool
+	// BinaryExtensions are additional extensions (like ".psd") treated as
+	// binary, on top of DefaultBinaryExtensions.
+	BinaryExtensions []string
+	// MaxRewriteBlobBytes streams blobs larger than this many bytes through
+	// the filter unchanged instead of buffering and rewriting them (0 = no limit),
+	// ReplaceHistoryContent included. Content filters still apply to them,
+	// through temp files.
+	MaxRewriteBlobBytes int64
+	// ExcludeLargerThan drops files whose blob is larger than this many bytes
+	// (0 = no limit); ExcludeExtensions drops files by extension (like
+	// ".psd", case-insensitive). Neither can be overridden by "!" patterns.
+	ExcludeLargerThan int64
+	ExcludeExtensions []string
+	// LFSMode controls Git LFS pointer files: LFSKeep (default), LFSPush,
+	// LFSExclude or LFSError. Pointers are never rewritten.
+	LFSMode string
+	// SubmodulePolicy controls gitlinks (submodule entries) and .gitmodules:
+	// SubmoduleRewrite (default), SubmoduleKeep or SubmoduleDrop.
+	SubmodulePolicy string
+	// DropNotes removes refs/notes/* from the published history. Otherwise
+	// note contents are rewritten and re-attached to the published commits.
+	DropNotes bool
+	// RedactSecrets replaces matches of BuiltinSecretDetectors (AWS keys,
+	// GitHub and Slack tokens, private key blocks) with SecretRedaction in
+	// file contents and messages.
+	RedactSecrets bool
+	// AuthorMap gives specific private identities their own public identity.
+	// Unmapped authors fall back to PublicAuthorName/PublicAuthorEmail.
+	AuthorMap []AuthorMapping
+	// Messages, if set, strips patterns from, truncates or templates commit
+	// messages after replacement.
+	Messages *MessageRules
+	// SkipCommitPatterns leave out commits whose message matches one of these
+	// regexes (e.g. `\[private\]`). Their file changes are folded into the
+	// next published commit on top of them, so published trees are unchanged.
+	SkipCommitPatterns []string
+	// PruneEmptyMerges drops merge parents that resolve to the first parent
+	// (or to an earlier merge parent) once skipped commits are remapped. A
+	// merge left with a single parent is published as an ordinary commit, or
+	// left out like any other commit if filtering removed all its changes.
+	PruneEmptyMerges bool
+	// KeepOriginalOIDs passes the original-oid lines of fast-export's
+	// --show-original-ids through to the output. They are stripped by
+	// default so that no private object id reaches the published repo.
+	KeepOriginalOIDs bool
+	// Trailers, if set, drops or rewrites identity trailers such as
+	// Co-authored-by in commit messages.
+	Trailers *TrailerRules
+	// SquashBase, if set, is the private commit id whose tree becomes a
+	// single baseline root commit; its history is not published and later
+	// commits are replayed on top. The export must use SquashExportArgs.
+	SquashBase string
+	// SquashHide are further private commits left out of a squashed export;
+	// commits built on them are attached to the baseline commit.
+	SquashHide []string
+	// GraftParent, if set, is a commit id of the import repo given as the
+	// parent of every root commit of the published history, so it continues
+	// history the target already had.
+	GraftParent string
+	// RefInclude, if non-empty, publishes only refs matching at least one
+	// glob (full ref names, e.g. "refs/heads/main" or "refs/tags/v*").
+	// RefExclude drops matching refs. The export should list the published
+	// refs (PublishedRefs) instead of --all; the filter enforces both either way.
+	RefInclude []string
+	RefExclude []string
+	// GoModules rewrite Go module paths in go.mod, go.sum, go.work and .go
+	// files (before replacement rules), e.g. when the public repository name
+	// differs from the private one. Rewritten go.mod files must still parse.
+	GoModules []GoModuleRewrite
+	// BranchRenames publish private branches under other names; they are
+	// applied before replacement rules.
+	BranchRenames []BranchRename
+	// RefNamespaces move published refs under other prefixes on the target,
+	// after branch renames and replacement rules.
+	RefNamespaces []RefNamespace
+	// Tags, if set, drops tags, keeps only annotated or matching tags, or
+	// strips tag messages.
+	Tags *TagRules
+	// Symlinks, if set, rewrites, drops or fails on symlinks whose target
+	// matches a pattern (by default, absolute targets).
+	Symlinks *SymlinkRules
+	// Timestamps, if set, rounds, shifts or re-spaces author, committer and
+	// tagger timestamps.
+	Timestamps *TimestampRules
+	// Entropy, if set, fails the export when published file content contains
+	// a likely credential detected by Shannon entropy.
+	Entropy *EntropyRules
+	// ContentFilters run external commands over the content of matching
+	// files (e.g. nbstripout for notebooks) before replacement rules.
+	ContentFilters []ContentFilter
+	// InjectFiles are published in every root commit and never change, e.g.
+	// a MIRROR_NOTICE.md that the private repo doesn't have.
+	InjectFiles []InjectedFile
 
+	// ExcludePatterns are evaluated in order and the last matching pattern
+	// wins; a pattern prefixed with "!" re-includes what earlier patterns
+	// excluded, as in .gitignore. Non-negotiable paths stay excluded.
 	ExcludePatterns []string
 	OptInPaths      []string
+	// GitAttributes are .gitattributes files (path -> content) whose
+	// export-ignore entries are excluded like ExcludePatterns (evaluated
+	// before them, so "!" patterns can re-include such paths).
+	GitAttributes map[string][]byte
+	// RedactPatterns keep matching files in the published history but
+	// replace their content with RedactPlaceholder (DefaultRedactPlaceholder
+	// if empty). Exclusion takes precedence.
+	RedactPatterns    []string
+	RedactPlaceholder string
+	// IncludePatterns, if non-empty, restricts the export to paths matching at
+	// least one glob (e.g. one monorepo component per target).
+	IncludePatterns []string
 
 	// ReplaceHistoryWithCurrent specifies files whose current (HEAD) content
 	// should be used in ALL historical commits, making it appear as if the file
-	// was always that way from the start.
+	// was always that way from the start. Entries may be globs such as
+	// "docs/legal/**".
 	ReplaceHistoryWithCurrent []string
 	// ReplaceHistoryContent maps normalized file paths to their HEAD content.
-	// This must be populated by the caller before filtering.
+	// This must be populated by the caller before filtering, for every HEAD
+	// file matching ReplaceHistoryWithCurrent (see ExpandReplaceHistory).
 	ReplaceHistoryContent map[string][]byte
 
 	PublicAuthorName  string
 	PublicAuthorEmail string
 }
 
+// UsernameReplacement pairs an additional private username with the string
+// that replaces it.
+type UsernameReplacement struct {
+	Username    string
+	Replacement string
+}
+
+// Submodule policies for Rules.SubmodulePolicy.
+const (
+	// SubmoduleRewrite keeps gitlinks and rewrites .gitmodules (including
+	// submodule URLs) through the replacement rules like any other file.
+	SubmoduleRewrite = "rewrite"
+	// SubmoduleKeep keeps gitlinks and publishes .gitmodules verbatim.
+	SubmoduleKeep = "keep"
+	// SubmoduleDrop removes gitlinks and .gitmodules from the published history.
+	SubmoduleDrop = "drop"
+)
+
+// DefaultBinaryExtensions are file extensions treated as binary when
+// Rules.SkipBinaryBlobs is enabled.
+var DefaultBinaryExtensions = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".bmp", ".ico", ".webp", ".psd",
+	".pdf", ".zip", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".tar", ".jar",
+	".exe", ".dll", ".so", ".dylib", ".a", ".o", ".class", ".wasm",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp3", ".mp4", ".mov", ".avi", ".wav", ".flac", ".ogg", ".webm",
+}
+
+// binarySniffLen is how many leading bytes are checked for NUL, like git does.
+const binarySniffLen = 8000
+
+// PathReplacements scopes a set of replacements (same syntax as
+// Rules.ExtraReplacements) to files matching any of Patterns.
+type PathReplacements struct {
+	Patterns     []string
+	Replacements map[string]string
+}
+
+// AuthorMapping maps a private identity to a public one. An identity matches
+// on PrivateEmail when set, otherwise on PrivateName (both case-insensitive).
+type AuthorMapping struct {
+	PrivateName, PrivateEmail string
+	PublicName, PublicEmail   string
+}
+
+// PathMapping rewrites paths under From to live under To instead.
+// An empty To maps the directory to the repository root.
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// replacementSet is a compiled set of extra replacements.
+type replacementSet struct {
+	pairs [][2]string
+	res   []*regexp.Regexp // case-insensitive patterns for literal replacements
+	// isRegex marks replacements declared with RegexReplacementPrefix;
+	// these expand capture groups instead of applying the case pattern.
+	isRegex []bool
+	// literals matches the ASCII literal replacements in a single pass;
+	// literalIdx maps its pattern indexes back to pairs.
+	literals   *acMatcher
+	literalIdx []int
+}
+
+type compiledPathRule struct {
+	patterns []string
+	set      replacementSet
+}
+
+type compiledUsername struct {
+	name string
+	re   *regexp.Regexp // case-insensitive pattern for the username
+	repl string
+}
+
 type CompiledRules struct {
-	private   string
-	privateRe *regexp.Regexp // case-insensitive pattern for private username
-	repl      string
-	extra     [][2]string
-	extraRe   []*regexp.Regexp // case-insensitive patterns for extra replacements
+	private string
+	repl    string
+	// usernames holds the primary and additional private usernames, longest
+	// first so a username containing another is rewritten before it.
+	usernames []compiledUsername
+	extra     replacementSet
+	secrets   replacementSet
+	entropy   *compiledEntropy
+	wholeWord bool
+
+	// pathRules are replacements that only apply to matching file contents.
+	pathRules []compiledPathRule
+	// pathMappings are prefix rewrites, sorted longest From first.
+	pathMappings []PathMapping
+	// subtree is the normalized Subtree directory ("" for the whole repo).
+	subtree string
+
+	skipBinary bool
+	binaryExts map[string]bool
+	// maxRewriteBlob is Rules.MaxRewriteBlobBytes (0 = no limit).
+	maxRewriteBlob int64
+	excludeLarger  int64
+	excludeExts    map[string]bool
+	lfsMode        string
+	submodules     string
+	dropNotes      bool
+	pruneMerges    bool
+	keepOrigOIDs   bool
 
 	exclude []string
 	optIn   map[string]bool
+	include []string
+	// redact are RedactPatterns; redactPlaceholder is the published content.
+	redact            []string
+	redactPlaceholder []byte
 
 	// replaceHistoryFiles maps normalized file paths to true for files that should
 	// have their history replaced with HEAD content.
 	replaceHistoryFiles map[string]bool
+	// replaceHistoryGlobs are ReplaceHistoryWithCurrent entries with wildcards.
+	replaceHistoryGlobs []string
 	// replaceHistoryContent maps normalized file paths to their HEAD content (after scrubbing).
 	replaceHistoryContent map[string][]byte
 
 	publicAuthorName  string
 	publicAuthorEmail string
+	// authorsByEmail and authorsByName index AuthorMap by lowercased key.
+	authorsByEmail map[string]AuthorMapping
+	authorsByName  map[string]AuthorMapping
+	// timestamps is nil when timestamps are published unchanged.
+	timestamps *TimestampRules
+	messages   *compiledMessages
+	trailers   *compiledTrailers
+	squashBase string
+	squashHide map[string]bool
+	graft      string
+	refInclude []string
+	refExclude []string
+	tags       *compiledTags
+	symlinks   *compiledSymlinks
+	// branchRenames are ordered exact names first, then longest prefix.
+	branchRenames []compiledRename
+	refNamespaces []compiledNamespace
+	goModules     []compiledGoModule
+	// skipCommits are the compiled SkipCommitPatterns.
+	skipCommits    []*regexp.Regexp
+	contentFilters []compiledContentFilter
+	// inject are the InjectFiles, sorted by path.
+	inject []InjectedFile
 }
 
 func Compile(r Rules) (CompiledRules, error) {
@@ -95,16 +366,16 @@ func Compile(r Rules) (CompiledRules, error) {
 		return CompiledRules{}, fmt.Errorf("replacement string must not contain the private username")
 	}
 
-	// Compile case-insensitive regex for private username
-	privateRe, err := regexp.Compile("(?i)" + regexp.QuoteMeta(priv))
+	usernames, err := compileUsernames(priv, repl, r.AdditionalUsernames, r.MatchWholeWord)
 	if err != nil {
-		return CompiledRules{}, fmt.Errorf("invalid private username pattern: %w", err)
+		return CompiledRules{}, err
 	}
 
 	// Start with non-negotiable patterns
 	nnPatterns := nonNegotiablePatterns()
 	ex := make([]string, 0, len(r.ExcludePatterns)+len(nnPatterns))
 	ex = append(ex, nnPatterns...)
+	ex = append(ex, exportIgnorePatterns(r.GitAttributes)...)
 
 	for _, p := range r.ExcludePatterns {
 		p = normPath(p)
@@ -135,19 +406,79 @@ func Compile(r Rules) (CompiledRules, error) {
 		finalEx = append(finalEx, p)
 	}
 
-	extraPairs := make([][2]string, 0, len(r.ExtraReplacements))
-	extraRe := make([]*regexp.Regexp, 0, len(r.ExtraReplacements))
-	for k, v := range r.ExtraReplacements {
-		k = strings.TrimSpace(k)
-		if k == "" {
+	include := make([]string, 0, len(r.IncludePatterns))
+	for _, p := range r.IncludePatterns {
+		if p = normPath(p); p != "" {
+			include = append(include, p)
+		}
+	}
+
+	extra, err := compileReplacements(r.ExtraReplacements)
+	if err != nil {
+		return CompiledRules{}, err
+	}
+
+	pathRules := make([]compiledPathRule, 0, len(r.PathReplacements))
+	for _, pr := range r.PathReplacements {
+		pats := make([]string, 0, len(pr.Patterns))
+		for _, p := range pr.Patterns {
+			if p = normPath(p); p != "" {
+				pats = append(pats, p)
+			}
+		}
+		if len(pats) == 0 {
 			continue
 		}
-		re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(k))
+		set, err := compileReplacements(pr.Replacements)
 		if err != nil {
-			return CompiledRules{}, fmt.Errorf("invalid extra replacement pattern %q: %w", k, err)
+			return CompiledRules{}, err
+		}
+		pathRules = append(pathRules, compiledPathRule{patterns: pats, set: set})
+	}
+
+	subtree := strings.Trim(normPath(r.Subtree), "/")
+	mappings := r.PathMappings
+	if subtree != "" {
+		mappings = append(append([]PathMapping{}, mappings...), PathMapping{From: subtree, To: ""})
+	}
+	pathMappings, err := compilePathMappings(mappings)
+	if err != nil {
+		return CompiledRules{}, err
+	}
+
+	if !validLFSMode(r.LFSMode) {
+		return CompiledRules{}, fmt.Errorf("invalid lfs mode %q (want keep, push, exclude or error)", r.LFSMode)
+	}
+
+	switch r.SubmodulePolicy {
+	case "", SubmoduleRewrite, SubmoduleKeep, SubmoduleDrop:
+	default:
+		return CompiledRules{}, fmt.Errorf("invalid submodule policy %q (want rewrite, keep or drop)", r.SubmodulePolicy)
+	}
+
+	excludeExts := map[string]bool{}
+	for _, e := range r.ExcludeExtensions {
+		if e = strings.ToLower(strings.TrimSpace(e)); e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		excludeExts[e] = true
+	}
+
+	binaryExts := map[string]bool{}
+	if r.SkipBinaryBlobs {
+		for _, e := range append(append([]string{}, DefaultBinaryExtensions...), r.BinaryExtensions...) {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e == "" {
+				continue
+			}
+			if !strings.HasPrefix(e, ".") {
+				e = "." + e
+			}
+			binaryExts[e] = true
 		}
-		extraPairs = append(extraPairs, [2]string{k, v})
-		extraRe = append(extraRe, re)
 	}
 
 	pubName := strings.TrimSpace(r.PublicAuthorName)
@@ -161,58 +492,307 @@ func Compile(r Rules) (CompiledRules, error) {
 
 	// Build replace history files map
 	replaceHistoryFiles := make(map[string]bool)
+	var replaceHistoryGlobs []string
 	for _, p := range r.ReplaceHistoryWithCurrent {
 		p = normPath(p)
 		if p == "" {
 			continue
 		}
+		if IsGlobPattern(p) {
+			replaceHistoryGlobs = append(replaceHistoryGlobs, p)
+			continue
+		}
 		replaceHistoryFiles[p] = true
 	}
 
-	// Copy and scrub the replace history content
-	replaceHistoryContent := make(map[string][]byte)
+	c := CompiledRules{
+		private:             priv,
+		usernames:           usernames,
+		repl:                repl,
+		extra:               extra,
+		wholeWord:           r.MatchWholeWord,
+		pathRules:           pathRules,
+		pathMappings:        pathMappings,
+		subtree:             subtree,
+		skipBinary:          r.SkipBinaryBlobs,
+		binaryExts:          binaryExts,
+		maxRewriteBlob:      r.MaxRewriteBlobBytes,
+		excludeLarger:       r.ExcludeLargerThan,
+		excludeExts:         excludeExts,
+		lfsMode:             r.LFSMode,
+		submodules:          r.SubmodulePolicy,
+		dropNotes:           r.DropNotes,
+		pruneMerges:         r.PruneEmptyMerges,
+		keepOrigOIDs:        r.KeepOriginalOIDs,
+		exclude:             finalEx,
+		optIn:               opt,
+		include:             include,
+		replaceHistoryFiles: replaceHistoryFiles,
+		replaceHistoryGlobs: replaceHistoryGlobs,
+		publicAuthorName:    pubName,
+		publicAuthorEmail:   pubEmail,
+		squashBase:          strings.TrimSpace(r.SquashBase),
+		graft:               strings.TrimSpace(r.GraftParent),
+		refInclude:          compileRefPatterns(r.RefInclude),
+		refExclude:          compileRefPatterns(r.RefExclude),
+	}
+	if r.RedactSecrets {
+		c.secrets = builtinSecretSet()
+	}
+	if err := c.compileAuthorMap(r.AuthorMap); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.entropy, err = compileEntropy(r.Entropy); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.timestamps, err = compileTimestamps(r.Timestamps); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.messages, err = compileMessages(r.Messages); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.trailers, err = compileTrailers(r.Trailers); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.skipCommits, err = compileSkipCommits(r.SkipCommitPatterns); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.contentFilters, err = compileContentFilters(r.ContentFilters); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.inject, err = compileInjectedFiles(r.InjectFiles); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.tags, err = compileTags(r.Tags); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.symlinks, err = compileSymlinks(r.Symlinks); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.branchRenames, err = compileBranchRenames(r.BranchRenames); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.refNamespaces, err = compileRefNamespaces(r.RefNamespaces); err != nil {
+		return CompiledRules{}, err
+	}
+	if c.goModules, err = compileGoModules(r.GoModules, c.usernames); err != nil {
+		return CompiledRules{}, err
+	}
+	for _, p := range r.RedactPatterns {
+		if p = normPath(p); p != "" {
+			c.redact = append(c.redact, p)
+		}
+	}
+	c.redactPlaceholder = []byte(DefaultRedactPlaceholder)
+	if r.RedactPlaceholder != "" {
+		c.redactPlaceholder = c.RewriteBytes([]byte(r.RedactPlaceholder))
+	}
+	c.squashHide = map[string]bool{}
+	for _, oid := range r.SquashHide {
+		c.squashHide[strings.TrimSpace(oid)] = true
+	}
+
+	// Copy and scrub the replace history content using the replacement rules
+	// we just compiled. Content over MaxRewriteBlobBytes is published
+	// unchanged, like other blobs that size.
+	c.replaceHistoryContent = make(map[string][]byte)
 	for p, content := range r.ReplaceHistoryContent {
 		p = normPath(p)
 		if p == "" {
 			continue
 		}
-		// Scrub the content using the replacement rules we just compiled
-		// We need to create a temporary CompiledRules just for scrubbing
-		scrubbedContent := privateRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		if c.StreamBlob(len(content)) {
+			c.replaceHistoryContent[p] = content
+			continue
+		}
+		c.replaceHistoryContent[p] = c.RewriteBytesForPath(content, p)
+	}
+
+	return c, nil
+}
+
+// compileUsernames compiles the primary and additional private usernames,
+// rejecting replacements that reintroduce any of them.
+func compileUsernames(priv, repl string, extra []UsernameReplacement, wholeWord bool) ([]compiledUsername, error) {
+	all := []UsernameReplacement{{Username: priv, Replacement: repl}}
+	seen := map[string]bool{strings.ToLower(priv): true}
+	for _, u := range extra {
+		name := strings.TrimSpace(u.Username)
+		if name == "" {
+			return nil, errors.New("additional username must not be empty")
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		ur := strings.TrimSpace(u.Replacement)
+		if ur == "" {
+			ur = repl
+		}
+		all = append(all, UsernameReplacement{Username: name, Replacement: ur})
+	}
+
+	out := make([]compiledUsername, 0, len(all))
+	for _, u := range all {
+		for _, other := range all {
+			if strings.Contains(strings.ToLower(u.Replacement), strings.ToLower(other.Username)) {
+				return nil, fmt.Errorf("replacement %q must not contain private username %q", u.Replacement, other.Username)
+			}
+		}
+		pat := compoundPattern(u.Username)
+		if wholeWord {
+			pat = `\b` + pat + `\b`
+		}
+		re, err := regexp.Compile("(?i)" + pat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private username pattern: %w", err)
+		}
+		out = append(out, compiledUsername{name: u.Username, re: re, repl: u.Replacement})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].name) > len(out[j].name) })
+	return out, nil
+}
+
+func compilePathMappings(ms []PathMapping) ([]PathMapping, error) {
+	byFrom := map[string]string{}
+	for _, m := range ms {
+		from := strings.Trim(normPath(m.From), "/")
+		if from == "" {
+			return nil, fmt.Errorf("path mapping requires a non-empty source prefix (to %q)", m.To)
+		}
+		byFrom[from] = strings.Trim(normPath(m.To), "/")
+	}
+	out := make([]PathMapping, 0, len(byFrom))
+	for from, to := range byFrom {
+		out = append(out, PathMapping{From: from, To: to})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i].From) != len(out[j].From) {
+			return len(out[i].From) > len(out[j].From)
+		}
+		return out[i].From < out[j].From
+	})
+	return out, nil
+}
+
+func compileReplacements(m map[string]string) (replacementSet, error) {
+	set := replacementSet{
+		pairs:   make([][2]string, 0, len(m)),
+		res:     make([]*regexp.Regexp, 0, len(m)),
+		isRegex: make([]bool, 0, len(m)),
+	}
+	// Regex keys (and non-ASCII literals) are applied one after another, so
+	// they run in key order: the same rules must always give the same
+	// output, and so the same public commit ids.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := m[k]
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		isRegex := strings.HasPrefix(k, RegexReplacementPrefix)
+		pat := "(?i)" + regexp.QuoteMeta(k)
+		if isRegex {
+			pat = strings.TrimPrefix(k, RegexReplacementPrefix)
+			if pat == "" {
+				continue
+			}
+		}
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return replacementSet{}, fmt.Errorf("invalid extra replacement pattern %q: %w", k, err)
+		}
+		set.pairs = append(set.pairs, [2]string{k, v})
+		set.res = append(set.res, re)
+		set.isRegex = append(set.isRegex, isRegex)
+	}
+	// Literal keys are matched together, longest match first, so the order
+	// keys come out of the map doesn't matter. Keys with non-ASCII text keep
+	// using their regexp for Unicode case folding.
+	var lits []string
+	for i, p := range set.pairs {
+		if !set.isRegex[i] && acSupported(p[0]) {
+			lits = append(lits, p[0])
+			set.literalIdx = append(set.literalIdx, i)
+		}
+	}
+	if len(lits) > 0 {
+		set.literals = newACMatcher(lits)
+	}
+	return set, nil
+}
+
+func (s replacementSet) apply(b []byte) []byte {
+	if s.literals != nil {
+		b = s.replaceLiterals(b)
+	}
+	for i, re := range s.res {
+		repl := s.pairs[i][1]
+		if s.isRegex[i] {
+			b = re.ReplaceAll(b, []byte(repl))
+			continue
+		}
+		if s.literals != nil && acSupported(s.pairs[i][0]) {
+			continue
+		}
+		b = re.ReplaceAllFunc(b, func(match []byte) []byte {
 			return []byte(applyCasePattern(string(match), repl))
 		})
-		for i, re := range extraRe {
-			replVal := extraPairs[i][1]
-			scrubbedContent = re.ReplaceAllFunc(scrubbedContent, func(match []byte) []byte {
-				return []byte(applyCasePattern(string(match), replVal))
-			})
-		}
-		replaceHistoryContent[p] = scrubbedContent
-	}
-
-	return CompiledRules{
-		private:               priv,
-		privateRe:             privateRe,
-		repl:                  repl,
-		extra:                 extraPairs,
-		extraRe:               extraRe,
-		exclude:               finalEx,
-		optIn:                 opt,
-		replaceHistoryFiles:   replaceHistoryFiles,
-		replaceHistoryContent: replaceHistoryContent,
-		publicAuthorName:      pubName,
-		publicAuthorEmail:     pubEmail,
-	}, nil
+	}
+	return b
+}
+
+// replaceLiterals rewrites every match of the literal replacements in one
+// pass, keeping b when nothing matches.
+func (s replacementSet) replaceLiterals(b []byte) []byte {
+	var out []byte
+	last := 0
+	s.literals.findAll(b, func(pat, start, end int) {
+		if out == nil {
+			out = make([]byte, 0, len(b))
+		}
+		out = append(out, b[last:start]...)
+		out = append(out, applyCasePattern(string(b[start:end]), s.pairs[s.literalIdx[pat]][1])...)
+		last = end
+	})
+	if out == nil {
+		return b
+	}
+	return append(out, b[last:]...)
 }
 
 func (c CompiledRules) Private() string     { return c.private }
 func (c CompiledRules) Replacement() string { return c.repl }
 
+// PrivateUsernames returns the primary and additional private usernames.
+func (c CompiledRules) PrivateUsernames() []string {
+	names := []string{c.private}
+	for _, u := range c.usernames {
+		if u.name != c.private {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
 // ShouldReplaceHistory returns true if the file should have its history
 // replaced with HEAD content.
 func (c CompiledRules) ShouldReplaceHistory(p string) bool {
 	p = normPath(p)
-	return c.replaceHistoryFiles[p]
+	if c.replaceHistoryFiles[p] {
+		return true
+	}
+	for _, pat := range c.replaceHistoryGlobs {
+		if matchGlob(pat, p) {
+			return true
+		}
+	}
+	return false
 }
 
 // GetReplaceHistoryContent returns the scrubbed HEAD content for a file
@@ -222,58 +802,271 @@ func (c CompiledRules) GetReplaceHistoryContent(p string) []byte {
 	return c.replaceHistoryContent[p]
 }
 
-// GetReplaceHistoryFiles returns the list of files that should have their
-// history replaced with HEAD content.
+// GetReplaceHistoryFiles returns the sorted list of files that should have
+// their history replaced with HEAD content: the exact paths, plus the HEAD
+// files matching a glob.
 func (c CompiledRules) GetReplaceHistoryFiles() []string {
 	files := make([]string, 0, len(c.replaceHistoryFiles))
 	for f := range c.replaceHistoryFiles {
 		files = append(files, f)
 	}
+	for f := range c.replaceHistoryContent {
+		if !c.replaceHistoryFiles[f] && c.ShouldReplaceHistory(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
 	return files
 }
 
+// ExpandReplaceHistory returns the files among headFiles matching a
+// ReplaceHistoryWithCurrent entry, plus the entries that name exact paths.
+func ExpandReplaceHistory(patterns, headFiles []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	for _, pat := range patterns {
+		pat = normPath(pat)
+		if pat == "" {
+			continue
+		}
+		if !IsGlobPattern(pat) {
+			add(pat)
+			continue
+		}
+		for _, f := range headFiles {
+			if matchGlob(pat, f) {
+				add(f)
+			}
+		}
+	}
+	return out
+}
+
+// IsGlobPattern reports whether p contains glob metacharacters, and so is
+// matched with MatchGlob rather than compared as a path.
+func IsGlobPattern(p string) bool { return strings.ContainsAny(p, "*?[") }
+
 func (c CompiledRules) ShouldExclude(p string) bool {
 	p = normPath(p)
-	if IsNonNegotiablePath(p) {
+	if IsNonNegotiablePath(p) || c.excludeExts[strings.ToLower(path.Ext(p))] {
 		return true
 	}
-	for _, pat := range c.exclude {
-		pat = normPath(pat)
-		if pat == "" {
+	// Last match wins, so the first match from the end decides.
+	for i := len(c.exclude) - 1; i >= 0; i-- {
+		pat := c.exclude[i]
+		negated := strings.HasPrefix(pat, "!")
+		if pat = normPath(strings.TrimPrefix(pat, "!")); pat == "" {
 			continue
 		}
 		if matchGlob(pat, p) {
-			return true
+			return !negated
 		}
 	}
 	return false
 }
 
+// MatchWholeWord reports whether the private username only matches as a
+// standalone word.
+func (c CompiledRules) MatchWholeWord() bool { return c.wholeWord }
+
 func (c CompiledRules) RewriteString(s string) string {
-	out := c.privateRe.ReplaceAllStringFunc(s, func(match string) string {
-		return applyCasePattern(match, c.repl)
-	})
-	for i, re := range c.extraRe {
-		repl := c.extra[i][1]
-		out = re.ReplaceAllStringFunc(out, func(match string) string {
-			return applyCasePattern(match, repl)
-		})
-	}
-	return out
+	return string(c.RewriteBytes([]byte(s)))
 }
 
 // RewriteBytes performs case-preserving replacement on byte slices.
 func (c CompiledRules) RewriteBytes(b []byte) []byte {
-	out := c.privateRe.ReplaceAllFunc(b, func(match []byte) []byte {
-		return []byte(applyCasePattern(string(match), c.repl))
-	})
-	for i, re := range c.extraRe {
-		repl := c.extra[i][1]
-		out = re.ReplaceAllFunc(out, func(match []byte) []byte {
-			return []byte(applyCasePattern(string(match), repl))
+	for _, u := range c.usernames {
+		b = u.re.ReplaceAllFunc(b, func(match []byte) []byte {
+			return []byte(applyCompoundCase(string(match), u.repl))
 		})
 	}
-	return out
+	return c.secrets.apply(c.extra.apply(b))
+}
+
+// HasPathMappings reports whether any path prefix mappings are configured.
+func (c CompiledRules) HasPathMappings() bool { return len(c.pathMappings) > 0 }
+
+// ShouldInclude reports whether private path p matches the include set.
+// It is always true when no include patterns are configured.
+func (c CompiledRules) ShouldInclude(p string) bool {
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, pat := range c.include {
+		if matchGlob(pat, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// InSubtree reports whether private path p lies inside the configured subtree.
+// It is always true when no subtree is configured.
+func (c CompiledRules) InSubtree(p string) bool {
+	if c.subtree == "" {
+		return true
+	}
+	return strings.HasPrefix(normPath(p), c.subtree+"/")
+}
+
+// MapPath applies the longest matching path prefix mapping to p.
+// Paths outside every mapping are returned unchanged.
+func (c CompiledRules) MapPath(p string) string {
+	for _, m := range c.pathMappings {
+		var rest string
+		switch {
+		case p == m.From:
+		case strings.HasPrefix(p, m.From+"/"):
+			rest = p[len(m.From)+1:]
+		default:
+			continue
+		}
+		if m.To == "" {
+			return rest
+		}
+		if rest == "" {
+			return m.To
+		}
+		return m.To + "/" + rest
+	}
+	return p
+}
+
+// SkipBinaryBlobs reports whether binary blobs bypass content rewriting.
+func (c CompiledRules) SkipBinaryBlobs() bool { return c.skipBinary }
+
+// IsBinary reports whether content (destined for path p, which may be empty)
+// looks binary: a NUL byte within the first 8000 bytes or a binary extension.
+func (c CompiledRules) IsBinary(content []byte, p string) bool {
+	if IsBinaryContent(content) {
+		return true
+	}
+	return p != "" && c.binaryExts[strings.ToLower(path.Ext(p))]
+}
+
+// IsBinaryContent reports whether content contains a NUL byte within its
+// first 8000 bytes, the same heuristic git uses.
+func IsBinaryContent(content []byte) bool {
+	n := len(content)
+	if n > binarySniffLen {
+		n = binarySniffLen
+	}
+	return bytes.IndexByte(content[:n], 0) >= 0
+}
+
+// StreamBlob reports whether a blob of n bytes exceeds MaxRewriteBlobBytes
+// and should be passed through without rewriting.
+func (c CompiledRules) StreamBlob(n int) bool {
+	return c.maxRewriteBlob > 0 && int64(n) > c.maxRewriteBlob
+}
+
+// ExcludeBlob reports whether files whose blob is n bytes are left out
+// (ExcludeLargerThan).
+func (c CompiledRules) ExcludeBlob(n int) bool {
+	return c.excludeLarger > 0 && int64(n) > c.excludeLarger
+}
+
+// LFSMode returns the configured LFS mode, LFSKeep if unset.
+func (c CompiledRules) LFSMode() string {
+	if c.lfsMode == "" {
+		return LFSKeep
+	}
+	return c.lfsMode
+}
+
+// SubmodulePolicy returns the configured submodule policy, SubmoduleRewrite
+// if unset.
+func (c CompiledRules) SubmodulePolicy() string {
+	if c.submodules == "" {
+		return SubmoduleRewrite
+	}
+	return c.submodules
+}
+
+// Verbatim reports whether content destined for path p is published without
+// rewriting (.gitmodules under SubmoduleKeep).
+func (c CompiledRules) Verbatim(p string) bool {
+	return c.submodules == SubmoduleKeep && normPath(p) == ".gitmodules"
+}
+
+// DropNotes reports whether notes refs are removed from the published history.
+func (c CompiledRules) DropNotes() bool { return c.dropNotes }
+
+// DeferBlobs reports whether blob rewriting depends on the destination path,
+// requiring the filter to hold blobs until a commit references them.
+func (c CompiledRules) DeferBlobs() bool {
+	return c.HasPathReplacements() || c.skipBinary || c.submodules == SubmoduleKeep || c.entropy != nil || len(c.goModules) > 0 || len(c.contentFilters) > 0
+}
+
+// HasPathReplacements reports whether any path-scoped replacements are configured.
+func (c CompiledRules) HasPathReplacements() bool { return len(c.pathRules) > 0 }
+
+// PathRuleKey identifies the set of path-scoped replacement rules matching p.
+// Paths with equal keys are rewritten identically; "" means no path rules apply.
+func (c CompiledRules) PathRuleKey(p string) string {
+	p = normPath(p)
+	var b strings.Builder
+	if c.skipBinary && c.binaryExts[strings.ToLower(path.Ext(p))] {
+		b.WriteString("bin,")
+	}
+	if c.Verbatim(p) {
+		b.WriteString("raw,")
+	}
+	if c.EntropyExempt(p) {
+		b.WriteString("noent,")
+	}
+	if len(c.goModules) > 0 && isGoFile(p) {
+		b.WriteString("go,")
+	}
+	for i, pr := range c.pathRules {
+		if pr.matches(p) {
+			fmt.Fprintf(&b, "%d,", i)
+		}
+	}
+	for _, cf := range c.contentFiltersFor(p) {
+		fmt.Fprintf(&b, "cf:%q,", cf.command)
+	}
+	return b.String()
+}
+
+// pathRuleKeyConstant reports whether PathRuleKey is "" for every path, so a
+// blob is rewritten the same way wherever it is used.
+func (c CompiledRules) pathRuleKeyConstant() bool {
+	if c.skipBinary && len(c.binaryExts) > 0 {
+		return false
+	}
+	if c.submodules == SubmoduleKeep || (c.entropy != nil && len(c.entropy.allowPaths) > 0) {
+		return false
+	}
+	return len(c.pathRules) == 0 && len(c.goModules) == 0 && len(c.contentFilters) == 0
+}
+
+// RewriteBytesForPath rewrites file content destined for path p: path-scoped
+// replacements matching p are applied first, followed by the global rules.
+func (c CompiledRules) RewriteBytesForPath(b []byte, p string) []byte {
+	p = normPath(p)
+	out := c.rewriteGoModules(b, p)
+	for _, pr := range c.pathRules {
+		if pr.matches(p) {
+			out = pr.set.apply(out)
+		}
+	}
+	return c.RewriteBytes(out)
+}
+
+func (pr compiledPathRule) matches(p string) bool {
+	for _, pat := range pr.patterns {
+		if matchGlob(pat, p) {
+			return true
+		}
+	}
+	return false
 }
 
 // applyCasePattern applies the case pattern of match to replacement.
@@ -328,6 +1121,52 @@ func applyCasePattern(match, replacement string) string {
 	return replacement
 }
 
+// compileAuthorMap indexes the author map, rejecting public identities that
+// still contain the private username.
+func (c *CompiledRules) compileAuthorMap(m []AuthorMapping) error {
+	c.authorsByEmail = map[string]AuthorMapping{}
+	c.authorsByName = map[string]AuthorMapping{}
+	for _, am := range m {
+		am.PublicName = strings.TrimSpace(am.PublicName)
+		am.PublicEmail = strings.TrimSpace(am.PublicEmail)
+		if am.PublicName == "" || am.PublicEmail == "" {
+			return fmt.Errorf("author map entry for %q needs a public name and email", am.PrivateName+am.PrivateEmail)
+		}
+		for _, priv := range c.PrivateUsernames() {
+			if strings.Contains(strings.ToLower(am.PublicName+am.PublicEmail), strings.ToLower(priv)) {
+				return fmt.Errorf("author map public identity %q <%s> must not contain the private username", am.PublicName, am.PublicEmail)
+			}
+		}
+		switch {
+		case strings.TrimSpace(am.PrivateEmail) != "":
+			key := strings.ToLower(strings.TrimSpace(am.PrivateEmail))
+			if _, ok := c.authorsByEmail[key]; !ok {
+				c.authorsByEmail[key] = am
+			}
+		case strings.TrimSpace(am.PrivateName) != "":
+			key := strings.ToLower(strings.TrimSpace(am.PrivateName))
+			if _, ok := c.authorsByName[key]; !ok {
+				c.authorsByName[key] = am
+			}
+		default:
+			return errors.New("author map entry needs a private name or email")
+		}
+	}
+	return nil
+}
+
+// PublicIdentity returns the public name and email for a private identity,
+// using the author map and falling back to the default public identity.
+func (c CompiledRules) PublicIdentity(name, email string) (string, string) {
+	if am, ok := c.authorsByEmail[strings.ToLower(strings.TrimSpace(email))]; ok {
+		return am.PublicName, am.PublicEmail
+	}
+	if am, ok := c.authorsByName[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return am.PublicName, am.PublicEmail
+	}
+	return c.publicAuthorName, c.publicAuthorEmail
+}
+
 func (c CompiledRules) PublicAuthorName() string  { return c.publicAuthorName }
 func (c CompiledRules) PublicAuthorEmail() string { return c.publicAuthorEmail }
 
@@ -352,6 +1191,11 @@ func matchGlob(pattern, target string) bool {
 	return matchSegs(ps, ts)
 }
 
+// MatchGlob reports whether the slash-separated path target matches
+// pattern, where "**" matches any number of path segments and the other
+// segments are matched with path.Match.
+func MatchGlob(pattern, target string) bool { return matchGlob(pattern, target) }
+
 func matchSegs(patSegs, pathSegs []string) bool {
 	if len(patSegs) == 0 {
 		return len(pathSegs) == 0
@@ -0,0 +1,33 @@
+package scrub
+
+import "regexp"
+
+// SecretRedaction replaces built-in secret matches when Rules.RedactSecrets is set.
+const SecretRedaction = "REDACTED"
+
+// SecretDetector is a named pattern for a well-known credential format.
+type SecretDetector struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// BuiltinSecretDetectors are the credential formats redacted by
+// Rules.RedactSecrets.
+var BuiltinSecretDetectors = []SecretDetector{
+	{Name: "aws-access-key-id", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}\b`)},
+	{Name: "github-fine-grained-token", Pattern: regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{Name: "private-key", Pattern: regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+}
+
+// builtinSecretSet returns the built-in detectors as a replacement set.
+func builtinSecretSet() replacementSet {
+	set := replacementSet{}
+	for _, d := range BuiltinSecretDetectors {
+		set.pairs = append(set.pairs, [2]string{d.Name, SecretRedaction})
+		set.res = append(set.res, d.Pattern)
+		set.isRegex = append(set.isRegex, true)
+	}
+	return set
+}
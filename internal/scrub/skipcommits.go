@@ -0,0 +1,44 @@
+package scrub
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+func compileSkipCommits(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip commit pattern %q: %w", p, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// skipCommitMessage reports whether a commit with this message (after
+// replacement rules, before message transforms) is left out by
+// Rules.SkipCommitPatterns.
+func (f *ExportFilter) skipCommitMessage(message []byte, messageFile *spoolFile) (bool, error) {
+	if len(f.rules.skipCommits) == 0 {
+		return false, nil
+	}
+	if messageFile != nil {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(messageFile.reader()); err != nil {
+			return false, err
+		}
+		message = buf.Bytes()
+	}
+	for _, re := range f.rules.skipCommits {
+		if re.Match(message) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
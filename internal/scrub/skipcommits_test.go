@@ -0,0 +1,42 @@
+package scrub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_SkipCommitsFoldsChangesIntoChild(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "Add a")
+	commitFiles(t, repo, map[string]string{"b.txt": "b\n"}, "[private] Add b for the demo")
+	commitFiles(t, repo, map[string]string{"c.txt": "c\n"}, "Add c")
+
+	rules, err := Compile(Rules{
+		PrivateUsername:    "obinnaokechukwu",
+		Replacement:        "johndoe",
+		SkipCommitPatterns: []string{`^\[private\]`},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+
+	log, err := gitx.Run(nil, bare, "log", "--format=%s", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if got := strings.Join(nonEmpty(strings.Split(log.Stdout, "\n")), ","); got != "Add c,Add a" {
+		t.Fatalf("published history = %q, want \"Add c,Add a\"", got)
+	}
+	show, err := gitx.Run(nil, bare, "show", "--name-only", "--format=", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	if got := strings.Join(nonEmpty(strings.Split(show.Stdout, "\n")), ","); got != "b.txt,c.txt" {
+		t.Fatalf("files changed by the child = %q, want b.txt,c.txt", got)
+	}
+}
@@ -0,0 +1,158 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// SquashMessage is the commit message of the baseline commit that replaces
+// the history up to Rules.SquashBase.
+const SquashMessage = "Initial commit\n"
+
+// SquashExportArgs returns the extra fast-export arguments a squashed export
+// needs: every commit lists its full tree (so a commit whose parents are
+// missing is still complete), parents outside the export are referenced by
+// object id, and the history behind base (its parents, given as object ids)
+// is left out.
+func SquashExportArgs(baseParents []string) []string {
+	args := []string{"--full-tree", "--reference-excluded-parents"}
+	for _, p := range baseParents {
+		args = append(args, "^"+p)
+	}
+	return args
+}
+
+// SquashBase returns the private commit published as the baseline commit, or
+// "" if history is replayed in full.
+func (c CompiledRules) SquashBase() string { return c.squashBase }
+
+// squashed reports whether a from/merge reference names a commit that is not
+// published in a squashed export: one left out of the export (referenced by
+// object id, not mark) or one hidden by Rules.SquashHide.
+func (f *ExportFilter) squashed(ref string) bool {
+	return ref != "" && (!strings.HasPrefix(ref, ":") || f.squashHidden[ref])
+}
+
+// hideSquashed drops a commit listed in Rules.SquashHide, reporting whether it
+// did. Later references to its mark are treated as squashed.
+func (f *ExportFilter) hideSquashed(oid, mark string) bool {
+	if !f.rules.squashHide[oid] {
+		return false
+	}
+	if mark != "" {
+		f.squashHidden[mark] = true
+	}
+	return true
+}
+
+// squashParents rewrites the parents of a commit in a squashed export. The
+// baseline commit loses its parents; references to squashed commits point at
+// the baseline instead. Duplicate parents are dropped.
+func (f *ExportFilter) squashParents(oid, mark, parent string, merges []string) (string, []string) {
+	if oid == f.rules.squashBase {
+		f.baselineMark = mark
+		f.baselineSeen = true
+		return "", nil
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, p := range append([]string{parent}, merges...) {
+		if f.squashed(p) {
+			p = f.baselineMark
+		}
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		out = append(out, p)
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+	return out[0], out[1:]
+}
+
+// deferSquashRecord holds back commits (and refs) that build on squashed
+// history until the baseline commit has been assigned a mark. fast-export
+// may emit an old side branch before the baseline. It reports whether the
+// record was deferred; if not, the caller handles it from the returned reader.
+func (f *ExportFilter) deferSquashRecord(line string, br *bufio.Reader) (bool, *bufio.Reader, error) {
+	var raw []byte
+	var err error
+	if strings.HasPrefix(line, "commit ") {
+		raw, err = readRawCommit(br)
+	} else if raw, err = readRawReset(br); err == nil {
+		if peek, perr := br.Peek(1); perr == nil && peek[0] == '\n' {
+			_, _ = br.ReadByte()
+			raw = append(raw, '\n')
+		}
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	mark, oid, parents := rawCommitRefs(raw)
+	mark = f.inputMark(mark)
+	hold := false
+	if oid != f.rules.squashBase {
+		for _, p := range parents {
+			if p = f.inputMark(p); f.squashed(p) || f.squashPending[p] {
+				hold = true
+			}
+		}
+	}
+	if !hold {
+		return false, bufio.NewReader(bytes.NewReader(raw)), nil
+	}
+	if mark != "" {
+		f.squashPending[mark] = true
+	}
+	f.deferredSquash = append(f.deferredSquash, deferredRecord{firstLine: line, raw: raw})
+	return true, nil, nil
+}
+
+// replaySquashDeferred emits the records held back by deferSquashRecord.
+func (f *ExportFilter) replaySquashDeferred(bw *bufio.Writer) error {
+	recs := f.deferredSquash
+	f.deferredSquash = nil
+	f.squashPending = map[string]bool{}
+	for _, rec := range recs {
+		br := bufio.NewReader(bytes.NewReader(rec.raw))
+		var err error
+		if strings.HasPrefix(rec.firstLine, "commit ") {
+			err = f.handleCommit(rec.firstLine, br, bw)
+		} else {
+			err = f.handleReset(rec.firstLine, br, bw)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawCommitRefs extracts the mark, original id and parents of a raw commit
+// record (or the target of a raw reset record).
+func rawCommitRefs(raw []byte) (mark, oid string, parents []string) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	for {
+		line, err := br.ReadString('\n')
+		switch {
+		case strings.HasPrefix(line, "mark "):
+			mark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+		case strings.HasPrefix(line, "original-oid "):
+			oid = strings.TrimSpace(strings.TrimPrefix(line, "original-oid "))
+		case strings.HasPrefix(line, "from "):
+			parents = append(parents, strings.TrimSpace(strings.TrimPrefix(line, "from ")))
+		case strings.HasPrefix(line, "merge "):
+			parents = append(parents, strings.TrimSpace(strings.TrimPrefix(line, "merge ")))
+		case strings.HasPrefix(line, "data "):
+			if n, perr := parseDataLen(line); perr == nil {
+				_, _ = br.Discard(n)
+			}
+		}
+		if err != nil {
+			return mark, oid, parents
+		}
+	}
+}
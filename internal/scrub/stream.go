@@ -0,0 +1,164 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+const (
+	// streamChunkSize is how much of a large payload is rewritten at a time.
+	streamChunkSize = 1 << 20
+	// streamOverlap is the window kept between chunks so matches that cross
+	// a chunk boundary are still rewritten. Matches longer than this are not
+	// guaranteed to be found in streamed payloads.
+	streamOverlap = 4096
+)
+
+// streamThreshold is the payload size above which data is rewritten in chunks
+// and spooled to a temp file instead of being held in memory.
+var streamThreshold = 8 << 20
+
+// RewriteStream copies src to dst, applying RewriteBytesForPath (p may be
+// empty) in bounded-size chunks. Chunks are cut at line boundaries where
+// possible and never inside a match, so the output is the same as rewriting
+// the whole payload at once, provided no match exceeds 4 KiB.
+func (c CompiledRules) RewriteStream(dst io.Writer, src io.Reader, p string) error {
+	var res []*regexp.Regexp
+	for _, u := range c.usernames {
+		res = append(res, u.re)
+	}
+	res = append(res, c.extra.res...)
+	res = append(res, c.secrets.res...)
+	np := normPath(p)
+	if isGoFile(np) {
+		for _, m := range c.goModules {
+			res = append(res, m.re)
+		}
+	}
+	for _, pr := range c.pathRules {
+		if pr.matches(np) {
+			res = append(res, pr.set.res...)
+		}
+	}
+
+	buf := make([]byte, 0, streamChunkSize+streamOverlap)
+	for {
+		n, err := io.ReadFull(src, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			_, werr := dst.Write(c.RewriteBytesForPath(buf, p))
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		cut := safeCut(buf, res)
+		if _, err := dst.Write(c.RewriteBytesForPath(buf[:cut], p)); err != nil {
+			return err
+		}
+		rest := copy(buf, buf[cut:])
+		buf = buf[:rest]
+	}
+}
+
+// safeCut picks where to split a full buffer: after the last newline in the
+// window before the overlap (or at a non-word boundary), moved back so no
+// match of res straddles it.
+func safeCut(buf []byte, res []*regexp.Regexp) int {
+	cut := len(buf) - streamOverlap
+	lo := cut - streamOverlap
+	if lo < 0 {
+		lo = 0
+	}
+	if i := bytes.LastIndexByte(buf[lo:cut], '\n'); i >= 0 {
+		cut = lo + i + 1
+	} else {
+		for cut > lo && isWordByte(buf[cut-1]) && isWordByte(buf[cut]) {
+			cut--
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		start := cut - streamOverlap
+		if start < 0 {
+			start = 0
+		}
+		for _, re := range res {
+			for _, m := range re.FindAllIndex(buf[start:], -1) {
+				if s, e := start+m[0], start+m[1]; s < cut && e > cut {
+					cut = s
+					changed = true
+				}
+			}
+		}
+	}
+	if cut <= 0 {
+		// A single match spans the whole window; give up on keeping it intact.
+		cut = len(buf) - streamOverlap
+	}
+	return cut
+}
+
+// spoolFile is a payload held in a temp file rather than in memory.
+type spoolFile struct {
+	f    *os.File
+	size int64
+}
+
+// spool writes the output of fill to a new temp file and rewinds it.
+func spool(fill func(w io.Writer) error) (*spoolFile, error) {
+	f, err := os.CreateTemp("", "git-copy-data-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &spoolFile{f: f}
+	w := bufio.NewWriter(f)
+	if err := fill(w); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.size, err = f.Seek(0, io.SeekCurrent); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// head returns up to n leading bytes of the spooled payload.
+func (s *spoolFile) head(n int) ([]byte, error) {
+	b := make([]byte, n)
+	m, err := s.f.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b[:m], nil
+}
+
+// reader returns a reader over the whole spooled payload.
+func (s *spoolFile) reader() io.Reader { return io.NewSectionReader(s.f, 0, s.size) }
+
+// writeData writes the spooled payload as a fast-import data command, without
+// the trailing newline.
+func (s *spoolFile) writeData(bw *bufio.Writer) error {
+	_, _ = bw.WriteString(fmt.Sprintf("data %d\n", s.size))
+	_, err := io.Copy(bw, s.reader())
+	return err
+}
+
+// Close removes the temp file.
+func (s *spoolFile) Close() {
+	_ = s.f.Close()
+	_ = os.Remove(s.f.Name())
+}
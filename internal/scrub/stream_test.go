@@ -0,0 +1,162 @@
+package scrub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestRewriteStream_MatchesRewriteBytes(t *testing.T) {
+	r, err := Compile(Rules{
+		PrivateUsername:   "obinnaokechukwu",
+		Replacement:       "johndoe",
+		ExtraReplacements: map[string]string{"regex:token-[0-9]+": "token-X"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	// Place matches around every likely chunk boundary, with and without newlines.
+	var in bytes.Buffer
+	for in.Len() < 3*streamChunkSize {
+		in.WriteString(strings.Repeat("x", 997))
+		in.WriteString("Obinnaokechukwu token-12345 ")
+		if in.Len()%3 == 0 {
+			in.WriteString("\n")
+		}
+	}
+	for _, off := range []int{streamChunkSize, streamChunkSize - streamOverlap} {
+		b := in.Bytes()
+		copy(b[off-7:], "obinnaokechukwu")
+	}
+
+	var out bytes.Buffer
+	if err := r.RewriteStream(&out, bytes.NewReader(in.Bytes()), ""); err != nil {
+		t.Fatalf("RewriteStream: %v", err)
+	}
+	want := r.RewriteBytes(in.Bytes())
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("streamed rewrite differs from in-memory rewrite (len %d vs %d)", out.Len(), len(want))
+	}
+}
+
+func TestRewriteStream_WholeWordAcrossChunks(t *testing.T) {
+	r, err := Compile(Rules{
+		PrivateUsername: "ben",
+		Replacement:     "alex",
+		MatchWholeWord:  true,
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	// A long run of word characters with no line breaks forces cuts mid-word.
+	in := []byte(strings.Repeat("benchmark", 3*streamChunkSize/9) + " ben")
+	var out bytes.Buffer
+	if err := r.RewriteStream(&out, bytes.NewReader(in), ""); err != nil {
+		t.Fatalf("RewriteStream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), r.RewriteBytes(in)) {
+		t.Fatalf("streamed whole-word rewrite differs from in-memory rewrite")
+	}
+}
+
+func TestExportFilter_StreamsLargePayloads(t *testing.T) {
+	old := streamThreshold
+	streamThreshold = 64
+	defer func() { streamThreshold = old }()
+
+	big := strings.Repeat("line by obinnaokechukwu\n", 20)
+	msg := "commit by obinnaokechukwu\n" + strings.Repeat("more text\n", 10)
+	for _, deferred := range []bool{false, true} {
+		repo := newFilterTestRepo(t)
+		commitFiles(t, repo, map[string]string{
+			"big.txt": big,
+			"a.txt":   "small\n",
+		}, msg)
+
+		rules, err := Compile(Rules{
+			PrivateUsername: "obinnaokechukwu",
+			Replacement:     "johndoe",
+			SkipBinaryBlobs: deferred,
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+
+		bare := filepath.Join(t.TempDir(), "out.git")
+		runExportFilterImport(t, repo, bare, rules)
+
+		res, err := gitx.Run(nil, bare, "show", "refs/heads/main:big.txt")
+		if err != nil {
+			t.Fatalf("show big.txt: %v", err)
+		}
+		if res.Stdout != strings.ReplaceAll(big, "obinnaokechukwu", "johndoe") {
+			t.Errorf("deferred=%v: big.txt not rewritten: %q", deferred, res.Stdout)
+		}
+		logRes, err := gitx.Run(nil, bare, "log", "-1", "--format=%B", "refs/heads/main")
+		if err != nil {
+			t.Fatalf("log: %v", err)
+		}
+		if strings.Contains(logRes.Stdout, "obinnaokechukwu") || !strings.Contains(logRes.Stdout, "commit by johndoe") {
+			t.Errorf("deferred=%v: message not rewritten: %q", deferred, logRes.Stdout)
+		}
+	}
+}
+
+func TestExportFilter_BoundsDeferredPayloads(t *testing.T) {
+	old := streamThreshold
+	streamThreshold = 64
+	defer func() { streamThreshold = old }()
+
+	repo := newFilterTestRepo(t)
+	files := map[string]string{}
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("docs/f%d.md", i)] = fmt.Sprintf("file %d by obinnaokechukwu\n", i)
+	}
+	commitFiles(t, repo, files, "docs")
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		PathReplacements: []PathReplacements{{
+			Patterns:     []string{"docs/*.md"},
+			Replacements: map[string]string{"file": "page"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	exp, err := gitx.Run(nil, repo, "fast-export", "--all")
+	if err != nil {
+		t.Fatalf("fast-export: %v", err)
+	}
+	f := NewExportFilter(rules)
+	if err := f.Filter(strings.NewReader(exp.Stdout), io.Discard); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	held := 0
+	for _, b := range f.pathBlobs {
+		held += len(b)
+	}
+	if held > streamThreshold || held != f.pathBlobBytes {
+		t.Errorf("held %d bytes of deferred payloads (counted %d), want at most %d", held, f.pathBlobBytes, streamThreshold)
+	}
+
+	bare := filepath.Join(t.TempDir(), "out.git")
+	runExportFilterImport(t, repo, bare, rules)
+	for i := 0; i < 8; i++ {
+		res, err := gitx.Run(nil, bare, "show", fmt.Sprintf("refs/heads/main:docs/f%d.md", i))
+		if err != nil {
+			t.Fatalf("show: %v", err)
+		}
+		if want := fmt.Sprintf("page %d by johndoe\n", i); res.Stdout != want {
+			t.Errorf("docs/f%d.md = %q, want %q", i, res.Stdout, want)
+		}
+	}
+}
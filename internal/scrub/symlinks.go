@@ -0,0 +1,136 @@
+package scrub
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Symlink modes for SymlinkRules.Mode.
+const (
+	// SymlinkRewrite replaces the matched part of the target with
+	// SymlinkRules.Replacement.
+	SymlinkRewrite = "rewrite"
+	// SymlinkDrop leaves matching symlinks out of the published tree.
+	SymlinkDrop = "drop"
+	// SymlinkFail stops the export at the first matching symlink.
+	SymlinkFail = "fail"
+)
+
+// DefaultSymlinkPattern matches absolute symlink targets (Unix, home
+// relative or Windows drive paths), which usually name private directories.
+const DefaultSymlinkPattern = `^(/|~|[A-Za-z]:[\\/])`
+
+// symlinkMaxTarget bounds the blobs checked as symlink targets; longer
+// targets exceed PATH_MAX on every platform git supports.
+const symlinkMaxTarget = 4096
+
+// SymlinkRules handle symlinks (mode 120000 entries) whose target, after
+// replacement rules, matches one of Patterns (DefaultSymlinkPattern when
+// empty).
+type SymlinkRules struct {
+	Mode     string
+	Patterns []string
+	// Replacement is the SymlinkRewrite expansion for each match ($1 refers
+	// to a capture group). A target rewritten to nothing is dropped.
+	Replacement string
+}
+
+type compiledSymlinks struct {
+	mode        string
+	patterns    []*regexp.Regexp
+	replacement []byte
+}
+
+func compileSymlinks(s *SymlinkRules) (*compiledSymlinks, error) {
+	if s == nil {
+		return nil, nil
+	}
+	cs := &compiledSymlinks{mode: s.Mode, replacement: []byte(s.Replacement)}
+	switch s.Mode {
+	case SymlinkRewrite, SymlinkDrop, SymlinkFail:
+	default:
+		return nil, fmt.Errorf("invalid symlink mode %q (want rewrite, drop or fail)", s.Mode)
+	}
+	patterns := s.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{DefaultSymlinkPattern}
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid symlink pattern %q: %w", p, err)
+		}
+		cs.patterns = append(cs.patterns, re)
+	}
+	return cs, nil
+}
+
+// SymlinkError reports a published symlink whose target matches a symlink
+// pattern under SymlinkFail.
+type SymlinkError struct {
+	Path   string
+	Commit string
+	Target string
+}
+
+func (e SymlinkError) Error() string {
+	return fmt.Sprintf("symlink %s in commit %s points to %q; set the symlink mode to %q or %q, or exclude the path", e.Path, e.Commit, e.Target, SymlinkRewrite, SymlinkDrop)
+}
+
+// symlinkMatch reports whether a published blob could be a symlink target
+// matching the symlink patterns.
+func (c CompiledRules) symlinkMatch(content []byte) bool {
+	if c.symlinks == nil || len(content) == 0 || len(content) > symlinkMaxTarget {
+		return false
+	}
+	for _, re := range c.symlinks.patterns {
+		if re.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteSymlink applies the SymlinkRewrite replacement to a target.
+func (c CompiledRules) rewriteSymlink(target []byte) []byte {
+	for _, re := range c.symlinks.patterns {
+		target = re.ReplaceAll(target, c.symlinks.replacement)
+	}
+	return target
+}
+
+// noteSymlinkCandidate remembers the published content of the blob at mark
+// if it would match the symlink patterns, so filterOps can apply the policy
+// when a commit uses it as a symlink.
+func (f *ExportFilter) noteSymlinkCandidate(mark string, content []byte) {
+	if mark != "" && f.rules.symlinkMatch(content) {
+		f.symlinkTargets[mark] = append([]byte(nil), content...)
+	}
+}
+
+// filterSymlink applies the symlink policy to "M 120000 dataref path". It
+// returns the op to publish, or "" to drop it; ok is false when the target
+// doesn't match and the op is handled as usual.
+func (f *ExportFilter) filterSymlink(dataref, path string) (op string, ok bool, err error) {
+	target, hit := f.symlinkTargets[dataref]
+	if !hit {
+		return "", false, nil
+	}
+	switch f.rules.symlinks.mode {
+	case SymlinkDrop:
+		return "", true, nil
+	case SymlinkFail:
+		return "", true, SymlinkError{Path: path, Commit: f.curCommit, Target: string(target)}
+	}
+	rewritten := f.rules.rewriteSymlink(target)
+	if len(rewritten) == 0 {
+		return "", true, nil
+	}
+	mark, seen := f.symlinkMarks[dataref]
+	if !seen {
+		mark = f.newMark()
+		f.symlinkMarks[dataref] = mark
+		f.pendingBlobs = append(f.pendingBlobs, pendingBlob{mark: mark, content: rewritten})
+	}
+	return fmt.Sprintf("M 120000 %s %s\n", mark, quotePath(path)), true, nil
+}
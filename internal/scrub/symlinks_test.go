@@ -0,0 +1,100 @@
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestExportFilter_SymlinkRewriteAndDrop(t *testing.T) {
+	repo := newFilterTestRepo(t)
+	for link, target := range map[string]string{
+		"data":       "/home/obinnaokechukwu/src/app/testdata",
+		"cache":      "/var/cache/app",
+		"docs/guide": "../README.md",
+	} {
+		full := filepath.Join(repo, filepath.FromSlash(link))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.Symlink(target, full); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+	// A regular file with an absolute path as content is not a symlink.
+	commitFiles(t, repo, map[string]string{"README.md": "readme\n", "path.txt": "/var/cache/app"}, "Add links")
+
+	for _, tc := range []struct {
+		name  string
+		rules Rules
+	}{
+		{"streamed", Rules{}},
+		// Path-scoped replacements defer blob rewriting to the commit.
+		{"deferred", Rules{PathReplacements: []PathReplacements{{Patterns: []string{"*.md"}, Replacements: map[string]string{"readme": "README"}}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.rules
+			r.PrivateUsername, r.Replacement = "obinnaokechukwu", "johndoe"
+			r.Symlinks = &SymlinkRules{Mode: SymlinkRewrite, Patterns: []string{`^/home/johndoe/src/app/`, `^/var/.*`}}
+			rules, err := Compile(r)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			bare := filepath.Join(t.TempDir(), "out.git")
+			runExportFilterImport(t, repo, bare, rules)
+
+			ls, err := gitx.Run(nil, bare, "ls-tree", "-r", "refs/heads/main")
+			if err != nil {
+				t.Fatalf("ls-tree: %v", err)
+			}
+			if strings.Contains(ls.Stdout, "\tcache\n") {
+				t.Errorf("symlink rewritten to nothing was published:\n%s", ls.Stdout)
+			}
+			for p, want := range map[string]string{"data": "testdata", "docs/guide": "../README.md", "path.txt": "/var/cache/app"} {
+				show, err := gitx.Run(nil, bare, "show", "refs/heads/main:"+p)
+				if err != nil || show.Stdout != want {
+					t.Errorf("%s = %q (%v), want %q", p, show.Stdout, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExportFilter_SymlinkFail(t *testing.T) {
+	target := "/home/obinnaokechukwu/notes"
+	stream := strings.Join([]string{
+		"blob", "mark :1", "data " + strconv.Itoa(len(target)), target,
+		"commit refs/heads/main", "mark :2",
+		"author A <a@example.com> 0 +0000", "committer A <a@example.com> 0 +0000", "data 0",
+		"M 120000 :1 notes", "", "",
+	}, "\n")
+	for _, mode := range []string{SymlinkFail, SymlinkDrop} {
+		rules, err := Compile(Rules{
+			PrivateUsername: "obinnaokechukwu",
+			Replacement:     "johndoe",
+			Symlinks:        &SymlinkRules{Mode: mode},
+		})
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		var out bytes.Buffer
+		err = NewExportFilter(rules).Filter(strings.NewReader(stream), &out)
+		var serr SymlinkError
+		switch mode {
+		case SymlinkFail:
+			if !errors.As(err, &serr) || serr.Path != "notes" || serr.Target != "/home/johndoe/notes" {
+				t.Errorf("fail mode: err = %v, want a SymlinkError for notes", err)
+			}
+		case SymlinkDrop:
+			if err != nil || strings.Contains(out.String(), "M 120000") {
+				t.Errorf("drop mode: err = %v, output still has the symlink:\n%s", err, out.String())
+			}
+		}
+	}
+}
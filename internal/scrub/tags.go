@@ -0,0 +1,80 @@
+package scrub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tag modes for TagRules.Mode.
+const (
+	// TagsAll publishes lightweight and annotated tags.
+	TagsAll = "all"
+	// TagsNone publishes no tags.
+	TagsNone = "none"
+	// TagsAnnotated publishes annotated tags only.
+	TagsAnnotated = "annotated"
+)
+
+// TagRules control which tags are published and how.
+type TagRules struct {
+	Mode string
+	// Patterns, if non-empty, publishes only tags whose name (without
+	// refs/tags/) matches a glob such as "v*".
+	Patterns []string
+	// StripMessages publishes annotated tags with an empty message.
+	StripMessages bool
+}
+
+type compiledTags struct {
+	mode          string
+	patterns      []string
+	stripMessages bool
+}
+
+func compileTags(t *TagRules) (*compiledTags, error) {
+	if t == nil {
+		return nil, nil
+	}
+	ct := &compiledTags{mode: t.Mode, patterns: compileRefPatterns(t.Patterns), stripMessages: t.StripMessages}
+	switch ct.mode {
+	case "", TagsAll:
+		ct.mode = TagsAll
+	case TagsNone, TagsAnnotated:
+	default:
+		return nil, fmt.Errorf("invalid tag mode %q (want all, none or annotated)", t.Mode)
+	}
+	if ct.mode == TagsAll && len(ct.patterns) == 0 && !ct.stripMessages {
+		return nil, nil
+	}
+	return ct, nil
+}
+
+// publishTag reports whether the tag policy keeps the tag name. Whether a
+// tag is annotated is checked by the filter.
+func (c CompiledRules) publishTag(name string) bool {
+	t := c.tags
+	if t == nil {
+		return true
+	}
+	if t.mode == TagsNone {
+		return false
+	}
+	if len(t.patterns) == 0 {
+		return true
+	}
+	for _, pat := range t.patterns {
+		if matchGlob(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lightweightTagRef reports whether commit and reset records on ref, which
+// would create a lightweight tag, must not be published under it.
+func (c CompiledRules) lightweightTagRef(ref string) bool {
+	return c.tags != nil && c.tags.mode == TagsAnnotated && strings.HasPrefix(ref, "refs/tags/")
+}
+
+// stripTagMessages reports whether annotated tag messages are emptied.
+func (c CompiledRules) stripTagMessages() bool { return c.tags != nil && c.tags.stripMessages }
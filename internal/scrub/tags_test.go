@@ -0,0 +1,86 @@
+package scrub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const tagTestStream = `blob
+mark :1
+data 2
+a
+
+commit refs/heads/main
+mark :2
+author A <a@example.com> 1700000000 +0000
+committer A <a@example.com> 1700000000 +0000
+data 4
+one
+M 100644 :1 a.txt
+
+reset refs/tags/lw
+from :2
+
+tag v1
+mark :3
+from :2
+tagger A <a@example.com> 1700000100 +0000
+data 7
+release
+tag rc1
+mark :4
+from :2
+tagger A <a@example.com> 1700000100 +0000
+data 3
+rc1
+tag outer
+mark :5
+from :4
+tagger A <a@example.com> 1700000100 +0000
+data 5
+outer
+`
+
+func filterTags(t *testing.T, tr *TagRules) string {
+	t.Helper()
+	rules, err := Compile(Rules{PrivateUsername: "obinnaokechukwu", Replacement: "johndoe", Tags: tr})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewExportFilter(rules).Filter(strings.NewReader(tagTestStream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	return out.String()
+}
+
+func TestExportFilter_TagPolicies(t *testing.T) {
+	out := filterTags(t, &TagRules{Mode: TagsNone})
+	if strings.Contains(out, "tag ") || strings.Contains(out, "refs/tags/") {
+		t.Errorf("mode none published tags:\n%s", out)
+	}
+
+	out = filterTags(t, &TagRules{Mode: TagsAnnotated})
+	if strings.Contains(out, "refs/tags/lw") {
+		t.Errorf("mode annotated published a lightweight tag:\n%s", out)
+	}
+	if !strings.Contains(out, "tag v1\n") {
+		t.Errorf("mode annotated dropped an annotated tag:\n%s", out)
+	}
+
+	// outer points at rc1, which is dropped; it is re-pointed at rc1's commit.
+	out = filterTags(t, &TagRules{Patterns: []string{"v*", "outer"}})
+	if strings.Contains(out, "tag rc1") || !strings.Contains(out, "tag outer\nmark :5\nfrom :2\n") {
+		t.Errorf("pattern filtering did not remap the dependent tag:\n%s", out)
+	}
+
+	out = filterTags(t, &TagRules{StripMessages: true})
+	if strings.Contains(out, "release") || !strings.Contains(out, "tag v1\nmark :3\nfrom :2\ntagger johndoe <johndoe@example.invalid> 1700000100 +0000\ndata 0\n") {
+		t.Errorf("tag message not stripped:\n%s", out)
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", Tags: &TagRules{Mode: "signed"}}); err == nil {
+		t.Errorf("expected error for unknown tag mode")
+	}
+}
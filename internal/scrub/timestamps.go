@@ -0,0 +1,167 @@
+package scrub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp modes for TimestampRules.Mode.
+const (
+	// TimestampKeep publishes timestamps unchanged.
+	TimestampKeep = "keep"
+	// TimestampDay rounds every timestamp down to midnight in its own time zone.
+	TimestampDay = "day"
+	// TimestampShift adds TimestampRules.Shift to every timestamp.
+	TimestampShift = "shift"
+	// TimestampRespace replaces commit times with an even sequence starting at
+	// midnight (UTC) of the first commit's day, TimestampRules.Interval apart.
+	TimestampRespace = "respace"
+)
+
+// DefaultRespaceInterval is the gap between commits in TimestampRespace mode.
+const DefaultRespaceInterval = time.Hour
+
+// TimestampRules controls how author, committer and tagger timestamps are
+// published, so the public history doesn't reveal private working hours.
+type TimestampRules struct {
+	Mode string
+	// Shift is added to every timestamp in TimestampShift mode.
+	Shift time.Duration
+	// Interval separates consecutive commits in TimestampRespace mode
+	// (DefaultRespaceInterval if zero).
+	Interval time.Duration
+	// Timezone, if set, replaces every zone offset (e.g. "+0000") without
+	// changing the instant, so dates and commit order are kept. Combined with
+	// TimestampDay, timestamps are rounded to midnight in this zone.
+	Timezone string
+}
+
+func compileTimestamps(t *TimestampRules) (*TimestampRules, error) {
+	if t == nil {
+		return nil, nil
+	}
+	out := *t
+	if out.Timezone != "" && !validTimezone(out.Timezone) {
+		return nil, fmt.Errorf("invalid timestamp timezone %q (want +hhmm or -hhmm)", out.Timezone)
+	}
+	switch out.Mode {
+	case "", TimestampKeep:
+		if out.Timezone == "" {
+			return nil, nil
+		}
+		out.Mode = TimestampKeep
+	case TimestampDay, TimestampShift:
+	case TimestampRespace:
+		if out.Interval < 0 {
+			return nil, fmt.Errorf("timestamp respace interval must not be negative")
+		}
+		if out.Interval == 0 {
+			out.Interval = DefaultRespaceInterval
+		}
+	default:
+		return nil, fmt.Errorf("invalid timestamp mode %q (want keep, day, shift or respace)", out.Mode)
+	}
+	return &out, nil
+}
+
+// splitIdentityTime splits an identity line ("kind Name <email> 1700000000
+// +0100\n") into the part up to the email, the unix time and the zone offset.
+func splitIdentityTime(line string) (head string, when int64, tz string, ok bool) {
+	line = strings.TrimRight(line, "\n")
+	i := strings.LastIndex(line, ">")
+	if i < 0 {
+		return "", 0, "", false
+	}
+	fields := strings.Fields(line[i+1:])
+	if len(fields) != 2 {
+		return "", 0, "", false
+	}
+	when, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+	return line[:i+1], when, fields[1], true
+}
+
+// validTimezone reports whether tz is a "+hhmm" zone offset git accepts.
+func validTimezone(tz string) bool {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return false
+	}
+	hh, err1 := strconv.Atoi(tz[1:3])
+	mm, err2 := strconv.Atoi(tz[3:5])
+	return err1 == nil && err2 == nil && hh < 24 && mm < 60
+}
+
+// tzOffsetSeconds parses a "+hhmm" zone offset.
+func tzOffsetSeconds(tz string) int64 {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return 0
+	}
+	hh, err1 := strconv.Atoi(tz[1:3])
+	mm, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	off := int64(hh*3600 + mm*60)
+	if tz[0] == '-' {
+		off = -off
+	}
+	return off
+}
+
+// tickCommitClock advances the respace clock for a new commit, seeding it
+// from the commit's committer (or author) line.
+func (f *ExportFilter) tickCommitClock(identityLine string) {
+	ts := f.rules.timestamps
+	if ts == nil || ts.Mode != TimestampRespace {
+		return
+	}
+	if f.clockSet {
+		f.clock += int64(ts.Interval / time.Second)
+		return
+	}
+	if _, when, _, ok := splitIdentityTime(identityLine); ok {
+		f.clock = when - mod(when, 86400)
+		f.clockSet = true
+	}
+}
+
+// retime rewrites the timestamp of an identity line according to the
+// configured timestamp rules.
+func (f *ExportFilter) retime(line string) string {
+	ts := f.rules.timestamps
+	if ts == nil {
+		return line
+	}
+	head, when, tz, ok := splitIdentityTime(line)
+	if !ok {
+		return line
+	}
+	if ts.Timezone != "" {
+		tz = ts.Timezone
+	}
+	switch ts.Mode {
+	case TimestampDay:
+		local := when + tzOffsetSeconds(tz)
+		when -= mod(local, 86400)
+	case TimestampShift:
+		when += int64(ts.Shift / time.Second)
+	case TimestampRespace:
+		if !f.clockSet {
+			return fmt.Sprintf("%s %d %s\n", head, when, tz)
+		}
+		when = f.clock
+	}
+	return fmt.Sprintf("%s %d %s\n", head, when, tz)
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
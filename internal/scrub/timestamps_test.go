@@ -0,0 +1,92 @@
+package scrub
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const timestampTestStream = `blob
+mark :1
+data 2
+a
+
+commit refs/heads/main
+mark :2
+author Private <p@example.com> 1700040000 +0200
+committer Private <p@example.com> 1700043600 +0200
+data 6
+first
+M 100644 :1 a.txt
+
+commit refs/heads/main
+mark :3
+author Private <p@example.com> 1700200000 -0500
+committer Private <p@example.com> 1700200000 -0500
+data 7
+second
+from :2
+M 100644 :1 b.txt
+
+tag v1
+from :3
+tagger Private <p@example.com> 1700300000 +0000
+data 4
+tag
+
+`
+
+func filterTimestamps(t *testing.T, ts *TimestampRules) []string {
+	t.Helper()
+	rules, err := Compile(Rules{
+		PrivateUsername: "obinnaokechukwu",
+		Replacement:     "johndoe",
+		Timestamps:      ts,
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewExportFilter(rules).Filter(strings.NewReader(timestampTestStream), &out); err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	re := regexp.MustCompile(`(?m)^(?:author|committer|tagger) .*> (\d+ [+-]\d{4})$`)
+	var got []string
+	for _, m := range re.FindAllStringSubmatch(out.String(), -1) {
+		got = append(got, m[1])
+	}
+	return got
+}
+
+func TestExportFilter_Timestamps(t *testing.T) {
+	cases := []struct {
+		name string
+		ts   *TimestampRules
+		want []string
+	}{
+		{"keep", nil, []string{"1700040000 +0200", "1700043600 +0200", "1700200000 -0500", "1700200000 -0500", "1700300000 +0000"}},
+		// 1700040000 is 2023-11-15 11:20 at +0200; midnight there is 1699999200.
+		{"day", &TimestampRules{Mode: TimestampDay}, []string{"1699999200 +0200", "1699999200 +0200", "1700197200 -0500", "1700197200 -0500", "1700265600 +0000"}},
+		{"shift", &TimestampRules{Mode: TimestampShift, Shift: -time.Hour}, []string{"1700036400 +0200", "1700040000 +0200", "1700196400 -0500", "1700196400 -0500", "1700296400 +0000"}},
+		// Seeded at UTC midnight of the first commit (1700006400), then one step per commit.
+		{"respace", &TimestampRules{Mode: TimestampRespace, Interval: 2 * time.Hour}, []string{"1700006400 +0200", "1700006400 +0200", "1700013600 -0500", "1700013600 -0500", "1700013600 +0000"}},
+		{"timezone", &TimestampRules{Timezone: "+0000"}, []string{"1700040000 +0000", "1700043600 +0000", "1700200000 +0000", "1700200000 +0000", "1700300000 +0000"}},
+		// Rounded to midnight in the published zone.
+		{"day in UTC", &TimestampRules{Mode: TimestampDay, Timezone: "+0000"}, []string{"1700006400 +0000", "1700006400 +0000", "1700179200 +0000", "1700179200 +0000", "1700265600 +0000"}},
+	}
+	for _, tc := range cases {
+		got := filterTimestamps(t, tc.ts)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: timestamps = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", Timestamps: &TimestampRules{Mode: "hourly"}}); err == nil {
+		t.Errorf("expected error for unknown timestamp mode")
+	}
+	if _, err := Compile(Rules{PrivateUsername: "a", Replacement: "b", Timestamps: &TimestampRules{Timezone: "UTC"}}); err == nil {
+		t.Errorf("expected error for invalid timezone")
+	}
+}
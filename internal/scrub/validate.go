@@ -5,8 +5,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os/exec"
+	"regexp"
+	"runtime"
 	"strings"
 	"time"
 
@@ -19,9 +19,48 @@ type ValidationError struct {
 
 func (e ValidationError) Error() string { return e.Reason }
 
+// ValidateOptions controls the invariants checked by ValidateScrubbedRepoWithOptions.
+type ValidateOptions struct {
+	PrivateUsername string
+	// AdditionalUsernames are checked alongside PrivateUsername
+	// (Rules.AdditionalUsernames).
+	AdditionalUsernames []string
+	ForbiddenPaths      []string
+	// MatchWholeWord only flags standalone occurrences of PrivateUsername,
+	// mirroring Rules.MatchWholeWord.
+	MatchWholeWord bool
+	// SkipBinaryBlobs ignores objects that look binary (NUL in the first 8000
+	// bytes), mirroring Rules.SkipBinaryBlobs.
+	SkipBinaryBlobs bool
+	// ForbiddenPatterns are regular expressions (internal hostnames, email
+	// domains, ticket prefixes) that must not match any object.
+	ForbiddenPatterns []string
+	// Parallelism is the number of cat-file processes scanning objects
+	// (0 = one per CPU). Small repos always use one.
+	Parallelism int
+}
+
 func ValidateScrubbedRepo(ctx context.Context, bareRepoPath string, privateUsername string, forbiddenPaths []string) error {
-	if privateUsername == "" {
-		return nil
+	return ValidateScrubbedRepoWithOptions(ctx, bareRepoPath, ValidateOptions{
+		PrivateUsername: privateUsername,
+		ForbiddenPaths:  forbiddenPaths,
+	})
+}
+
+func ValidateScrubbedRepoWithOptions(ctx context.Context, bareRepoPath string, opts ValidateOptions) error {
+	privateUsername := opts.PrivateUsername
+	forbiddenPaths := opts.ForbiddenPaths
+	if strings.TrimSpace(privateUsername) == "" {
+		// Checking for nothing would pass any repo.
+		return ValidationError{Reason: "no private username to check the scrubbed repo for"}
+	}
+	patterns := make([]*regexp.Regexp, 0, len(opts.ForbiddenPatterns))
+	for _, p := range opts.ForbiddenPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid forbidden pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, re)
 	}
 
 	// 1) Ensure forbidden paths do not exist in any tree
@@ -54,66 +93,114 @@ func ValidateScrubbedRepo(ctx context.Context, bareRepoPath string, privateUsern
 		}
 	}
 
-	// 2) Search all object contents for private username
+	// 2) Search all object contents for private username and forbidden patterns
 	if ctx == nil {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Minute)
 		defer cancel()
 	}
-	cmd := exec.CommandContext(ctx, "git", "cat-file", "--batch-all-objects", "--batch")
-	cmd.Dir = bareRepoPath
-	stdout, err := cmd.StdoutPipe()
+	needles := [][]byte{bytes.ToLower([]byte(privateUsername))}
+	for _, u := range opts.AdditionalUsernames {
+		if u = strings.TrimSpace(u); u != "" {
+			needles = append(needles, bytes.ToLower([]byte(u)))
+		}
+	}
+	check := func(oid, typ string, buf []byte) error {
+		if opts.SkipBinaryBlobs && typ == "blob" && IsBinaryContent(buf) {
+			return nil
+		}
+		// Case-insensitive check for each private username
+		header := []byte(oid + " " + typ)
+		for _, needleLower := range needles {
+			if containsFold(buf, needleLower, opts.MatchWholeWord) || containsFold(header, needleLower, opts.MatchWholeWord) {
+				return ValidationError{Reason: "private username still present in scrubbed git objects"}
+			}
+		}
+		for _, re := range patterns {
+			if re.Match(buf) {
+				return ValidationError{Reason: fmt.Sprintf("forbidden pattern %q matches scrubbed %s %s", re, typ, oid)}
+			}
+		}
+		return nil
+	}
+
+	oids, err := gitx.ListObjects(ctx, bareRepoPath)
 	if err != nil {
 		return err
 	}
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Start(); err != nil {
-		return err
+	return scanObjects(ctx, bareRepoPath, oids, ScanWorkers(opts.Parallelism, len(oids)), check)
+}
+
+// minObjectsPerWorker keeps small repos on a single cat-file process, where
+// starting more would cost more than it saves.
+const minObjectsPerWorker = 1000
+
+// ScanWorkers returns how many cat-file processes scan n objects, given the
+// requested parallelism (0 = one per CPU). Validation and audit share it.
+func ScanWorkers(parallelism, n int) int {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
 	}
+	if limit := (n + minObjectsPerWorker - 1) / minObjectsPerWorker; parallelism > limit {
+		parallelism = limit
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return parallelism
+}
 
-	needleLower := bytes.ToLower([]byte(privateUsername))
-	privateUsernameLower := strings.ToLower(privateUsername)
-	br := bufio.NewReader(stdout)
-	for {
-		h, err := br.ReadString('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			_ = cmd.Process.Kill()
-			return err
-		}
-		h = strings.TrimSpace(h)
-		if h == "" {
-			continue
-		}
-		parts := strings.Split(h, " ")
-		if len(parts) < 3 {
-			continue
-		}
-		var size int
-		_, _ = fmt.Sscanf(parts[2], "%d", &size)
-		if size < 0 {
-			size = 0
+// scanObjects runs check on every object in oids, sharded round-robin across
+// workers cat-file processes so large blobs spread evenly. The first error
+// stops the other workers.
+func scanObjects(ctx context.Context, repoPath string, oids []string, workers int, check func(oid, typ string, buf []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		shard := make([]string, 0, len(oids)/workers+1)
+		for i := w; i < len(oids); i += workers {
+			shard = append(shard, oids[i])
 		}
-		buf := make([]byte, size)
-		if _, err := io.ReadFull(br, buf); err != nil {
-			_ = cmd.Process.Kill()
-			return err
+		go func() { errs <- gitx.ReadObjects(ctx, repoPath, shard, check) }()
+	}
+	var first error
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
 		}
-		// Consume trailing newline after object payload
-		_, _ = br.ReadByte()
+	}
+	return first
+}
 
-		// Case-insensitive check for private username
-		if bytes.Contains(bytes.ToLower(buf), needleLower) || strings.Contains(strings.ToLower(h), privateUsernameLower) {
-			_ = cmd.Process.Kill()
-			return ValidationError{Reason: "private username still present in scrubbed git objects"}
+// containsFold reports whether b contains the lowercased needle, ignoring case.
+// With wholeWord set, matches adjacent to ASCII word characters are ignored.
+func containsFold(b, needleLower []byte, wholeWord bool) bool {
+	return IndexWord(bytes.ToLower(b), needleLower, wholeWord) >= 0
+}
+
+// IndexWord returns the offset of the first occurrence of needle in b, or -1.
+// With wholeWord set, occurrences adjacent to ASCII word characters are skipped.
+func IndexWord(b, needle []byte, wholeWord bool) int {
+	if !wholeWord {
+		return bytes.Index(b, needle)
+	}
+	for off := 0; ; {
+		i := bytes.Index(b[off:], needle)
+		if i < 0 {
+			return -1
+		}
+		start := off + i
+		end := start + len(needle)
+		if (start == 0 || !isWordByte(b[start-1])) && (end == len(b) || !isWordByte(b[end])) {
+			return start
 		}
+		off = start + 1
 	}
+}
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("git cat-file validation failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
-	}
-	return nil
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
 }
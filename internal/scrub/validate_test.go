@@ -2,8 +2,11 @@ package scrub
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
@@ -83,3 +86,106 @@ func TestValidateScrubbedRepo_PassesWhenClean(t *testing.T) {
 		t.Fatalf("expected pass, got: %v", err)
 	}
 }
+
+func TestValidateScrubbedRepo_FailsWithoutUsername(t *testing.T) {
+	repo := initRepo(t, "hello world")
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if _, err := gitx.Run(nil, "", "clone", "--bare", repo, bare); err != nil {
+		t.Fatalf("clone --bare: %v", err)
+	}
+
+	opts := ValidateOptions{AdditionalUsernames: []string{"workacct"}, ForbiddenPatterns: []string{`TICKET-[0-9]+`}}
+	err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts)
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected an empty username to fail validation, got: %v", err)
+	}
+}
+
+func TestValidateScrubbedRepo_MatchWholeWordIgnoresEmbedded(t *testing.T) {
+	repo := initRepo(t, "benchmark results\n")
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if _, err := gitx.Run(nil, "", "clone", "--bare", repo, bare); err != nil {
+		t.Fatalf("clone --bare: %v", err)
+	}
+
+	opts := ValidateOptions{PrivateUsername: "ben", MatchWholeWord: true}
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err != nil {
+		t.Fatalf("expected pass, got: %v", err)
+	}
+	opts.MatchWholeWord = false
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err == nil {
+		t.Fatalf("expected substring match to fail validation")
+	}
+}
+
+func TestValidateScrubbedRepo_ChecksAdditionalUsernames(t *testing.T) {
+	repo := initRepo(t, "hello workacct\n")
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if _, err := gitx.Run(nil, "", "clone", "--bare", repo, bare); err != nil {
+		t.Fatalf("clone --bare: %v", err)
+	}
+
+	opts := ValidateOptions{PrivateUsername: "obinnaokechukwu"}
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err != nil {
+		t.Fatalf("expected pass, got: %v", err)
+	}
+	opts.AdditionalUsernames = []string{"workacct"}
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err == nil {
+		t.Fatalf("expected additional username to fail validation")
+	}
+}
+
+func TestValidateScrubbedRepo_ForbiddenPatterns(t *testing.T) {
+	repo := initRepo(t, "see build.corp.internal for details\n")
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if _, err := gitx.Run(nil, "", "clone", "--bare", repo, bare); err != nil {
+		t.Fatalf("clone --bare: %v", err)
+	}
+
+	opts := ValidateOptions{PrivateUsername: "obinnaokechukwu", ForbiddenPatterns: []string{`\bTICKET-[0-9]+\b`}}
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err != nil {
+		t.Fatalf("expected pass, got: %v", err)
+	}
+	opts.ForbiddenPatterns = append(opts.ForbiddenPatterns, `[a-z0-9.-]+\.corp\.internal`)
+	err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts)
+	var verr ValidationError
+	if !errors.As(err, &verr) || !strings.Contains(verr.Reason, "corp") {
+		t.Fatalf("expected forbidden pattern to fail validation, got: %v", err)
+	}
+}
+
+func TestValidateScrubbedRepo_ParallelScanFindsUsername(t *testing.T) {
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if err := gitx.InitEmptyBare(bare); err != nil {
+		t.Fatalf("init --bare: %v", err)
+	}
+	// Enough objects for several workers; only one mentions the username.
+	var stream strings.Builder
+	for i := 0; i < 3*minObjectsPerWorker; i++ {
+		content := fmt.Sprintf("blob %d\n", i)
+		if i == 2*minObjectsPerWorker+7 {
+			content = "written by obinnaokechukwu\n"
+		}
+		fmt.Fprintf(&stream, "blob\ndata %d\n%s\n", len(content), content)
+	}
+	cmd := gitx.FastImportCmd(bare)
+	cmd.Stdin = strings.NewReader(stream.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("fast-import: %v\n%s", err, out)
+	}
+
+	if got := ScanWorkers(4, 3*minObjectsPerWorker); got != 3 {
+		t.Errorf("ScanWorkers = %d, want 3 (one per %d objects)", got, minObjectsPerWorker)
+	}
+	opts := ValidateOptions{PrivateUsername: "obinnaokechukwu", Parallelism: 4}
+	err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts)
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected the username to fail validation, got: %v", err)
+	}
+	opts.PrivateUsername = "someoneelse"
+	if err := ValidateScrubbedRepoWithOptions(context.Background(), bare, opts); err != nil {
+		t.Fatalf("expected pass, got: %v", err)
+	}
+}
@@ -18,6 +18,90 @@ type TargetState struct {
 	LastPrivateRefs string    `json:"last_private_refs,omitempty"` // hash of refs snapshot
 	LastPublicPush  string    `json:"last_public_push,omitempty"`  // hash of refs snapshot from scrubbed repo
 	LastConfigHash  string    `json:"last_config_hash,omitempty"`  // hash of config affecting scrubbing/push
+	SquashBase      string    `json:"squash_base,omitempty"`       // private commit published as the baseline ("squash"/"future" history modes)
+	SquashHide      []string  `json:"squash_hide,omitempty"`       // commits hidden by the first "future" sync, in states written before they moved to a file
+	SquashHideCount int       `json:"squash_hide_count,omitempty"` // number of commits in the target's squash-hide file
+	AdoptedBase     string    `json:"adopted_base,omitempty"`      // target commit the scrubbed history was grafted onto (adopt mode "graft")
+	HistoryCutoff   string    `json:"history_cutoff,omitempty"`    // set by sync --since; overrides the target's history_cutoff
+	// AuditedTips are the ref tips of the scrubbed cache at its last clean
+	// audit, made with options hashing to AuditOptionsHash. Later audits
+	// only scan history added since.
+	AuditedTips      []string `json:"audited_tips,omitempty"`
+	AuditOptionsHash string   `json:"audit_options_hash,omitempty"`
+	// ReplacementCheckTips are the private ref tips at the last replacement
+	// check, made for the replacements in CheckedReplacements in
+	// ReplacementCheckMode. Later checks only scan history added since.
+	ReplacementCheckTips []string `json:"replacement_check_tips,omitempty"`
+	CheckedReplacements  []string `json:"checked_replacements,omitempty"`
+	ReplacementCheckMode string   `json:"replacement_check_mode,omitempty"`
+	// PushedRefs are the refs of the last successful push, by name.
+	PushedRefs map[string]string `json:"pushed_refs,omitempty"`
+	// Remote identifies the target's repo as of the last successful push,
+	// so a repo since deleted and recreated at its URL isn't pushed to
+	// unawares.
+	Remote *RemoteIdentity `json:"remote,omitempty"`
+	// Mirrors are the states of the target's mirrors, by URL.
+	Mirrors map[string]*MirrorState `json:"mirrors,omitempty"`
+	// LastPushAttempts is the number of tries the last push took.
+	LastPushAttempts int `json:"last_push_attempts,omitempty"`
+	// VerifyFailures are the refs the target didn't have as pushed when the
+	// last push was verified, if that failed.
+	VerifyFailures []RefMismatch `json:"verify_failures,omitempty"`
+	// History holds the metrics of the most recent syncs, oldest first.
+	History []SyncRecord `json:"history,omitempty"`
+}
+
+// MirrorState is the state of a target's mirror, like that of the target:
+// its last successful push and the error of the last push, if that failed.
+type MirrorState struct {
+	LastSyncAt time.Time         `json:"last_sync_at"`
+	LastError  string            `json:"last_error,omitempty"`
+	PushedRefs map[string]string `json:"pushed_refs,omitempty"`
+	Remote     *RemoteIdentity   `json:"remote,omitempty"`
+}
+
+// RemoteIdentity identifies a target's repo at URL. ID and Name, its id
+// and "account/name" on the hosting service, are only known for providers
+// with an API git-copy can ask.
+type RemoteIdentity struct {
+	URL  string `json:"url"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Head is the ref the repo's HEAD named.
+	Head string `json:"head,omitempty"`
+}
+
+// RefMismatch is a target ref that doesn't hold the pushed value. Target
+// is empty if the target lacks the ref, Pushed if the push deleted it.
+type RefMismatch struct {
+	Ref    string `json:"ref"`
+	Target string `json:"target"`
+	Pushed string `json:"pushed"`
+}
+
+// SyncRecord is the metrics of one sync of a target.
+type SyncRecord struct {
+	At         time.Time `json:"at"`
+	DurationMS int64     `json:"duration_ms"`
+	// Incremental is set when only the commits added since the previous
+	// sync were filtered, which Commits and BlobsRewritten then count.
+	Incremental    bool   `json:"incremental,omitempty"`
+	Commits        int    `json:"commits"`
+	BlobsRewritten int    `json:"blobs_rewritten"`
+	BytesPushed    int64  `json:"bytes_pushed"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MaxHistory is the number of syncs TargetState.History keeps.
+const MaxHistory = 20
+
+// RecordSync appends r to the target's history, dropping the oldest
+// records beyond MaxHistory.
+func (ts *TargetState) RecordSync(r SyncRecord) {
+	ts.History = append(ts.History, r)
+	if n := len(ts.History) - MaxHistory; n > 0 {
+		ts.History = append([]SyncRecord(nil), ts.History[n:]...)
+	}
 }
 
 func StatePath(repoPath string) string {
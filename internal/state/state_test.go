@@ -38,3 +38,16 @@ func TestState_SaveLoadRoundTrip(t *testing.T) {
 		t.Fatalf("unexpected state path: %s", StatePath(tmp))
 	}
 }
+
+func TestTargetState_RecordSyncKeepsRecent(t *testing.T) {
+	var ts TargetState
+	for i := 1; i <= MaxHistory+5; i++ {
+		ts.RecordSync(SyncRecord{Commits: i})
+	}
+	if len(ts.History) != MaxHistory {
+		t.Fatalf("expected %d records, got %d", MaxHistory, len(ts.History))
+	}
+	if first, last := ts.History[0].Commits, ts.History[MaxHistory-1].Commits; first != 6 || last != MaxHistory+5 {
+		t.Errorf("expected records 6..%d, got %d..%d", MaxHistory+5, first, last)
+	}
+}
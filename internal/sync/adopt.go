@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// adoptRef is the target branch adopt mode "graft" grafts onto; headRef is
+// the public ref of the head branch.
+func adoptRef(t config.Target, headRef string) string {
+	if b := strings.Trim(t.Adopt.Branch, "/"); b != "" {
+		return "refs/heads/" + b
+	}
+	return headRef
+}
+
+// targetAdoptBase returns the target commit the scrubbed history is grafted
+// onto in adopt mode "graft". The first sync pins the tip of the target's
+// adopt branch in the target state; targets that lack the branch, or that
+// git-copy pushed to before, have none.
+func targetAdoptBase(ctx context.Context, repoPath string, t config.Target, ts *state.TargetState, headRef string, opts Options) (string, error) {
+	if t.Adopt == nil || t.Adopt.Mode != config.AdoptGraft {
+		return "", nil
+	}
+	if ts.AdoptedBase != "" || len(ts.PushedRefs) > 0 {
+		return ts.AdoptedBase, nil
+	}
+	url := targetURL(repoPath, t)
+	if p, ok := gitx.LocalPath(url); ok {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return "", nil
+		}
+	}
+	env, err := targetPushEnv(t, opts)
+	if err != nil {
+		return "", err
+	}
+	refs, err := gitx.LsRemote(ctx, repoPath, url, env)
+	if err != nil {
+		return "", fmt.Errorf("adopt: %w", err)
+	}
+	ts.AdoptedBase = refs[adoptRef(t, headRef)]
+	return ts.AdoptedBase, nil
+}
+
+// fetchAdoptBase fetches the target's adopt branch, and with it the adopted
+// base, into the job's new import repo, for fast-import to graft onto.
+func fetchAdoptBase(ctx context.Context, job *targetJob) error {
+	ref := adoptRef(job.target, job.headRef)
+	if err := gitx.FetchObjects(ctx, job.tmpBare, job.target.RepoURL, []string{ref}, job.adoptEnv); err != nil {
+		return fmt.Errorf("adopt: %w", err)
+	}
+	if _, err := gitx.Run(ctx, job.tmpBare, "cat-file", "-e", job.adoptBase+"^{commit}"); err != nil {
+		return fmt.Errorf("adopt: target branch %s no longer has the adopted commit %s", ref, shortOID(job.adoptBase))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// commitMapFile, in the cache's incremental directory, maps every published
+// private commit to its public scrubbed commit, one "<private> <public>"
+// line per commit, sorted by private id. Commits the filter dropped (e.g.
+// skipped or squashed ones) map to the public commit that took their place.
+// Annotated tags, which the export marks too, are mapped the same way.
+const commitMapFile = "commit-map"
+
+// CommitMapping is a private commit and the public commit it became.
+type CommitMapping struct {
+	Private string
+	Public  string
+}
+
+// readMarks reads a fast-export or fast-import marks file into a map from
+// mark (":12") to object id. A missing file has no marks.
+func readMarks(path string) (map[string]string, error) {
+	marks := map[string]string{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if mark, oid, ok := strings.Cut(sc.Text(), " "); ok {
+			marks[mark] = oid
+		}
+	}
+	return marks, sc.Err()
+}
+
+// joinCommitMap joins the job's source and public marks, through the marks
+// the filter remapped, into a map from private to public commit.
+func joinCommitMap(job *targetJob) (map[string]string, error) {
+	source, err := readMarks(incrementalPath(job.tmpBare, sourceMarksFile))
+	if err != nil {
+		return nil, err
+	}
+	public, err := readMarks(incrementalPath(job.tmpBare, publicMarksFile))
+	if err != nil {
+		return nil, err
+	}
+	fs := job.filterState
+	m := map[string]string{}
+	for mark, private := range source {
+		if r, ok := fs.InputMarks[mark]; ok {
+			mark = r
+		}
+		if r, ok := fs.MarkMap[mark]; ok {
+			mark = r
+		}
+		if pub, ok := public[mark]; ok {
+			m[private] = pub
+		}
+	}
+	return m, nil
+}
+
+// writeCommitMap writes the joined marks into the commit map of the job's
+// temporary repo.
+func writeCommitMap(job *targetJob) error {
+	m, err := joinCommitMap(job)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for private, pub := range m {
+		lines = append(lines, private+" "+pub+"\n")
+	}
+	sort.Strings(lines)
+	return os.WriteFile(incrementalPath(job.tmpBare, commitMapFile), []byte(strings.Join(lines, "")), 0o644)
+}
+
+// replaceRefPrefix holds replace refs, named after the commit they replace.
+const replaceRefPrefix = "refs/replace/"
+
+// renameReplaceRefs renames the imported replace refs of the job's
+// temporary repo, which name private commits, after the public commits
+// those became. Replace refs for commits that weren't published are
+// dropped. It must run after writeCommitMap.
+func renameReplaceRefs(ctx context.Context, job *targetJob) error {
+	refs, err := gitx.ListRefs(job.tmpBare)
+	if err != nil {
+		return err
+	}
+	var public map[string]string
+	for _, ref := range sortedRefNames(refs) {
+		private, ok := strings.CutPrefix(ref, replaceRefPrefix)
+		if !ok {
+			continue
+		}
+		if public == nil {
+			if public, err = readCommitMap(job.tmpBare); err != nil {
+				return err
+			}
+		}
+		pub := public[private]
+		if pub == private {
+			continue
+		}
+		if pub != "" {
+			if _, err := gitx.Run(ctx, job.tmpBare, "update-ref", replaceRefPrefix+pub, refs[ref]); err != nil {
+				return err
+			}
+		}
+		if _, err := gitx.Run(ctx, job.tmpBare, "update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCommitMap reads the commit map of bare, by private commit id.
+func readCommitMap(bare string) (map[string]string, error) {
+	b, err := os.ReadFile(incrementalPath(bare, commitMapFile))
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if private, public, ok := strings.Cut(line, " "); ok {
+			m[private] = public
+		}
+	}
+	return m, nil
+}
+
+// LookupCommit returns the entries of the commit map of the cached scrubbed
+// repo bare whose private or public commit id starts with prefix.
+func LookupCommit(bare, prefix string) ([]CommitMapping, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("invalid commit id %q: want at least 4 hex digits", prefix)
+	}
+	f, err := os.Open(incrementalPath(bare, commitMapFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []CommitMapping
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		private, public, ok := strings.Cut(sc.Text(), " ")
+		if ok && (strings.HasPrefix(private, prefix) || strings.HasPrefix(public, prefix)) {
+			out = append(out, CommitMapping{Private: private, Public: public})
+		}
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+// providerTokenEnvs are the variables push tokens are read from, by
+// provider, for targets whose auth names no token_env.
+var providerTokenEnvs = map[string][]string{
+	"github": {"GH_TOKEN", "GITHUB_TOKEN"},
+	"gitlab": {"GITLAB_TOKEN"},
+	"gitea":  {"GITEA_TOKEN"},
+}
+
+// tokenCredentialHelper answers git's credential requests with the
+// username and token in GIT_COPY_USERNAME and GIT_COPY_TOKEN, so the token
+// never appears in a command line or config file.
+const tokenCredentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$GIT_COPY_USERNAME" "$GIT_COPY_TOKEN"; }; f`
+
+// envPushEnv is getPushEnv for Options.CredentialsFromEnv: for HTTPS URLs
+// it returns an environment giving git the token in the target's
+// auth.token_env, or else in its provider's usual variable, in place of any
+// configured credential helper. It fails if such a target has no token.
+func envPushEnv(t config.Target) ([]string, error) {
+	if !strings.HasPrefix(t.RepoURL, "https://") {
+		return nil, nil
+	}
+	token, names := envToken(t)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no push token for target %s: set %s", t.Label, strings.Join(names, " or "))
+	}
+	return []string{
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0=",
+		"GIT_CONFIG_KEY_1=credential.helper",
+		"GIT_CONFIG_VALUE_1=" + tokenCredentialHelper,
+		"GIT_COPY_USERNAME=" + tokenUsername(t),
+		"GIT_COPY_TOKEN=" + token,
+	}, nil
+}
+
+// envToken returns the token in the target's auth.token_env, or else in
+// the first set of its provider's usual variables, along with the names of
+// the variables it looked at.
+func envToken(t config.Target) (string, []string) {
+	names := providerTokenEnvs[t.Provider]
+	if t.Auth.TokenEnv != "" {
+		names = []string{t.Auth.TokenEnv}
+	}
+	for _, name := range names {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			return token, names
+		}
+	}
+	return "", names
+}
+
+// tokenUsername is the username the target's host expects with a token.
+func tokenUsername(t config.Target) string {
+	switch t.Provider {
+	case "github":
+		return "x-access-token"
+	case "gitlab":
+		return "oauth2"
+	}
+	if t.Account != "" {
+		return t.Account
+	}
+	return "git-copy"
+}
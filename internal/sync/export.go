@@ -0,0 +1,369 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// exportArgs returns the git fast-export arguments for a target. Targets with
+// identical arguments share one export pass. Original ids let the filter
+// re-attach notes to published commits; fast-import ignores them. Tags get
+// marks so that tags of tags can be exported. Targets with ref filters
+// export only their published refs, and replace refs are left out unless
+// the target publishes them.
+func exportArgs(job *targetJob) []string {
+	args := []string{"--signed-tags=strip", "--tag-of-filtered-object=rewrite", "--show-original-ids", "--mark-tags"}
+	args = append(args, job.exportExtra...)
+	if job.exportRefs != nil {
+		return append(args, job.exportRefs...)
+	}
+	if !job.target.ReplaceRefs {
+		return append([]string{"--exclude=" + replaceRefPrefix + "*", "--all"}, args...)
+	}
+	return append([]string{"--all"}, args...)
+}
+
+// runExports groups jobs by export arguments and runs one fast-export per
+// group, up to limit groups at once, fanning the stream out to every job's
+// filter and fast-import. Failures are recorded per job in job.err.
+func runExports(ctx context.Context, srcRepo string, jobs []*targetJob, limit int) {
+	var order []string
+	groups := map[string][]*targetJob{}
+	argsByKey := map[string][]string{}
+	for _, job := range jobs {
+		args := exportArgs(job)
+		key := strings.Join(append(args, sourceMarksKey(job)), "\x00")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+			argsByKey[key] = args
+		}
+		groups[key] = append(groups[key], job)
+	}
+	forEachLimit(len(order), limit, func(i int) {
+		key := order[i]
+		exportFilterImportMulti(ctx, srcRepo, argsByKey[key], groups[key])
+	})
+}
+
+// importSink is one target's filter -> fast-import pipeline fed by a shared
+// export. A dry run with an output file also writes the stream there.
+type importSink struct {
+	job       *targetJob
+	filter    *scrub.ExportFilter
+	imp       *exec.Cmd
+	impStderr bytes.Buffer
+	pw        *io.PipeWriter
+	filterErr chan error
+	active    bool
+	// verify is the second pipeline of a reproducibility check: the same
+	// export filtered again, hashed and compared with the output's hash.
+	verify *verifySink
+}
+
+type verifySink struct {
+	pw     *io.PipeWriter
+	active bool
+	err    chan error
+	// want hashes the sink's output; got is the hash of the second run's.
+	want hash.Hash
+	got  []byte
+}
+
+func exportFilterImportMulti(ctx context.Context, srcRepo string, args []string, jobs []*targetJob) {
+	fail := func(err error) {
+		for _, job := range jobs {
+			if job.err == nil {
+				job.err = err
+			}
+		}
+	}
+
+	// Jobs in a group resume from identical source marks (or none), so the
+	// first job's marks file serves the export and is copied to the others.
+	sourceMarks, err := filepath.Abs(incrementalPath(jobs[0].tmpBare, sourceMarksFile))
+	if err != nil {
+		fail(err)
+		return
+	}
+	markArgs := []string{"--export-marks=" + sourceMarks}
+	if jobs[0].resume != nil {
+		markArgs = append(markArgs, "--import-marks="+sourceMarks)
+	}
+	args = append(markArgs, args...)
+
+	// Fast-export
+	exp := gitx.FastExportCmd(srcRepo, args...)
+	// Export the history as stored: with replacements applied, commits
+	// would be published with the parents of their replacements.
+	exp.Env = append(os.Environ(), "GIT_NO_REPLACE_OBJECTS=1")
+	expStdout, err := exp.StdoutPipe()
+	if err != nil {
+		fail(err)
+		return
+	}
+	var expStderr bytes.Buffer
+	exp.Stderr = &expStderr
+
+	// One fast-import (and filter) per target.
+	var sinks []*importSink
+	for _, job := range jobs {
+		s := &importSink{job: job, filterErr: make(chan error, 1)}
+		out, err := s.start()
+		if err != nil {
+			job.err = err
+			continue
+		}
+		pr, pw := io.Pipe()
+		s.pw = pw
+		s.active = true
+		s.filter = scrub.NewExportFilter(job.rules)
+		if job.resume != nil {
+			s.filter.Resume(*job.resume)
+		}
+		if job.progress != nil {
+			s.filter.OnProgress(job.progress)
+		}
+		var w io.Writer = out
+		if job.verify {
+			s.verify = startVerify(job)
+			w = io.MultiWriter(out, s.verify.want)
+		}
+		go func() {
+			ferr := s.filter.Filter(pr, w)
+			if cerr := out.Close(); ferr == nil && job.output != "" {
+				ferr = cerr
+			}
+			// Unblock the fan-out if the filter stopped early.
+			if ferr != nil {
+				_ = pr.CloseWithError(ferr)
+			} else {
+				_ = pr.Close()
+			}
+			s.filterErr <- ferr
+		}()
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return
+	}
+
+	if err := exp.Start(); err != nil {
+		for _, s := range sinks {
+			_ = s.pw.Close()
+			<-s.filterErr
+			s.closeVerify()
+			s.kill()
+		}
+		fail(fmt.Errorf("fast-export start failed: %w (%s)", err, strings.TrimSpace(expStderr.String())))
+		return
+	}
+
+	// Fan the export stream out to every filter that is still consuming it.
+	// We must drain the pipe before calling exp.Wait(), which closes it.
+	buf := make([]byte, 64*1024)
+	for {
+		n, rerr := expStdout.Read(buf)
+		if n > 0 {
+			for _, s := range sinks {
+				if !s.active {
+					continue
+				}
+				if _, werr := s.pw.Write(buf[:n]); werr != nil {
+					s.active = false
+				}
+				if v := s.verify; v != nil && v.active {
+					if _, werr := v.pw.Write(buf[:n]); werr != nil {
+						v.active = false
+					}
+				}
+			}
+		}
+		if rerr != nil {
+			if !errors.Is(rerr, io.EOF) {
+				fail(rerr)
+			}
+			break
+		}
+	}
+	for _, s := range sinks {
+		_ = s.pw.Close()
+	}
+
+	// Now wait for export process (pipe is drained, safe to close)
+	expErr := exp.Wait()
+
+	for _, s := range sinks {
+		ferr := <-s.filterErr
+		verr := s.closeVerify()
+		// Check errors in order of occurrence
+		switch {
+		case expErr != nil:
+			s.kill()
+			s.job.err = fmt.Errorf("fast-export failed: %w (%s)", expErr, strings.TrimSpace(expStderr.String()))
+		case ferr != nil:
+			s.kill()
+			s.job.err = fmt.Errorf("export filter failed: %w", ferr)
+		case verr != nil:
+			s.kill()
+			s.job.err = verr
+		default:
+			if err := s.imp.Wait(); err != nil {
+				s.job.err = fmt.Errorf("fast-import failed: %w (%s)", err, strings.TrimSpace(s.impStderr.String()))
+				continue
+			}
+			if s.job.err == nil {
+				s.job.stats = s.filter.Progress()
+				s.job.lfsObjects = s.filter.LFSObjects()
+				s.job.filterState = s.filter.State()
+				s.job.writtenRefs = s.filter.WrittenRefs()
+				if s.job.tmpBare != jobs[0].tmpBare {
+					if err := copyFile(sourceMarks, incrementalPath(s.job.tmpBare, sourceMarksFile)); err != nil && !os.IsNotExist(err) {
+						s.job.err = err
+						continue
+					}
+				}
+				// A resumed import adds one pack to the cache; repacking
+				// everything would cost as much as a full sync.
+				if s.job.resume == nil {
+					_, _ = gitx.Run(ctx, s.job.tmpBare, repackArgs(s.job.target.Repack)...)
+				}
+			}
+		}
+	}
+}
+
+// repackArgs returns the git arguments repacking a target's cache after a
+// full import as p says.
+func repackArgs(p *config.RepackPolicy) []string {
+	args := []string{"repack", "-adq"}
+	if p == nil {
+		return args
+	}
+	if p.Force {
+		args = append(args, "-f")
+	}
+	if p.Window > 0 {
+		args = append(args, "--window="+strconv.Itoa(p.Window))
+	}
+	if p.Depth > 0 {
+		args = append(args, "--depth="+strconv.Itoa(p.Depth))
+	}
+	if p.Bitmaps != nil {
+		if *p.Bitmaps {
+			args = append(args, "--write-bitmap-index")
+		} else {
+			args = append(args, "--no-write-bitmap-index")
+		}
+	}
+	return args
+}
+
+// start opens the sink's output: the stdin of a new fast-import into the
+// job's temporary repo, teed to the dry-run output file if any.
+func (s *importSink) start() (io.WriteCloser, error) {
+	job := s.job
+	publicMarks, err := filepath.Abs(incrementalPath(job.tmpBare, publicMarksFile))
+	if err != nil {
+		return nil, err
+	}
+	impArgs := []string{"--export-marks=" + publicMarks}
+	if job.resume != nil {
+		impArgs = append(impArgs, "--import-marks="+publicMarks)
+	}
+	s.imp = gitx.FastImportCmd(job.tmpBare, impArgs...)
+	impStdin, err := s.imp.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.imp.Stderr = &s.impStderr
+	if err := s.imp.Start(); err != nil {
+		return nil, fmt.Errorf("fast-import start failed: %w (%s)", err, strings.TrimSpace(s.impStderr.String()))
+	}
+	if job.output != "" {
+		file, err := os.Create(job.output)
+		if err != nil {
+			_ = impStdin.Close()
+			_ = s.imp.Wait()
+			return nil, err
+		}
+		return teeWriteCloser{Writer: io.MultiWriter(impStdin, file), stdin: impStdin, file: file}, nil
+	}
+	return impStdin, nil
+}
+
+// teeWriteCloser writes to fast-import's stdin and a file. Close reports
+// the file's error; fast-import's are reported by its exit status.
+type teeWriteCloser struct {
+	io.Writer
+	stdin io.Closer
+	file  *os.File
+}
+
+func (t teeWriteCloser) Close() error {
+	_ = t.stdin.Close()
+	return t.file.Close()
+}
+
+// startVerify starts a second filter over the export with the job's rules
+// and state, hashing its output.
+func startVerify(job *targetJob) *verifySink {
+	pr, pw := io.Pipe()
+	v := &verifySink{pw: pw, active: true, want: sha256.New(), err: make(chan error, 1)}
+	f := scrub.NewExportFilter(job.rules)
+	if job.resume != nil {
+		f.Resume(*job.resume)
+	}
+	out := sha256.New()
+	go func() {
+		ferr := f.Filter(pr, out)
+		if ferr != nil {
+			_ = pr.CloseWithError(ferr)
+		} else {
+			_ = pr.Close()
+		}
+		v.got = out.Sum(nil)
+		v.err <- ferr
+	}()
+	return v
+}
+
+// closeVerify ends the sink's reproducibility check, if any, once the
+// output filter is done. It reports a second run that failed or produced a
+// different stream.
+func (s *importSink) closeVerify() error {
+	v := s.verify
+	if v == nil {
+		return nil
+	}
+	s.verify = nil
+	_ = v.pw.Close()
+	if err := <-v.err; err != nil {
+		return fmt.Errorf("reproducibility check: second filter run failed: %w", err)
+	}
+	if !bytes.Equal(v.got, v.want.Sum(nil)) {
+		return errors.New("reproducibility check: filtering the same export twice gave different output; published commit ids would change between syncs")
+	}
+	return nil
+}
+
+// kill stops the sink's fast-import, if any.
+func (s *importSink) kill() {
+	if s.imp != nil {
+		_ = s.imp.Process.Kill()
+		_ = s.imp.Wait()
+	}
+}
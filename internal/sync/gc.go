@@ -0,0 +1,288 @@
+package sync
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/repo"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// repoRecordFile, in a repo's cache directory, holds the private repo's
+// path, so GC can tell which caches no longer belong to a configured target.
+const repoRecordFile = "repo"
+
+// cacheSuffixes are the suffixes of the per-target entries of a repo's
+// cache directory; all but ".git" and ".lock" are left over by syncs.
+var cacheSuffixes = []string{".tmp.git", ".dryrun.git", ".git", ".lock"}
+
+type GCOptions struct {
+	CacheDir string
+	// MaxSize, if positive, caps the total size of the target caches: the
+	// least recently synced are removed until the rest fit. Their targets
+	// are filtered from scratch on their next sync. Targets with signing are
+	// never evicted: their rebuild would sign every object again, giving
+	// the whole public history new ids.
+	MaxSize int64
+	// DryRun reports what would be removed without changing anything.
+	DryRun bool
+}
+
+// GCRemoval is a cache entry GC removed, or would remove in a dry run.
+type GCRemoval struct {
+	Path   string
+	Reason string
+	Size   int64
+}
+
+type GCResult struct {
+	Removed []GCRemoval
+	// Busy are the caches of targets being synced, which were skipped.
+	Busy []string
+	// Size is the total size of the target caches GC keeps.
+	Size int64
+}
+
+// gcCache is a target cache GC keeps, unless it is evicted for MaxSize.
+type gcCache struct {
+	repoPath, dir, label string
+	size                 int64
+	synced               time.Time
+	signed               bool
+}
+
+// GC removes the caches of repos and targets whose config no longer exists
+// and the temporary caches interrupted syncs left, repacks the remaining
+// caches if git thinks it worthwhile, then applies opts.MaxSize. Cache
+// directories without a repo record (written by every sync) are left alone,
+// as are targets locked by a running sync. Lock files are never removed.
+func GC(ctx context.Context, opts GCOptions) (GCResult, error) {
+	if opts.CacheDir == "" {
+		opts.CacheDir = defaultCacheDir()
+	}
+	var res GCResult
+	dirs, err := os.ReadDir(opts.CacheDir)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	var kept []gcCache
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(opts.CacheDir, d.Name(), repoRecordFile))
+		if err != nil {
+			continue
+		}
+		caches, err := gcRepoCache(ctx, filepath.Join(opts.CacheDir, d.Name()), strings.TrimSpace(string(b)), opts, &res)
+		if err != nil {
+			return res, err
+		}
+		kept = append(kept, caches...)
+	}
+
+	for _, c := range kept {
+		res.Size += c.size
+	}
+	if opts.MaxSize <= 0 || res.Size <= opts.MaxSize {
+		return res, nil
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].synced.Before(kept[j].synced) })
+	for _, c := range kept {
+		if res.Size <= opts.MaxSize {
+			break
+		}
+		if c.signed {
+			continue
+		}
+		removed, err := removeTargetCache(c.dir, c.label, "evicted to fit the cache size limit", opts.DryRun, &res)
+		if err != nil {
+			return res, err
+		}
+		if !removed {
+			continue
+		}
+		res.Size -= c.size
+		if !opts.DryRun {
+			// Without this the target would count as up to date, and stay
+			// uncached until the private repo changes.
+			_ = updateTargetState(c.repoPath, stateLockPath(filepath.Dir(c.dir), filepath.Base(c.dir)), c.label, func(ts *state.TargetState) {
+				ts.LastPrivateRefs = ""
+			})
+		}
+	}
+	return res, nil
+}
+
+// gcRepoCache collects the garbage of one repo's cache directory and
+// returns the target caches it keeps.
+func gcRepoCache(ctx context.Context, dir, repoPath string, opts GCOptions, res *GCResult) ([]gcCache, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	reason := ""
+	var targets map[string]config.Target
+	var st state.RepoState
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		reason = "repo " + repoPath + " no longer exists"
+	} else if !repo.HasConfig(ctx, repoPath) {
+		reason = "repo " + repoPath + " has no git-copy config"
+	} else {
+		cfg, err := repo.LoadRepoConfigFromAnyBranch(ctx, repoPath)
+		if err != nil {
+			// Leave the caches alone until the config is fixed.
+			return nil, nil
+		}
+		st, _ = state.Load(repoPath)
+		targets = map[string]config.Target{}
+		for _, t := range cfg.Targets {
+			targets[t.Label] = t
+		}
+	}
+
+	var labels []string
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.Name() == "state.lock" {
+			continue
+		}
+		for _, suffix := range cacheSuffixes {
+			if label := strings.TrimSuffix(e.Name(), suffix); label != e.Name() {
+				if !seen[label] {
+					seen[label] = true
+					labels = append(labels, label)
+				}
+				break
+			}
+		}
+	}
+	busy := len(res.Busy)
+	var kept []gcCache
+	for _, label := range labels {
+		t, configured := targets[label]
+		if reason != "" || !configured {
+			why := reason
+			if why == "" {
+				why = "target " + label + " is no longer configured"
+			}
+			if _, err := removeTargetCache(dir, label, why, opts.DryRun, res); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c, err := gcTargetCache(ctx, dir, label, opts.DryRun, res)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			c.repoPath, c.signed = repoPath, t.Signing != nil
+			if ts := st.Targets[label]; ts != nil && !ts.LastSyncAt.IsZero() {
+				c.synced = ts.LastSyncAt
+			}
+			kept = append(kept, *c)
+		}
+	}
+	if reason != "" && !opts.DryRun && len(res.Busy) == busy {
+		// Without its record GC ignores the directory, which now only
+		// holds lock files.
+		if err := os.Remove(filepath.Join(dir, repoRecordFile)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// gcTargetCache removes the leftovers of a configured target's interrupted
+// syncs and repacks its cache. It returns nil if the target has no cache or
+// is being synced.
+func gcTargetCache(ctx context.Context, dir, label string, dryRun bool, res *GCResult) (*gcCache, error) {
+	l, _, err := tryLock(targetLockPath(filepath.Dir(dir), filepath.Base(dir), label))
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		res.Busy = append(res.Busy, filepath.Join(dir, label+".git"))
+		return nil, nil
+	}
+	defer l.unlock()
+	for _, suffix := range []string{".tmp.git", ".dryrun.git"} {
+		p := filepath.Join(dir, label+suffix)
+		if err := removeCacheEntry(p, "left over by an interrupted sync", dryRun, res); err != nil {
+			return nil, err
+		}
+	}
+	bare := filepath.Join(dir, label+".git")
+	info, err := os.Stat(bare)
+	if err != nil {
+		return nil, nil
+	}
+	if !dryRun {
+		_, _ = gitx.Run(ctx, bare, "gc", "--auto", "--quiet")
+	}
+	return &gcCache{dir: dir, label: label, size: dirSize(bare), synced: info.ModTime()}, nil
+}
+
+// removeTargetCache removes every cache entry of the target unless it is
+// being synced, reporting whether it did.
+func removeTargetCache(dir, label, reason string, dryRun bool, res *GCResult) (bool, error) {
+	lockPath := targetLockPath(filepath.Dir(dir), filepath.Base(dir), label)
+	l, _, err := tryLock(lockPath)
+	if err != nil {
+		return false, err
+	}
+	if l == nil {
+		res.Busy = append(res.Busy, filepath.Join(dir, label+".git"))
+		return false, nil
+	}
+	defer l.unlock()
+	for _, suffix := range cacheSuffixes {
+		if suffix == ".lock" {
+			continue
+		}
+		if err := removeCacheEntry(filepath.Join(dir, label+suffix), reason, dryRun, res); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func removeCacheEntry(path, reason string, dryRun bool, res *GCResult) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	res.Removed = append(res.Removed, GCRemoval{Path: path, Reason: reason, Size: dirSize(path)})
+	if dryRun {
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// dirSize is the total size of the files under path.
+func dirSize(path string) int64 {
+	var n int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// writeRepoRecord records the private repo a cache directory belongs to.
+func writeRepoRecord(cacheDir, repoKey, repoPath string) error {
+	return os.WriteFile(filepath.Join(cacheDir, repoKey, repoRecordFile), []byte(repoPath+"\n"), 0o644)
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	cacheDir := filepath.Join(tmp, "cache")
+
+	src := filepath.Join(tmp, "src")
+	if err := os.MkdirAll(filepath.Join(src, ".git-copy"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := gitx.Run(ctx, src, "init", "-b", "main"); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: filepath.Join(tmp, "dst.git"),
+			InitialHistoryMode: "full",
+		}},
+	}
+	b, _ := json.Marshal(cfg)
+	if err := os.WriteFile(filepath.Join(src, ".git-copy", "config.json"), b, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := state.Save(src, state.RepoState{Targets: map[string]*state.TargetState{"t": {LastPrivateRefs: "abc"}}}); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	// Caches of src's target "t", of a removed target, left over by an
+	// interrupted sync, of a deleted repo and of an unknown repo.
+	mkcache := func(repoKey, repoPath string, names ...string) string {
+		t.Helper()
+		dir := filepath.Join(cacheDir, repoKey)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if repoPath != "" {
+			if err := writeRepoRecord(cacheDir, repoKey, repoPath); err != nil {
+				t.Fatalf("writeRepoRecord: %v", err)
+			}
+		}
+		for _, name := range names {
+			if err := gitx.InitEmptyBare(filepath.Join(dir, name)); err != nil {
+				t.Fatalf("init %s: %v", name, err)
+			}
+		}
+		return dir
+	}
+	live := mkcache(repoCacheKey(src), src, "t.git", "t.tmp.git", "old.git")
+	gone := mkcache("deadbeefdeadbeef", filepath.Join(tmp, "deleted"), "t.git")
+	unknown := mkcache("0123456789abcdef", "", "t.git")
+
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+	res, err := GC(ctx, GCOptions{CacheDir: cacheDir, DryRun: true})
+	if err != nil {
+		t.Fatalf("GC (dry run): %v", err)
+	}
+	if len(res.Removed) != 3 || !exists(filepath.Join(live, "old.git")) {
+		t.Fatalf("dry run: expected 3 removals and no changes, got %+v", res.Removed)
+	}
+
+	if res, err = GC(ctx, GCOptions{CacheDir: cacheDir}); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if len(res.Removed) != 3 || res.Size == 0 {
+		t.Errorf("expected 3 removals and a kept size, got %+v", res)
+	}
+	if !exists(filepath.Join(live, "t.git")) || exists(filepath.Join(live, "t.tmp.git")) || exists(filepath.Join(live, "old.git")) {
+		t.Errorf("expected only t.git to remain in %s", live)
+	}
+	if exists(filepath.Join(gone, "t.git")) || exists(filepath.Join(gone, repoRecordFile)) {
+		t.Errorf("cache of deleted repo kept")
+	}
+	if !exists(filepath.Join(gone, "t.lock")) {
+		t.Errorf("lock file of deleted repo's target removed")
+	}
+	if !exists(filepath.Join(unknown, "t.git")) {
+		t.Errorf("cache without repo record removed")
+	}
+
+	// Over the size limit the target's cache is evicted, and its next sync
+	// rebuilds it.
+	if res, err = GC(ctx, GCOptions{CacheDir: cacheDir, MaxSize: 1}); err != nil {
+		t.Fatalf("GC (max size): %v", err)
+	}
+	if len(res.Removed) != 1 || res.Size != 0 || exists(filepath.Join(live, "t.git")) {
+		t.Errorf("expected t.git to be evicted, got %+v", res)
+	}
+	st, _ := state.Load(src)
+	if ts := st.Targets["t"]; ts == nil || ts.LastPrivateRefs != "" {
+		t.Errorf("evicted target still counts as up to date: %+v", ts)
+	}
+
+	// A signing target's rebuild would rewrite its public history, so it is
+	// never evicted.
+	cfg.Targets[0].Signing = &config.SigningKey{Key: "ABCD1234"}
+	b, _ = json.Marshal(cfg)
+	if err := os.WriteFile(filepath.Join(src, ".git-copy", "config.json"), b, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	mkcache(repoCacheKey(src), src, "t.git")
+	if res, err = GC(ctx, GCOptions{CacheDir: cacheDir, MaxSize: 1}); err != nil {
+		t.Fatalf("GC (max size, signing): %v", err)
+	}
+	if len(res.Removed) != 0 || !exists(filepath.Join(live, "t.git")) {
+		t.Errorf("expected the signing target's cache to be kept, got %+v", res)
+	}
+}
+
+func TestGC_SkipsLockedTargets(t *testing.T) {
+	tmp := t.TempDir()
+	cacheDir := filepath.Join(tmp, "cache")
+	dir := filepath.Join(cacheDir, "deadbeefdeadbeef")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := writeRepoRecord(cacheDir, "deadbeefdeadbeef", filepath.Join(tmp, "deleted")); err != nil {
+		t.Fatalf("writeRepoRecord: %v", err)
+	}
+	if err := gitx.InitEmptyBare(filepath.Join(dir, "t.git")); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	// A sync holds the lock, or dry runs share it.
+	for _, lock := range []func(string) (*fileLock, int, error){tryLock, tryRLock} {
+		l, _, err := lock(targetLockPath(cacheDir, "deadbeefdeadbeef", "t"))
+		if err != nil || l == nil {
+			t.Fatalf("lock: %v %v", l, err)
+		}
+		res, err := GC(context.Background(), GCOptions{CacheDir: cacheDir})
+		l.unlock()
+		if err != nil {
+			t.Fatalf("GC: %v", err)
+		}
+		if len(res.Removed) != 0 || len(res.Busy) != 1 {
+			t.Errorf("expected the locked cache to be skipped, got %+v", res)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "t.git")); err != nil {
+			t.Errorf("locked cache removed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/notify"
+)
+
+// HookError reports a hook command that failed.
+type HookError struct {
+	Hook    string // "pre_sync" or "post_sync"
+	Command string
+	Err     error
+	Output  string
+}
+
+func (e *HookError) Error() string {
+	msg := fmt.Sprintf("%s hook %q failed: %v", e.Hook, e.Command, e.Err)
+	if e.Output != "" {
+		msg += "\n" + e.Output
+	}
+	return msg
+}
+
+func (e *HookError) Unwrap() error { return e.Err }
+
+// hookEnv describes the target's sync to its hooks. scrubbed is the
+// scrubbed repo the target is pushed from.
+func hookEnv(repoPath string, t config.Target, sourceCommit, scrubbed string) []string {
+	return []string{
+		"GIT_COPY_REPO=" + repoPath,
+		"GIT_COPY_TARGET=" + t.Label,
+		"GIT_COPY_TARGET_URL=" + t.RepoURL,
+		"GIT_COPY_SOURCE_COMMIT=" + sourceCommit,
+		"GIT_COPY_SCRUBBED_REPO=" + scrubbed,
+	}
+}
+
+// resultEnv adds the outcome of the sync to a post_sync hook's environment.
+func resultEnv(env []string, res Result) []string {
+	outcome, msg := "ok", ""
+	if res.Error != nil {
+		outcome, msg = "error", res.Error.Error()
+	}
+	return append(env,
+		"GIT_COPY_RESULT="+outcome,
+		"GIT_COPY_ERROR="+msg,
+		"GIT_COPY_REF_UPDATES="+strconv.Itoa(len(res.RefUpdates)),
+		"GIT_COPY_NEW_COMMITS="+strconv.Itoa(res.NewCommits),
+	)
+}
+
+// syncEvent describes the target's sync to webhooks. repoKey stands for
+// the private repo, whose path isn't sent, and pushed are the refs the push
+// published, if it got that far.
+func syncEvent(repoKey string, res Result, pushed map[string]string) notify.SyncEvent {
+	ev := notify.SyncEvent{
+		Repo:         repoKey,
+		Target:       res.TargetLabel,
+		TargetURL:    res.TargetURL,
+		SourceCommit: res.SourceCommit,
+		Status:       "ok",
+		PushedRefs:   pushed,
+		NewCommits:   res.NewCommits,
+		DurationMS:   res.Duration.Milliseconds(),
+		FinishedAt:   time.Now().UTC(),
+	}
+	if res.Error != nil {
+		ev.Status, ev.Error = "error", res.Error.Error()
+	}
+	return ev
+}
+
+// runHook runs command with sh -c in the private repo, with env added to
+// the environment. An empty command does nothing.
+func runHook(ctx context.Context, hook, command, repoPath string, env []string) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = repoPath
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return &HookError{Hook: hook, Command: command, Err: err, Output: strings.TrimSpace(out.String())}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	"github.com/obinnaokechukwu/git-copy/internal/provider"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// RemoteChangedError reports a target whose URL no longer leads to the repo
+// git-copy last pushed to, e.g. because it was deleted and recreated, or
+// renamed and its name reused.
+type RemoteChangedError struct {
+	Target string
+	Reason string
+}
+
+func (e *RemoteChangedError) Error() string {
+	return fmt.Sprintf("target %s: the repo at its URL is not the one git-copy pushed to: %s; rerun with --allow-remote-overwrite to push to it anyway", e.Target, e.Reason)
+}
+
+// repoInfoTimeout bounds the lookup of a target's identity, which is only
+// advisory.
+const repoInfoTimeout = 30 * time.Second
+
+// targetRepoIdentifier returns the provider that looks up the identity of
+// the target's repo, or nil if its host has no API for it. With
+// Options.CredentialsFromEnv, the gh CLI isn't used.
+func targetRepoIdentifier(t config.Target, opts Options) provider.RepoIdentifier {
+	if t.Account == "" || t.RepoName == "" {
+		return nil
+	}
+	token, _ := envToken(t)
+	switch t.Provider {
+	case "github":
+		return provider.GitHubProvider{UseGHCLI: !opts.CredentialsFromEnv, Token: token, BaseURL: t.Auth.BaseURL}
+	case "gitlab":
+		return provider.GitLabProvider{BaseURL: t.Auth.BaseURL, Token: token}
+	case "gitea":
+		return provider.GiteaProvider{BaseURL: t.Auth.BaseURL, Token: token}
+	}
+	return nil
+}
+
+// remoteIdentity returns the identity of the target's repo: head, the ref
+// its HEAD names, and its id and name on the hosting service, if the
+// service can be asked. A failed lookup leaves those unknown.
+func remoteIdentity(ctx context.Context, job *targetJob, head string) *state.RemoteIdentity {
+	id := &state.RemoteIdentity{URL: job.target.RepoURL, Head: head}
+	if job.repoIdentifier != nil {
+		ctx, cancel := context.WithTimeout(ctx, repoInfoTimeout)
+		defer cancel()
+		if info, err := job.repoIdentifier.RepoInfo(ctx, job.target.Account, job.target.RepoName); err == nil {
+			id.ID, id.Name = info.ID, info.FullName
+		}
+	}
+	return id
+}
+
+// checkRemoteIdentity compares the target's identity, cur, and its refs in
+// the push scope, remote, with those of git-copy's last push to the same
+// URL. It returns a change if the repo has another id. A renamed repo, one
+// whose HEAD names another ref, or one that lost every ref git-copy pushed
+// (which pushing into loses nothing), only adds a warning to the job.
+func checkRemoteIdentity(job *targetJob, cur *state.RemoteIdentity, remote map[string]string, prefixes []string) *RemoteChangedError {
+	t := job.target
+	if full := t.Account + "/" + t.RepoName; cur.Name != "" && !strings.EqualFold(cur.Name, full) {
+		job.warnings = append(job.warnings, fmt.Sprintf("target %s: repo %s was renamed to %s; update the target's config", t.Label, full, cur.Name))
+	}
+	prev := job.prevRemote
+	if prev == nil || prev.URL != cur.URL {
+		return nil
+	}
+	if prev.ID != "" && cur.ID != "" && prev.ID != cur.ID {
+		return &RemoteChangedError{Target: t.Label, Reason: fmt.Sprintf("its id changed from %s to %s", prev.ID, cur.ID)}
+	}
+	if prev.Head != "" && cur.Head != "" && prev.Head != cur.Head {
+		job.warnings = append(job.warnings, fmt.Sprintf("target %s: its HEAD changed from %s to %s since git-copy last pushed", t.Label, prev.Head, cur.Head))
+	}
+	if len(remote) == 0 && len(refsUnder(job.prevPushed, prefixes)) > 0 {
+		job.warnings = append(job.warnings, fmt.Sprintf("target %s has none of the refs git-copy pushed; it may have been deleted and recreated", t.Label))
+	}
+	return nil
+}
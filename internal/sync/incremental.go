@@ -0,0 +1,177 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// Incremental syncs keep the fast-export marks (private commits), the
+// fast-import marks (scrubbed objects) and the export filter's state inside
+// the cached scrubbed repo. When the rules are unchanged, the next sync
+// starts from a clone of the cache and exports only the commits the marks
+// don't cover.
+const (
+	incrementalDir    = "git-copy"
+	sourceMarksFile   = "source.marks"
+	publicMarksFile   = "public.marks"
+	filterStateFile   = "filter.json"
+	incrementalFormat = 1
+)
+
+type incrementalState struct {
+	Format int `json:"format"`
+	// RulesKey identifies the rules the cache was filtered with.
+	RulesKey string            `json:"rules_key"`
+	Filter   scrub.FilterState `json:"filter"`
+}
+
+// rulesKey hashes the filter rules, including content read from HEAD such
+// as replace_history_with_current files: any change means earlier commits
+// would be scrubbed differently, so the history must be filtered again.
+func rulesKey(r scrub.Rules) string {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func incrementalPath(bare, name string) string {
+	return filepath.Join(bare, incrementalDir, name)
+}
+
+// initImportRepo creates the job's temporary bare repo. It is a clone of
+// the cached repo when that was built with the same rules (key != "" and
+// resume allowed), so the import only adds new commits; otherwise it is
+// empty, bar the history adopted from the target, and the whole history is
+// filtered.
+func initImportRepo(ctx context.Context, job *targetJob, key string, resume bool) error {
+	_ = os.RemoveAll(job.tmpBare)
+	job.rulesKey = key
+	job.resume = nil
+	if resume && key != "" {
+		if st, ok := loadIncrementalState(job.finalBare); ok && st.RulesKey == key {
+			if err := cloneCache(ctx, job.finalBare, job.tmpBare); err == nil {
+				job.resume = &st.Filter
+				return nil
+			}
+			_ = os.RemoveAll(job.tmpBare)
+		}
+	}
+	if err := gitx.InitEmptyBare(job.tmpBare); err != nil {
+		return err
+	}
+	if job.adoptBase != "" {
+		if err := fetchAdoptBase(ctx, job); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(filepath.Join(job.tmpBare, incrementalDir), 0o755)
+}
+
+func loadIncrementalState(bare string) (incrementalState, bool) {
+	for _, name := range []string{sourceMarksFile, publicMarksFile} {
+		if _, err := os.Stat(incrementalPath(bare, name)); err != nil {
+			return incrementalState{}, false
+		}
+	}
+	b, err := os.ReadFile(incrementalPath(bare, filterStateFile))
+	if err != nil {
+		return incrementalState{}, false
+	}
+	var st incrementalState
+	if err := json.Unmarshal(b, &st); err != nil || st.Format != incrementalFormat {
+		return incrementalState{}, false
+	}
+	return st, true
+}
+
+// cloneCache copies the cached repo (hardlinking objects) and its marks.
+func cloneCache(ctx context.Context, finalBare, tmpBare string) error {
+	if _, err := gitx.Run(ctx, "", "clone", "--mirror", "--quiet", finalBare, tmpBare); err != nil {
+		return err
+	}
+	// Removing the remote with "git remote remove" would delete every ref
+	// matched by the mirror refspec, i.e. all of them.
+	if _, err := gitx.Run(ctx, tmpBare, "config", "--remove-section", "remote.origin"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(tmpBare, incrementalDir), 0o755); err != nil {
+		return err
+	}
+	for _, name := range []string{sourceMarksFile, publicMarksFile} {
+		if err := copyFile(incrementalPath(finalBare, name), incrementalPath(tmpBare, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveIncrementalState records the filter state next to the marks written by
+// the import, and removes refs a resumed import didn't write: the export
+// names every private ref, so those were deleted or are no longer published.
+func saveIncrementalState(ctx context.Context, job *targetJob) error {
+	if job.resume != nil {
+		written := map[string]bool{}
+		for _, r := range job.writtenRefs {
+			written[r] = true
+		}
+		refs, err := gitx.ListRefs(job.tmpBare)
+		if err != nil {
+			return err
+		}
+		for r := range refs {
+			if !written[r] {
+				if _, err := gitx.Run(ctx, job.tmpBare, "update-ref", "-d", r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if job.rulesKey == "" {
+		return nil
+	}
+	// fast-export doesn't write its marks file when there was nothing new.
+	for _, name := range []string{sourceMarksFile, publicMarksFile} {
+		if _, err := os.Stat(incrementalPath(job.tmpBare, name)); os.IsNotExist(err) {
+			if err := os.WriteFile(incrementalPath(job.tmpBare, name), nil, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	b, err := json.Marshal(incrementalState{Format: incrementalFormat, RulesKey: job.rulesKey, Filter: job.filterState})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(incrementalPath(job.tmpBare, filterStateFile), b, 0o644)
+}
+
+// sourceMarksKey identifies the private commits a job's export skips, so
+// jobs resuming from identical marks can share one export.
+func sourceMarksKey(job *targetJob) string {
+	if job.resume == nil {
+		return ""
+	}
+	b, err := os.ReadFile(incrementalPath(job.tmpBare, sourceMarksFile))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0o644)
+}
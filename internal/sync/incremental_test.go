@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestSyncRepo_IncrementalResumesFromMarks(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(name, content string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{name: content}, "edit "+name)
+	}
+	commit("a.txt", "by obinnaokechukwu\n")
+	commit(".env", "SECRET=1\n")
+	_, _ = gitx.Run(ctx, src, "branch", "topic")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**", ".env"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	sync := func() Result {
+		t.Helper()
+		res, err := SyncRepo(ctx, src, cfg, "", opts)
+		if err != nil || res[0].Error != nil {
+			t.Fatalf("SyncRepo: %v %v", err, res)
+		}
+		return res[0]
+	}
+	if res := sync(); res.Incremental {
+		t.Errorf("first sync reported as incremental")
+	}
+	first, err := gitx.Run(ctx, dst, "rev-list", "--reverse", "main")
+	if err != nil {
+		t.Fatalf("rev-list: %v", err)
+	}
+
+	commit("b.txt", "more from obinnaokechukwu\n")
+	_, _ = gitx.Run(ctx, src, "branch", "-D", "topic")
+	if res := sync(); !res.Incremental || res.Stats.Commits != 1 {
+		t.Errorf("second sync: incremental = %v, %d commits filtered; want incremental, 1 commit", res.Incremental, res.Stats.Commits)
+	}
+
+	cache := filepath.Join(opts.CacheDir, repoCacheKey(src), "t.git")
+	// An incremental import leaves the first sync's pack alone; small
+	// imports are stored as loose objects.
+	count, err := gitx.Run(ctx, cache, "count-objects")
+	if err != nil || strings.HasPrefix(count.Stdout, "0 objects") {
+		t.Errorf("count-objects = %q (%v), want the new commit stored loose", count.Stdout, err)
+	}
+
+	second, err := gitx.Run(ctx, dst, "rev-list", "--reverse", "main")
+	if err != nil {
+		t.Fatalf("rev-list: %v", err)
+	}
+	// The first commit was skipped (.env only), so history is a.txt, b.txt.
+	got, want := nonEmptyLines(second.Stdout), nonEmptyLines(first.Stdout)
+	if len(got) != 2 || len(want) != 1 || got[0] != want[0] {
+		t.Fatalf("history after incremental sync = %v, want %v plus one commit", got, want)
+	}
+	b, err := gitx.Run(ctx, dst, "show", "main:b.txt")
+	if err != nil || b.Stdout != "more from johndoe\n" {
+		t.Errorf("b.txt = %q (%v), want scrubbed content", b.Stdout, err)
+	}
+	parent, err := gitx.Run(ctx, dst, "rev-parse", "main^")
+	if err != nil || strings.TrimSpace(parent.Stdout) != want[0] {
+		t.Errorf("new commit parent = %q (%v), want %s", parent.Stdout, err, want[0])
+	}
+	refs, err := gitx.Run(ctx, dst, "for-each-ref", "--format=%(refname)")
+	if err != nil {
+		t.Fatalf("for-each-ref: %v", err)
+	}
+	if got := strings.Join(nonEmptyLines(refs.Stdout), ","); got != "refs/heads/main" {
+		t.Errorf("published refs = %q, want the deleted topic branch gone", got)
+	}
+}
+
+func TestSyncRepo_IncrementalRebuildsAfterHistoryRewrite(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for _, msg := range []string{"one", "two"} {
+		commitFiles(t, src, map[string]string{"f.txt": msg + "\n"}, msg)
+	}
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+
+	// Rewrite the last commit and drop the old one from the private repo, so
+	// the saved marks name a commit that no longer exists.
+	if _, err := gitx.Run(ctx, src, "commit", "--amend", "-m", "two, reworded"); err != nil {
+		t.Fatalf("amend: %v", err)
+	}
+	_, _ = gitx.Run(ctx, src, "reflog", "expire", "--expire=now", "--all")
+	_, _ = gitx.Run(ctx, src, "gc", "--prune=now", "--quiet")
+
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo after rewrite: %v %v", err, res)
+	}
+	if res[0].Incremental {
+		t.Errorf("sync after rewrite reported as incremental, want a rebuild")
+	}
+	msg, err := gitx.Run(ctx, dst, "log", "-1", "--format=%s", "main")
+	if err != nil || strings.TrimSpace(msg.Stdout) != "two, reworded" {
+		t.Errorf("published tip = %q (%v), want the rewritten commit", msg.Stdout, err)
+	}
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// lockPoll is how often a sync waiting for a lock tries again.
+var lockPoll = 500 * time.Millisecond
+
+// LockedError reports a target skipped because another git-copy process
+// holds its lock.
+type LockedError struct {
+	Target string
+	// PID is the holder's process id, if it could be read.
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("target %s is being synced by another git-copy process (pid %d); skipped", e.Target, e.PID)
+	}
+	return fmt.Sprintf("target %s is being synced by another git-copy process; skipped", e.Target)
+}
+
+// fileLock is an advisory lock held on an open file. The lock is dropped
+// when the file is closed, so a crashed sync never leaves it behind. Lock
+// files are never removed: a process that opened the old file could still
+// lock it while another locks a new one at the same path.
+type fileLock struct {
+	f *os.File
+}
+
+// targetLockPath is the lock guarding a target's cache and push.
+func targetLockPath(cacheDir, repoKey, label string) string {
+	return filepath.Join(cacheDir, repoKey, label+".lock")
+}
+
+// stateLockPath is the lock guarding the read-modify-write of the repo's
+// sync state, which all targets share.
+func stateLockPath(cacheDir, repoKey string) string {
+	return filepath.Join(cacheDir, repoKey, "state.lock")
+}
+
+// tryLock takes the lock at path without waiting. If another process holds
+// it, it returns nil and the holder's pid (0 if unknown).
+func tryLock(path string) (*fileLock, int, error) {
+	return tryLockMode(path, false)
+}
+
+// tryRLock takes the lock at path shared, as dry runs do: they only read the
+// cache, but GC and syncs must not replace it meanwhile.
+func tryRLock(path string) (*fileLock, int, error) {
+	return tryLockMode(path, true)
+}
+
+func tryLockMode(path string, shared bool) (*fileLock, int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	ok, err := lockFile(f, shared)
+	if err != nil || !ok {
+		b, _ := os.ReadFile(path)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		f.Close()
+		return nil, pid, err
+	}
+	if shared {
+		return &fileLock{f: f}, 0, nil
+	}
+	// Record the holder for the messages of syncs that find it locked.
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &fileLock{f: f}, 0, nil
+}
+
+// waitLock takes the lock at path, waiting for its holders to release it.
+func waitLock(ctx context.Context, path string, shared bool) (*fileLock, error) {
+	for {
+		l, _, err := tryLockMode(path, shared)
+		if l != nil || err != nil {
+			return l, err
+		}
+		t := time.NewTimer(lockPoll)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// updateTargetState applies update to label's sync state. The state on
+// disk is reread under the state lock, so targets saved meanwhile by other
+// syncs keep their updates.
+func updateTargetState(repoPath, lockPath, label string, update func(ts *state.TargetState)) error {
+	l, err := waitLock(context.Background(), lockPath, false)
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+	st, _ := state.Load(repoPath)
+	if st.Targets == nil {
+		st.Targets = map[string]*state.TargetState{}
+	}
+	if st.Targets[label] == nil {
+		st.Targets[label] = &state.TargetState{}
+	}
+	update(st.Targets[label])
+	return state.Save(repoPath, st)
+}
+
+func (l *fileLock) unlock() {
+	if l == nil {
+		return
+	}
+	unlockFile(l.f)
+	l.f.Close()
+}
@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"context"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// MirrorResult is the outcome of the push of a target's scrubbed repo to
+// one of its mirrors. A failed mirror doesn't fail the target, nor the
+// other way round.
+type MirrorResult struct {
+	URL          string
+	Error        error
+	RefUpdates   []RefUpdate
+	NewCommits   int
+	PushAttempts int
+	PushedBytes  int64
+}
+
+// mirrorPush is a mirror's push and what it left on the mirror.
+type mirrorPush struct {
+	res        MirrorResult
+	pushedRefs map[string]string
+	remote     *state.RemoteIdentity
+}
+
+// mirrorTarget returns the target as pushed to mirror m.
+func mirrorTarget(repoPath string, t config.Target, m config.Mirror) config.Target {
+	t.RepoURL, t.Provider = m.URL, m.Provider
+	t.Auth = config.AuthRef{TokenEnv: m.TokenEnv}
+	t.RepoURL = targetURL(repoPath, t)
+	return t
+}
+
+// pushMirrors pushes the scrubbed cache at job.finalBare to each of the
+// target's mirrors in turn, each checked against what git-copy last pushed
+// to it (job.prevMirrors), and records the outcomes in job.mirrors.
+func pushMirrors(ctx context.Context, job *targetJob, opts Options) {
+	for _, m := range job.target.Mirrors {
+		mj := *job
+		mj.target = mirrorTarget(job.srcRepo, job.target, m)
+		mj.prevPushed, mj.prevRemote = nil, nil
+		if ms := job.prevMirrors[m.URL]; ms != nil {
+			mj.prevPushed, mj.prevRemote = ms.PushedRefs, ms.Remote
+		}
+		mj.pushedRefs, mj.remote, mj.repoIdentifier, mj.warnings = nil, nil, nil, nil
+		mj.refUpdates, mj.newCommits, mj.pushedBytes = nil, 0, 0
+
+		mp := mirrorPush{res: MirrorResult{URL: m.URL}}
+		env, stop, err := throttledPushEnv(ctx, &mj, opts)
+		if err == nil {
+			mp.res.PushAttempts, err = retry.Do(ctx, retryPolicy(mj.target), gitx.IsTransient, func() error {
+				if err := pushTarget(ctx, &mj, env); err != nil {
+					return err
+				}
+				return gitx.LFSPushObjects(ctx, mj.srcRepo, mj.target.RepoURL, mj.lfsObjects, env)
+			})
+			stop()
+		}
+		mp.res.Error = err
+		mp.res.RefUpdates, mp.res.NewCommits, mp.res.PushedBytes = mj.refUpdates, mj.newCommits, mj.pushedBytes
+		mp.pushedRefs, mp.remote = mj.pushedRefs, mj.remote
+		job.mirrors = append(job.mirrors, mp)
+		job.warnings = append(job.warnings, mj.warnings...)
+	}
+}
+
+// recordMirrors updates the states of the target's mirrors, in ts, with
+// the job's pushes, and forgets mirrors no longer configured.
+func recordMirrors(ts *state.TargetState, t config.Target, job *targetJob) {
+	configured := map[string]bool{}
+	for _, m := range t.Mirrors {
+		configured[m.URL] = true
+	}
+	for url := range ts.Mirrors {
+		if !configured[url] {
+			delete(ts.Mirrors, url)
+		}
+	}
+	for _, mp := range job.mirrors {
+		if ts.Mirrors == nil {
+			ts.Mirrors = map[string]*state.MirrorState{}
+		}
+		ms := ts.Mirrors[mp.res.URL]
+		if ms == nil {
+			ms = &state.MirrorState{}
+			ts.Mirrors[mp.res.URL] = ms
+		}
+		if mp.res.Error != nil {
+			ms.LastError = mp.res.Error.Error()
+		} else {
+			ms.LastError, ms.LastSyncAt = "", time.Now()
+		}
+		if mp.pushedRefs != nil {
+			ms.PushedRefs, ms.Remote = mp.pushedRefs, mp.remote
+		}
+	}
+}
+
+// mirrorsSynced reports whether every mirror of the target was pushed to,
+// without error, by the last sync.
+func mirrorsSynced(t config.Target, ts *state.TargetState) bool {
+	for _, m := range t.Mirrors {
+		if ms := ts.Mirrors[m.URL]; ms == nil || ms.LastError != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package sync
+
+import gosync "sync"
+
+// defaultTargetConcurrency is the default of Options.TargetConcurrency.
+const defaultTargetConcurrency = 4
+
+func targetConcurrency(opts Options) int {
+	if opts.TargetConcurrency > 0 {
+		return opts.TargetConcurrency
+	}
+	return defaultTargetConcurrency
+}
+
+// forEachLimit calls fn(0) to fn(n-1), at most limit at a time, and waits
+// for them to return.
+func forEachLimit(n, limit int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg gosync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
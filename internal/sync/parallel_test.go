@@ -0,0 +1,34 @@
+package sync
+
+import (
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestForEachLimit(t *testing.T) {
+	var mu gosync.Mutex
+	running, peak := 0, 0
+	done := make([]bool, 10)
+	forEachLimit(len(done), 3, func(i int) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		done[i] = true
+		mu.Unlock()
+	})
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("fn(%d) was not called", i)
+		}
+	}
+	if peak > 3 || peak < 2 {
+		t.Errorf("peak concurrency = %d, want at most 3 (and some parallelism)", peak)
+	}
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+)
+
+// CheckReplacements reports replacement strings of target t that already
+// occur in the private history (file contents, commit messages or
+// identities). Published occurrences of such a string are ambiguous: an audit
+// can't tell scrubbed text from text that was always there.
+func CheckReplacements(ctx context.Context, repoPath string, cfg config.RepoConfig, t config.Target) ([]string, error) {
+	return checkReplacements(ctx, repoPath, targetReplacements(cfg, t), nil)
+}
+
+// checkReplacements looks for repls in the private history, leaving out the
+// commits reachable from since.
+func checkReplacements(ctx context.Context, repoPath string, repls, since []string) ([]string, error) {
+	var problems []string
+	for _, r := range repls {
+		commit, where, err := firstOccurrence(ctx, repoPath, r, since)
+		if err != nil {
+			return nil, err
+		}
+		if commit != "" {
+			problems = append(problems, fmt.Sprintf("replacement %q already appears in the private history (%s in commit %s)", r, where, commit))
+		}
+	}
+	return problems, nil
+}
+
+// targetReplacements returns the distinct replacement strings of target t,
+// sorted.
+func targetReplacements(cfg config.RepoConfig, t config.Target) []string {
+	repls := []string{targetReplacement(t)}
+	for _, u := range cfg.AdditionalUsernames {
+		if u.Replacement != "" {
+			repls = append(repls, u.Replacement)
+		}
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, r := range repls {
+		r = strings.TrimSpace(r)
+		if r == "" || seen[strings.ToLower(r)] {
+			continue
+		}
+		seen[strings.ToLower(r)] = true
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// checkReplacementsPreflight applies defaults.replacement_check to the
+// replacements of t: "fail" returns an error, "off" skips the check, and the
+// default records warnings on res. Once the history up to refs passed (or was
+// warned about), ts records its tips and later syncs only scan the commits
+// added since, unless the replacements or the mode change.
+func checkReplacementsPreflight(ctx context.Context, repoPath string, cfg config.RepoConfig, t config.Target, ts *state.TargetState, refs map[string]string, res *Result) error {
+	mode := cfg.Defaults.ReplacementCheck
+	if mode == "off" {
+		return nil
+	}
+	repls := targetReplacements(cfg, t)
+	var since []string
+	if slices.Equal(ts.CheckedReplacements, repls) && ts.ReplacementCheckMode == mode {
+		since = ts.ReplacementCheckTips
+	}
+	problems, err := checkReplacements(ctx, repoPath, repls, since)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 && mode == "fail" {
+		return fmt.Errorf("%s; choose another replacement or set defaults.replacement_check to \"warn\"", strings.Join(problems, "; "))
+	}
+	res.Warnings = append(res.Warnings, problems...)
+	ts.CheckedReplacements, ts.ReplacementCheckMode, ts.ReplacementCheckTips = repls, mode, refTips(refs)
+	return nil
+}
+
+// refTips returns the distinct commits refs point to, sorted.
+func refTips(refs map[string]string) []string {
+	seen := map[string]bool{}
+	var tips []string
+	for _, sha := range refs {
+		if !seen[sha] {
+			seen[sha] = true
+			tips = append(tips, sha)
+		}
+	}
+	sort.Strings(tips)
+	return tips
+}
+
+// firstOccurrence finds a commit whose content, message or identity contains
+// s (case-insensitively), returning "" if there is none. Commits reachable
+// from since are not searched.
+func firstOccurrence(ctx context.Context, repoPath, s string, since []string) (commit, where string, err error) {
+	checks := []struct{ where, arg string }{
+		{"file contents", "-S" + s},
+		{"commit message", "--grep=" + s},
+		{"author", "--author=" + s},
+		{"committer", "--committer=" + s},
+	}
+	for _, c := range checks {
+		args := []string{"log", "--ignore-missing", "--all", "-i", "-F", "-1", "--format=%h", c.arg}
+		if len(since) > 0 {
+			args = append(append(args, "--not"), since...)
+		}
+		res, err := gitx.Run(ctx, repoPath, args...)
+		if err != nil {
+			return "", "", err
+		}
+		if h := strings.TrimSpace(res.Stdout); h != "" {
+			return h, c.where, nil
+		}
+	}
+	return "", "", nil
+}
+
+// targetReplacement returns the string replacing the private username for t.
+func targetReplacement(t config.Target) string {
+	if t.Replacement != "" {
+		return t.Replacement
+	}
+	return t.Account
+}
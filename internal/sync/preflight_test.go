@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+func TestSyncRepo_ReplacementCheck(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"README.md": "maintained by johndoe\n"}, "init")
+
+	newCfg := func(mode, dst string) config.RepoConfig {
+		return config.RepoConfig{
+			Version:         config.RepoConfigVersion,
+			PrivateUsername: "obinnaokechukwu",
+			HeadBranch:      "main",
+			Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}, ReplacementCheck: mode},
+			Targets: []config.Target{{
+				Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+				InitialHistoryMode: "full",
+			}},
+		}
+	}
+	newDst := func(name string) string {
+		dst := newBareRepo(t, filepath.Join(tmp, name+".git"))
+		return dst
+	}
+
+	res, err := SyncRepo(ctx, src, newCfg("", newDst("warn")), "", Options{CacheDir: filepath.Join(tmp, "cache-warn")})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if len(res[0].Warnings) != 1 || !strings.Contains(res[0].Warnings[0], `"johndoe"`) {
+		t.Errorf("warnings = %q, want one about johndoe", res[0].Warnings)
+	}
+
+	// Later syncs only scan the commits added since.
+	warnCfg := newCfg("", filepath.Join(tmp, "warn.git"))
+	commitFiles(t, src, map[string]string{"main.go": "package main\n"}, "add main")
+	res, err = SyncRepo(ctx, src, warnCfg, "", Options{CacheDir: filepath.Join(tmp, "cache-warn")})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if len(res[0].Warnings) != 0 {
+		t.Errorf("warnings = %q, want none for a commit without the replacement", res[0].Warnings)
+	}
+	commitFiles(t, src, map[string]string{"NOTES.md": "ask johndoe\n"}, "add notes")
+	res, err = SyncRepo(ctx, src, warnCfg, "", Options{CacheDir: filepath.Join(tmp, "cache-warn")})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if len(res[0].Warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the new commit", res[0].Warnings)
+	}
+
+	res, err = SyncRepo(ctx, src, newCfg("fail", newDst("fail")), "", Options{CacheDir: filepath.Join(tmp, "cache-fail")})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	if res[0].Error == nil || !strings.Contains(res[0].Error.Error(), "already appears") {
+		t.Errorf("error = %v, want replacement check failure", res[0].Error)
+	}
+
+	res, err = SyncRepo(ctx, src, newCfg("off", newDst("off")), "", Options{CacheDir: filepath.Join(tmp, "cache-off")})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if len(res[0].Warnings) != 0 {
+		t.Errorf("warnings = %q, want none with the check off", res[0].Warnings)
+	}
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// Preview runs the files of the private HEAD under paths (files or
+// directories; all files when paths is empty) through target t's rules and
+// returns how each would be published. Nothing is exported or imported.
+// Submodule entries have no content to preview and are left out.
+func Preview(ctx context.Context, repoPath string, cfg config.RepoConfig, t config.Target, paths []string) ([]scrub.FilePreview, error) {
+	rulesIn, err := targetRules(ctx, repoPath, cfg, t)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := scrub.Compile(rulesIn)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := gitx.ListTree(repoPath, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []scrub.PrivateFile
+	var oids []string
+	for _, e := range entries {
+		if e.Mode == "160000" || !previewSelected(e.Path, paths) {
+			continue
+		}
+		files = append(files, scrub.PrivateFile{Path: e.Path, Mode: e.Mode})
+		oids = append(oids, e.OID)
+	}
+	i := 0
+	if err := gitx.ReadObjects(ctx, repoPath, oids, func(_, _ string, content []byte) error {
+		files[i].Content = content
+		i++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return scrub.Preview(rules, files)
+}
+
+// previewSelected reports whether p is one of paths or inside one of them.
+func previewSelected(p string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, sel := range paths {
+		sel = strings.Trim(path.Clean(sel), "/")
+		if sel == "." || p == sel || strings.HasPrefix(p, sel+"/") {
+			return true
+		}
+	}
+	return false
+}
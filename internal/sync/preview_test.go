@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+func TestPreview_SelectsPathsFromHead(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo(t, filepath.Join(t.TempDir(), "src"))
+	commitFiles(t, src, map[string]string{
+		"docs/a.md":  "written by obinnaokechukwu\n",
+		"docs/b.md":  "b\n",
+		"docsx/c.md": "c\n",
+		".env":       "SECRET=1\n",
+	}, "init")
+
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".env"}},
+	}
+	target := config.Target{Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst"}
+
+	files, err := Preview(ctx, src, cfg, target, []string{"docs/", ".env"})
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	got := map[string]string{}
+	for _, f := range files {
+		got[f.Path] = f.PublicPath + ":" + string(f.PublicContent)
+	}
+	want := map[string]string{
+		".env":      ":",
+		"docs/a.md": "docs/a.md:written by johndoe\n",
+		"docs/b.md": "docs/b.md:b\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Preview = %v, want %v", got, want)
+	}
+	for p, w := range want {
+		if got[p] != w {
+			t.Errorf("%s = %q, want %q", p, got[p], w)
+		}
+	}
+}
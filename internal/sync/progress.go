@@ -0,0 +1,35 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+// defaultProgressInterval is how often Options.Progress is called while a
+// target is being filtered, unless Options.ProgressInterval is set.
+const defaultProgressInterval = 2 * time.Second
+
+// throttleProgress wraps fn so it is called at most once per interval, plus
+// for the final report.
+func throttleProgress(fn func(scrub.Progress), interval time.Duration) func(scrub.Progress) {
+	var last time.Time
+	return func(p scrub.Progress) {
+		if now := time.Now(); p.Done || now.Sub(last) >= interval {
+			last = now
+			fn(p)
+		}
+	}
+}
+
+// jobProgress returns the progress callback for target label, or nil.
+func jobProgress(opts Options, label string) func(scrub.Progress) {
+	if opts.Progress == nil {
+		return nil
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return throttleProgress(func(p scrub.Progress) { opts.Progress(label, p) }, interval)
+}
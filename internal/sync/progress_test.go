@@ -0,0 +1,20 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/scrub"
+)
+
+func TestThrottleProgress(t *testing.T) {
+	var got []scrub.Progress
+	report := throttleProgress(func(p scrub.Progress) { got = append(got, p) }, time.Hour)
+	for i := 1; i <= 5; i++ {
+		report(scrub.Progress{Commits: i})
+	}
+	report(scrub.Progress{Commits: 5, Done: true})
+	if len(got) != 2 || got[0].Commits != 1 || !got[1].Done {
+		t.Errorf("reports = %+v, want the first and the final one", got)
+	}
+}
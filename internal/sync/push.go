@@ -0,0 +1,439 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
+)
+
+// publishedRefPrefixes are the refs the "refs" push mode publishes.
+var publishedRefPrefixes = []string{"refs/heads/", "refs/tags/", "refs/notes/"}
+
+// pushScope returns the target's push policy and the prefixes of the refs
+// it publishes (none: all of them).
+func pushScope(t config.Target) (config.PushPolicy, []string) {
+	var policy config.PushPolicy
+	if t.Push != nil {
+		policy = *t.Push
+	}
+	prefixes := NamespacePrefixes(t.Namespaces())
+	if len(prefixes) == 0 && policy.Mode == config.PushModeRefs {
+		prefixes = publishedRefPrefixes
+		if t.ReplaceRefs {
+			prefixes = append(append([]string{}, prefixes...), replaceRefPrefix)
+		}
+	}
+	return policy, prefixes
+}
+
+// planPush records in job the ref updates and new commits a push of the
+// scrubbed repo at bare would give the target, relative to the refs git-copy
+// last pushed there. Remote refs git-copy doesn't know of are not counted.
+func planPush(ctx context.Context, job *targetJob, bare string) error {
+	policy, prefixes := pushScope(job.target)
+	local, err := gitx.ListRefs(bare)
+	if err != nil {
+		return err
+	}
+	local = refsUnder(local, prefixes)
+	prev := refsUnder(job.prevPushed, prefixes)
+
+	job.refUpdates = nil
+	for _, ref := range sortedRefNames(local) {
+		if prev[ref] != local[ref] {
+			job.refUpdates = append(job.refUpdates, RefUpdate{Ref: ref, Old: prev[ref], New: local[ref]})
+		}
+	}
+	if policy.Mode != config.PushModeRefs || policy.Prune {
+		for _, ref := range deletedRefs(prev, local) {
+			job.refUpdates = append(job.refUpdates, RefUpdate{Ref: ref, Old: prev[ref]})
+		}
+	}
+	counted := prev
+	if job.adoptBase != "" && len(prev) == 0 {
+		// The adopted history is on the target already.
+		counted = map[string]string{"": job.adoptBase}
+	}
+	job.newCommits, err = countNewCommits(ctx, bare, local, counted)
+	return err
+}
+
+// countNewCommits counts the commits reachable from the refs of local but
+// not from those of prev that bare has.
+func countNewCommits(ctx context.Context, bare string, local, prev map[string]string) (int, error) {
+	if len(local) == 0 {
+		return 0, nil
+	}
+	var revs strings.Builder
+	for _, sha := range local {
+		revs.WriteString(sha + "\n")
+	}
+	if len(prev) > 0 {
+		var oids strings.Builder
+		for _, sha := range prev {
+			oids.WriteString(sha + "\n")
+		}
+		res, err := gitx.RunInput(ctx, bare, []byte(oids.String()), "cat-file", "--batch-check=%(objectname)")
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range strings.Split(res.Stdout, "\n") {
+			if line != "" && !strings.HasSuffix(line, " missing") {
+				revs.WriteString("^" + line + "\n")
+			}
+		}
+	}
+	res, err := gitx.RunInput(ctx, bare, []byte(revs.String()), "rev-list", "--count", "--stdin")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(res.Stdout))
+}
+
+// retryPolicy returns the target's retry policy, filling in the defaults.
+// The config was validated, so the durations parse.
+func retryPolicy(t config.Target) retry.Policy {
+	p := retry.Default
+	if r := t.Retry; r != nil {
+		if r.Attempts > 0 {
+			p.Attempts = r.Attempts
+		}
+		if r.Delay != "" {
+			p.Delay, _ = time.ParseDuration(r.Delay)
+		}
+		if r.MaxDelay != "" {
+			p.MaxDelay, _ = time.ParseDuration(r.MaxDelay)
+		}
+	}
+	return p
+}
+
+// DivergedError reports target refs that were changed since git-copy last
+// pushed them, which a push would overwrite or delete.
+type DivergedError struct {
+	// Refs are the diverged refs, sorted.
+	Refs []RefUpdate
+}
+
+func (e *DivergedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "target has %d ref(s) git-copy did not push; rerun with --allow-remote-overwrite to overwrite them:", len(e.Refs))
+	for _, u := range e.Refs {
+		fmt.Fprintf(&b, "\n  %s: target %s, last pushed %s", u.Ref, shortOID(u.Old), shortOID(u.New))
+	}
+	return b.String()
+}
+
+func shortOID(sha string) string {
+	switch {
+	case sha == "":
+		return "(none)"
+	case len(sha) > 12:
+		return sha[:12]
+	}
+	return sha
+}
+
+// checkDivergence compares the target's refs, remote, with those git-copy
+// last pushed. A ref the push would change that is neither where git-copy
+// left it nor already at the new value was changed by someone else, and
+// fails the push with a *DivergedError. Before the first push, published refs
+// at the adopted base are not diverged: the push fast-forwards them.
+func checkDivergence(job *targetJob, remote, local map[string]string) error {
+	policy, prefixes := pushScope(job.target)
+	prev := refsUnder(job.prevPushed, prefixes)
+	touched := map[string]bool{}
+	switch {
+	case policy.ForceWithLease:
+		for _, u := range job.refUpdates {
+			touched[u.Ref] = true
+		}
+		// The lease is taken on the remote value, so refs that moved on the
+		// target are pushed too.
+		for ref, sha := range local {
+			touched[ref] = touched[ref] || remote[ref] != sha
+		}
+	case policy.Mode == config.PushModeRefs:
+		for ref := range local {
+			touched[ref] = true
+		}
+		for _, u := range job.refUpdates {
+			touched[u.Ref] = true
+		}
+	default:
+		// A mirror deletes every remote ref it doesn't have.
+		for ref := range local {
+			touched[ref] = true
+		}
+		for ref := range remote {
+			touched[ref] = true
+		}
+	}
+
+	var diverged []RefUpdate
+	for _, ref := range sortedRefNames(remote) {
+		sha := remote[ref]
+		if len(prev) == 0 && sha == job.adoptBase && local[ref] != "" {
+			continue
+		}
+		if touched[ref] && sha != prev[ref] && sha != local[ref] {
+			diverged = append(diverged, RefUpdate{Ref: ref, Old: sha, New: prev[ref]})
+		}
+	}
+	if len(diverged) > 0 {
+		return &DivergedError{Refs: diverged}
+	}
+	return nil
+}
+
+// VerifyError reports target refs that don't hold what the push sent,
+// found by listing the target after pushing, e.g. after a partial push or
+// a transfer mangled by a proxy.
+type VerifyError struct {
+	// Refs are the mismatched refs, sorted: Old is the target's value
+	// (empty if it lacks the ref), New the pushed one (empty for a ref the
+	// push deleted).
+	Refs []RefUpdate
+}
+
+func (e *VerifyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "push verification failed: %d target ref(s) don't match what was pushed:", len(e.Refs))
+	for _, u := range e.Refs {
+		fmt.Fprintf(&b, "\n  %s: target %s, pushed %s", u.Ref, shortOID(u.Old), shortOID(u.New))
+	}
+	return b.String()
+}
+
+// verifyPush compares the target's refs after the push, remote, with the
+// pushed refs, local, and the refs the push deleted.
+func verifyPush(job *targetJob, remote, local map[string]string) error {
+	var bad []RefUpdate
+	for _, ref := range sortedRefNames(local) {
+		if remote[ref] != local[ref] {
+			bad = append(bad, RefUpdate{Ref: ref, Old: remote[ref], New: local[ref]})
+		}
+	}
+	for _, u := range job.refUpdates {
+		if sha, ok := remote[u.Ref]; ok && u.New == "" {
+			bad = append(bad, RefUpdate{Ref: u.Ref, Old: sha})
+		}
+	}
+	if len(bad) > 0 {
+		sort.Slice(bad, func(i, j int) bool { return bad[i].Ref < bad[j].Ref })
+		return &VerifyError{Refs: bad}
+	}
+	return nil
+}
+
+// remoteRefs lists the target's refs in the push scope, leaving out those
+// the hosting service creates itself.
+// It also returns the ref the target's HEAD names.
+func remoteRefs(ctx context.Context, job *targetJob, prefixes []string, env []string) (map[string]string, string, error) {
+	refs, head, err := gitx.LsRemoteHead(ctx, job.finalBare, job.target.RepoURL, env)
+	if err != nil {
+		return nil, "", err
+	}
+	refs = refsUnder(refs, prefixes)
+	for ref := range refs {
+		if hasAnyPrefix(ref, gitx.ForgeRefPrefixes) {
+			delete(refs, ref)
+		}
+	}
+	return refs, head, nil
+}
+
+// pushTarget pushes the scrubbed cache at job.finalBare to the target as its
+// push policy says, and records the refs it published in job.pushedRefs (if
+// verification fails, those the target reports).
+// Unless job.allowOverwrite is set, the target is first checked for being
+// the repo git-copy last pushed to (on the first try only), and for refs
+// changed since git-copy last pushed them (job.prevPushed). Afterwards the target is listed again to
+// check that it has the pushed refs, and its identity is recorded in
+// job.remote.
+func pushTarget(ctx context.Context, job *targetJob, env []string) error {
+	t := job.target
+	if err := planPush(ctx, job, job.finalBare); err != nil {
+		return err
+	}
+	policy, prefixes := pushScope(t)
+	refsMode := policy.Mode == config.PushModeRefs
+	local, err := gitx.ListRefs(job.finalBare)
+	if err != nil {
+		return err
+	}
+	local = refsUnder(local, prefixes)
+	if gitx.IsBundle(t.RepoURL) {
+		return writeBundle(ctx, job, local)
+	}
+	if p, ok := gitx.LocalPath(t.RepoURL); ok {
+		// A local mirror is created on first use.
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			if err := gitx.InitEmptyBare(p); err != nil {
+				return err
+			}
+		}
+	}
+	remote, head, err := remoteRefs(ctx, job, prefixes, env)
+	if err != nil {
+		return err
+	}
+	identity := remoteIdentity(ctx, job, head)
+	if !job.remoteChecked {
+		job.remoteChecked = true
+		if changed := checkRemoteIdentity(job, identity, remote, prefixes); changed != nil {
+			if !job.allowOverwrite {
+				return changed
+			}
+			job.warnings = append(job.warnings, fmt.Sprintf("target %s: pushing anyway to a repo that is not the one git-copy pushed to: %s", changed.Target, changed.Reason))
+		}
+	}
+	if !job.allowOverwrite {
+		if err := checkDivergence(job, remote, local); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case policy.ForceWithLease:
+		// Only refs whose value on the target changes are pushed, each
+		// leased on the value just listed, so nothing pushed to the target
+		// meanwhile is lost. Deletions are limited to refs git-copy pushed,
+		// so unknown remote refs survive even a mirror.
+		lease := map[string]string{}
+		var refspecs []string
+		for _, ref := range sortedRefNames(local) {
+			if remote[ref] != local[ref] {
+				lease[ref] = remote[ref]
+				refspecs = append(refspecs, ref+":"+ref)
+			}
+		}
+		for _, u := range job.refUpdates {
+			if u.New != "" {
+				continue
+			}
+			if _, ok := remote[u.Ref]; !ok {
+				continue
+			}
+			lease[u.Ref] = remote[u.Ref]
+			refspecs = append(refspecs, ":"+u.Ref)
+		}
+		job.pushedBytes, err = gitx.PushRefspecs(ctx, job.finalBare, t.RepoURL, refspecs, lease, env)
+	case refsMode:
+		refspecs := make([]string, 0, len(prefixes))
+		for _, p := range prefixes {
+			refspecs = append(refspecs, p+"*:"+p+"*")
+		}
+		for _, u := range job.refUpdates {
+			if u.New == "" {
+				refspecs = append(refspecs, ":"+u.Ref)
+			}
+		}
+		job.pushedBytes, err = gitx.PushRefspecs(ctx, job.finalBare, t.RepoURL, refspecs, nil, env)
+	case len(prefixes) > 0:
+		// Only the namespaces belong to the mirror; --mirror would delete
+		// every other ref on the target.
+		job.pushedBytes, err = gitx.PushPrefixes(ctx, job.finalBare, t.RepoURL, prefixes, env)
+	default:
+		job.pushedBytes, err = gitx.PushMirror(ctx, job.finalBare, t.RepoURL, env)
+	}
+	if err != nil {
+		return err
+	}
+	after, head, err := remoteRefs(ctx, job, prefixes, env)
+	if err != nil {
+		return fmt.Errorf("verify push: %w", err)
+	}
+	// A first push into an empty repo may have set its HEAD.
+	identity.Head = head
+	if err := verifyPush(job, after, local); err != nil {
+		// The push went through: what the target holds now is what the
+		// next sync must compare with.
+		job.pushedRefs, job.remote = after, identity
+		return err
+	}
+	job.pushedRefs, job.remote = local, identity
+	return nil
+}
+
+// writeBundle replaces the target's bundle file with one holding local, the
+// published refs, and a HEAD for clones to check out: the head branch, or
+// else the first published branch. The bundle is rewritten whole, without a
+// network, so push policies and the divergence check don't apply.
+func writeBundle(ctx context.Context, job *targetJob, local map[string]string) error {
+	path, _ := gitx.LocalPath(job.target.RepoURL)
+	refs := sortedRefNames(local)
+	head := job.headRef
+	if _, ok := local[head]; !ok {
+		head = ""
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, "refs/heads/") {
+				head = ref
+				break
+			}
+		}
+	}
+	if head != "" {
+		if _, err := gitx.Run(ctx, job.finalBare, "symbolic-ref", "HEAD", head); err != nil {
+			return err
+		}
+		refs = append(refs, "HEAD")
+	}
+	if err := gitx.WriteBundle(ctx, job.finalBare, path, refs); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		job.pushedBytes = info.Size()
+	}
+	job.pushedRefs = local
+	return nil
+}
+
+// refsUnder returns the refs under any of prefixes, or all of them if there
+// are no prefixes.
+func refsUnder(refs map[string]string, prefixes []string) map[string]string {
+	out := map[string]string{}
+	for ref, sha := range refs {
+		if len(prefixes) == 0 || hasAnyPrefix(ref, prefixes) {
+			out[ref] = sha
+		}
+	}
+	return out
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletedRefs returns the refs of prev that local no longer has, sorted.
+func deletedRefs(prev, local map[string]string) []string {
+	var out []string
+	for _, ref := range sortedRefNames(prev) {
+		if _, ok := local[ref]; !ok {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+func sortedRefNames(refs map[string]string) []string {
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	return names
+}
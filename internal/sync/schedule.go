@@ -0,0 +1,23 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+)
+
+// nextDue returns when the target's schedule next allows a sync after its
+// last successful one at last, or the zero time if it may sync any time.
+// The config was validated, so the schedule parses.
+func nextDue(t config.Target, last time.Time) time.Time {
+	s := t.Schedule
+	if s == nil || last.IsZero() {
+		return time.Time{}
+	}
+	if s.Interval != "" {
+		d, _ := time.ParseDuration(s.Interval)
+		return last.Add(d)
+	}
+	c, _ := config.ParseCron(s.Cron)
+	return c.Next(last.Local())
+}
@@ -0,0 +1,517 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+// Signature blocks appended to tag messages, as recognized by git.
+var tagSignatureMarkers = [][]byte{
+	[]byte("-----BEGIN PGP SIGNATURE-----"),
+	[]byte("-----BEGIN SSH SIGNATURE-----"),
+}
+
+// signPublished re-signs the annotated tags of the job's imported repo, and
+// its commits when key.Commits is set, then moves the refs, the notes and the
+// import marks to the signed objects. The export strips the private
+// signatures, so this is the only way the mirror can carry verified tags.
+//
+// Signatures aren't reproducible: signing the same commit again gives it a
+// new id, and every descendant with it. Signed objects that didn't change
+// (those cloned from the cache) are kept, so incremental syncs only sign what
+// they imported, and a rebuild reuses the signed commits of the cache through
+// its commit map, and its signed tags, wherever the unsigned object is the
+// same. Without the cache every public id changes.
+func signPublished(ctx context.Context, job *targetJob, key config.SigningKey) error {
+	bare := job.tmpBare
+	refs, err := gitx.ListRefs(bare)
+	if err != nil {
+		return err
+	}
+	w, err := gitx.NewObjectWriter(ctx, bare)
+	if err != nil {
+		return err
+	}
+	s := &signer{ctx: ctx, bare: bare, prevBare: job.finalBare, key: key, w: w, remap: map[string]string{}}
+	err = s.sign(job, refs)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := updateRemappedRefs(ctx, bare, refs, s.remap); err != nil {
+		return err
+	}
+	return remapMarks(incrementalPath(bare, publicMarksFile), s.remap)
+}
+
+// A signer re-signs the objects of an imported repo, recording rewritten ids
+// in remap.
+type signer struct {
+	ctx      context.Context
+	bare     string
+	prevBare string // the cache, whose signatures are reused
+	key      config.SigningKey
+	w        *gitx.ObjectWriter
+	remap    map[string]string
+
+	prevTags map[string][]byte // signed tags of the cache, by unsigned payload
+}
+
+func (s *signer) sign(job *targetJob, refs map[string]string) error {
+	if s.key.Commits {
+		prev, err := s.previousCommits(job)
+		if err != nil {
+			return err
+		}
+		if err := s.signCommits(prev); err != nil {
+			return err
+		}
+	}
+	if err := s.signTags(refs); err != nil {
+		return err
+	}
+	return s.remapNotes(refs)
+}
+
+// previousCommits returns the signed commits the cache published for the
+// private commits this job imported differently, by imported commit id.
+func (s *signer) previousCommits(job *targetJob) (map[string][]byte, error) {
+	if s.prevBare == "" {
+		return nil, nil
+	}
+	published, err := readCommitMap(s.prevBare)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	imported, err := joinCommitMap(job)
+	if err != nil {
+		return nil, err
+	}
+	byPublic := map[string][]string{}
+	var oids []string
+	for private, oid := range imported {
+		if pub, ok := published[private]; ok && pub != oid {
+			if byPublic[pub] == nil {
+				oids = append(oids, pub)
+			}
+			byPublic[pub] = append(byPublic[pub], oid)
+		}
+	}
+	if len(oids) == 0 {
+		return nil, nil
+	}
+	sort.Strings(oids)
+	prev := map[string][]byte{}
+	err = gitx.ReadObjects(s.ctx, s.prevBare, oids, func(oid, typ string, content []byte) error {
+		if typ == "commit" {
+			for _, imp := range byPublic[oid] {
+				prev[imp] = content
+			}
+		}
+		return nil
+	})
+	return prev, err
+}
+
+// signCommits signs every commit reachable from a non-notes ref, parents
+// first. A commit whose previous signed version in prev differs only by its
+// signature gets that version back.
+func (s *signer) signCommits(prev map[string][]byte) error {
+	commits, err := gitx.RevList(s.bare, "--reverse", "--topo-order", "--exclude=refs/notes/*", "--all")
+	if err != nil {
+		return err
+	}
+	return gitx.ReadObjects(s.ctx, s.bare, commits, func(oid, _ string, content []byte) error {
+		payload, changed, signed := unsignedCommit(content, s.remap)
+		if signed && !changed {
+			return nil
+		}
+		signedCommit := prev[oid]
+		if signedCommit != nil {
+			if p, _, _ := unsignedCommit(signedCommit, nil); !bytes.Equal(p, payload) {
+				signedCommit = nil
+			}
+		}
+		if signedCommit == nil {
+			sig, err := signPayload(s.ctx, s.key, payload)
+			if err != nil {
+				return fmt.Errorf("commit %s: %w", oid, err)
+			}
+			signedCommit = insertCommitSignature(payload, sig)
+		}
+		return s.write(oid, "commit", signedCommit)
+	})
+}
+
+// signTags signs the annotated tags under refs/tags, and the tags those tag,
+// tagged objects first. Lightweight tags follow their commit.
+func (s *signer) signTags(refs map[string]string) error {
+	tags, err := readTags(s.ctx, s.bare, refs)
+	if err != nil {
+		return err
+	}
+	oids := make([]string, 0, len(tags))
+	for oid := range tags {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+	done := map[string]bool{}
+	var visit func(oid string) error
+	visit = func(oid string) error {
+		if done[oid] {
+			return nil
+		}
+		done[oid] = true
+		if target := tagObject(tags[oid]); tags[target] != nil {
+			if err := visit(target); err != nil {
+				return err
+			}
+		}
+		payload, changed, signed := unsignedTag(tags[oid], s.remap)
+		if signed && !changed {
+			return nil
+		}
+		signedTag, err := s.previousTag(payload)
+		if err != nil {
+			return err
+		}
+		if signedTag == nil {
+			sig, err := signPayload(s.ctx, s.key, payload)
+			if err != nil {
+				return fmt.Errorf("tag %s: %w", oid, err)
+			}
+			signedTag = append(payload, sig...)
+		}
+		return s.write(oid, "tag", signedTag)
+	}
+	for _, oid := range oids {
+		if err := visit(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousTag returns the signed tag of the cache with the given unsigned
+// payload, or nil.
+func (s *signer) previousTag(payload []byte) ([]byte, error) {
+	if s.prevTags == nil {
+		s.prevTags = map[string][]byte{}
+		if _, err := os.Stat(s.prevBare); s.prevBare != "" && err == nil {
+			refs, err := gitx.ListRefs(s.prevBare)
+			if err != nil {
+				return nil, err
+			}
+			tags, err := readTags(s.ctx, s.prevBare, refs)
+			if err != nil {
+				return nil, err
+			}
+			for _, raw := range tags {
+				if p, _, signed := unsignedTag(raw, nil); signed {
+					s.prevTags[string(p)] = raw
+				}
+			}
+		}
+	}
+	return s.prevTags[string(payload)], nil
+}
+
+// write stores a signed object and records it as the new id of oid.
+func (s *signer) write(oid, typ string, content []byte) error {
+	signedOID, err := s.w.Write(typ, content)
+	if err != nil {
+		return err
+	}
+	if signedOID != oid {
+		s.remap[oid] = signedOID
+	}
+	return nil
+}
+
+// remapNotes moves the notes of re-signed commits to their signed ids. Each
+// changed notes ref gets a copy of its tip commit with the new tree, so the
+// result doesn't depend on when it was made; its history keeps the old ids.
+func (s *signer) remapNotes(refs map[string]string) error {
+	if len(s.remap) == 0 {
+		return nil
+	}
+	index, err := os.CreateTemp("", "git-copy-notes-index-")
+	if err != nil {
+		return err
+	}
+	index.Close()
+	defer os.Remove(index.Name())
+	for _, ref := range sortedRefNames(refs) {
+		if !strings.HasPrefix(ref, "refs/notes/") {
+			continue
+		}
+		tip := refs[ref]
+		res, err := gitx.Run(s.ctx, s.bare, "ls-tree", "-r", "-z", tip)
+		if err != nil {
+			return err
+		}
+		zero := strings.Repeat("0", len(tip))
+		var info bytes.Buffer
+		for _, entry := range strings.Split(res.Stdout, "\x00") {
+			meta, path, ok := strings.Cut(entry, "\t")
+			fields := strings.Fields(meta)
+			if !ok || len(fields) != 3 {
+				continue
+			}
+			if signed, ok := s.remap[strings.ReplaceAll(path, "/", "")]; ok {
+				fmt.Fprintf(&info, "0 %s\t%s\n%s %s\t%s\n", zero, path, fields[0], fields[2], signed)
+			}
+		}
+		if info.Len() == 0 {
+			continue
+		}
+		run := func(input []byte, args ...string) (string, error) {
+			cmd := exec.CommandContext(s.ctx, "git", args...)
+			cmd.Dir = s.bare
+			cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+index.Name())
+			cmd.Stdin = bytes.NewReader(input)
+			out, err := cmd.Output()
+			if err != nil {
+				return "", fmt.Errorf("git %s failed: %w", strings.Join(args, " "), err)
+			}
+			return strings.TrimSpace(string(out)), nil
+		}
+		if _, err := run(nil, "read-tree", tip); err != nil {
+			return err
+		}
+		if _, err := run(info.Bytes(), "update-index", "--index-info"); err != nil {
+			return err
+		}
+		tree, err := run(nil, "write-tree")
+		if err != nil {
+			return err
+		}
+		var raw []byte
+		if err := gitx.ReadObjects(s.ctx, s.bare, []string{tip}, func(_, _ string, content []byte) error {
+			raw = content
+			return nil
+		}); err != nil {
+			return err
+		}
+		_, rest, _ := bytes.Cut(raw, []byte("\n"))
+		if err := s.write(tip, "commit", append([]byte("tree "+tree+"\n"), rest...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTags returns the annotated tags under refs/tags and the tags they tag,
+// by id.
+func readTags(ctx context.Context, bare string, refs map[string]string) (map[string][]byte, error) {
+	tags := map[string][]byte{}
+	seen := map[string]bool{}
+	var oids []string
+	for ref, oid := range refs {
+		if strings.HasPrefix(ref, "refs/tags/") && !seen[oid] {
+			seen[oid] = true
+			oids = append(oids, oid)
+		}
+	}
+	for len(oids) > 0 {
+		sort.Strings(oids)
+		var next []string
+		err := gitx.ReadObjects(ctx, bare, oids, func(oid, typ string, content []byte) error {
+			if typ != "tag" {
+				return nil
+			}
+			tags[oid] = content
+			if target := tagObject(content); !seen[target] && bytes.Contains(content, []byte("\ntype tag\n")) {
+				seen[target] = true
+				next = append(next, target)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		oids = next
+	}
+	return tags, nil
+}
+
+// tagObject returns the id of the object a raw tag tags.
+func tagObject(raw []byte) string {
+	line, _, _ := bytes.Cut(raw, []byte("\n"))
+	obj, _ := strings.CutPrefix(string(line), "object ")
+	return obj
+}
+
+// unsignedCommit returns a raw commit without its signature headers and with
+// parents mapped through remap. changed reports a remapped parent and signed
+// an existing signature.
+func unsignedCommit(raw []byte, remap map[string]string) (payload []byte, changed, signed bool) {
+	header, body, _ := bytes.Cut(raw, []byte("\n\n"))
+	var out bytes.Buffer
+	inSig := false
+	for _, line := range strings.Split(string(header), "\n") {
+		if strings.HasPrefix(line, " ") {
+			// Continuation of the previous header.
+			if !inSig {
+				out.WriteString(line + "\n")
+			}
+			continue
+		}
+		inSig = strings.HasPrefix(line, "gpgsig ") || strings.HasPrefix(line, "gpgsig-sha256 ")
+		if inSig {
+			signed = true
+			continue
+		}
+		if p, ok := strings.CutPrefix(line, "parent "); ok {
+			if np, ok := remap[p]; ok {
+				line, changed = "parent "+np, true
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	out.WriteString("\n")
+	out.Write(body)
+	return out.Bytes(), changed, signed
+}
+
+// insertCommitSignature adds sig as a gpgsig header at the end of the
+// commit's headers.
+func insertCommitSignature(payload, sig []byte) []byte {
+	end := bytes.Index(payload, []byte("\n\n")) + 1
+	var out bytes.Buffer
+	out.Write(payload[:end])
+	out.WriteString("gpgsig ")
+	out.WriteString(strings.ReplaceAll(strings.TrimSuffix(string(sig), "\n"), "\n", "\n "))
+	out.WriteString("\n")
+	out.Write(payload[end:])
+	return out.Bytes()
+}
+
+// unsignedTag returns a raw tag without its signature block and with the
+// tagged object mapped through remap. The message is given a final newline
+// so a signature can be appended.
+func unsignedTag(raw []byte, remap map[string]string) (payload []byte, changed, signed bool) {
+	header, body, _ := bytes.Cut(raw, []byte("\n\n"))
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(header), "\n") {
+		if obj, ok := strings.CutPrefix(line, "object "); ok {
+			if nobj, ok := remap[obj]; ok {
+				line, changed = "object "+nobj, true
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	out.WriteString("\n")
+	for i := 0; i < len(body); {
+		if hasSignatureMarker(body[i:]) {
+			body, signed = body[:i], true
+			break
+		}
+		j := bytes.IndexByte(body[i:], '\n')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	out.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		out.WriteString("\n")
+	}
+	return out.Bytes(), changed, signed
+}
+
+func hasSignatureMarker(line []byte) bool {
+	for _, m := range tagSignatureMarkers {
+		if bytes.HasPrefix(line, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// signPayload returns an armored detached signature of payload made with
+// gpg or ssh-keygen, as git would.
+func signPayload(ctx context.Context, key config.SigningKey, payload []byte) ([]byte, error) {
+	var cmd *exec.Cmd
+	if key.Format == config.SigningSSH {
+		file := key.Key
+		if rest, ok := strings.CutPrefix(file, "~/"); ok {
+			home, _ := os.UserHomeDir()
+			file = filepath.Join(home, rest)
+		}
+		cmd = exec.CommandContext(ctx, "ssh-keygen", "-Y", "sign", "-n", "git", "-f", file)
+	} else {
+		cmd = exec.CommandContext(ctx, "gpg", "--batch", "--status-fd=2", "-bsau", key.Key)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w\n%s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s produced no signature", cmd.Args[0])
+	}
+	return stdout.Bytes(), nil
+}
+
+// updateRemappedRefs points refs at their re-signed objects.
+func updateRemappedRefs(ctx context.Context, bare string, refs map[string]string, remap map[string]string) error {
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		if _, ok := remap[refs[ref]]; ok {
+			names = append(names, ref)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	var in bytes.Buffer
+	for _, ref := range names {
+		fmt.Fprintf(&in, "update %s %s %s\n", ref, remap[refs[ref]], refs[ref])
+	}
+	_, err := gitx.RunInput(ctx, bare, in.Bytes(), "update-ref", "--stdin")
+	return err
+}
+
+// remapMarks rewrites a fast-import marks file so that later incremental
+// imports build on the re-signed commits.
+func remapMarks(path string, remap map[string]string) error {
+	if len(remap) == 0 {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		mark, oid, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if n, ok := remap[oid]; ok {
+			lines[i] = mark + " " + n
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)
+}
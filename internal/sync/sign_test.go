@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+)
+
+func TestSyncRepo_SignsTagsAndCommits(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// ECDSA signatures differ each time, like gpg's.
+	key := filepath.Join(tmp, "signing_key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ecdsa", "-N", "", "-C", "johndoe", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatalf("read public key: %v", err)
+	}
+	allowed := filepath.Join(tmp, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte("johndoe@users.noreply.github.com "+string(pub)), 0o644); err != nil {
+		t.Fatalf("write allowed signers: %v", err)
+	}
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(name, msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{name: msg + "\n"}, msg)
+	}
+	commit("a.txt", "First")
+	if _, err := gitx.Run(ctx, src, "tag", "-a", "-m", "Release v1", "v1"); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	if _, err := gitx.Run(ctx, src, "tag", "-a", "-m", "Announce v1", "v1-announce", "v1"); err != nil {
+		t.Fatalf("nested tag: %v", err)
+	}
+	if _, err := gitx.Run(ctx, src, "notes", "add", "-m", "Reviewed", "main"); err != nil {
+		t.Fatalf("notes add: %v", err)
+	}
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	_, _ = gitx.Run(ctx, dst, "config", "gpg.ssh.allowedSignersFile", allowed)
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Targets: []config.Target{{
+			Label:              "t",
+			Provider:           "none",
+			Account:            "johndoe",
+			RepoName:           "dst",
+			RepoURL:            dst,
+			PublicAuthorEmail:  "johndoe@users.noreply.github.com",
+			InitialHistoryMode: "full",
+			Signing:            &config.SigningKey{Format: config.SigningSSH, Key: key, Commits: true},
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache"), Validate: true}
+	if _, err := SyncRepo(ctx, src, cfg, "", opts); err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	for _, verify := range [][]string{{"verify-commit", "refs/heads/main"}, {"verify-tag", "v1"}, {"verify-tag", "v1-announce"}} {
+		if _, err := gitx.Run(ctx, dst, verify...); err != nil {
+			t.Fatalf("%s: %v", verify[0], err)
+		}
+	}
+	first, err := gitx.RevParse(dst, "refs/heads/main")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	// The nested tag tags a signed tag, and the note follows the signed
+	// commit. (fast-export names the inner tag after the outer one.)
+	res, err := gitx.Run(ctx, dst, "rev-parse", "refs/tags/v1", "refs/tags/v1-announce^{tag}")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	tags := strings.Fields(res.Stdout)
+	v1 := tags[0]
+	outer, err := gitx.Run(ctx, dst, "cat-file", "tag", tags[1])
+	if err != nil {
+		t.Fatalf("cat-file: %v", err)
+	}
+	inner := strings.TrimPrefix(strings.Fields(outer.Stdout)[1], "object ")
+	if _, err := gitx.Run(ctx, dst, "verify-tag", inner); err != nil {
+		t.Fatalf("verify-tag of the nested tag %s: %v", inner, err)
+	}
+	if got, err := gitx.Run(ctx, dst, "notes", "show", first); err != nil || strings.TrimSpace(got.Stdout) != "Reviewed" {
+		t.Fatalf("notes show %s = %q, %v", first, got.Stdout, err)
+	}
+
+	// An incremental sync signs the new commit and keeps the published ones.
+	commit("b.txt", "Second")
+	if _, err := SyncRepo(ctx, src, cfg, "", opts); err != nil {
+		t.Fatalf("SyncRepo (second): %v", err)
+	}
+	if _, err := gitx.Run(ctx, dst, "verify-commit", "refs/heads/main"); err != nil {
+		t.Fatalf("verify-commit after second sync: %v", err)
+	}
+	parent, err := gitx.RevParse(dst, "refs/heads/main^")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	if parent != first {
+		t.Fatalf("second sync rewrote the published commit: parent %s, want %s", parent, first)
+	}
+	log, err := gitx.Run(ctx, dst, "log", "--format=%s", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if got := strings.Join(nonEmptyLines(log.Stdout), ","); got != "Second,First" {
+		t.Fatalf("history = %q", got)
+	}
+
+	// A rebuild reuses the cached signatures, so the public ids stay put.
+	head, _ := gitx.RevParse(dst, "refs/heads/main")
+	force := opts
+	force.Force = true
+	if _, err := SyncRepo(ctx, src, cfg, "", force); err != nil {
+		t.Fatalf("SyncRepo (force): %v", err)
+	}
+	res, err = gitx.Run(ctx, dst, "rev-parse", "refs/heads/main", "refs/tags/v1", "refs/tags/v1-announce")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	if got, want := strings.Fields(res.Stdout), []string{head, v1, tags[1]}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("rebuild moved the refs to %v, want %v", got, want)
+	}
+}
@@ -1,13 +1,14 @@
 package sync
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -15,14 +16,77 @@ import (
 
 	"github.com/obinnaokechukwu/git-copy/internal/config"
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/notify"
 	"github.com/obinnaokechukwu/git-copy/internal/provider"
+	"github.com/obinnaokechukwu/git-copy/internal/retry"
 	"github.com/obinnaokechukwu/git-copy/internal/scrub"
 	"github.com/obinnaokechukwu/git-copy/internal/state"
+	"github.com/obinnaokechukwu/git-copy/internal/throttle"
 )
 
 type Options struct {
 	CacheDir string
 	Validate bool
+	// ValidateParallelism is the number of processes scanning objects during
+	// validation (default: one per CPU).
+	ValidateParallelism int
+	// Progress, if set, is called periodically while each target's history
+	// is filtered, and once when it is done. Calls for different targets may
+	// be concurrent.
+	Progress func(label string, p scrub.Progress)
+	// ProgressInterval is the minimum time between Progress calls for a
+	// target (default 2s).
+	ProgressInterval time.Duration
+	// DryRun filters and imports every selected target into a throwaway
+	// repo and validates it, reporting the ref updates a push would make,
+	// without pushing. DryRunOutput, if set, also receives the fast-import
+	// stream, and the whole history is then filtered. The private repo, the
+	// cache and the sync state are left untouched.
+	DryRun       bool
+	DryRunOutput string
+	// VerifyReproducible filters each target's export twice and fails the
+	// target if the two outputs differ, so nondeterminism in the rules shows
+	// up before it churns the published commit ids.
+	VerifyReproducible bool
+	// Force syncs targets whose private refs and config are unchanged since
+	// their last successful sync, rebuilding the history from scratch rather
+	// than resuming from the cache, and ignores their schedules. For use
+	// after the cache or the target were changed by hand. A rebuild of a
+	// signing target reuses the signatures in its cache.
+	Force bool
+	// IgnoreSchedule syncs targets whose schedule isn't due, as usual:
+	// incrementally, and only if something changed.
+	IgnoreSchedule bool
+	// TargetConcurrency is the number of targets filtered, validated and
+	// pushed at once (default 4). Targets sharing an export count once
+	// while filtering.
+	TargetConcurrency int
+	// UpdatePrivate, if set, overrides the config's update_private: how the
+	// private repo is updated before its refs are read.
+	UpdatePrivate string
+	// AllowRemoteOverwrite pushes even when the target has refs that were
+	// changed since git-copy last pushed them, e.g. by a fix pushed by hand
+	// to the mirror. Without it such a target fails with a *DivergedError.
+	AllowRemoteOverwrite bool
+	// WaitForLock waits for targets another git-copy process is syncing,
+	// calling LockWaiting (if set) once per target when it has to wait.
+	// Without it such targets are skipped, with a *LockedError.
+	WaitForLock bool
+	LockWaiting func(label string, pid int)
+	// HistoryCutoff, if set, overrides the history_cutoff of every synced
+	// target: older history is published as one baseline commit. The
+	// override is kept in the target state and applies to later syncs too,
+	// until another HistoryCutoff replaces it; "none" removes it. Targets
+	// in the "squash" or "future" history modes reject it.
+	HistoryCutoff string
+	// MaxUploadRate, if positive, limits the upload rate of the sync's
+	// pushes, in bytes per second: their total, however many targets and
+	// mirrors are pushed at once. Each target's push.max_upload_rate may
+	// limit its own pushes further.
+	MaxUploadRate int64
+	// CredentialsFromEnv takes push tokens from the environment only (see
+	// envPushEnv) rather than from the gh CLI, for CI runners.
+	CredentialsFromEnv bool
 }
 
 type Result struct {
@@ -31,6 +95,41 @@ type Result struct {
 	SourceCommit string // short hash of source HEAD
 	DidWork      bool
 	Error        error
+	// Warnings are problems that did not stop the sync.
+	Warnings []string
+	// Stats are the filter's totals when the history was filtered.
+	Stats scrub.Progress
+	// Incremental is set when the sync resumed from the cache's marks, so
+	// Stats only count the commits added since the last sync.
+	Incremental bool
+	// RefUpdates are the target refs the push changed, or would change in
+	// a dry run, and NewCommits the commits it gave the target.
+	RefUpdates []RefUpdate
+	NewCommits int
+	// PushAttempts is the number of tries the push took, retries of
+	// network or server failures included (0: nothing was pushed).
+	PushAttempts int
+	// PushedBytes is the size of the pack the push sent (of the bundle for
+	// bundle targets).
+	PushedBytes int64
+	// Mirrors are the outcomes of the pushes to the target's mirrors,
+	// which Error doesn't include.
+	Mirrors []MirrorResult
+	// NextDue is set when the target was skipped because its schedule
+	// doesn't allow a sync before then.
+	NextDue time.Time
+	// Duration is the time from the start of the target's preparation to
+	// the end of its push. The export is shared between targets with the
+	// same export settings, so it counts towards each of them.
+	Duration time.Duration
+}
+
+// RefUpdate is a change to a target ref. Old is empty for a created ref and
+// New for a deleted one.
+type RefUpdate struct {
+	Ref string
+	Old string
+	New string
 }
 
 func SyncRepo(ctx context.Context, repoPath string, cfg config.RepoConfig, onlyTarget string, opts Options) ([]Result, error) {
@@ -43,13 +142,61 @@ func SyncRepo(ctx context.Context, repoPath string, cfg config.RepoConfig, onlyT
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	switch opts.UpdatePrivate {
+	case "", config.UpdatePrivatePull, config.UpdatePrivateFetch, config.UpdatePrivateNever:
+	default:
+		return nil, fmt.Errorf("update-private must be %s, %s or %s, not %q", config.UpdatePrivatePull, config.UpdatePrivateFetch, config.UpdatePrivateNever, opts.UpdatePrivate)
+	}
 
 	// update private repo first (best-effort)
-	clean, _ := gitx.HasCleanWorktree(repoPath)
-	if clean {
-		_ = gitx.PullRebaseAutostash(repoPath)
-	} else {
-		_ = gitx.FetchAll(repoPath)
+	update := cfg.UpdatePrivate
+	if opts.UpdatePrivate != "" {
+		update = opts.UpdatePrivate
+	}
+	if !opts.DryRun {
+		switch update {
+		case "", config.UpdatePrivatePull:
+			clean, _ := gitx.HasCleanWorktree(repoPath)
+			if clean {
+				_ = gitx.PullRebaseAutostash(repoPath)
+			} else {
+				_ = gitx.FetchAll(repoPath)
+			}
+		case config.UpdatePrivateFetch:
+			_ = gitx.FetchAll(repoPath)
+		}
+	}
+
+	// Hold the lock of every selected target until the sync is done, so a
+	// concurrent run (e.g. a manual sync racing the daemon's poll) can't
+	// push the same target or swap its cache at the same time. Locks are
+	// taken in configuration order, so waiting syncs can't deadlock. Dry
+	// runs share the lock: they only read the cache.
+	repoKey := repoCacheKey(repoPath)
+	locked := map[string]*LockedError{}
+	for _, t := range cfg.Targets {
+		if onlyTarget != "" && t.Label != onlyTarget {
+			continue
+		}
+		path := targetLockPath(opts.CacheDir, repoKey, t.Label)
+		l, pid, err := tryLockMode(path, opts.DryRun)
+		if err == nil && l == nil && opts.WaitForLock {
+			if opts.LockWaiting != nil {
+				opts.LockWaiting(t.Label, pid)
+			}
+			l, err = waitLock(ctx, path, opts.DryRun)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l == nil {
+			locked[t.Label] = &LockedError{Target: t.Label, PID: pid}
+			continue
+		}
+		defer l.unlock()
+	}
+	if !opts.DryRun {
+		_ = writeRepoRecord(opts.CacheDir, repoKey, repoPath)
 	}
 
 	privateRefs, err := gitx.ListRefs(repoPath)
@@ -63,10 +210,24 @@ func SyncRepo(ctx context.Context, repoPath string, cfg config.RepoConfig, onlyT
 		st.Targets = map[string]*state.TargetState{}
 	}
 
-	repoKey := repoCacheKey(repoPath)
-	results := []Result{}
 	sourceCommit := gitx.HeadShort(repoPath)
 
+	// First pass: decide which targets need work and prepare them, so targets
+	// with compatible export settings can share a single fast-export run.
+	type entry struct {
+		target     config.Target
+		res        Result
+		ts         *state.TargetState
+		configHash string
+		job        *targetJob
+		start      time.Time
+	}
+	var entries []*entry
+	var jobs []*targetJob
+	var uploads *throttle.Limiter
+	if opts.MaxUploadRate > 0 {
+		uploads = throttle.NewLimiter(opts.MaxUploadRate)
+	}
 	for _, t := range cfg.Targets {
 		if onlyTarget != "" && t.Label != onlyTarget {
 			continue
@@ -76,27 +237,170 @@ func SyncRepo(ctx context.Context, repoPath string, cfg config.RepoConfig, onlyT
 			ts = &state.TargetState{}
 			st.Targets[t.Label] = ts
 		}
+		t, cutoffErr := applyHistoryCutoff(t, ts, opts.HistoryCutoff)
 		configHash := targetConfigHash(cfg, t)
+		e := &entry{
+			target:     t,
+			res:        Result{TargetLabel: t.Label, TargetURL: t.RepoURL, SourceCommit: sourceCommit},
+			ts:         ts,
+			configHash: configHash,
+			start:      time.Now(),
+		}
+		entries = append(entries, e)
+		if le := locked[t.Label]; le != nil {
+			e.res.Error = le
+			continue
+		}
+		if cutoffErr != nil {
+			e.res.Error = cutoffErr
+			continue
+		}
+		// Skip until the target's schedule allows another sync, even if
+		// refs changed.
+		if !opts.DryRun && !opts.Force && !opts.IgnoreSchedule {
+			if next := nextDue(t, ts.LastSyncAt); !next.IsZero() && time.Now().Before(next) {
+				e.res.NextDue = next
+				continue
+			}
+		}
 		// Skip if private refs unchanged and last sync succeeded
-		if ts.LastPrivateRefs == privateRefsHash && ts.LastError == "" && ts.LastConfigHash == configHash {
-			results = append(results, Result{TargetLabel: t.Label, TargetURL: t.RepoURL, SourceCommit: sourceCommit, DidWork: false, Error: nil})
+		if !opts.DryRun && !opts.Force && ts.LastPrivateRefs == privateRefsHash && ts.LastError == "" && ts.LastConfigHash == configHash && mirrorsSynced(t, ts) {
+			continue
+		}
+		e.res.DidWork = true
+		// The history scan is slow on large repos; after the first sync
+		// only new commits are scanned.
+		if e.res.Error = checkReplacementsPreflight(ctx, repoPath, cfg, t, ts, privateRefs, &e.res); e.res.Error != nil {
+			continue
+		}
+		squash, err := targetSquashPoint(repoPath, cfg, t, ts)
+		if err != nil {
+			e.res.Error = err
 			continue
 		}
+		job, err := prepareTarget(ctx, repoPath, repoKey, cfg, t, ts, squash, opts)
+		if err != nil {
+			e.res.Error = err
+			continue
+		}
+		job.prevPushed = ts.PushedRefs
+		job.prevRemote = ts.Remote
+		job.prevMirrors = ts.Mirrors
+		job.sourceCommit = sourceCommit
+		job.uploadLimiter = uploads
+		e.job = job
+		jobs = append(jobs, job)
+	}
 
-		res := Result{TargetLabel: t.Label, TargetURL: t.RepoURL, SourceCommit: sourceCommit, DidWork: true}
+	runExports(ctx, repoPath, jobs, targetConcurrency(opts))
+	// A resumed export fails when the saved marks name commits the private
+	// repo no longer has (e.g. after a force push and gc); rebuild those
+	// targets from scratch.
+	var rebuild []*targetJob
+	for _, job := range jobs {
+		if job.err != nil && job.resume != nil {
+			if job.err = initImportRepo(ctx, job, job.rulesKey, false); job.err == nil {
+				rebuild = append(rebuild, job)
+			}
+		}
+	}
+	runExports(ctx, repoPath, rebuild, targetConcurrency(opts))
 
-		err := syncTarget(ctx, repoPath, repoKey, cfg, t, opts)
-		if err != nil {
-			res.Error = err
-			ts.LastError = err.Error()
+	// Validate and push the targets in parallel; results, hooks and state
+	// are handled in configuration order below.
+	var finishing []*entry
+	for _, e := range entries {
+		if e.res.DidWork && e.job != nil {
+			finishing = append(finishing, e)
+		}
+	}
+	forEachLimit(len(finishing), targetConcurrency(opts), func(i int) {
+		e := finishing[i]
+		e.res.Incremental = e.job.resume != nil
+		e.res.Error = finishTarget(ctx, cfg, e.job, opts)
+		e.res.Stats = e.job.stats
+		e.res.RefUpdates, e.res.NewCommits = e.job.refUpdates, e.job.newCommits
+		e.res.PushAttempts, e.res.PushedBytes = e.job.pushAttempts, e.job.pushedBytes
+		e.res.Warnings = append(e.res.Warnings, e.job.warnings...)
+		for _, mp := range e.job.mirrors {
+			e.res.Mirrors = append(e.res.Mirrors, mp.res)
+		}
+		e.res.Duration = time.Since(e.start)
+	})
+
+	results := []Result{}
+	for _, e := range entries {
+		if !e.res.DidWork {
+			results = append(results, e.res)
+			continue
+		}
+		if e.job == nil {
+			e.res.Duration = time.Since(e.start)
+		}
+		if opts.DryRun {
+			results = append(results, e.res)
+			continue
+		}
+		if hooks := e.target.Hooks; hooks != nil && hooks.PostSync != "" {
+			scrubbed := ""
+			if e.job != nil {
+				scrubbed = e.job.finalBare
+			}
+			env := resultEnv(hookEnv(repoPath, e.target, sourceCommit, scrubbed), e.res)
+			if err := runHook(ctx, "post_sync", hooks.PostSync, repoPath, env); err != nil {
+				e.res.Warnings = append(e.res.Warnings, err.Error())
+			}
+		}
+		if len(cfg.Webhooks) > 0 {
+			var pushed map[string]string
+			if e.job != nil {
+				pushed = e.job.pushedRefs
+			}
+			// The receivers are posted to at once, each for a bounded time.
+			ev := syncEvent(repoKey, e.res, pushed)
+			errs := make([]error, len(cfg.Webhooks))
+			forEachLimit(len(cfg.Webhooks), len(cfg.Webhooks), func(i int) {
+				errs[i] = notify.PostWebhook(ctx, cfg.Webhooks[i], ev)
+			})
+			for _, err := range errs {
+				if err != nil {
+					e.res.Warnings = append(e.res.Warnings, err.Error())
+				}
+			}
+		}
+		e.ts.VerifyFailures = nil
+		var verr *VerifyError
+		if errors.As(e.res.Error, &verr) {
+			for _, u := range verr.Refs {
+				e.ts.VerifyFailures = append(e.ts.VerifyFailures, state.RefMismatch{Ref: u.Ref, Target: u.Old, Pushed: u.New})
+			}
+		}
+		if e.res.Error != nil {
+			e.ts.LastError = e.res.Error.Error()
 		} else {
-			ts.LastError = ""
-			ts.LastSyncAt = time.Now()
-			ts.LastPrivateRefs = privateRefsHash
-			ts.LastConfigHash = configHash
+			e.ts.LastError = ""
+			e.ts.LastSyncAt = time.Now()
+			e.ts.LastPrivateRefs = privateRefsHash
+			e.ts.LastConfigHash = e.configHash
 		}
-		results = append(results, res)
-		_ = state.Save(repoPath, st)
+		// Recorded even if a later step failed, as the refs are on the remote.
+		if e.job != nil && e.job.pushedRefs != nil {
+			e.ts.PushedRefs = e.job.pushedRefs
+			if e.job.remote != nil {
+				e.ts.Remote = e.job.remote
+			}
+		}
+		if e.job != nil {
+			recordMirrors(e.ts, e.target, e.job)
+		}
+		if e.res.PushAttempts > 0 {
+			e.ts.LastPushAttempts = e.res.PushAttempts
+		}
+		e.ts.RecordSync(syncRecord(e.res))
+		results = append(results, e.res)
+		_ = updateTargetState(repoPath, stateLockPath(opts.CacheDir, repoKey), e.target.Label, func(ts *state.TargetState) {
+			*ts = *e.ts
+		})
 	}
 
 	return results, nil
@@ -105,8 +409,10 @@ func SyncRepo(ctx context.Context, repoPath string, cfg config.RepoConfig, onlyT
 type configHashPayload struct {
 	Version int `json:"version"`
 
-	PrivateUsername string `json:"private_username"`
-	HeadBranch      string `json:"head_branch"`
+	PrivateUsername     string                      `json:"private_username"`
+	AdditionalUsernames []config.AdditionalUsername `json:"additional_usernames,omitempty"`
+	MatchWholeWord      bool                        `json:"match_whole_word,omitempty"`
+	HeadBranch          string                      `json:"head_branch"`
 
 	TargetLabel    string `json:"target_label"`
 	Provider       string `json:"provider"`
@@ -117,11 +423,50 @@ type configHashPayload struct {
 	PublicName     string `json:"public_author_name"`
 	PublicEmail    string `json:"public_author_email"`
 	InitialHistory string `json:"initial_history_mode"`
+	HistoryCutoff  string `json:"history_cutoff,omitempty"`
 
-	Exclude                   []string          `json:"exclude"`
-	OptIn                     []string          `json:"opt_in"`
-	ReplaceHistoryWithCurrent []string          `json:"replace_history_with_current"`
-	ExtraReplacementPairs     map[string]string `json:"extra_replacements"`
+	Exclude                   []string                 `json:"exclude"`
+	OptIn                     []string                 `json:"opt_in"`
+	Redact                    []string                 `json:"redact,omitempty"`
+	RedactPlaceholder         string                   `json:"redact_placeholder,omitempty"`
+	ReplaceHistoryWithCurrent []string                 `json:"replace_history_with_current"`
+	ExtraReplacementPairs     map[string]string        `json:"extra_replacements"`
+	PathReplacements          []config.PathReplacement `json:"path_replacements,omitempty"`
+	PathMappings              []config.PathMapping     `json:"path_mappings,omitempty"`
+	Subtree                   string                   `json:"subtree,omitempty"`
+	Include                   []string                 `json:"include,omitempty"`
+	SkipBinaryBlobs           bool                     `json:"skip_binary_blobs,omitempty"`
+	BinaryExtensions          []string                 `json:"binary_extensions,omitempty"`
+	MaxRewriteBlobBytes       int64                    `json:"max_rewrite_blob_bytes,omitempty"`
+	LFS                       string                   `json:"lfs,omitempty"`
+	Submodules                string                   `json:"submodules,omitempty"`
+	GoModule                  string                   `json:"go_module,omitempty"`
+	DropNotes                 bool                     `json:"drop_notes,omitempty"`
+	ReplaceRefs               bool                     `json:"replace_refs,omitempty"`
+	KeepOriginalOIDs          bool                     `json:"keep_original_oids,omitempty"`
+	RefInclude                []string                 `json:"ref_include,omitempty"`
+	RefExclude                []string                 `json:"ref_exclude,omitempty"`
+	Tags                      *config.TagPolicy        `json:"tags,omitempty"`
+	BranchRenames             []config.BranchRename    `json:"branch_renames,omitempty"`
+	RefNamespaces             []config.RefNamespace    `json:"ref_namespaces,omitempty"`
+	Push                      *config.PushPolicy       `json:"push,omitempty"`
+	Adopt                     *config.AdoptPolicy      `json:"adopt,omitempty"`
+	RedactSecrets             bool                     `json:"redact_secrets,omitempty"`
+	EntropyCheck              *config.EntropyCheck     `json:"entropy_check,omitempty"`
+	AuthorMap                 []config.AuthorMapping   `json:"author_map,omitempty"`
+	Timestamps                *config.TimestampRule    `json:"timestamps,omitempty"`
+	Messages                  *config.MessageRules     `json:"messages,omitempty"`
+	Trailers                  *config.TrailerRules     `json:"trailers,omitempty"`
+	ForbiddenPatterns         []string                 `json:"forbidden_patterns,omitempty"`
+	Symlinks                  *config.SymlinkPolicy    `json:"symlinks,omitempty"`
+	ExportIgnore              bool                     `json:"export_ignore,omitempty"`
+	ExcludeLargerThan         string                   `json:"exclude_larger_than,omitempty"`
+	ExcludeExtensions         []string                 `json:"exclude_extensions,omitempty"`
+	SkipCommits               []string                 `json:"skip_commits,omitempty"`
+	PruneEmptyMerges          bool                     `json:"prune_empty_merges,omitempty"`
+	Signing                   *config.SigningKey       `json:"signing,omitempty"`
+	ContentFilters            []config.ContentFilter   `json:"content_filters,omitempty"`
+	Inject                    []config.InjectedFile    `json:"inject,omitempty"`
 }
 
 func targetConfigHash(cfg config.RepoConfig, t config.Target) string {
@@ -146,11 +491,21 @@ func targetConfigHash(cfg config.RepoConfig, t config.Target) string {
 		repl = t.Account
 	}
 
+	push := t.Push
+	if push != nil && push.MaxUploadRate != "" {
+		// Throttling changes nothing published.
+		p := *push
+		p.MaxUploadRate = ""
+		push = &p
+	}
+
 	payload := configHashPayload{
 		Version: 1,
 
-		PrivateUsername: cfg.PrivateUsername,
-		HeadBranch:      cfg.HeadBranch,
+		PrivateUsername:     cfg.PrivateUsername,
+		AdditionalUsernames: cfg.AdditionalUsernames,
+		MatchWholeWord:      cfg.MatchWholeWord,
+		HeadBranch:          cfg.HeadBranch,
 
 		TargetLabel:    t.Label,
 		Provider:       t.Provider,
@@ -161,11 +516,50 @@ func targetConfigHash(cfg config.RepoConfig, t config.Target) string {
 		PublicName:     t.PublicAuthorName,
 		PublicEmail:    t.PublicAuthorEmail,
 		InitialHistory: t.InitialHistoryMode,
+		HistoryCutoff:  t.HistoryCutoff,
 
 		Exclude:                   exclude,
 		OptIn:                     optIn,
+		Redact:                    append(append([]string{}, cfg.Defaults.Redact...), t.Redact...),
+		RedactPlaceholder:         cfg.Defaults.RedactPlaceholder,
 		ReplaceHistoryWithCurrent: replaceHistoryWithCurrent,
 		ExtraReplacementPairs:     cfg.Defaults.ExtraReplacementPairs,
+		PathReplacements:          cfg.Defaults.PathReplacements,
+		PathMappings:              append(append([]config.PathMapping{}, cfg.Defaults.PathMappings...), t.PathMappings...),
+		Subtree:                   t.Subtree,
+		Include:                   t.Include,
+		SkipBinaryBlobs:           cfg.Defaults.SkipBinaryBlobs,
+		BinaryExtensions:          cfg.Defaults.BinaryExtensions,
+		MaxRewriteBlobBytes:       cfg.Defaults.MaxRewriteBlobBytes,
+		LFS:                       t.LFS,
+		Submodules:                t.Submodules,
+		GoModule:                  t.GoModule,
+		DropNotes:                 t.DropNotes,
+		ReplaceRefs:               t.ReplaceRefs,
+		KeepOriginalOIDs:          t.KeepOriginalOIDs,
+		RefInclude:                t.RefInclude,
+		RefExclude:                t.RefExclude,
+		Tags:                      t.Tags,
+		BranchRenames:             t.BranchRenames,
+		RefNamespaces:             t.RefNamespaces,
+		Push:                      push,
+		Adopt:                     t.Adopt,
+		RedactSecrets:             cfg.Defaults.RedactSecrets,
+		EntropyCheck:              cfg.Defaults.EntropyCheck,
+		AuthorMap:                 append(append([]config.AuthorMapping{}, t.AuthorMap...), cfg.Defaults.AuthorMap...),
+		Timestamps:                t.Timestamps,
+		Messages:                  targetMessages(cfg, t),
+		Trailers:                  targetTrailers(cfg, t),
+		ForbiddenPatterns:         cfg.Defaults.ForbiddenPatterns,
+		Symlinks:                  cfg.Defaults.Symlinks,
+		ExportIgnore:              cfg.Defaults.ExportIgnore,
+		ExcludeLargerThan:         cfg.Defaults.ExcludeLargerThan,
+		ExcludeExtensions:         cfg.Defaults.ExcludeExtensions,
+		SkipCommits:               cfg.Defaults.SkipCommits,
+		PruneEmptyMerges:          cfg.Defaults.PruneEmptyMerges,
+		Signing:                   t.Signing,
+		ContentFilters:            t.ContentFilters,
+		Inject:                    t.Inject,
 	}
 
 	b, _ := json.Marshal(payload)
@@ -173,8 +567,320 @@ func targetConfigHash(cfg config.RepoConfig, t config.Target) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func syncTarget(ctx context.Context, repoPath, repoKey string, cfg config.RepoConfig, t config.Target, opts Options) error {
-	// Build rules
+// squashPoint describes a squashed export: base is the private commit whose
+// tree is published as the baseline commit ("" to replay the full history)
+// and hide lists further commits that are not published.
+type squashPoint struct {
+	base string
+	hide []string
+}
+
+// targetSquashPoint returns the target's squash point. A history cutoff names
+// the base directly. In "squash" and "future" modes the first sync pins the
+// head branch tip in the target state, so later syncs replay only the commits
+// made on top of it; "future" also hides every other commit on a branch or
+// tag, so only commits made after the first sync are published. Those are
+// listed in the target's squash-hide file rather than the state.
+func targetSquashPoint(repoPath string, cfg config.RepoConfig, t config.Target, ts *state.TargetState) (squashPoint, error) {
+	if cutoff := strings.TrimSpace(t.HistoryCutoff); cutoff != "" {
+		base, err := resolveHistoryCutoff(repoPath, cfg.HeadBranch, cutoff)
+		return squashPoint{base: base}, err
+	}
+	mode := t.InitialHistoryMode
+	if mode != "squash" && mode != "future" {
+		return squashPoint{}, nil
+	}
+	if ts.SquashBase == "" {
+		base, err := gitx.RevParse(repoPath, "refs/heads/"+cfg.HeadBranch)
+		if err != nil {
+			return squashPoint{}, err
+		}
+		ts.SquashBase = base
+		ts.SquashHide, ts.SquashHideCount = nil, 0
+		if mode == "future" {
+			hide, err := gitx.RevList(repoPath, "--branches", "--tags", "^"+base)
+			if err != nil {
+				return squashPoint{}, err
+			}
+			ts.SquashHide = hide
+		}
+	}
+	sp := squashPoint{base: ts.SquashBase}
+	if len(ts.SquashHide) == 0 && ts.SquashHideCount == 0 {
+		return sp, nil
+	}
+	path, err := squashHidePath(repoPath, t.Label)
+	if err != nil {
+		return squashPoint{}, err
+	}
+	if len(ts.SquashHide) > 0 {
+		// A new list, or one from a state written before the lists moved
+		// out of it.
+		sort.Strings(ts.SquashHide)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return squashPoint{}, err
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(ts.SquashHide, "\n")+"\n"), 0o644); err != nil {
+			return squashPoint{}, err
+		}
+		ts.SquashHide, ts.SquashHideCount = nil, len(ts.SquashHide)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return squashPoint{}, fmt.Errorf("read the commits hidden from %s: %w", t.Label, err)
+	}
+	if sp.hide = strings.Fields(string(b)); len(sp.hide) != ts.SquashHideCount {
+		return squashPoint{}, fmt.Errorf("%s lists %d commits hidden from %s, want %d", path, len(sp.hide), t.Label, ts.SquashHideCount)
+	}
+	return sp, nil
+}
+
+// squashHidePath is the file listing the commits a "future" target hides,
+// one per line. It is written once, by the first sync, and lives in the
+// private repo's git directory.
+func squashHidePath(repoPath, label string) (string, error) {
+	res, err := gitx.Run(nil, repoPath, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(res.Stdout)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoPath, dir)
+	}
+	return filepath.Join(dir, "git-copy", "squash-hide", label), nil
+}
+
+// applyHistoryCutoff returns t with the history cutoff override of the
+// target state, after recording override (Options.HistoryCutoff) in it.
+// Keeping the override means the next sync publishes the same history
+// instead of seeing a changed config and rebuilding without it.
+func applyHistoryCutoff(t config.Target, ts *state.TargetState, override string) (config.Target, error) {
+	switch override {
+	case "":
+	case "none":
+		ts.HistoryCutoff = ""
+	default:
+		ts.HistoryCutoff = override
+	}
+	if ts.HistoryCutoff == "" {
+		return t, nil
+	}
+	if m := t.InitialHistoryMode; m == "squash" || m == "future" {
+		// A cutoff would publish history these modes hide.
+		return t, fmt.Errorf("--since %s: target %s uses initial_history_mode %q; use --since none to remove the override", ts.HistoryCutoff, t.Label, m)
+	}
+	t.HistoryCutoff = ts.HistoryCutoff
+	return t, nil
+}
+
+// resolveHistoryCutoff maps a cutoff (a date such as 2024-01-31, an RFC 3339
+// time, or a tag or commit) to the last head branch commit at the cutoff. A date
+// before the first commit squashes nothing.
+func resolveHistoryCutoff(repoPath, headBranch, cutoff string) (string, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if when, err := time.Parse(layout, cutoff); err == nil {
+			return gitx.LastCommitBefore(repoPath, "refs/heads/"+headBranch, when)
+		}
+	}
+	base, err := gitx.RevParse(repoPath, cutoff)
+	if err != nil {
+		return "", fmt.Errorf("history_cutoff: %w", err)
+	}
+	return base, nil
+}
+
+// targetJob carries a prepared target through export, validation and push.
+type targetJob struct {
+	target    config.Target
+	rules     scrub.CompiledRules
+	optIn     []string
+	srcRepo   string
+	tmpBare   string
+	finalBare string
+	// lfsObjects are LFS object ids to upload after the push (lfs mode "push").
+	lfsObjects []string
+	// exportRefs, if set, are the refs to export instead of --all.
+	exportRefs []string
+	// exportExtra are additional fast-export arguments (squashed history).
+	exportExtra []string
+	// rulesKey identifies the filter rules for incremental syncs ("" to
+	// never resume); resume is the saved filter state when the import
+	// continues the cached repo.
+	rulesKey string
+	resume   *scrub.FilterState
+	// filterState and writtenRefs are recorded by the export phase.
+	filterState scrub.FilterState
+	writtenRefs []string
+	// progress receives the filter's progress reports, if set.
+	progress func(scrub.Progress)
+	// stats are the filter's totals.
+	stats scrub.Progress
+	// dryRun stops the job after validation; output, if set, also receives
+	// the filtered stream.
+	dryRun bool
+	output string
+	// verify runs a second filter over the export to check that the output
+	// is reproducible.
+	verify bool
+	// prevPushed are the refs git-copy last pushed to the target, and
+	// pushedRefs those pushed by this sync.
+	prevPushed map[string]string
+	pushedRefs map[string]string
+	// prevRemote identifies the target's repo at the last push, and remote
+	// at this one; repoIdentifier, if set, looks up its id. remoteChecked
+	// is set once the first try of the push has compared the two, so
+	// retries don't repeat its warnings.
+	prevRemote     *state.RemoteIdentity
+	remote         *state.RemoteIdentity
+	repoIdentifier provider.RepoIdentifier
+	remoteChecked  bool
+	// warnings are problems that didn't fail the sync.
+	warnings []string
+	// prevMirrors are the states of the target's mirrors before the sync,
+	// and mirrors the pushes to them.
+	prevMirrors map[string]*state.MirrorState
+	mirrors     []mirrorPush
+	// pushAttempts counts the tries of the push, and pushedBytes is the
+	// size of the pack (or bundle) its last try wrote.
+	pushAttempts int
+	pushedBytes  int64
+	// sourceCommit is the short hash of the private HEAD, for hooks.
+	sourceCommit string
+	// uploadLimiter, if set, paces the uploads of all the sync's pushes.
+	uploadLimiter *throttle.Limiter
+	// headRef is the public ref of the head branch.
+	headRef string
+	// allowOverwrite skips the check for refs changed on the target.
+	allowOverwrite bool
+	// adoptBase is the target commit the history is grafted onto (adopt
+	// mode "graft"), fetched with adoptEnv.
+	adoptBase string
+	adoptEnv  []string
+	// refUpdates and newCommits describe the push, or the push a dry run
+	// would make.
+	refUpdates []RefUpdate
+	newCommits int
+	// err records a failure from the shared export phase.
+	err error
+}
+
+// targetURL returns the target's URL. git runs in the cache, so relative
+// local targets are made relative to the private repo.
+func targetURL(repoPath string, t config.Target) string {
+	if p, ok := gitx.LocalPath(t.RepoURL); ok && !filepath.IsAbs(p) {
+		return filepath.Join(repoPath, p)
+	}
+	return t.RepoURL
+}
+
+// prepareTarget compiles the target's rules and creates an empty temporary
+// bare repo for the scrubbed import, grafted onto the adopted base if any.
+func prepareTarget(ctx context.Context, repoPath, repoKey string, cfg config.RepoConfig, t config.Target, ts *state.TargetState, squash squashPoint, opts Options) (*targetJob, error) {
+	t.RepoURL = targetURL(repoPath, t)
+	if gitx.IsBundle(t.RepoURL) && t.LFS == scrub.LFSPush {
+		return nil, fmt.Errorf("target %s: bundles can't carry LFS objects; set lfs to \"keep\" or \"exclude\"", t.Label)
+	}
+	var baseParents []string
+	if squash.base != "" {
+		var err error
+		if baseParents, err = gitx.CommitParents(repoPath, squash.base); err != nil {
+			return nil, fmt.Errorf("squash base %s: %w", squash.base, err)
+		}
+	}
+	rulesIn, err := targetRules(ctx, repoPath, cfg, t)
+	if err != nil {
+		return nil, err
+	}
+	rulesIn.SquashBase = squash.base
+	rulesIn.SquashHide = squash.hide
+	rules, err := scrub.Compile(rulesIn)
+	if err != nil {
+		return nil, err
+	}
+	// The head branch as published, after branch renames and namespaces.
+	headRef := rules.PublicRef("refs/heads/" + cfg.HeadBranch)
+	adoptBase, err := targetAdoptBase(ctx, repoPath, t, ts, headRef, opts)
+	if err != nil {
+		return nil, err
+	}
+	if adoptBase != "" {
+		rulesIn.GraftParent = adoptBase
+		if rules, err = scrub.Compile(rulesIn); err != nil {
+			return nil, err
+		}
+	}
+	optIn := append(append([]string{}, cfg.Defaults.OptIn...), t.OptIn...)
+
+	cacheDir := filepath.Join(opts.CacheDir, repoKey)
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	finalBare := filepath.Join(cacheDir, t.Label+".git")
+	tmpBare := filepath.Join(cacheDir, t.Label+".tmp.git")
+	if opts.DryRun {
+		// Don't disturb a concurrent sync's import.
+		tmpBare = filepath.Join(cacheDir, t.Label+".dryrun.git")
+	}
+
+	job := &targetJob{
+		target:         t,
+		rules:          rules,
+		optIn:          optIn,
+		srcRepo:        repoPath,
+		tmpBare:        tmpBare,
+		finalBare:      finalBare,
+		progress:       jobProgress(opts, t.Label),
+		verify:         opts.VerifyReproducible,
+		headRef:        headRef,
+		allowOverwrite: opts.AllowRemoteOverwrite,
+		adoptBase:      adoptBase,
+		repoIdentifier: targetRepoIdentifier(t, opts),
+	}
+	if adoptBase != "" {
+		if job.adoptEnv, err = targetPushEnv(t, opts); err != nil {
+			return nil, err
+		}
+	}
+	key := rulesKey(rulesIn)
+	if opts.DryRun {
+		job.dryRun, job.output = true, opts.DryRunOutput
+		// The saved stream holds the whole history.
+		if job.output != "" {
+			key = ""
+		}
+	}
+	// Squashed exports rebuild the baseline commit every time, so they
+	// always replay the history.
+	if err := initImportRepo(ctx, job, key, squash.base == "" && !opts.Force); err != nil {
+		return nil, err
+	}
+	if squash.base != "" {
+		job.exportExtra = scrub.SquashExportArgs(baseParents)
+	}
+	if rules.HasRefFilters() {
+		refs, err := gitx.ListRefs(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(refs))
+		for r := range refs {
+			if t.ReplaceRefs || !strings.HasPrefix(r, replaceRefPrefix) {
+				names = append(names, r)
+			}
+		}
+		job.exportRefs = rules.PublishedRefs(names)
+		if len(job.exportRefs) == 0 {
+			_ = os.RemoveAll(tmpBare)
+			return nil, fmt.Errorf("target %s: ref_include/ref_exclude match no refs", t.Label)
+		}
+	}
+	return job, nil
+}
+
+// targetRules builds the scrub rules for target t, reading files from the
+// private HEAD where rules depend on it. Squash settings are left to the
+// caller.
+func targetRules(ctx context.Context, repoPath string, cfg config.RepoConfig, t config.Target) (scrub.Rules, error) {
 	repl := t.Replacement
 	if repl == "" {
 		repl = t.Account
@@ -188,9 +894,17 @@ func syncTarget(ctx context.Context, repoPath, repoKey string, cfg config.RepoCo
 	replaceHistoryWithCurrent := append([]string{}, cfg.Defaults.ReplaceHistoryWithCurrent...)
 	replaceHistoryWithCurrent = append(replaceHistoryWithCurrent, t.ReplaceHistoryWithCurrent...)
 
-	// Read HEAD content for replace_history_with_current files
+	// Read HEAD content for replace_history_with_current files, expanding globs
+	// against the HEAD tree.
+	var headFiles []string
+	for _, p := range replaceHistoryWithCurrent {
+		if scrub.IsGlobPattern(p) {
+			headFiles, _ = gitx.ListFiles(repoPath, "HEAD")
+			break
+		}
+	}
 	replaceHistoryContent := make(map[string][]byte)
-	for _, filePath := range replaceHistoryWithCurrent {
+	for _, filePath := range scrub.ExpandReplaceHistory(replaceHistoryWithCurrent, headFiles) {
 		content, err := readFileFromHEAD(ctx, repoPath, filePath)
 		if err != nil {
 			// File doesn't exist in HEAD, skip it
@@ -199,38 +913,290 @@ func syncTarget(ctx context.Context, repoPath, repoKey string, cfg config.RepoCo
 		replaceHistoryContent[filePath] = content
 	}
 
-	rules, err := scrub.Compile(scrub.Rules{
+	// Sizes were checked by RepoConfig.Validate.
+	excludeLargerThan, _ := config.ParseByteSize(cfg.Defaults.ExcludeLargerThan)
+
+	var gitAttributes map[string][]byte
+	if cfg.Defaults.ExportIgnore {
+		var err error
+		if gitAttributes, err = headGitAttributes(ctx, repoPath); err != nil {
+			return scrub.Rules{}, err
+		}
+	}
+
+	pathReplacements := make([]scrub.PathReplacements, 0, len(cfg.Defaults.PathReplacements))
+	for _, pr := range cfg.Defaults.PathReplacements {
+		pathReplacements = append(pathReplacements, scrub.PathReplacements{Patterns: pr.Paths, Replacements: pr.Replacements})
+	}
+
+	// Target path mappings are listed after defaults so they override on equal prefixes.
+	pathMappings := []scrub.PathMapping{}
+	for _, m := range append(append([]config.PathMapping{}, cfg.Defaults.PathMappings...), t.PathMappings...) {
+		pathMappings = append(pathMappings, scrub.PathMapping{From: m.From, To: m.To})
+	}
+
+	// Target author mappings come first so they win over the defaults.
+	var authorMap []scrub.AuthorMapping
+	for _, am := range append(append([]config.AuthorMapping{}, t.AuthorMap...), cfg.Defaults.AuthorMap...) {
+		authorMap = append(authorMap, scrub.AuthorMapping{
+			PrivateName:  am.PrivateName,
+			PrivateEmail: am.PrivateEmail,
+			PublicName:   am.PublicName,
+			PublicEmail:  am.PublicEmail,
+		})
+	}
+
+	var contentFilters []scrub.ContentFilter
+	for _, cf := range t.ContentFilters {
+		contentFilters = append(contentFilters, scrub.ContentFilter{Patterns: cf.Paths, Command: cf.Command})
+	}
+
+	var injectFiles []scrub.InjectedFile
+	for _, inj := range t.Inject {
+		injectFiles = append(injectFiles, scrub.InjectedFile{Path: inj.Path, Content: []byte(inj.Content)})
+	}
+
+	var entropy *scrub.EntropyRules
+	if ec := cfg.Defaults.EntropyCheck; ec != nil {
+		entropy = &scrub.EntropyRules{Threshold: ec.Threshold, HexThreshold: ec.HexThreshold, MinLength: ec.MinLength, Allow: ec.Allow, AllowPaths: ec.AllowPaths}
+	}
+
+	var additionalUsernames []scrub.UsernameReplacement
+	for _, u := range cfg.AdditionalUsernames {
+		additionalUsernames = append(additionalUsernames, scrub.UsernameReplacement{Username: u.Username, Replacement: u.Replacement})
+	}
+
+	var timestamps *scrub.TimestampRules
+	if tp := t.Timestamps; tp != nil {
+		timestamps = &scrub.TimestampRules{Mode: tp.Mode, Timezone: tp.Timezone}
+		// Durations were checked by RepoConfig.Validate.
+		timestamps.Shift, _ = time.ParseDuration(tp.Shift)
+		timestamps.Interval, _ = time.ParseDuration(tp.Interval)
+	}
+
+	var messages *scrub.MessageRules
+	if mr := targetMessages(cfg, t); mr != nil {
+		messages = &scrub.MessageRules{StripPatterns: mr.StripPatterns, MaxBodyLines: mr.MaxBodyLines, Template: mr.Template}
+		if ir := mr.IssueRefs; ir != nil {
+			issueMap, err := targetIssueMap(ctx, repoPath, ir)
+			if err != nil {
+				return scrub.Rules{}, err
+			}
+			messages.IssueRefs = &scrub.IssueRefRules{Pattern: ir.Pattern, Map: issueMap, Replacement: ir.Replacement}
+		}
+	}
+
+	var trailers *scrub.TrailerRules
+	if tr := targetTrailers(cfg, t); tr != nil {
+		trailers = &scrub.TrailerRules{Mode: tr.Mode, Keys: tr.Keys}
+	}
+
+	var tags *scrub.TagRules
+	if tp := t.Tags; tp != nil {
+		tags = &scrub.TagRules{Mode: tp.Mode, Patterns: tp.Patterns, StripMessages: tp.StripMessages}
+	}
+
+	var symlinks *scrub.SymlinkRules
+	if sp := cfg.Defaults.Symlinks; sp != nil {
+		symlinks = &scrub.SymlinkRules{Mode: sp.Mode, Patterns: sp.Patterns, Replacement: sp.Replacement}
+	}
+
+	branchRenames := make([]scrub.BranchRename, 0, len(t.BranchRenames))
+	for _, br := range t.BranchRenames {
+		branchRenames = append(branchRenames, scrub.BranchRename{From: br.From, To: br.To})
+	}
+
+	namespaces := t.Namespaces()
+	refNamespaces := make([]scrub.RefNamespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		refNamespaces = append(refNamespaces, scrub.RefNamespace{From: ns.From, To: ns.To})
+	}
+
+	goModules, err := targetGoModules(ctx, repoPath, t)
+	if err != nil {
+		return scrub.Rules{}, err
+	}
+
+	return scrub.Rules{
 		PrivateUsername:           cfg.PrivateUsername,
+		AdditionalUsernames:       additionalUsernames,
+		MatchWholeWord:            cfg.MatchWholeWord,
 		Replacement:               repl,
 		ExtraReplacements:         cfg.Defaults.ExtraReplacementPairs,
+		PathReplacements:          pathReplacements,
+		PathMappings:              pathMappings,
+		Subtree:                   t.Subtree,
+		IncludePatterns:           t.Include,
+		SkipBinaryBlobs:           cfg.Defaults.SkipBinaryBlobs,
+		BinaryExtensions:          cfg.Defaults.BinaryExtensions,
+		MaxRewriteBlobBytes:       cfg.Defaults.MaxRewriteBlobBytes,
+		ExcludeLargerThan:         excludeLargerThan,
+		ExcludeExtensions:         cfg.Defaults.ExcludeExtensions,
+		LFSMode:                   t.LFS,
+		SubmodulePolicy:           t.Submodules,
+		DropNotes:                 t.DropNotes,
+		KeepOriginalOIDs:          t.KeepOriginalOIDs,
+		RefInclude:                t.RefInclude,
+		RefExclude:                t.RefExclude,
+		Tags:                      tags,
+		Symlinks:                  symlinks,
+		BranchRenames:             branchRenames,
+		RefNamespaces:             refNamespaces,
+		GoModules:                 goModules,
+		RedactSecrets:             cfg.Defaults.RedactSecrets,
+		Entropy:                   entropy,
+		AuthorMap:                 authorMap,
+		Timestamps:                timestamps,
+		Messages:                  messages,
+		SkipCommitPatterns:        cfg.Defaults.SkipCommits,
+		ContentFilters:            contentFilters,
+		InjectFiles:               injectFiles,
+		PruneEmptyMerges:          cfg.Defaults.PruneEmptyMerges,
+		Trailers:                  trailers,
 		ExcludePatterns:           exclude,
 		OptInPaths:                optIn,
+		GitAttributes:             gitAttributes,
+		RedactPatterns:            append(append([]string{}, cfg.Defaults.Redact...), t.Redact...),
+		RedactPlaceholder:         cfg.Defaults.RedactPlaceholder,
 		ReplaceHistoryWithCurrent: replaceHistoryWithCurrent,
 		ReplaceHistoryContent:     replaceHistoryContent,
 		PublicAuthorName:          t.PublicAuthorName,
 		PublicAuthorEmail:         t.PublicAuthorEmail,
-	})
+	}, nil
+}
+
+// headGitAttributes returns the content of every .gitattributes file at HEAD,
+// by path.
+func headGitAttributes(ctx context.Context, repoPath string) (map[string][]byte, error) {
+	files, err := gitx.ListFiles(repoPath, "HEAD")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	attrs := map[string][]byte{}
+	for _, p := range files {
+		if path.Base(p) != ".gitattributes" {
+			continue
+		}
+		content, err := readFileFromHEAD(ctx, repoPath, p)
+		if err != nil {
+			return nil, err
+		}
+		attrs[p] = content
+	}
+	return attrs, nil
+}
 
-	cacheDir := filepath.Join(opts.CacheDir, repoKey)
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-		return err
+// targetGoModules returns the Go module rewrite for a target with go_module
+// set. The private module path is read from go.mod at HEAD (in the subtree,
+// if any); "auto" publishes it under the target's account and repo name,
+// keeping the host and any suffix such as a major version.
+func targetGoModules(ctx context.Context, repoPath string, t config.Target) ([]scrub.GoModuleRewrite, error) {
+	mode := strings.TrimSpace(t.GoModule)
+	if mode == "" {
+		return nil, nil
 	}
-	finalBare := filepath.Join(cacheDir, t.Label+".git")
-	tmpBare := filepath.Join(cacheDir, t.Label+".tmp.git")
+	gomod, err := readFileFromHEAD(ctx, repoPath, path.Join(t.Subtree, "go.mod"))
+	if err != nil {
+		return nil, fmt.Errorf("go_module: no go.mod at HEAD")
+	}
+	from := scrub.GoModulePath(gomod)
+	if from == "" {
+		return nil, fmt.Errorf("go_module: go.mod declares no module")
+	}
+	to := mode
+	if mode == "auto" {
+		segs := strings.Split(from, "/")
+		if len(segs) < 3 {
+			return nil, fmt.Errorf("go_module: cannot derive a public path from module %q; set go_module explicitly", from)
+		}
+		segs[1], segs[2] = t.Account, t.RepoName
+		to = strings.Join(segs, "/")
+	}
+	if to == from {
+		return nil, nil
+	}
+	return []scrub.GoModuleRewrite{{From: from, To: to}}, nil
+}
 
-	_ = os.RemoveAll(tmpBare)
-	if err := gitx.InitEmptyBare(tmpBare); err != nil {
-		return err
+// targetIssueMap merges the issue numbers of an issue_refs map file (read
+// from HEAD) with the inline map, which wins on conflicts.
+func targetIssueMap(ctx context.Context, repoPath string, ir *config.IssueRefRules) (map[string]string, error) {
+	if ir.MapFile == "" {
+		return ir.Map, nil
+	}
+	b, err := readFileFromHEAD(ctx, repoPath, ir.MapFile)
+	if err != nil {
+		return nil, fmt.Errorf("issue_refs.map_file %s: %w", ir.MapFile, err)
+	}
+	m := map[string]string{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("issue_refs.map_file %s:%d: want \"<private> <public>\"", ir.MapFile, i+1)
+		}
+		m[fields[0]] = fields[1]
+	}
+	for k, v := range ir.Map {
+		m[k] = v
 	}
+	return m, nil
+}
+
+// targetMessages returns the target's message transforms, falling back to
+// the repo defaults.
+func targetMessages(cfg config.RepoConfig, t config.Target) *config.MessageRules {
+	if t.Messages != nil {
+		return t.Messages
+	}
+	return cfg.Defaults.Messages
+}
 
-	// Run fast-export -> filter -> fast-import
-	if err := exportFilterImport(ctx, repoPath, tmpBare, rules); err != nil {
+// targetTrailers returns the target's trailer handling, falling back to the
+// repo defaults.
+func targetTrailers(cfg config.RepoConfig, t config.Target) *config.TrailerRules {
+	if t.Trailers != nil {
+		return t.Trailers
+	}
+	return cfg.Defaults.Trailers
+}
+
+// finishTarget validates the imported repo, moves it into the cache and pushes it.
+func finishTarget(ctx context.Context, cfg config.RepoConfig, job *targetJob, opts Options) error {
+	t := job.target
+	optIn := job.optIn
+	tmpBare, finalBare := job.tmpBare, job.finalBare
+	if job.err != nil {
+		_ = os.RemoveAll(tmpBare)
+		return job.err
+	}
+	if job.dryRun {
+		defer os.RemoveAll(tmpBare)
+	}
+	if err := saveIncrementalState(ctx, job); err != nil {
+		_ = os.RemoveAll(tmpBare)
+		return err
+	}
+	// Dry runs leave signing out: it may need the key's passphrase.
+	if t.Signing != nil && !job.dryRun {
+		if err := signPublished(ctx, job, *t.Signing); err != nil {
+			_ = os.RemoveAll(tmpBare)
+			return fmt.Errorf("sign %s: %w", t.Label, err)
+		}
+	}
+	// After signing, which rewrites the public commits and their marks.
+	// Dry runs too, so that they plan the renamed replace refs.
+	if err := writeCommitMap(job); err != nil {
 		_ = os.RemoveAll(tmpBare)
 		return err
 	}
+	if err := renameReplaceRefs(ctx, job); err != nil {
+		_ = os.RemoveAll(tmpBare)
+		return fmt.Errorf("rename replace refs: %w", err)
+	}
 
 	// Validate invariants before pushing
 	if opts.Validate {
@@ -242,24 +1208,76 @@ func syncTarget(ctx context.Context, repoPath, repoKey string, cfg config.RepoCo
 		if !contains(optIn, "CLAUDE.md") {
 			forbidden = append(forbidden, "CLAUDE.md")
 		}
-		if err := scrub.ValidateScrubbedRepo(ctx, tmpBare, cfg.PrivateUsername, forbidden); err != nil {
+		if err := scrub.ValidateScrubbedRepoWithOptions(ctx, tmpBare, scrub.ValidateOptions{
+			PrivateUsername:     cfg.PrivateUsername,
+			AdditionalUsernames: cfg.PrivateUsernames()[1:],
+			ForbiddenPaths:      forbidden,
+			MatchWholeWord:      cfg.MatchWholeWord,
+			SkipBinaryBlobs:     cfg.Defaults.SkipBinaryBlobs,
+			ForbiddenPatterns:   cfg.Defaults.ForbiddenPatterns,
+			Parallelism:         opts.ValidateParallelism,
+		}); err != nil {
 			_ = os.RemoveAll(tmpBare)
 			return err
 		}
 	}
 
+	// Before this version the pushed refs weren't recorded; the cache holds
+	// them, unless the last push failed.
+	if job.prevPushed == nil {
+		if _, err := os.Stat(finalBare); err == nil {
+			job.prevPushed, _ = gitx.ListRefs(finalBare)
+		}
+	}
+	if job.dryRun {
+		return planPush(ctx, job, tmpBare)
+	}
+
 	// Atomically replace cache
 	_ = os.RemoveAll(finalBare)
 	if err := os.Rename(tmpBare, finalBare); err != nil {
 		return fmt.Errorf("failed to move scrubbed repo into place: %w", err)
 	}
 
-	// Push mirror - set GH_TOKEN for GitHub HTTPS URLs with multi-account support
-	pushEnv := getPushEnv(t)
-	if err := gitx.PushMirror(ctx, finalBare, t.RepoURL, pushEnv); err != nil {
-		return err
+	if t.Hooks != nil {
+		env := hookEnv(job.srcRepo, t, job.sourceCommit, finalBare)
+		if err := runHook(ctx, "pre_sync", t.Hooks.PreSync, job.srcRepo, env); err != nil {
+			return err
+		}
+	}
+
+	pushEnv, stop, err := throttledPushEnv(ctx, job, opts)
+	if err == nil {
+		job.pushAttempts, err = retry.Do(ctx, retryPolicy(t), gitx.IsTransient, func() error {
+			if err := pushTarget(ctx, job, pushEnv); err != nil {
+				return err
+			}
+			return gitx.LFSPushObjects(ctx, job.srcRepo, t.RepoURL, job.lfsObjects, pushEnv)
+		})
+		stop()
+	}
+	// Whether or not the target's push failed.
+	pushMirrors(ctx, job, opts)
+	return err
+}
+
+// NamespacePrefixes returns the target-side ref prefixes of namespaces.
+func NamespacePrefixes(namespaces []config.RefNamespace) []string {
+	var out []string
+	for _, ns := range namespaces {
+		out = append(out, strings.TrimSuffix(strings.TrimSpace(ns.To), "*"))
+	}
+	return out
+}
+
+// targetPushEnv returns the environment giving git the credentials for the
+// target: envPushEnv with Options.CredentialsFromEnv, else getPushEnv.
+func targetPushEnv(t config.Target, opts Options) ([]string, error) {
+	if opts.CredentialsFromEnv {
+		return envPushEnv(t)
 	}
-	return nil
+	// Set GH_TOKEN for GitHub HTTPS URLs with multi-account support
+	return getPushEnv(t), nil
 }
 
 // getPushEnv returns environment variables needed for pushing to the target.
@@ -280,63 +1298,6 @@ func getPushEnv(t config.Target) []string {
 	return []string{"GH_TOKEN=" + token}
 }
 
-func exportFilterImport(ctx context.Context, srcRepo, dstBare string, rules scrub.CompiledRules) error {
-	// Fast-export
-	exp := gitx.FastExportCmd(srcRepo, "--all", "--signed-tags=strip", "--tag-of-filtered-object=rewrite")
-	expStdout, err := exp.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	var expStderr bytes.Buffer
-	exp.Stderr = &expStderr
-
-	// Fast-import
-	imp := gitx.FastImportCmd(dstBare)
-	impStdin, err := imp.StdinPipe()
-	if err != nil {
-		return err
-	}
-	var impStderr bytes.Buffer
-	imp.Stderr = &impStderr
-
-	if err := imp.Start(); err != nil {
-		return fmt.Errorf("fast-import start failed: %w (%s)", err, strings.TrimSpace(impStderr.String()))
-	}
-	if err := exp.Start(); err != nil {
-		_ = imp.Process.Kill()
-		return fmt.Errorf("fast-export start failed: %w (%s)", err, strings.TrimSpace(expStderr.String()))
-	}
-
-	filter := scrub.NewExportFilter(rules)
-	filterErrCh := make(chan error, 1)
-	go func() {
-		defer impStdin.Close()
-		filterErrCh <- filter.Filter(expStdout, impStdin)
-	}()
-
-	// Wait for filter to finish FIRST - it reads from export stdout.
-	// We must drain the pipe before calling exp.Wait(), which closes it.
-	ferr := <-filterErrCh
-
-	// Now wait for export process (pipe is drained, safe to close)
-	expErr := exp.Wait()
-
-	// Check errors in order of occurrence
-	if expErr != nil {
-		_ = imp.Process.Kill()
-		return fmt.Errorf("fast-export failed: %w (%s)", expErr, strings.TrimSpace(expStderr.String()))
-	}
-	if ferr != nil {
-		_ = imp.Process.Kill()
-		return fmt.Errorf("export filter failed: %w", ferr)
-	}
-	if err := imp.Wait(); err != nil {
-		return fmt.Errorf("fast-import failed: %w (%s)", err, strings.TrimSpace(impStderr.String()))
-	}
-	_, _ = gitx.Run(ctx, dstBare, "repack", "-adq")
-	return nil
-}
-
 func repoCacheKey(repoPath string) string {
 	sum := sha256.Sum256([]byte(repoPath))
 	return hex.EncodeToString(sum[:8])
@@ -364,3 +1325,19 @@ func readFileFromHEAD(ctx context.Context, repoPath, filePath string) ([]byte, e
 	}
 	return []byte(res.Stdout), nil
 }
+
+// syncRecord gives the metrics of a target's sync for its history.
+func syncRecord(res Result) state.SyncRecord {
+	r := state.SyncRecord{
+		At:             time.Now(),
+		DurationMS:     res.Duration.Milliseconds(),
+		Incremental:    res.Incremental,
+		Commits:        res.Stats.Commits,
+		BlobsRewritten: res.Stats.BlobsRewritten,
+		BytesPushed:    res.PushedBytes,
+	}
+	if res.Error != nil {
+		r.Error = res.Error.Error()
+	}
+	return r
+}
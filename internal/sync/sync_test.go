@@ -2,13 +2,22 @@ package sync
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/obinnaokechukwu/git-copy/internal/config"
 	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/notify"
+	"github.com/obinnaokechukwu/git-copy/internal/state"
+	"github.com/obinnaokechukwu/git-copy/internal/throttle"
 )
 
 func TestSyncRepo_RebuildsWhenConfigChangesEvenIfRefsUnchanged(t *testing.T) {
@@ -118,3 +127,1786 @@ func nonEmptyLines(s string) []string {
 	return out
 }
 
+// newTestRepo creates a private repo at dir, on main, committing as the
+// private username.
+func newTestRepo(t *testing.T, dir string) string {
+	t.Helper()
+	ctx := context.Background()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := gitx.Run(ctx, dir, "init", "-b", "main"); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	_, _ = gitx.Run(ctx, dir, "config", "user.name", "obinnaokechukwu")
+	_, _ = gitx.Run(ctx, dir, "config", "user.email", "obinnaokechukwu@private.invalid")
+	return dir
+}
+
+// newBareRepo creates an empty bare repo at dir, for a target to push to.
+func newBareRepo(t *testing.T, dir string) string {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := gitx.Run(ctx, "", "init", "--bare", "-b", "main", dir); err != nil {
+		t.Fatalf("git init --bare: %v", err)
+	}
+	return dir
+}
+
+// commitFiles writes each file in files and commits only those paths as
+// msg, leaving the sync state under .git-copy untracked.
+func commitFiles(t *testing.T, repo string, files map[string]string, msg string) {
+	t.Helper()
+	ctx := context.Background()
+	args := []string{"add", "--"}
+	for p, content := range files {
+		full := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+		args = append(args, p)
+	}
+	if _, err := gitx.Run(ctx, repo, args...); err != nil {
+		t.Fatalf("git add: %v", err)
+	}
+	if _, err := gitx.Run(ctx, repo, "commit", "-m", msg); err != nil {
+		t.Fatalf("git commit: %v", err)
+	}
+}
+
+func TestSyncRepo_MonorepoSplitAcrossTargets(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{
+		"cli/main.go":      "package main\n",
+		"server/server.go": "package server\n",
+	}, "initial")
+
+	dstCLI := newBareRepo(t, filepath.Join(tmp, "cli.git"))
+	dstServer := newBareRepo(t, filepath.Join(tmp, "server.git"))
+
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Targets: []config.Target{
+			{Label: "cli", Provider: "none", Account: "public", RepoName: "cli", RepoURL: dstCLI, Include: []string{"cli/**"}},
+			{Label: "server", Provider: "none", Account: "public", RepoName: "server", RepoURL: dstServer, Include: []string{"server/**"}},
+		},
+	}
+	results, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache")})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatalf("target %s: %v", r.TargetLabel, r.Error)
+		}
+	}
+
+	for dst, want := range map[string]string{dstCLI: "cli/main.go", dstServer: "server/server.go"} {
+		ls, err := gitx.Run(ctx, dst, "ls-tree", "-r", "--name-only", "refs/heads/main")
+		if err != nil {
+			t.Fatalf("ls-tree %s: %v", dst, err)
+		}
+		if strings.TrimSpace(ls.Stdout) != want {
+			t.Fatalf("expected only %s in %s, got:\n%s", want, dst, ls.Stdout)
+		}
+	}
+}
+
+func TestSyncRepo_SquashHistoryMode(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(file, content, msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{file: content}, msg)
+	}
+	commit("a.txt", "one\n", "first private commit")
+	_, _ = gitx.Run(ctx, src, "tag", "v0.1")
+	_, _ = gitx.Run(ctx, src, "branch", "side")
+	commit("a.txt", "two\n", "second private commit")
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "side")
+	commit("side.txt", "side\n", "side branch work")
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "main")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "squash",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+
+	logMain, err := gitx.Run(ctx, dst, "log", "--format=%s", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if got := nonEmptyLines(logMain.Stdout); len(got) != 1 || got[0] != "Initial commit" {
+		t.Fatalf("expected one baseline commit, got %q", got)
+	}
+	baseline, _ := gitx.Run(ctx, dst, "rev-parse", "refs/heads/main")
+	if show, _ := gitx.Run(ctx, dst, "show", "refs/heads/main:a.txt"); show.Stdout != "two\n" {
+		t.Errorf("baseline tree has a.txt = %q", show.Stdout)
+	}
+	// The side branch forked from squashed history is attached to the baseline.
+	if res, err := gitx.Run(ctx, dst, "rev-parse", "refs/heads/side^"); err != nil || res.Stdout != baseline.Stdout {
+		t.Errorf("side branch parent = %q (%v), want baseline %q", res.Stdout, err, baseline.Stdout)
+	}
+	if _, err := gitx.Run(ctx, dst, "rev-parse", "--verify", "refs/tags/v0.1"); err == nil {
+		t.Errorf("tag of a squashed commit was published")
+	}
+
+	// New commits are appended on top of the same baseline.
+	commit("b.txt", "three\n", "after squash")
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo (second): %v %v", err, res)
+	}
+	logMain, _ = gitx.Run(ctx, dst, "log", "--format=%s", "refs/heads/main")
+	if got := nonEmptyLines(logMain.Stdout); len(got) != 2 || got[0] != "after squash" {
+		t.Fatalf("expected new commit on top of baseline, got %q", got)
+	}
+	if res, _ := gitx.Run(ctx, dst, "rev-parse", "refs/heads/main^"); res.Stdout != baseline.Stdout {
+		t.Errorf("baseline changed between syncs: %q vs %q", res.Stdout, baseline.Stdout)
+	}
+}
+
+func TestSyncRepo_HistoryCutoff(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for i, date := range []string{"2023-01-10T12:00:00Z", "2023-02-10T12:00:00Z", "2023-03-10T12:00:00Z"} {
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		t.Setenv("GIT_AUTHOR_DATE", date)
+		commitFiles(t, src, map[string]string{"a.txt": strings.Repeat("x", i+1)}, "commit "+date[:7])
+	}
+	if _, err := gitx.Run(ctx, src, "tag", "-a", "-m", "v1", "v1", "HEAD~1"); err != nil {
+		t.Fatalf("git tag: %v", err)
+	}
+
+	cases := []struct {
+		cutoff string
+		want   []string
+		// override passes the cutoff as Options.HistoryCutoff.
+		override bool
+	}{
+		{"2023-02-20", []string{"commit 2023-03", "Initial commit"}, false},
+		{"HEAD~2", []string{"commit 2023-03", "commit 2023-02", "Initial commit"}, false},
+		{"2022-12-01", []string{"commit 2023-03", "commit 2023-02", "commit 2023-01"}, false},
+		{"v1", []string{"commit 2023-03", "Initial commit"}, false},
+		{"HEAD~1", []string{"commit 2023-03", "Initial commit"}, true},
+	}
+	for i, tc := range cases {
+		dst := newBareRepo(t, filepath.Join(tmp, "dst"+tc.cutoff+".git"))
+		cfg := config.RepoConfig{
+			Version:         config.RepoConfigVersion,
+			PrivateUsername: "obinnaokechukwu",
+			HeadBranch:      "main",
+			Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+			Targets: []config.Target{{
+				Label: "t" + string(rune('a'+i)), Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+				HistoryCutoff: tc.cutoff,
+			}},
+		}
+		opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+		if tc.override {
+			cfg.Targets[0].HistoryCutoff, opts.HistoryCutoff = "", tc.cutoff
+		}
+		if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+			t.Fatalf("cutoff %s: SyncRepo: %v %v", tc.cutoff, err, res)
+		}
+		if tc.override && cfg.Targets[0].HistoryCutoff != "" {
+			t.Errorf("cutoff %s: the override changed the caller's config", tc.cutoff)
+		}
+		logRes, err := gitx.Run(ctx, dst, "log", "--format=%s", "refs/heads/main")
+		if err != nil {
+			t.Fatalf("log: %v", err)
+		}
+		if got := nonEmptyLines(logRes.Stdout); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("cutoff %s: history = %q, want %q", tc.cutoff, got, tc.want)
+		}
+		if !tc.override {
+			continue
+		}
+		// The override is kept: the next sync has nothing to do rather than
+		// rebuilding the full history.
+		opts.HistoryCutoff = ""
+		if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil || res[0].DidWork {
+			t.Fatalf("sync after --since: %v %+v", err, res)
+		}
+		// It never reveals what the squash and future modes hide.
+		cfg.Targets[0].InitialHistoryMode = "squash"
+		res, err := SyncRepo(ctx, src, cfg, "", opts)
+		if err != nil || res[0].Error == nil || !strings.Contains(res[0].Error.Error(), "--since none") {
+			t.Fatalf("expected squash mode to reject the override, got %v %+v", err, res)
+		}
+		opts.HistoryCutoff = "none"
+		if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+			t.Fatalf("--since none: %v %+v", err, res)
+		}
+	}
+}
+
+func TestSyncRepo_FutureHistoryMode(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(file, msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{file: msg + "\n"}, msg)
+	}
+	commit("a.txt", "old main work")
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "-b", "feature")
+	commit("f.txt", "old feature work")
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "main")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "future",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if _, err := gitx.Run(ctx, dst, "rev-parse", "--verify", "refs/heads/feature"); err == nil {
+		t.Errorf("pre-existing feature branch was published")
+	}
+	// The hidden commits are listed in a file of their own, not the state.
+	st, _ := state.Load(src)
+	if ts := st.Targets["t"]; ts.SquashHide != nil || ts.SquashHideCount != 1 {
+		t.Errorf("state = %+v, want 1 hidden commit kept out of it", ts)
+	}
+	hidePath, err := squashHidePath(src, "t")
+	if err != nil {
+		t.Fatalf("squashHidePath: %v", err)
+	}
+	hidden, err := os.ReadFile(hidePath)
+	if err != nil {
+		t.Fatalf("read squash-hide file: %v", err)
+	}
+
+	// Only commits made after the first sync are published.
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "feature")
+	commit("g.txt", "new feature work")
+	_, _ = gitx.Run(ctx, src, "checkout", "-q", "main")
+	commit("b.txt", "new main work")
+
+	// Without its list the target can't keep the old commits hidden.
+	if err := os.Remove(hidePath); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error == nil {
+		t.Fatalf("SyncRepo without the squash-hide file: %v %+v, want an error", err, res)
+	}
+	if err := os.WriteFile(hidePath, hidden, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo (second): %v %v", err, res)
+	}
+	for ref, want := range map[string]string{
+		"refs/heads/main":    "new main work|Initial commit",
+		"refs/heads/feature": "new feature work|Initial commit",
+	} {
+		logRes, err := gitx.Run(ctx, dst, "log", "--format=%s", ref)
+		if err != nil {
+			t.Fatalf("log %s: %v", ref, err)
+		}
+		if got := strings.Join(nonEmptyLines(logRes.Stdout), "|"); got != want {
+			t.Errorf("%s history = %q, want %q", ref, got, want)
+		}
+	}
+	// The feature branch keeps its full tree even though its parent was squashed.
+	if _, err := gitx.Run(ctx, dst, "show", "refs/heads/feature:f.txt"); err != nil {
+		t.Errorf("feature tree lost f.txt: %v", err)
+	}
+}
+
+func TestSyncRepo_RefFilters(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for _, msg := range []string{"one", "two"} {
+		commitFiles(t, src, map[string]string{msg + ".txt": msg + "\n"}, msg)
+	}
+	_, _ = gitx.Run(ctx, src, "branch", "wip")
+	_, _ = gitx.Run(ctx, src, "tag", "-a", "v1.0", "-m", "release")
+	_, _ = gitx.Run(ctx, src, "tag", "internal-rc")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			RefInclude:         []string{"refs/heads/main", "refs/tags/v*"},
+		}},
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache")}); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	refs, err := gitx.Run(ctx, dst, "for-each-ref", "--format=%(refname)")
+	if err != nil {
+		t.Fatalf("for-each-ref: %v", err)
+	}
+	if got := strings.Join(nonEmptyLines(refs.Stdout), ","); got != "refs/heads/main,refs/tags/v1.0" {
+		t.Errorf("published refs = %q, want main and v1.0 only", got)
+	}
+}
+
+func TestSyncRepo_RefNamespacesScopePush(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "one")
+	_, _ = gitx.Run(ctx, src, "tag", "v1")
+
+	// The target already has its own branch, which a mirror push would delete.
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	if _, err := gitx.Run(ctx, src, "push", dst, "main:refs/heads/upstream"); err != nil {
+		t.Fatalf("seed target: %v", err)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			RefNamespaces:      []config.RefNamespace{{From: "refs/heads/*", To: "refs/heads/mirror/*"}},
+		}},
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache")}); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	refs, err := gitx.Run(ctx, dst, "for-each-ref", "--format=%(refname)")
+	if err != nil {
+		t.Fatalf("for-each-ref: %v", err)
+	}
+	if got := strings.Join(nonEmptyLines(refs.Stdout), ","); got != "refs/heads/mirror/main,refs/heads/upstream" {
+		t.Errorf("target refs = %q, want mirror/main next to upstream", got)
+	}
+}
+
+func TestSyncRepo_PushRefsWithLease(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+	_, _ = gitx.Run(ctx, src, "tag", "v1")
+	_, _ = gitx.Run(ctx, src, "branch", "topic")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	if _, err := gitx.Run(ctx, src, "push", dst, "main:refs/heads/upstream"); err != nil {
+		t.Fatalf("seed target: %v", err)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			Push:               &config.PushPolicy{Mode: config.PushModeRefs, Prune: true, ForceWithLease: true},
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	targetRefs := func() string {
+		t.Helper()
+		refs, err := gitx.Run(ctx, dst, "for-each-ref", "--format=%(refname)")
+		if err != nil {
+			t.Fatalf("for-each-ref: %v", err)
+		}
+		return strings.Join(nonEmptyLines(refs.Stdout), ",")
+	}
+
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if got := targetRefs(); got != "refs/heads/main,refs/heads/topic,refs/heads/upstream,refs/tags/v1" {
+		t.Errorf("target refs = %q", got)
+	}
+
+	// Pruning only deletes refs git-copy pushed.
+	_, _ = gitx.Run(ctx, src, "branch", "-D", "topic")
+	commit("two")
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if got := targetRefs(); got != "refs/heads/main,refs/heads/upstream,refs/tags/v1" {
+		t.Errorf("target refs after deleting topic = %q", got)
+	}
+
+	// A fix pushed to the target's main by hand is not overwritten.
+	if _, err := gitx.Run(ctx, src, "push", "--force", dst, "main~1:refs/heads/main"); err != nil {
+		t.Fatalf("manual push: %v", err)
+	}
+	manual, _ := gitx.Run(ctx, dst, "rev-parse", "main")
+	commit("three")
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var diverged *DivergedError
+	if !errors.As(res[0].Error, &diverged) {
+		t.Errorf("sync over a moved remote ref: error = %v, want a DivergedError", res[0].Error)
+	}
+	if after, _ := gitx.Run(ctx, dst, "rev-parse", "main"); after.Stdout != manual.Stdout {
+		t.Errorf("target main moved from %s to %s", manual.Stdout, after.Stdout)
+	}
+}
+
+func TestSyncRepo_RefusesDivergedTarget(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "public", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+
+	// A branch pushed to the mirror by hand would be deleted by the next
+	// mirror push.
+	if _, err := gitx.Run(ctx, src, "push", dst, "main:refs/heads/hotfix"); err != nil {
+		t.Fatalf("manual push: %v", err)
+	}
+	commit("two")
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var diverged *DivergedError
+	if !errors.As(res[0].Error, &diverged) {
+		t.Fatalf("sync over a diverged target: error = %v, want a DivergedError", res[0].Error)
+	}
+	if len(diverged.Refs) != 1 || diverged.Refs[0].Ref != "refs/heads/hotfix" || diverged.Refs[0].New != "" {
+		t.Errorf("diverged refs = %+v, want refs/heads/hotfix", diverged.Refs)
+	}
+	if _, err := gitx.Run(ctx, dst, "rev-parse", "--verify", "refs/heads/hotfix"); err != nil {
+		t.Errorf("hotfix was deleted from the target: %v", err)
+	}
+
+	opts.AllowRemoteOverwrite = true
+	res, err = SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo --allow-remote-overwrite: %v %v", err, res)
+	}
+	if res[0].PushAttempts != 1 {
+		t.Errorf("PushAttempts = %d, want 1", res[0].PushAttempts)
+	}
+	if _, err := gitx.Run(ctx, dst, "rev-parse", "--verify", "refs/heads/hotfix"); err == nil {
+		t.Errorf("hotfix survived a sync allowed to overwrite the target")
+	}
+
+	// Every sync is in the target's history, the refused one included.
+	st, _ := state.Load(src)
+	h := st.Targets["t"].History
+	if len(h) != 3 || h[0].Error != "" || h[1].Error == "" || h[2].Error != "" {
+		t.Fatalf("history = %+v, want ok, error, ok", h)
+	}
+	if h[0].Commits != 1 || h[0].BytesPushed == 0 || !h[1].Incremental || h[1].Commits != 1 || h[2].BytesPushed == 0 {
+		t.Errorf("history metrics = %+v", h)
+	}
+}
+
+func TestSyncRepo_VerifyReproducible(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "hello obinnaokechukwu\n"}, "one")
+
+	for _, tc := range []struct {
+		name    string
+		filters []config.ContentFilter
+		wantErr bool
+	}{
+		{name: "stable"},
+		// A filter stamping its output with the time can't be reproduced.
+		{name: "unstable", filters: []config.ContentFilter{{Paths: []string{"*.txt"}, Command: "cat; date +%s%N"}}, wantErr: true},
+	} {
+		dst := newBareRepo(t, filepath.Join(tmp, tc.name+".git"))
+		cfg := config.RepoConfig{
+			Version:         config.RepoConfigVersion,
+			PrivateUsername: "obinnaokechukwu",
+			HeadBranch:      "main",
+			Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+			Targets: []config.Target{{
+				Label: tc.name, Provider: "none", Account: "public", RepoName: tc.name, RepoURL: dst,
+				InitialHistoryMode: "full",
+				ContentFilters:     tc.filters,
+			}},
+		}
+		res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache"), VerifyReproducible: true})
+		if err != nil {
+			t.Fatalf("%s: SyncRepo: %v", tc.name, err)
+		}
+		gotErr := res[0].Error
+		if tc.wantErr != (gotErr != nil) || (gotErr != nil && !strings.Contains(gotErr.Error(), "reproducib")) {
+			t.Errorf("%s: sync error = %v, want error %v", tc.name, gotErr, tc.wantErr)
+		}
+	}
+}
+
+func TestSyncRepo_DryRunWritesStream(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "hi from obinnaokechukwu\n"}, "init")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	out := filepath.Join(tmp, "stream.fi")
+	res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache"), DryRun: true, DryRunOutput: out})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if res[0].Stats.Commits != 1 || res[0].Stats.Blobs != 1 {
+		t.Errorf("stats = %+v, want 1 commit and 1 blob", res[0].Stats)
+	}
+	stream, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if !strings.Contains(string(stream), "hi from johndoe") || strings.Contains(string(stream), "obinnaokechukwu") {
+		t.Errorf("stream is not scrubbed:\n%s", stream)
+	}
+	if u := res[0].RefUpdates; len(u) != 1 || u[0].Ref != "refs/heads/main" || u[0].Old != "" || u[0].New == "" || res[0].NewCommits != 1 {
+		t.Errorf("ref updates = %+v, new commits = %d; want main created with 1 commit", u, res[0].NewCommits)
+	}
+	if refs, _ := gitx.ListRefs(dst); len(refs) != 0 {
+		t.Errorf("dry run pushed refs: %v", refs)
+	}
+	if _, err := os.Stat(filepath.Join(src, ".git-copy", "state.json")); !os.IsNotExist(err) {
+		t.Errorf("dry run saved sync state (%v)", err)
+	}
+}
+
+func TestSyncRepo_DryRunAfterSync(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+	_, _ = gitx.Run(ctx, src, "branch", "topic")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	before, _ := gitx.ListRefs(dst)
+
+	commit("two")
+	commit("three")
+	_, _ = gitx.Run(ctx, src, "tag", "v1")
+	_, _ = gitx.Run(ctx, src, "branch", "-D", "topic")
+	opts.DryRun = true
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo --dry-run: %v %v", err, res)
+	}
+	if !res[0].Incremental || res[0].NewCommits != 2 {
+		t.Errorf("incremental = %v, new commits = %d; want an incremental run adding 2", res[0].Incremental, res[0].NewCommits)
+	}
+	var got []string
+	for _, u := range res[0].RefUpdates {
+		switch {
+		case u.Old == "":
+			got = append(got, "create "+u.Ref)
+		case u.New == "":
+			got = append(got, "delete "+u.Ref)
+		default:
+			got = append(got, "update "+u.Ref)
+		}
+	}
+	want := []string{"update refs/heads/main", "create refs/tags/v1", "delete refs/heads/topic"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ref updates = %q, want %q", got, want)
+	}
+	if after, _ := gitx.ListRefs(dst); len(after) != len(before) || after["refs/heads/main"] != before["refs/heads/main"] {
+		t.Errorf("dry run changed the target: %v -> %v", before, after)
+	}
+	cached, _ := gitx.ListRefs(filepath.Join(opts.CacheDir, repoCacheKey(src), "t.git"))
+	if cached["refs/heads/main"] != before["refs/heads/main"] {
+		t.Errorf("dry run changed the cache")
+	}
+	if _, err := os.Stat(filepath.Join(opts.CacheDir, repoCacheKey(src), "t.dryrun.git")); !os.IsNotExist(err) {
+		t.Errorf("dry run left its repo behind (%v)", err)
+	}
+}
+
+func TestSyncRepo_ForceRebuildsUpToDateTarget(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	// The target's branch is deleted by hand; nothing changed privately.
+	if _, err := gitx.Run(ctx, dst, "update-ref", "-d", "refs/heads/main"); err != nil {
+		t.Fatalf("update-ref: %v", err)
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].DidWork {
+		t.Fatalf("unforced SyncRepo: %v, did work = %v, want a skip", err, res[0].DidWork)
+	}
+
+	opts.Force = true
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("forced SyncRepo: %v %v", err, res)
+	}
+	if !res[0].DidWork || res[0].Incremental {
+		t.Errorf("forced sync: did work = %v, incremental = %v; want a full rebuild", res[0].DidWork, res[0].Incremental)
+	}
+	if _, err := gitx.RevParse(dst, "refs/heads/main"); err != nil {
+		t.Errorf("forced sync did not restore main: %v", err)
+	}
+}
+
+func TestSyncRepo_ScheduleSkipsTargetUntilDue(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+	}
+	for _, label := range []string{"hourly", "always"} {
+		dst := newBareRepo(t, filepath.Join(tmp, label+".git"))
+		cfg.Targets = append(cfg.Targets, config.Target{
+			Label: label, Provider: "none", Account: "johndoe", RepoName: label, RepoURL: dst,
+			InitialHistoryMode: "full",
+		})
+	}
+	cfg.Targets[0].Schedule = &config.SyncSchedule{Interval: "1h"}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil || res[1].Error != nil || !res[0].DidWork {
+		t.Fatalf("first SyncRepo: %v %v", err, res)
+	}
+
+	commit("two")
+	start := time.Now()
+	res, err = SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[1].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if res[0].DidWork || res[0].NextDue.Before(start) || res[0].NextDue.After(start.Add(time.Hour)) {
+		t.Errorf("hourly target: did work = %v, next due %v; want a skip until within the hour", res[0].DidWork, res[0].NextDue)
+	}
+	if !res[1].DidWork {
+		t.Errorf("unscheduled target was skipped")
+	}
+
+	ignore := opts
+	ignore.IgnoreSchedule = true
+	if res, err = SyncRepo(ctx, src, cfg, "hourly", ignore); err != nil || res[0].Error != nil || !res[0].DidWork || !res[0].Incremental {
+		t.Errorf("SyncRepo of the hourly target ignoring its schedule: %v %v, want an incremental sync", err, res)
+	}
+	if res, err = SyncRepo(ctx, src, cfg, "hourly", ignore); err != nil || res[0].DidWork {
+		t.Errorf("SyncRepo of the up-to-date hourly target ignoring its schedule: %v %v, want a skip", err, res)
+	}
+
+	opts.Force = true
+	if res, err = SyncRepo(ctx, src, cfg, "hourly", opts); err != nil || res[0].Error != nil || !res[0].DidWork || res[0].Incremental {
+		t.Errorf("forced SyncRepo of the hourly target: %v %v, want a rebuild", err, res)
+	}
+}
+
+func TestSyncRepo_Hooks(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	pre := filepath.Join(tmp, "pre.log")
+	post := filepath.Join(tmp, "post.log")
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			Hooks: &config.SyncHooks{
+				// The check sees the scrubbed history before it is pushed.
+				PreSync:  `git --git-dir="$GIT_COPY_SCRUBBED_REPO" log -1 --format=%s main > "` + pre + `" && ! git --git-dir="$GIT_COPY_SCRUBBED_REPO" log --format=%s main | grep -q block`,
+				PostSync: `echo "$GIT_COPY_TARGET $GIT_COPY_RESULT $GIT_COPY_NEW_COMMITS" > "` + post + `"`,
+			},
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil || len(res[0].Warnings) != 0 {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if b, _ := os.ReadFile(pre); string(b) != "one\n" {
+		t.Errorf("pre_sync saw %q", b)
+	}
+	if b, _ := os.ReadFile(post); string(b) != "t ok 1\n" {
+		t.Errorf("post_sync saw %q", b)
+	}
+
+	// A failing pre_sync check stops the push.
+	commit("block")
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var hookErr *HookError
+	if !errors.As(res[0].Error, &hookErr) || hookErr.Hook != "pre_sync" {
+		t.Errorf("sync with a failing pre_sync hook: error = %v", res[0].Error)
+	}
+	if out, _ := gitx.Run(ctx, dst, "log", "-1", "--format=%s", "main"); strings.TrimSpace(out.Stdout) != "one" {
+		t.Errorf("target main is at %q after a failed pre_sync hook", out.Stdout)
+	}
+	if b, _ := os.ReadFile(post); string(b) != "t error 0\n" {
+		t.Errorf("post_sync after the failure saw %q", b)
+	}
+}
+
+func TestSyncRepo_Webhooks(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	var events []notify.SyncEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev notify.SyncEvent
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "a\n"}, "one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+		Webhooks: []string{srv.URL},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil || len(res[0].Warnings) != 0 {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	main, err := gitx.RevParse(dst, "refs/heads/main")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d webhook events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Target != "t" || ev.Repo != repoCacheKey(src) || ev.Status != "ok" || ev.NewCommits != 1 || ev.PushedRefs["refs/heads/main"] != main {
+		t.Errorf("event = %+v", ev)
+	}
+
+	// Up-to-date targets made no attempt and send nothing.
+	if _, err := SyncRepo(ctx, src, cfg, "", opts); err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("got %d webhook events after a skipped sync, want 1", len(events))
+	}
+}
+
+func TestSyncRepo_LocalAndBundleTargets(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+	_, _ = gitx.Run(ctx, src, "tag", "v1")
+
+	bundle := filepath.Join(tmp, "out", "public.bundle")
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{
+			// Neither exists yet; the directory is relative to the repo.
+			{Label: "dir", Provider: "local", Account: "johndoe", RepoName: "mirror", RepoURL: "../mirror.git", InitialHistoryMode: "full"},
+			{Label: "bundle", Provider: "local", Account: "johndoe", RepoName: "public", RepoURL: bundle, InitialHistoryMode: "full"},
+		},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	sync := func() {
+		t.Helper()
+		res, err := SyncRepo(ctx, src, cfg, "", opts)
+		if err != nil || res[0].Error != nil || res[1].Error != nil {
+			t.Fatalf("SyncRepo: %v %v", err, res)
+		}
+	}
+	sync()
+	commit("two")
+	sync()
+
+	want, err := gitx.ListRefs(filepath.Join(opts.CacheDir, repoCacheKey(src), "dir.git"))
+	if err != nil {
+		t.Fatalf("ListRefs: %v", err)
+	}
+	mirror, err := gitx.ListRefs(filepath.Join(tmp, "mirror.git"))
+	if err != nil || gitx.HashRefs(mirror) != gitx.HashRefs(want) {
+		t.Errorf("local mirror refs = %v, %v; want %v", mirror, err, want)
+	}
+
+	clone := filepath.Join(tmp, "clone")
+	if _, err := gitx.Run(ctx, tmp, "clone", bundle, clone); err != nil {
+		t.Fatalf("clone bundle: %v", err)
+	}
+	out, _ := gitx.Run(ctx, clone, "log", "--format=%s", "main")
+	if strings.Join(nonEmptyLines(out.Stdout), ",") != "two,one" {
+		t.Errorf("bundle history = %q", out.Stdout)
+	}
+	if _, err := gitx.Run(ctx, clone, "rev-parse", "--verify", "refs/tags/v1"); err != nil {
+		t.Errorf("bundle lacks tag v1: %v", err)
+	}
+	if _, err := os.Stat(bundle + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary bundle left behind: %v", err)
+	}
+}
+
+func TestSyncRepo_UpdatePrivate(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// The private repo is a clean clone of origin, which gets a new commit.
+	origin := newTestRepo(t, filepath.Join(tmp, "origin"))
+	commit := func(msg string) {
+		t.Helper()
+		commitFiles(t, origin, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	commit("one")
+	src := filepath.Join(tmp, "src")
+	if _, err := gitx.Run(ctx, tmp, "clone", origin, src); err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	// The sync state must not make the checkout look dirty.
+	if err := os.WriteFile(filepath.Join(src, ".git", "info", "exclude"), []byte(".git-copy/\n"), 0o644); err != nil {
+		t.Fatalf("write exclude: %v", err)
+	}
+	before, _ := gitx.RevParse(src, "HEAD")
+	commit("two")
+	upstream, _ := gitx.RevParse(origin, "HEAD")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+		UpdatePrivate: config.UpdatePrivateNever,
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	check := func(mode, wantHead, wantTracking string) {
+		t.Helper()
+		if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+			t.Fatalf("SyncRepo (%s): %v %v", mode, err, res)
+		}
+		head, _ := gitx.RevParse(src, "HEAD")
+		tracking, _ := gitx.RevParse(src, "refs/remotes/origin/main")
+		if head != wantHead || tracking != wantTracking {
+			t.Errorf("%s: HEAD %s, origin/main %s; want %s, %s", mode, head, tracking, wantHead, wantTracking)
+		}
+	}
+	check("never", before, before)
+	opts.UpdatePrivate = config.UpdatePrivateFetch
+	check("fetch", before, upstream)
+	opts.UpdatePrivate = config.UpdatePrivatePull
+	check("pull", upstream, upstream)
+
+	opts.UpdatePrivate = "sometimes"
+	if _, err := SyncRepo(ctx, src, cfg, "", opts); err == nil {
+		t.Errorf("SyncRepo accepted update-private %q", opts.UpdatePrivate)
+	}
+}
+
+func TestSyncRepo_TargetLock(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "one\n"}, "one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	cacheDir := filepath.Join(tmp, "cache")
+
+	// Another sync holds the target's lock.
+	held, _, err := tryLock(targetLockPath(cacheDir, repoCacheKey(src), "t"))
+	if err != nil || held == nil {
+		t.Fatalf("tryLock: %v %v", held, err)
+	}
+	res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: cacheDir})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var locked *LockedError
+	if !errors.As(res[0].Error, &locked) || locked.PID != os.Getpid() || res[0].DidWork {
+		t.Fatalf("expected a skip with LockedError naming pid %d, got %+v", os.Getpid(), res[0])
+	}
+	if refs, _ := gitx.ListRefs(dst); len(refs) != 0 {
+		t.Errorf("locked target was pushed: %v", refs)
+	}
+
+	// A waiting sync proceeds once the lock is released.
+	defer func(d time.Duration) { lockPoll = d }(lockPoll)
+	lockPoll = 10 * time.Millisecond
+	waited := false
+	res, err = SyncRepo(ctx, src, cfg, "", Options{
+		CacheDir:    cacheDir,
+		WaitForLock: true,
+		LockWaiting: func(label string, pid int) {
+			waited = label == "t" && pid == os.Getpid()
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				held.unlock()
+			}()
+		},
+	})
+	if err != nil || res[0].Error != nil || !res[0].DidWork {
+		t.Fatalf("SyncRepo (waiting): %v %+v", err, res)
+	}
+	if !waited {
+		t.Errorf("LockWaiting was not called with the holder's pid")
+	}
+	if refs, _ := gitx.ListRefs(dst); refs["refs/heads/main"] == "" {
+		t.Errorf("target not pushed after waiting: %v", refs)
+	}
+}
+
+func TestSyncRepo_VerifiesPushedRefs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "one\n"}, "one")
+	if _, err := gitx.Run(ctx, src, "branch", "dev"); err != nil {
+		t.Fatalf("branch: %v", err)
+	}
+
+	// The target accepts the push but loses one branch, as a partial push
+	// would.
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	hook := "#!/bin/sh\ngit update-ref -d refs/heads/main\n"
+	if err := os.WriteFile(filepath.Join(dst, "hooks", "post-receive"), []byte(hook), 0o755); err != nil {
+		t.Fatalf("write hook: %v", err)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "cache")})
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var verr *VerifyError
+	if !errors.As(res[0].Error, &verr) {
+		t.Fatalf("error = %v, want a VerifyError", res[0].Error)
+	}
+	if len(verr.Refs) != 1 || verr.Refs[0].Ref != "refs/heads/main" || verr.Refs[0].Old != "" || verr.Refs[0].New == "" {
+		t.Errorf("mismatched refs = %+v, want refs/heads/main missing", verr.Refs)
+	}
+	st, _ := state.Load(src)
+	ts := st.Targets["t"]
+	if ts.LastError == "" || len(ts.VerifyFailures) != 1 || ts.VerifyFailures[0].Ref != "refs/heads/main" {
+		t.Errorf("state = %+v, want the verification failure recorded", ts)
+	}
+	// The refs the target does hold are recorded, so the next sync doesn't
+	// take them for foreign changes.
+	if _, ok := ts.PushedRefs["refs/heads/main"]; ok || ts.PushedRefs["refs/heads/dev"] == "" {
+		t.Errorf("pushed refs = %v, want only what the target reports", ts.PushedRefs)
+	}
+}
+
+func TestSyncRepo_CommitMap(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commit := func(msg string) string {
+		t.Helper()
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+		sha, _ := gitx.RevParse(src, "HEAD")
+		return sha
+	}
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	bare := filepath.Join(opts.CacheDir, repoCacheKey(src), "t.git")
+	var first string
+	for i, msg := range []string{"one", "two"} {
+		private := commit(msg)
+		if first == "" {
+			first = private
+		}
+		if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].Error != nil {
+			t.Fatalf("SyncRepo %d: %v %v", i+1, err, res)
+		}
+		// The latest private commit maps to the target's head, in both
+		// directions.
+		public, _ := gitx.RevParse(dst, "refs/heads/main")
+		for _, id := range []string{private, public[:10]} {
+			m, err := LookupCommit(bare, id)
+			if err != nil || len(m) != 1 || m[0].Private != private || m[0].Public != public {
+				t.Errorf("sync %d: LookupCommit(%s) = %+v, %v; want %s → %s", i+1, id, m, err, private, public)
+			}
+		}
+	}
+	// The incremental sync kept the first commit's entry.
+	if m, err := LookupCommit(bare, first); err != nil || len(m) != 1 {
+		t.Errorf("LookupCommit(first) = %+v, %v", m, err)
+	}
+	if _, err := LookupCommit(bare, "xyz"); err == nil {
+		t.Errorf("LookupCommit accepted an invalid id")
+	}
+}
+
+func TestSyncRepo_ReplaceRefs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for _, msg := range []string{"one", "two", "three"} {
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+	// Graft "three" onto "one".
+	if _, err := gitx.Run(ctx, src, "replace", "--graft", "HEAD", "HEAD~2"); err != nil {
+		t.Fatalf("git replace: %v", err)
+	}
+
+	var targets []config.Target
+	for _, label := range []string{"plain", "replace"} {
+		dst := newBareRepo(t, filepath.Join(tmp, label+".git"))
+		targets = append(targets, config.Target{
+			Label: label, Provider: "none", Account: "johndoe", RepoName: label, RepoURL: dst,
+			InitialHistoryMode: "full", ReplaceRefs: label == "replace",
+		})
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets:         targets,
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	for i := 1; i <= 2; i++ {
+		res, err := SyncRepo(ctx, src, cfg, "", opts)
+		if err != nil {
+			t.Fatalf("SyncRepo %d: %v", i, err)
+		}
+		for _, r := range res {
+			if r.Error != nil {
+				t.Fatalf("SyncRepo %d: %s: %v", i, r.TargetLabel, r.Error)
+			}
+		}
+
+		for _, label := range []string{"plain", "replace"} {
+			dst := filepath.Join(tmp, label+".git")
+			// The graft doesn't leak into the published history.
+			out, err := gitx.Run(ctx, dst, "--no-replace-objects", "log", "--format=%s", "main")
+			if err != nil {
+				t.Fatalf("git log: %v", err)
+			}
+			if got := strings.Fields(out.Stdout); strings.Join(got, " ") != "three two one" {
+				t.Errorf("sync %d: %s history = %v", i, label, got)
+			}
+			refs, err := gitx.ListRefs(dst)
+			if err != nil {
+				t.Fatalf("ListRefs: %v", err)
+			}
+			var replace []string
+			for ref := range refs {
+				if strings.HasPrefix(ref, "refs/replace/") {
+					replace = append(replace, ref)
+				}
+			}
+			if label == "plain" {
+				if len(replace) != 0 {
+					t.Errorf("sync %d: plain target has replace refs %v", i, replace)
+				}
+				continue
+			}
+			// The replace ref names the public "three" and grafts it onto
+			// the public "one".
+			head := refs["refs/heads/main"]
+			if len(replace) != 1 || replace[0] != "refs/replace/"+head {
+				t.Fatalf("sync %d: replace refs = %v, want refs/replace/%s", i, replace, head)
+			}
+			out, err = gitx.Run(ctx, dst, "log", "--format=%s", "main")
+			if err != nil {
+				t.Fatalf("git log: %v", err)
+			}
+			if got := strings.Fields(out.Stdout); strings.Join(got, " ") != "three one" {
+				t.Errorf("sync %d: replaced history = %v", i, got)
+			}
+		}
+		if i == 1 {
+			// The incremental sync keeps the renamed replace ref.
+			if _, err := gitx.Run(ctx, src, "branch", "other", "HEAD~1"); err != nil {
+				t.Fatalf("git branch: %v", err)
+			}
+		}
+	}
+
+	// A dry run plans the replace ref under its public name too.
+	fresh := newBareRepo(t, filepath.Join(tmp, "fresh.git"))
+	cfg.Targets = []config.Target{targets[1]}
+	cfg.Targets[0].Label, cfg.Targets[0].RepoURL = "fresh", fresh
+	res, err := SyncRepo(ctx, src, cfg, "", Options{CacheDir: filepath.Join(tmp, "dry-cache"), DryRun: true})
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo (dry run): %v %+v", err, res)
+	}
+	updates := map[string]string{}
+	var replace []string
+	for _, u := range res[0].RefUpdates {
+		updates[u.Ref] = u.New
+		if strings.HasPrefix(u.Ref, "refs/replace/") {
+			replace = append(replace, u.Ref)
+		}
+	}
+	if head := updates["refs/heads/main"]; len(replace) != 1 || replace[0] != "refs/replace/"+head {
+		t.Errorf("dry run plans replace refs %v, want refs/replace/%s", replace, head)
+	}
+}
+
+func TestEnvPushEnv(t *testing.T) {
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "gh-secret")
+	t.Setenv("DEPLOY_TOKEN", "deploy-secret")
+	env := func(vars []string) map[string]string {
+		m := map[string]string{}
+		for _, v := range vars {
+			k, val, _ := strings.Cut(v, "=")
+			m[k] = val
+		}
+		return m
+	}
+
+	gh := config.Target{Label: "gh", Provider: "github", Account: "johndoe", RepoURL: "https://github.com/johndoe/dst.git"}
+	vars, err := envPushEnv(gh)
+	if err != nil {
+		t.Fatalf("envPushEnv: %v", err)
+	}
+	if m := env(vars); m["GIT_COPY_TOKEN"] != "gh-secret" || m["GIT_COPY_USERNAME"] != "x-access-token" || m["GIT_CONFIG_VALUE_1"] != tokenCredentialHelper {
+		t.Errorf("github env = %v", vars)
+	}
+
+	// The target's token_env takes precedence.
+	gh.Auth.TokenEnv = "DEPLOY_TOKEN"
+	if vars, err = envPushEnv(gh); err != nil || env(vars)["GIT_COPY_TOKEN"] != "deploy-secret" {
+		t.Errorf("token_env env = %v, %v", vars, err)
+	}
+	gh.Auth.TokenEnv = "MISSING_TOKEN"
+	if _, err := envPushEnv(gh); err == nil || !strings.Contains(err.Error(), "MISSING_TOKEN") {
+		t.Errorf("missing token: err = %v", err)
+	}
+
+	// SSH and local targets need no token.
+	for _, url := range []string{"git@github.com:johndoe/dst.git", "/tmp/dst.git"} {
+		if vars, err := envPushEnv(config.Target{Label: "t", Provider: "github", RepoURL: url}); err != nil || vars != nil {
+			t.Errorf("envPushEnv(%s) = %v, %v", url, vars, err)
+		}
+	}
+}
+
+func TestThrottledPushEnv(t *testing.T) {
+	t.Setenv("GIT_SSH_COMMAND", "ssh -i key")
+	repoPath := t.TempDir()
+	if _, err := gitx.Run(context.Background(), repoPath, "init", "-q"); err != nil {
+		t.Fatalf("git init: %v", err)
+	}
+	opts := Options{MaxUploadRate: 4096}
+	shared := throttle.NewLimiter(opts.MaxUploadRate)
+	run := func(url string) ([]string, []string) {
+		t.Helper()
+		job := &targetJob{srcRepo: repoPath, uploadLimiter: shared, target: config.Target{
+			Label: "t", Provider: "none", RepoURL: url, Push: &config.PushPolicy{MaxUploadRate: "1KB"},
+		}}
+		env, stop, err := throttledPushEnv(context.Background(), job, opts)
+		if err != nil {
+			t.Fatalf("throttledPushEnv(%s): %v", url, err)
+		}
+		stop()
+		return env, job.warnings
+	}
+
+	// Pushes share the sync's limiter, and the target's lower rate applies
+	// on top of it.
+	if ls := uploadLimiters(&targetJob{uploadLimiter: shared, target: config.Target{Push: &config.PushPolicy{MaxUploadRate: "1KB"}}}, opts); len(ls) != 2 || ls[0] != shared {
+		t.Errorf("limiters = %v, want the shared one and the target's", ls)
+	}
+	if ls := uploadLimiters(&targetJob{uploadLimiter: shared, target: config.Target{Push: &config.PushPolicy{MaxUploadRate: "1MB"}}}, opts); len(ls) != 1 || ls[0] != shared {
+		t.Errorf("limiters = %v, want the shared one only", ls)
+	}
+
+	env, _ := run("git@example.com:johndoe/dst.git")
+	if len(env) != 2 || env[0] != "GIT_SSH_VARIANT=ssh" || !strings.HasPrefix(env[1], "GIT_SSH_COMMAND=") || !strings.Contains(env[1], "' throttle-pipe 127.0.0.1:") || !strings.HasSuffix(env[1], " ssh -i key") {
+		t.Errorf("ssh env = %q", env)
+	}
+	// plink must still be run as plink.
+	t.Setenv("GIT_SSH_COMMAND", "'C:/Program Files/PuTTY/plink.exe' -batch")
+	if env, _ := run("git@example.com:johndoe/dst.git"); len(env) != 2 || env[0] != "GIT_SSH_VARIANT=plink" {
+		t.Errorf("plink env = %q", env)
+	}
+	t.Setenv("GIT_SSH_VARIANT", "simple")
+	if env, _ := run("git@example.com:johndoe/dst.git"); len(env) != 1 {
+		t.Errorf("env with GIT_SSH_VARIANT set = %q, want it left alone", env)
+	}
+	env, _ = run("https://example.com/johndoe/dst.git")
+	m := map[string]string{}
+	for _, v := range env {
+		k, val, _ := strings.Cut(v, "=")
+		m[k] = val
+	}
+	if !strings.HasPrefix(m["https_proxy"], "http://127.0.0.1:") || m["HTTPS_PROXY"] != m["https_proxy"] {
+		t.Errorf("https env = %q", env)
+	}
+	if env, warnings := run("/tmp/dst.git"); env != nil || warnings != nil {
+		t.Errorf("local target env = %q, warnings = %q; want neither", env, warnings)
+	}
+	if _, warnings := run("git://example.com/dst.git"); len(warnings) != 1 {
+		t.Errorf("git:// target warnings = %q, want one", warnings)
+	}
+}
+
+func TestSyncRepo_Adopt(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	newRepo := func(name string) string {
+		t.Helper()
+		dir := filepath.Join(tmp, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if _, err := gitx.Run(ctx, dir, "init", "-b", "main"); err != nil {
+			t.Fatalf("git init: %v", err)
+		}
+		_, _ = gitx.Run(ctx, dir, "config", "user.name", "John Doe")
+		_, _ = gitx.Run(ctx, dir, "config", "user.email", "johndoe@example.com")
+		return dir
+	}
+	commit := func(dir, file, msg string) {
+		t.Helper()
+		commitFiles(t, dir, map[string]string{file: msg + "\n"}, msg)
+	}
+	log := func(dst, ref string) string {
+		t.Helper()
+		out, err := gitx.Run(ctx, dst, "log", "--format=%s", ref)
+		if err != nil {
+			t.Fatalf("git log: %v", err)
+		}
+		return strings.Join(strings.Fields(out.Stdout), " ")
+	}
+
+	// The history published by hand, on every target.
+	manual := newRepo("manual")
+	commit(manual, "README", "manual")
+	manualTip, _ := gitx.RevParse(manual, "HEAD")
+	var targets []config.Target
+	for _, label := range []string{"graft", "branch", "none", "renamed"} {
+		dst := newBareRepo(t, filepath.Join(tmp, label+".git"))
+		tgt := config.Target{
+			Label: label, Provider: "none", Account: "johndoe", RepoName: label, RepoURL: dst,
+			InitialHistoryMode: "full",
+		}
+		refspec := "main"
+		switch label {
+		case "renamed":
+			// The head branch is published as trunk: the graft finds it
+			// under its public name.
+			tgt.Adopt = &config.AdoptPolicy{Mode: "graft"}
+			tgt.BranchRenames = []config.BranchRename{{From: "main", To: "trunk"}}
+			refspec = "main:trunk"
+		case "graft", "branch":
+			tgt.Adopt = &config.AdoptPolicy{Mode: label}
+		}
+		if _, err := gitx.Run(ctx, manual, "push", dst, refspec); err != nil {
+			t.Fatalf("git push: %v", err)
+		}
+		targets = append(targets, tgt)
+	}
+
+	src := newRepo("src")
+	_, _ = gitx.Run(ctx, src, "config", "user.name", "obinnaokechukwu")
+	_, _ = gitx.Run(ctx, src, "config", "user.email", "obinnaokechukwu@private.invalid")
+	commit(src, "a.txt", "one")
+	commit(src, "a.txt", "two")
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets:         targets,
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	for _, r := range res {
+		var derr *DivergedError
+		switch {
+		case r.TargetLabel == "none":
+			// Without adopt the target's history isn't overwritten
+			// silently.
+			if !errors.As(r.Error, &derr) {
+				t.Errorf("none: error = %v, want a DivergedError", r.Error)
+			}
+		case r.Error != nil:
+			t.Fatalf("%s: %v", r.TargetLabel, r.Error)
+		case r.TargetLabel == "graft" && r.NewCommits != 2:
+			t.Errorf("graft: %d new commits, want 2", r.NewCommits)
+		}
+	}
+	graft := filepath.Join(tmp, "graft.git")
+	if got := log(graft, "main"); got != "two one manual" {
+		t.Errorf("graft: history = %q", got)
+	}
+	if got := log(filepath.Join(tmp, "renamed.git"), "trunk"); got != "two one manual" {
+		t.Errorf("renamed: history = %q", got)
+	}
+	branch := filepath.Join(tmp, "branch.git")
+	if tip, _ := gitx.RevParse(branch, "main"); tip != manualTip {
+		t.Errorf("branch: main moved to %s", tip)
+	}
+	if got := log(branch, "git-copy/main"); got != "two one" {
+		t.Errorf("branch: git-copy/main history = %q", got)
+	}
+	st, err := state.Load(src)
+	if err != nil {
+		t.Fatalf("state.Load: %v", err)
+	}
+	if got := st.Targets["graft"].AdoptedBase; got != manualTip {
+		t.Errorf("adopted base = %q, want %s", got, manualTip)
+	}
+
+	// Later syncs, incremental or from scratch, keep the graft.
+	commit(src, "a.txt", "three")
+	if res, err := SyncRepo(ctx, src, cfg, "graft", opts); err != nil || res[0].Error != nil || !res[0].Incremental {
+		t.Fatalf("incremental SyncRepo: %v %+v", err, res)
+	}
+	head, _ := gitx.RevParse(graft, "main")
+	if got := log(graft, "main"); got != "three two one manual" {
+		t.Errorf("graft: history after incremental sync = %q", got)
+	}
+	opts.Force = true
+	if res, err := SyncRepo(ctx, src, cfg, "graft", opts); err != nil || res[0].Error != nil || res[0].Incremental {
+		t.Fatalf("forced SyncRepo: %v %+v", err, res)
+	}
+	if got, _ := gitx.RevParse(graft, "main"); got != head {
+		t.Errorf("graft: rebuilt head %s, want %s", got, head)
+	}
+}
+
+func TestSyncRepo_Repack(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	for _, msg := range []string{"one", "two"} {
+		commitFiles(t, src, map[string]string{"a.txt": msg + "\n"}, msg)
+	}
+
+	noBitmaps := false
+	var targets []config.Target
+	for _, label := range []string{"default", "tuned"} {
+		dst := newBareRepo(t, filepath.Join(tmp, label+".git"))
+		tgt := config.Target{
+			Label: label, Provider: "none", Account: "johndoe", RepoName: label, RepoURL: dst,
+			InitialHistoryMode: "full",
+		}
+		if label == "tuned" {
+			tgt.Repack = &config.RepackPolicy{Window: 50, Depth: 20, Force: true, Bitmaps: &noBitmaps}
+		}
+		targets = append(targets, tgt)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets:         targets,
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	for _, r := range res {
+		if r.Error != nil {
+			t.Fatalf("%s: %v", r.TargetLabel, r.Error)
+		}
+	}
+	for label, wantBitmap := range map[string]bool{"default": true, "tuned": false} {
+		packs, _ := filepath.Glob(filepath.Join(opts.CacheDir, repoCacheKey(src), label+".git", "objects", "pack", "*.pack"))
+		bitmaps, _ := filepath.Glob(filepath.Join(opts.CacheDir, repoCacheKey(src), label+".git", "objects", "pack", "*.bitmap"))
+		if len(packs) != 1 {
+			t.Errorf("%s: %d packs, want 1", label, len(packs))
+		}
+		if got := len(bitmaps) > 0; got != wantBitmap {
+			t.Errorf("%s: bitmap index written = %v, want %v", label, got, wantBitmap)
+		}
+	}
+	if got := strings.Join(repackArgs(targets[1].Repack), " "); got != "repack -adq -f --window=50 --depth=20 --no-write-bitmap-index" {
+		t.Errorf("repackArgs = %q", got)
+	}
+}
+
+func TestSyncRepo_RemoteIdentity(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	n := 0
+	commit := func() {
+		t.Helper()
+		n++
+		commitFiles(t, src, map[string]string{"a.txt": strings.Repeat("x", n) + "\n"}, "change")
+	}
+	commit()
+
+	// A Gitea API serving the identity of the repo behind the local target.
+	var repo atomic.Value
+	repo.Store(map[string]any{"id": 1, "full_name": "johndoe/dst"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repos/johndoe/dst" || r.Header.Get("Authorization") != "token TOKEN" {
+			w.WriteHeader(404)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(repo.Load())
+	}))
+	defer srv.Close()
+	t.Setenv("GIT_COPY_TEST_GITEA_TOKEN", "TOKEN")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "gitea", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			Auth:               config.AuthRef{Method: "token_env", TokenEnv: "GIT_COPY_TEST_GITEA_TOKEN", BaseURL: srv.URL},
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+	remote := func() state.RemoteIdentity {
+		t.Helper()
+		st, err := state.Load(src)
+		if err != nil || st.Targets["t"] == nil || st.Targets["t"].Remote == nil {
+			t.Fatalf("state: %v %+v", err, st)
+		}
+		return *st.Targets["t"].Remote
+	}
+	run := func(opts Options, wantWarning string) {
+		t.Helper()
+		res, err := SyncRepo(ctx, src, cfg, "", opts)
+		if err != nil || res[0].Error != nil {
+			t.Fatalf("SyncRepo: %v %v", err, res)
+		}
+		warned := wantWarning == "" && len(res[0].Warnings) == 0
+		for _, w := range res[0].Warnings {
+			warned = warned || wantWarning != "" && strings.Contains(w, wantWarning)
+		}
+		if !warned {
+			t.Errorf("warnings = %q, want %q", res[0].Warnings, wantWarning)
+		}
+	}
+
+	run(opts, "")
+	if got, want := remote(), (state.RemoteIdentity{URL: dst, ID: "1", Name: "johndoe/dst", Head: "refs/heads/main"}); got != want {
+		t.Errorf("remote = %+v, want %+v", got, want)
+	}
+
+	// The repo was deleted and another created under its name.
+	repo.Store(map[string]any{"id": 2, "full_name": "johndoe/dst"})
+	commit()
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil {
+		t.Fatalf("SyncRepo: %v", err)
+	}
+	var changed *RemoteChangedError
+	if !errors.As(res[0].Error, &changed) || !strings.Contains(changed.Reason, "from 1 to 2") {
+		t.Fatalf("error = %v, want a RemoteChangedError", res[0].Error)
+	}
+	if remote().ID != "1" {
+		t.Errorf("failed sync recorded the new id")
+	}
+	overwrite := opts
+	overwrite.AllowRemoteOverwrite = true
+	run(overwrite, "pushing anyway")
+	if remote().ID != "2" {
+		t.Errorf("remote id = %q, want 2", remote().ID)
+	}
+
+	repo.Store(map[string]any{"id": 2, "full_name": "johndoe/moved"})
+	commit()
+	run(opts, "renamed to johndoe/moved")
+
+	// Someone switched the target's default branch.
+	job := &targetJob{target: cfg.Targets[0], prevRemote: &state.RemoteIdentity{URL: dst, Head: "refs/heads/main"}}
+	if changed := checkRemoteIdentity(job, &state.RemoteIdentity{URL: dst, Head: "refs/heads/dev"}, nil, nil); changed != nil || len(job.warnings) != 1 || !strings.Contains(job.warnings[0], "HEAD changed from refs/heads/main to refs/heads/dev") {
+		t.Errorf("head change: %v %q", changed, job.warnings)
+	}
+
+	// Emptied on the host without the id changing (or a host without ids).
+	repo.Store(map[string]any{"id": 2, "full_name": "johndoe/dst"})
+	if err := os.RemoveAll(dst); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	newBareRepo(t, dst)
+	commit()
+	run(opts, "may have been deleted and recreated")
+	if _, err := gitx.RevParse(dst, "refs/heads/main"); err != nil {
+		t.Errorf("emptied target was not pushed to: %v", err)
+	}
+}
+
+func TestSyncRepo_Mirrors(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	src := newTestRepo(t, filepath.Join(tmp, "src"))
+	commitFiles(t, src, map[string]string{"a.txt": "one\n"}, "one")
+
+	dst := newBareRepo(t, filepath.Join(tmp, "dst.git"))
+	good := newBareRepo(t, filepath.Join(tmp, "good.git"))
+	// Not a repo: pushes to it fail.
+	bad := filepath.Join(tmp, "bad.git")
+	if err := os.WriteFile(bad, []byte("x\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg := config.RepoConfig{
+		Version:         config.RepoConfigVersion,
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Exclude: []string{".git-copy/**"}},
+		Targets: []config.Target{{
+			Label: "t", Provider: "none", Account: "johndoe", RepoName: "dst", RepoURL: dst,
+			InitialHistoryMode: "full",
+			Mirrors:            []config.Mirror{{URL: bad}, {URL: good}},
+		}},
+	}
+	opts := Options{CacheDir: filepath.Join(tmp, "cache")}
+
+	res, err := SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil {
+		t.Fatalf("SyncRepo: %v %v", err, res)
+	}
+	if len(res[0].Mirrors) != 2 || res[0].Mirrors[0].Error == nil || res[0].Mirrors[1].Error != nil {
+		t.Fatalf("mirrors = %+v, want bad failed and good synced", res[0].Mirrors)
+	}
+	want, err := gitx.RevParse(dst, "refs/heads/main")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	if got, err := gitx.RevParse(good, "refs/heads/main"); err != nil || got != want {
+		t.Errorf("good mirror main = %q (%v), want %q", got, err, want)
+	}
+	st, _ := state.Load(src)
+	ms := st.Targets["t"].Mirrors
+	if ms[bad] == nil || ms[bad].LastError == "" || ms[good] == nil || ms[good].LastError != "" || ms[good].PushedRefs["refs/heads/main"] != want {
+		t.Errorf("mirror states = %+v", ms)
+	}
+
+	// The failed mirror is retried though nothing changed, then the target
+	// is up to date.
+	if err := os.Remove(bad); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	newBareRepo(t, bad)
+	res, err = SyncRepo(ctx, src, cfg, "", opts)
+	if err != nil || res[0].Error != nil || !res[0].DidWork {
+		t.Fatalf("SyncRepo: %v %v, want a retry", err, res)
+	}
+	for _, m := range res[0].Mirrors {
+		if m.Error != nil {
+			t.Errorf("mirror %s: %v", m.URL, m.Error)
+		}
+	}
+	if got, err := gitx.RevParse(bad, "refs/heads/main"); err != nil || got != want {
+		t.Errorf("retried mirror main = %q (%v), want %q", got, err, want)
+	}
+	if res, err := SyncRepo(ctx, src, cfg, "", opts); err != nil || res[0].DidWork {
+		t.Fatalf("SyncRepo: %v, did work = %v, want up to date", err, res[0].DidWork)
+	}
+}
+
+func TestTargetRules_TargetTrailers(t *testing.T) {
+	// No rule here reads the repo.
+	repo := t.TempDir()
+	cfg := config.RepoConfig{
+		PrivateUsername: "obinnaokechukwu",
+		HeadBranch:      "main",
+		Defaults:        config.TargetDefaults{Trailers: &config.TrailerRules{Mode: "drop"}},
+	}
+	for _, tc := range []struct {
+		target config.Target
+		want   string
+	}{
+		{config.Target{Label: "defaults", Replacement: "publicuser"}, "drop"},
+		{config.Target{Label: "own", Replacement: "publicuser", Trailers: &config.TrailerRules{Mode: "rewrite"}}, "rewrite"},
+	} {
+		rules, err := targetRules(context.Background(), repo, cfg, tc.target)
+		if err != nil {
+			t.Fatalf("%s: targetRules: %v", tc.target.Label, err)
+		}
+		if rules.Trailers == nil || rules.Trailers.Mode != tc.want {
+			t.Errorf("%s: trailers = %+v, want mode %q", tc.target.Label, rules.Trailers, tc.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/obinnaokechukwu/git-copy/internal/config"
+	gitx "github.com/obinnaokechukwu/git-copy/internal/git"
+	"github.com/obinnaokechukwu/git-copy/internal/throttle"
+)
+
+// uploadLimiters returns the limiters the job's pushes upload through: the
+// sync's limiter of Options.MaxUploadRate, job.uploadLimiter, shared by all
+// its targets and mirrors, and one of the target's own push.max_upload_rate
+// if that's lower. None means no limit.
+func uploadLimiters(job *targetJob, opts Options) []*throttle.Limiter {
+	var ls []*throttle.Limiter
+	if job.uploadLimiter != nil {
+		ls = append(ls, job.uploadLimiter)
+	}
+	if p := job.target.Push; p != nil {
+		if r, _ := config.ParseByteSize(p.MaxUploadRate); r > 0 && (opts.MaxUploadRate <= 0 || r < opts.MaxUploadRate) {
+			ls = append(ls, throttle.NewLimiter(r))
+		}
+	}
+	return ls
+}
+
+// throttledPushEnv is targetPushEnv for the job's target, with its uploads
+// limited as uploadLimiters says. HTTPS pushes go through a loopback proxy
+// pacing what git sends; SSH pushes run ssh under "git-copy throttle-pipe",
+// which passes ssh's input through a loopback throttle.Pacer. Local targets
+// upload nothing and aren't throttled; other targets that can't be get a
+// warning. The returned function stops the proxy or pacer once the push is
+// done.
+func throttledPushEnv(ctx context.Context, job *targetJob, opts Options) ([]string, func(), error) {
+	t := job.target
+	stop := func() {}
+	env, err := targetPushEnv(t, opts)
+	limiters := uploadLimiters(job, opts)
+	if err != nil || len(limiters) == 0 {
+		return env, stop, err
+	}
+	if _, ok := gitx.LocalPath(t.RepoURL); ok {
+		return env, stop, nil
+	}
+	switch {
+	case strings.HasPrefix(t.RepoURL, "https://"):
+		if res, err := gitx.Run(ctx, job.srcRepo, "config", "--get-urlmatch", "http.proxy", t.RepoURL); err == nil && strings.TrimSpace(res.Stdout) != "" {
+			// git prefers its config to https_proxy.
+			job.warnings = append(job.warnings, fmt.Sprintf("push to %s not throttled: git's http.proxy is set", t.RepoURL))
+			return env, stop, nil
+		}
+		p, err := throttle.ListenProxy(limiters...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("throttle push: %w", err)
+		}
+		// Both cases, as curl prefers the lower; no_proxy would bypass it.
+		env = append(env, "https_proxy="+p.URL(), "HTTPS_PROXY="+p.URL(), "no_proxy=", "NO_PROXY=")
+		return env, func() { _ = p.Close() }, nil
+	case strings.Contains(t.RepoURL, "://") && !strings.HasPrefix(t.RepoURL, "ssh://"):
+		job.warnings = append(job.warnings, fmt.Sprintf("push to %s not throttled: only SSH and HTTPS pushes can be", t.RepoURL))
+		return env, stop, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("throttle push: %w", err)
+	}
+	ssh := sshCommand(ctx, job.srcRepo, env)
+	// git tells ssh's variant from the command's name, which is now ours.
+	if v := sshVariant(ctx, job.srcRepo, env, ssh); v != "" {
+		env = append(env, "GIT_SSH_VARIANT="+v)
+	}
+	p, err := throttle.ListenPacer(limiters...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("throttle push: %w", err)
+	}
+	env = append(env, "GIT_SSH_COMMAND="+shellQuote(exe)+" throttle-pipe "+p.Addr()+" "+ssh)
+	return env, func() { _ = p.Close() }, nil
+}
+
+// sshVariant is the GIT_SSH_VARIANT to run the ssh command line ssh with,
+// as git would tell it from the command's name, or "" if the environment
+// or ssh.variant already sets one or git would have to probe for it.
+func sshVariant(ctx context.Context, repoPath string, env []string, ssh string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GIT_SSH_VARIANT=") {
+			return ""
+		}
+	}
+	if os.Getenv("GIT_SSH_VARIANT") != "" {
+		return ""
+	}
+	if res, err := gitx.Run(ctx, repoPath, "config", "--get", "ssh.variant"); err == nil && strings.TrimSpace(res.Stdout) != "" {
+		return ""
+	}
+	name := ssh
+	if rest, ok := strings.CutPrefix(ssh, "'"); ok {
+		name, _, _ = strings.Cut(rest, "'")
+	} else if fields := strings.Fields(ssh); len(fields) > 0 {
+		name = fields[0]
+	}
+	name = strings.ToLower(strings.TrimSuffix(filepath.Base(filepath.ToSlash(name)), ".exe"))
+	switch name {
+	case "ssh", "plink", "putty", "tortoiseplink":
+		return name
+	}
+	return ""
+}
+
+// sshCommand is the command git would run ssh with, with env added to the
+// environment, as a shell command line.
+func sshCommand(ctx context.Context, repoPath string, env []string) string {
+	lookup := func(name string) string {
+		for i := len(env) - 1; i >= 0; i-- {
+			if v, ok := strings.CutPrefix(env[i], name+"="); ok {
+				return v
+			}
+		}
+		return os.Getenv(name)
+	}
+	if c := lookup("GIT_SSH_COMMAND"); c != "" {
+		return c
+	}
+	if res, err := gitx.Run(ctx, repoPath, "config", "--get", "core.sshCommand"); err == nil && strings.TrimSpace(res.Stdout) != "" {
+		return strings.TrimSpace(res.Stdout)
+	}
+	if p := lookup("GIT_SSH"); p != "" {
+		return shellQuote(p)
+	}
+	return "ssh"
+}
+
+// shellQuote quotes s as one word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,65 @@
+package throttle
+
+import (
+	"io"
+	"net"
+)
+
+// Pacer is a loopback server sending back what its clients send, no faster
+// than its limiters allow. "git-copy throttle-pipe" passes ssh's input
+// through it, so throttled SSH pushes, which run in processes of their own,
+// share the limiters of the process that started the pacer.
+type Pacer struct {
+	ln       net.Listener
+	limiters []*Limiter
+}
+
+// ListenPacer starts a pacer on a loopback port limited by each of ls.
+func ListenPacer(ls ...*Limiter) (*Pacer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Pacer{ln: ln, limiters: ls}
+	go p.serve()
+	return p, nil
+}
+
+// Addr is the pacer's address, for Pipe.
+func (p *Pacer) Addr() string {
+	return p.ln.Addr().String()
+}
+
+// Close stops accepting connections; open ones end with their clients.
+func (p *Pacer) Close() error {
+	return p.ln.Close()
+}
+
+func (p *Pacer) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			_, _ = io.Copy(c, NewReader(c, p.limiters...))
+			closeWrite(c)
+		}()
+	}
+}
+
+// Pipe copies src to dst through the pacer at addr, until src ends.
+func Pipe(addr string, dst io.Writer, src io.Reader) error {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go func() {
+		_, _ = io.Copy(c, src)
+		closeWrite(c)
+	}()
+	_, err = io.Copy(dst, c)
+	return err
+}
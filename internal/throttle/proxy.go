@@ -0,0 +1,133 @@
+package throttle
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Proxy is an HTTP proxy for git's HTTPS connections: it tunnels CONNECT
+// requests, pacing the bytes clients send with its limiters. Tunnels go
+// through the proxy the environment names for their host, if any.
+type Proxy struct {
+	ln       net.Listener
+	limiters []*Limiter
+}
+
+// ListenProxy starts a proxy on a loopback port limiting uploads to what
+// each of ls allows.
+func ListenProxy(ls ...*Limiter) (*Proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{ln: ln, limiters: ls}
+	go p.serve()
+	return p, nil
+}
+
+// URL is the proxy's URL, for https_proxy.
+func (p *Proxy) URL() string {
+	return "http://" + p.ln.Addr().String()
+}
+
+// Close stops accepting connections; open tunnels end with their clients.
+func (p *Proxy) Close() error {
+	return p.ln.Close()
+}
+
+func (p *Proxy) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(c)
+	}
+}
+
+func (p *Proxy) handle(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		fmt.Fprint(c, "HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n")
+		return
+	}
+	up, err := dialTunnel(req.Host)
+	if err != nil {
+		fmt.Fprintf(c, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n%v\n", err)
+		return
+	}
+	defer up.Close()
+	fmt.Fprint(c, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(c, up)
+		closeWrite(c)
+		close(done)
+	}()
+	// br may hold the start of the client's TLS handshake.
+	_, _ = io.Copy(up, NewReader(br, p.limiters...))
+	closeWrite(up)
+	<-done
+}
+
+// closeWrite shuts down the sending side of c, so its peer reads the end of
+// the stream but can still reply.
+func closeWrite(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+	} else {
+		c.Close()
+	}
+}
+
+// dialTunnel connects to host ("host:port"), through the proxy the
+// environment sets for HTTPS connections to it, if any.
+func dialTunnel(host string) (net.Conn, error) {
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return net.Dial("tcp", host)
+	}
+	addr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		addr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	connect := "CONNECT " + host + " HTTP/1.1\r\nHost: " + host + "\r\n"
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		connect += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, connect+"\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Nothing follows the response until the client's handshake, so the
+	// reader can't take any of the tunnel's bytes.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", proxyURL.Host, host, resp.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,76 @@
+// Package throttle limits the rate at which pushes upload, for targets
+// pushed to over a connection the daemon shouldn't saturate.
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter paces the bytes passed through it to a rate, shared by every
+// reader using it. Bursts are limited to a tenth of a second's worth.
+type Limiter struct {
+	rate  float64 // bytes per second
+	chunk int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter to rate bytes per second, which must be
+// positive.
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: float64(rate), chunk: int(max(rate/10, 512))}
+}
+
+// wait takes n bytes' worth of tokens, sleeping until the rate allows them.
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = float64(l.chunk)
+	} else {
+		l.tokens = min(float64(l.chunk), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+type reader struct {
+	r     io.Reader
+	ls    []*Limiter
+	chunk int
+}
+
+// NewReader returns a reader of r that returns no more bytes than each of
+// ls allows.
+func NewReader(r io.Reader, ls ...*Limiter) io.Reader {
+	rd := &reader{r: r, ls: ls}
+	for _, l := range ls {
+		if rd.chunk == 0 || l.chunk < rd.chunk {
+			rd.chunk = l.chunk
+		}
+	}
+	return rd
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.chunk > 0 && len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		for _, l := range r.ls {
+			l.wait(n)
+		}
+	}
+	return n, err
+}
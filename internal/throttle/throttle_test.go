@@ -0,0 +1,113 @@
+package throttle
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReaderLimitsRate(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 30<<10)
+	start := time.Now()
+	got, err := io.ReadAll(NewReader(bytes.NewReader(data), NewLimiter(100<<10)))
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	elapsed := time.Since(start)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(data))
+	}
+	// All but the first tenth of a second's worth are paced.
+	if elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("reading 30KiB at 100KiB/s took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestProxyTunnelsAndLimitsUploads(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer upstream.Close()
+	received := make(chan int, 1)
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		n, _ := io.Copy(io.Discard, c)
+		_, _ = io.WriteString(c, "done")
+		received <- int(n)
+	}()
+
+	p, err := ListenProxy(NewLimiter(100 << 10))
+	if err != nil {
+		t.Fatalf("ListenProxy: %v", err)
+	}
+	defer p.Close()
+	c, err := net.Dial("tcp", p.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	addr := upstream.Addr().String()
+	if _, err := io.WriteString(c, "CONNECT "+addr+" HTTP/1.1\r\nHost: "+addr+"\r\n\r\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v %v", resp, err)
+	}
+
+	start := time.Now()
+	if _, err := c.Write(bytes.Repeat([]byte("x"), 30<<10)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = c.(*net.TCPConn).CloseWrite()
+	if n := <-received; n != 30<<10 {
+		t.Errorf("upstream received %d bytes, want %d", n, 30<<10)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("uploading 30KiB at 100KiB/s took %v, want about 200ms", elapsed)
+	}
+	if reply, _ := io.ReadAll(br); string(reply) != "done" {
+		t.Errorf("reply = %q, want the upstream's", reply)
+	}
+}
+
+func TestPacerSharesItsLimiters(t *testing.T) {
+	p, err := ListenPacer(NewLimiter(100 << 10))
+	if err != nil {
+		t.Fatalf("ListenPacer: %v", err)
+	}
+	defer p.Close()
+
+	// Two pipes at once share the rate.
+	data := bytes.Repeat([]byte("x"), 15<<10)
+	start := time.Now()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var out bytes.Buffer
+			err := Pipe(p.Addr(), &out, bytes.NewReader(data))
+			if err == nil && !bytes.Equal(out.Bytes(), data) {
+				err = io.ErrShortWrite
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Pipe: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("piping 2x15KiB at 100KiB/s took %v, want about 200ms", elapsed)
+	}
+}